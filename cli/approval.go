@@ -9,6 +9,11 @@ import (
 
 // sendApproval 将审批结果写入当前 agent Session 的审批通道，并返回提示信息。
 func sendApproval(reqID string, approved bool) (string, error) {
+	return sendApprovalDecision(server.ApprovalDecision{RequestID: reqID, Approved: approved})
+}
+
+// sendApprovalDecision 写入完整的审批结果，Always 表示本会话内始终允许该远程工具。
+func sendApprovalDecision(d server.ApprovalDecision) (string, error) {
 	sess, err := getOrInitReplAgent()
 	if err != nil {
 		return "", err
@@ -18,11 +23,14 @@ func sendApproval(reqID string, approved bool) (string, error) {
 	timer := time.NewTimer(2 * time.Second)
 	defer timer.Stop()
 	select {
-	case ch <- server.ApprovalDecision{RequestID: reqID, Approved: approved}:
-		if approved {
-			return fmt.Sprintf("已批准补丁请求: %s", reqID), nil
+	case ch <- d:
+		switch {
+		case d.Approved && d.Always:
+			return fmt.Sprintf("已批准请求，本会话内不再询问该工具: %s", d.RequestID), nil
+		case d.Approved:
+			return fmt.Sprintf("已批准请求: %s", d.RequestID), nil
 		}
-		return fmt.Sprintf("已拒绝补丁请求: %s", reqID), nil
+		return fmt.Sprintf("已拒绝请求: %s", d.RequestID), nil
 	case <-timer.C:
 		return "", fmt.Errorf("审批通道暂不可用，请稍后重试")
 	}
@@ -17,6 +17,24 @@ import (
 //   - 直接运行 `chase-code` 时，默认进入基于 agent 的 REPL；
 //   - 也可以通过子命令显式调用 shell/repl。
 func Run() {
+	// config 子命令只读取配置，不依赖模型可用，需在初始化 LLM 之前处理。
+	if len(os.Args) >= 2 && os.Args[1] == "config" {
+		if err := runConfig(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "config 命令失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// mcp 子命令只管理 MCP 配置、检查 server 与登录，同样无需初始化 LLM。
+	if len(os.Args) >= 2 && os.Args[1] == "mcp" {
+		if err := runMCP(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "mcp 命令失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 初始化 LLM 配置
 	if err := llm.Init(); err != nil {
 		fmt.Fprintf(os.Stderr, "初始化 LLM 失败: %v\n", err)
@@ -44,6 +62,11 @@ func Run() {
 			fmt.Fprintf(os.Stderr, "repl 退出: %v\n", err)
 			os.Exit(1)
 		}
+	case "mcp-server":
+		if err := runMCPServer(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "mcp-server 退出: %v\n", err)
+			os.Exit(1)
+		}
 	case "help", "-h", "--help":
 		usage()
 	default:
@@ -71,16 +94,34 @@ func usage() {
   %[1]s                 # 直接进入 agent REPL（推荐）
   %[1]s shell [选项] -- <shell 命令字符串>
   %[1]s repl
+  %[1]s config show [--resolved]
+  %[1]s mcp list|add|remove|enable|disable|test <name>
+  %[1]s mcp login|logout <server>
+  %[1]s mcp trust [--revoke]
+  %[1]s mcp-server [--approval=elicit|reject] [--no-task]
 
 子命令说明:
   (无子命令)          进入基于 LLM+工具的 agent repl，默认使用 /agent 处理输入。
   shell                使用当前用户默认 shell 执行命令，默认启用 login shell。
   repl                 进入交互式终端，在同一工作目录下多轮执行 agent/shell。
+  config show          列出已加载的配置文件；--resolved 输出合并后的配置及每项来源。
+  mcp list             列出 ~/.chase-code/mcp.json 与项目 .chase-code/mcp.json 中的 MCP server（显式指定 mcp_config 时只用该文件）。
+  mcp add|remove       添加或删除 MCP server，默认写入用户配置，--project 写入项目配置。
+  mcp enable|disable   启用或禁用 MCP server。
+  mcp test             连接并初始化 server，列出工具并校验每个工具的参数 schema。
+  mcp login            对远程 MCP server 执行 OAuth 授权（PKCE + 本地回调），凭据保存在 ~/.chase-code/mcp-auth/。
+  mcp logout           删除远程 MCP server 已保存的凭据。
+  mcp trust            信任当前项目（--revoke 取消），信任后才加载项目 .chase-code/mcp.json，
+                       以及项目 config.yaml 中的 model/models/fallback/roles/approval_mode/allowed_commands；
+                       项目配置不能覆盖用户配置中的同名 MCP server，其中的 autoApprove 一律忽略。
+  mcp-server           通过 stdio 以 MCP server 身份暴露 shell_command、apply_patch 与 chase_code_task；
+                       需要确认的操作默认通过 elicitation 询问客户端，--approval=reject 时一律拒绝。
 
 示例:
   %[1]s
   %[1]s shell -- "ls -la"
   %[1]s repl
+  %[1]s config show --resolved
 `, prog)
 }
 
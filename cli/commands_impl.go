@@ -20,6 +20,26 @@ func (c *AgentCommand) Help() string {
 	return "用法: /agent <指令>\n示例: /agent 帮我把 main.go 里的错误修复了"
 }
 
+// ContinueCommand 实现 /continue 命令。
+type ContinueCommand struct{}
+
+func (c *ContinueCommand) Name() string        { return "continue" }
+func (c *ContinueCommand) Aliases() []string   { return nil }
+func (c *ContinueCommand) Description() string { return "步数耗尽后继续当前任务" }
+func (c *ContinueCommand) Help() string {
+	return "用法: /continue [n]\n在任务因达到最大步数中止后，继续执行 n 步（不追加新的用户消息）。"
+}
+
+// StepsCommand 实现 /steps 命令。
+type StepsCommand struct{}
+
+func (c *StepsCommand) Name() string        { return "steps" }
+func (c *StepsCommand) Aliases() []string   { return nil }
+func (c *StepsCommand) Description() string { return "查看或设置单次任务步数预算" }
+func (c *StepsCommand) Help() string {
+	return "用法:\n  /steps               显示当前步数预算\n  /steps <n>           设置步数预算"
+}
+
 // ApprovalsCommand 实现 /approvals 命令。
 type ApprovalsCommand struct{}
 
@@ -35,15 +55,15 @@ type ApproveCommand struct{}
 
 func (c *ApproveCommand) Name() string        { return "approve" }
 func (c *ApproveCommand) Aliases() []string   { return nil }
-func (c *ApproveCommand) Description() string { return "批准补丁请求" }
-func (c *ApproveCommand) Help() string        { return "用法: /approve <请求ID>" }
+func (c *ApproveCommand) Description() string { return "批准补丁或 MCP 工具请求" }
+func (c *ApproveCommand) Help() string        { return "用法: /approve <请求ID> [--always]" }
 
 // RejectCommand 实现 /reject 命令。
 type RejectCommand struct{}
 
 func (c *RejectCommand) Name() string        { return "reject" }
 func (c *RejectCommand) Aliases() []string   { return nil }
-func (c *RejectCommand) Description() string { return "拒绝补丁请求" }
+func (c *RejectCommand) Description() string { return "拒绝补丁或 MCP 工具请求" }
 func (c *RejectCommand) Help() string        { return "用法: /reject <请求ID>" }
 
 // QuitCommand 实现 /quit 命令。
@@ -77,7 +97,9 @@ func (c *ModelCommand) Name() string        { return "model" }
 func (c *ModelCommand) Aliases() []string   { return nil }
 func (c *ModelCommand) Description() string { return "查看或切换当前使用的模型" }
 func (c *ModelCommand) Help() string {
-	return "用法:\n  /model               显示当前模型\n  /model <alias>       切换到指定模型"
+	return "用法:\n  /model               显示可用模型与当前模型\n  /model <alias>       切换到指定模型\n  /model reload        重新读取配置文件并刷新模型列表\n" +
+		"  /model add <name> --base-url <url> --model <id> [--type completions|responses|claude|local] [--api-key <key>|--api-key-cmd <cmd>]\n" +
+		"                       添加模型端点并写入 ~/.chase-code/config.yaml"
 }
 
 // ResumeCommand 实现 /resume 命令。
@@ -100,9 +122,31 @@ func (c *CompactCommand) Help() string {
 	return "用法: /compact\n生成当前会话摘要并压缩上下文以释放 Token。"
 }
 
+// MCPCommand 实现 /mcp 命令。
+type MCPCommand struct{}
+
+func (c *MCPCommand) Name() string        { return "mcp" }
+func (c *MCPCommand) Aliases() []string   { return nil }
+func (c *MCPCommand) Description() string { return "查看 MCP server 状态或刷新工具列表" }
+func (c *MCPCommand) Help() string {
+	return "用法:\n  /mcp                 显示各 server 状态、工具数、最近错误与延迟\n  /mcp refresh         立即重新拉取工具列表"
+}
+
+// ResourcesCommand 实现 /resources 命令。
+type ResourcesCommand struct{}
+
+func (c *ResourcesCommand) Name() string        { return "resources" }
+func (c *ResourcesCommand) Aliases() []string   { return nil }
+func (c *ResourcesCommand) Description() string { return "列出 MCP 资源（@server:uri 引用）" }
+func (c *ResourcesCommand) Help() string {
+	return "用法: /resources [server]\n在输入中写 @server:uri 可将资源内容附加到消息。"
+}
+
 func init() {
 	Register(&ShellCommand{})
 	Register(&AgentCommand{})
+	Register(&ContinueCommand{})
+	Register(&StepsCommand{})
 	Register(&ApprovalsCommand{})
 	Register(&ApproveCommand{})
 	Register(&RejectCommand{})
@@ -112,4 +156,6 @@ func init() {
 	Register(&ModelCommand{})
 	Register(&ResumeCommand{})
 	Register(&CompactCommand{})
+	Register(&ResourcesCommand{})
+	Register(&MCPCommand{})
 }
@@ -0,0 +1,55 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"chase-code/config"
+)
+
+// runConfig 处理 config 子命令，目前支持 `config show [--resolved]`。
+func runConfig(args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		return fmt.Errorf("用法: chase-code config show [--resolved]")
+	}
+
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	resolved := fs.Bool("resolved", false, "输出合并后的配置及每项来源")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	cfg := config.Get()
+	writeConfigFiles(os.Stdout, cfg.Files)
+	if *resolved {
+		fmt.Fprintln(os.Stdout)
+		writeResolvedConfig(os.Stdout, cfg.Resolved())
+	}
+	return nil
+}
+
+// writeConfigFiles 按优先级从低到高输出已加载的配置文件。
+func writeConfigFiles(w io.Writer, files []config.ConfigFile) {
+	fmt.Fprintln(w, "配置文件（优先级从低到高，环境变量优先于所有文件）:")
+	if len(files) == 0 {
+		fmt.Fprintln(w, "  (无)")
+		return
+	}
+	for _, f := range files {
+		fmt.Fprintf(w, "  [%s] %s\n", f.Source, f.Path)
+	}
+}
+
+// writeResolvedConfig 以表格形式输出合并后的配置项与来源。
+func writeResolvedConfig(w io.Writer, values []config.ResolvedValue) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, v := range values {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,441 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"chase-code/config"
+	servermcp "chase-code/server/mcp"
+)
+
+const (
+	// mcpLoginTimeout 为等待用户在浏览器中完成授权的最长时间。
+	mcpLoginTimeout = 5 * time.Minute
+	// mcpTestTimeout 为 mcp test 连接、初始化并列出工具的最长时间。
+	mcpTestTimeout = 2 * time.Minute
+)
+
+const mcpUsage = `用法:
+  chase-code mcp list
+  chase-code mcp add <name> [--project] [--type stdio|sse|streamableHttp] [--url URL] [--env K=V]... [--header K=V]... [--bearer-token-env VAR] [--timeout 秒] [--auto-approve a,b] [--enabled-tools glob,...] [--disabled-tools glob,...] [--cwd DIR] [-- command args...]
+  chase-code mcp remove|enable|disable <name> [--project|--user]
+  chase-code mcp test <name>
+  chase-code mcp login|logout <server>
+  chase-code mcp trust [--revoke]`
+
+// runMCP 处理 mcp 子命令：管理 MCP 配置文件、检查 server 以及 OAuth 登录。
+func runMCP(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", mcpUsage)
+	}
+	switch args[0] {
+	case "list":
+		return runMCPList(os.Stdout)
+	case "trust":
+		return runMCPTrust(args[1:])
+	}
+	if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+		return fmt.Errorf("%s", mcpUsage)
+	}
+	name := strings.TrimSpace(args[1])
+	switch args[0] {
+	case "add":
+		return runMCPAdd(name, args[2:])
+	case "remove":
+		return runMCPEdit("remove", name, args[2:])
+	case "enable":
+		return runMCPEdit("enable", name, args[2:])
+	case "disable":
+		return runMCPEdit("disable", name, args[2:])
+	case "test":
+		return runMCPTest(name)
+	case "login":
+		return runMCPLogin(name)
+	case "logout":
+		removed, err := servermcp.Logout(name)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			fmt.Fprintf(os.Stdout, "MCP server %s 没有已保存的凭据\n", name)
+			return nil
+		}
+		fmt.Fprintf(os.Stdout, "已删除 MCP server %s 的凭据\n", name)
+		return nil
+	}
+	return fmt.Errorf("未知的 mcp 子命令: %s\n\n%s", args[0], mcpUsage)
+}
+
+// runMCPList 输出所有配置文件中生效的 server，同名 server 以用户配置为准，未信任的项目配置不列出其中的 server。
+func runMCPList(w io.Writer) error {
+	files, err := mcpConfigFiles()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "MCP 配置文件（优先级从低到高）:")
+	for _, f := range files {
+		note := ""
+		if !f.Trusted {
+			note = fmt.Sprintf("（项目未信任，%d 个 server 未加载，可运行 chase-code mcp trust）", len(f.Config.MCPServers))
+		}
+		fmt.Fprintf(w, "  [%s] %s%s\n", f.Scope, f.Path, note)
+	}
+
+	scopes := mcpServerScopes(files)
+	if len(scopes) == 0 {
+		fmt.Fprintln(w, "\n尚未配置 MCP server，可通过 chase-code mcp add 添加。")
+		return nil
+	}
+	names := make([]string, 0, len(scopes))
+	for name := range scopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tENDPOINT\tSTATUS\tSCOPE")
+	for _, name := range names {
+		f := scopes[name]
+		s := f.Config.MCPServers[name]
+		status := "enabled"
+		if s.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", name, emptyAsDash(s.TransportType()), mcpEndpoint(s), status, f.Scope)
+	}
+	return tw.Flush()
+}
+
+// mcpEndpoint 返回 server 的 url 或启动命令，用于展示。
+func mcpEndpoint(s servermcp.MCPRemoteServerConfig) string {
+	if s.TransportType() == "stdio" {
+		return strings.Join(append([]string{s.Command}, s.Args...), " ")
+	}
+	return s.URL
+}
+
+func emptyAsDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// keyValueFlag 为可重复的 K=V 参数，如 --env FOO=bar --env BAZ=qux。
+type keyValueFlag map[string]string
+
+func (f keyValueFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for k, v := range f {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f keyValueFlag) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || strings.TrimSpace(key) == "" {
+		return fmt.Errorf("参数应为 K=V 形式: %q", v)
+	}
+	f[strings.TrimSpace(key)] = value
+	return nil
+}
+
+// runMCPAdd 向用户配置（--project 时为项目配置）添加一个 server。
+func runMCPAdd(name string, args []string) error {
+	fs := flag.NewFlagSet("mcp add", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	project := fs.Bool("project", false, "写入项目配置 .chase-code/mcp.json（默认写入 ~/.chase-code/mcp.json）")
+	typ := fs.String("type", "", "连接方式: stdio|sse|streamableHttp；未指定时有 --url 视为 streamableHttp，否则为 stdio")
+	url := fs.String("url", "", "远程 server 地址")
+	bearerEnv := fs.String("bearer-token-env", "", "从该环境变量读取 bearer token")
+	timeout := fs.Int("timeout", 0, "请求超时（秒），0 表示默认 60 秒")
+	autoApprove := fs.String("auto-approve", "", "无需审批的工具名，逗号分隔")
+	enabledTools := fs.String("enabled-tools", "", "只暴露匹配的工具（glob），逗号分隔")
+	disabledTools := fs.String("disabled-tools", "", "排除匹配的工具（glob），逗号分隔")
+	cwd := fs.String("cwd", "", "stdio server 的工作目录")
+	env := keyValueFlag{}
+	headers := keyValueFlag{}
+	fs.Var(env, "env", "stdio server 的环境变量 K=V，可重复")
+	fs.Var(headers, "header", "远程 server 的请求头 K=V，可重复")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	s := servermcp.MCPRemoteServerConfig{
+		Type:           strings.TrimSpace(*typ),
+		URL:            strings.TrimSpace(*url),
+		Timeout:        *timeout,
+		BearerTokenEnv: strings.TrimSpace(*bearerEnv),
+		Cwd:            strings.TrimSpace(*cwd),
+	}
+	if command := fs.Args(); len(command) > 0 {
+		s.Command = command[0]
+		s.Args = command[1:]
+	}
+	if s.Type == "" {
+		if s.URL != "" {
+			s.Type = "streamableHttp"
+		} else {
+			s.Type = "stdio"
+		}
+	}
+	if len(env) > 0 {
+		s.Env = env
+	}
+	if len(headers) > 0 {
+		s.Headers = headers
+	}
+	s.AutoApprove = splitCommaList(*autoApprove)
+	s.EnabledTools = splitCommaList(*enabledTools)
+	s.DisabledTools = splitCommaList(*disabledTools)
+	if err := validateMCPServer(name, s); err != nil {
+		return err
+	}
+
+	if *project && (len(s.AutoApprove) > 0 || s.BearerTokenEnv != "") {
+		return fmt.Errorf("项目配置不支持 --auto-approve 与 --bearer-token-env，请写入用户配置")
+	}
+
+	target, err := mcpTargetFile(*project)
+	if err != nil {
+		return err
+	}
+	if _, exists := target.Config.MCPServers[name]; exists {
+		return fmt.Errorf("%s 中已存在 MCP server %q，请先 chase-code mcp remove %s", target.Path, name, name)
+	}
+	target.Config.MCPServers[name] = s
+	if err := servermcp.SaveMCPConfig(target.Path, target.Config); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "已添加 MCP server %s（%s）到 %s\n", name, s.TransportType(), target.Path)
+	return nil
+}
+
+// splitCommaList 拆分逗号分隔的参数并去掉空项。
+func splitCommaList(v string) []string {
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// validateMCPServer 检查新增 server 的连接方式与必填字段。
+func validateMCPServer(name string, s servermcp.MCPRemoteServerConfig) error {
+	switch s.TransportType() {
+	case "stdio":
+		if strings.TrimSpace(s.Command) == "" {
+			return fmt.Errorf("stdio server %q 需要在 -- 之后给出启动命令", name)
+		}
+	case "sse", "streamable_http":
+		if s.URL == "" {
+			return fmt.Errorf("远程 server %q 需要 --url", name)
+		}
+	default:
+		return fmt.Errorf("不支持的 type: %q（可选 stdio|sse|streamableHttp）", s.Type)
+	}
+	return s.ValidateToolFilters(name)
+}
+
+// mcpTargetFile 返回 add 写入的配置文件：显式指定 mcp_config 时总是该文件。
+func mcpTargetFile(project bool) (mcpConfigFile, error) {
+	files, err := mcpConfigFiles()
+	if err != nil {
+		return mcpConfigFile{}, err
+	}
+	want := mcpScopeUser
+	if project {
+		want = mcpScopeProject
+	}
+	for _, f := range files {
+		if f.Scope == mcpScopeExplicit || f.Scope == want {
+			return f, nil
+		}
+	}
+	return mcpConfigFile{}, fmt.Errorf("无法确定 %s 级 MCP 配置文件路径", want)
+}
+
+// runMCPEdit 删除、启用或禁用一个 server。未指定 --project/--user 时修改其生效定义所在的文件
+// （项目配置不能覆盖用户配置，因此按优先级从低到高查找第一个定义）。
+func runMCPEdit(action, name string, args []string) error {
+	fs := flag.NewFlagSet("mcp "+action, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	project := fs.Bool("project", false, "只修改项目配置")
+	user := fs.Bool("user", false, "只修改用户配置")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *project && *user {
+		return fmt.Errorf("--project 与 --user 不能同时使用")
+	}
+
+	files, err := mcpConfigFiles()
+	if err != nil {
+		return err
+	}
+	var target *mcpConfigFile
+	for i := range files {
+		f := files[i]
+		if (*project && f.Scope == mcpScopeUser) || (*user && f.Scope == mcpScopeProject) {
+			continue
+		}
+		if _, ok := f.Config.MCPServers[name]; ok {
+			target = &files[i]
+			break
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("MCP 配置中没有 server %q", name)
+	}
+
+	var msg string
+	switch action {
+	case "remove":
+		delete(target.Config.MCPServers, name)
+		msg = "已删除"
+	case "enable", "disable":
+		s := target.Config.MCPServers[name]
+		s.Disabled = action == "disable"
+		target.Config.MCPServers[name] = s
+		msg = "已启用"
+		if s.Disabled {
+			msg = "已禁用"
+		}
+	}
+	if err := servermcp.SaveMCPConfig(target.Path, target.Config); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%s MCP server %s（%s）\n", msg, name, target.Path)
+	return nil
+}
+
+// runMCPTest 连接并初始化 server，列出工具并校验每个工具的参数 schema。
+func runMCPTest(name string) error {
+	server, err := lookupMCPServer(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mcpTestTimeout)
+	defer cancel()
+
+	fmt.Fprintf(os.Stdout, "正在连接 %s（%s）...\n", name, emptyAsDash(server.TransportType()))
+	check, err := servermcp.CheckServer(ctx, name, server)
+	if check != nil {
+		fmt.Fprintf(os.Stdout, "连接并初始化成功，耗时 %s\n", check.ConnectTime.Round(time.Millisecond))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "工具 %d 个:\n", len(check.Tools))
+	invalid, filtered := 0, 0
+	for _, t := range check.Tools {
+		switch {
+		case t.Filtered:
+			filtered++
+			fmt.Fprintf(os.Stdout, "  - %s（已被 enabledTools/disabledTools 过滤）\n", t.Tool)
+		case t.Problem != "":
+			invalid++
+			fmt.Fprintf(os.Stdout, "  ✗ %s: %s\n", t.Tool, t.Problem)
+		case t.Fixed:
+			fmt.Fprintf(os.Stdout, "  ~ %s（schema 已自动修正）\n", t.Tool)
+		default:
+			fmt.Fprintf(os.Stdout, "  ✓ %s\n", t.Tool)
+		}
+	}
+	fmt.Fprintf(os.Stdout, "暴露给模型 %d 个工具，schema 约 %d tokens\n", len(check.Tools)-filtered, check.SchemaTokens)
+	if invalid > 0 {
+		return fmt.Errorf("%d 个工具的参数 schema 无法使用", invalid)
+	}
+	return nil
+}
+
+// runMCPLogin 对配置中的远程 server 执行 OAuth 登录。
+func runMCPLogin(name string) error {
+	server, err := lookupMCPServer(name)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mcpLoginTimeout)
+	defer cancel()
+	err = servermcp.Login(ctx, name, server, servermcp.LoginOptions{
+		OpenBrowser: openBrowser,
+		Out:         os.Stdout,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "MCP server %s 登录成功，凭据已保存到 ~/.chase-code/mcp-auth/\n", name)
+	return nil
+}
+
+// lookupMCPServer 从合并后的 MCP 配置中查找指定 server。
+func lookupMCPServer(name string) (servermcp.MCPRemoteServerConfig, error) {
+	cfg, warnings, err := loadMCPConfig()
+	if err != nil {
+		return servermcp.MCPRemoteServerConfig{}, err
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "警告: %s\n", w)
+	}
+	if cfg == nil {
+		return servermcp.MCPRemoteServerConfig{}, fmt.Errorf("未配置 MCP server，可通过 chase-code mcp add 添加")
+	}
+	server, ok := cfg.MCPServers[name]
+	if !ok {
+		return servermcp.MCPRemoteServerConfig{}, fmt.Errorf("MCP 配置中没有 server %q", name)
+	}
+	return server, nil
+}
+
+// runMCPTrust 将当前项目加入（--revoke 时移出）用户的信任列表，
+// 信任后才会加载项目 .chase-code/mcp.json 与项目 config.yaml 中的模型、审批等配置。
+func runMCPTrust(args []string) error {
+	fs := flag.NewFlagSet("mcp trust", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	revoke := fs.Bool("revoke", false, "取消信任当前项目")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	root := config.ProjectRoot()
+	if root == "" {
+		return fmt.Errorf("无法确定当前项目目录")
+	}
+	if err := config.SetProjectTrusted(root, !*revoke); err != nil {
+		return err
+	}
+	if *revoke {
+		fmt.Fprintf(os.Stdout, "已取消信任项目 %s，不再加载其 MCP 配置与模型、审批等配置\n", root)
+		return nil
+	}
+	fmt.Fprintf(os.Stdout, "已信任项目 %s，将加载 %s 与项目 config.yaml 中的模型、审批等配置（MCP autoApprove 仍会被忽略）\n", root, config.ProjectMCPConfigPath())
+	return nil
+}
+
+// openBrowser 使用系统默认浏览器打开链接。
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
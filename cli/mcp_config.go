@@ -0,0 +1,130 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"chase-code/config"
+	servermcp "chase-code/server/mcp"
+)
+
+// MCP 配置文件的作用域。
+const (
+	mcpScopeExplicit = "mcp_config"
+	mcpScopeUser     = "user"
+	mcpScopeProject  = "project"
+)
+
+// mcpConfigFile 为一个参与合并的 MCP 配置文件。
+type mcpConfigFile struct {
+	Scope string
+	Path  string
+	// Config 为文件内容；文件不存在时为空配置，保存后即创建该文件。
+	Config *servermcp.MCPConfig
+	// Trusted 为 false 表示项目配置所在项目未被用户信任，文件仍可编辑，但其中的 server 不会加载。
+	Trusted bool
+}
+
+// mcpConfigFiles 返回当前生效的 MCP 配置文件，按优先级从低到高排列：
+// 显式指定了 mcp_config（或 CHASE_CODE_MCP_CONFIG）时只使用该文件；
+// 否则依次为 ~/.chase-code/mcp.json 与项目 .chase-code/mcp.json。
+// 项目配置（包括由项目 config.yaml 指定的 mcp_config）只在项目被 chase-code mcp trust 信任后加载，
+// 且只能新增 server，不能覆盖用户配置中的同名 server。
+func mcpConfigFiles() ([]mcpConfigFile, error) {
+	if path := config.Get().MCPConfigPath; path != "" {
+		f, err := loadMCPConfigFile(mcpScopeExplicit, path, config.Get().MCPConfigFromProject())
+		if err != nil {
+			return nil, err
+		}
+		return []mcpConfigFile{f}, nil
+	}
+
+	var files []mcpConfigFile
+	userPath := config.UserMCPConfigPath()
+	if userPath != "" {
+		f, err := loadMCPConfigFile(mcpScopeUser, userPath, false)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	// 在 home 目录下运行且不在 git 仓库中时，项目配置与用户配置是同一个文件。
+	if projectPath := config.ProjectMCPConfigPath(); projectPath != "" && filepath.Clean(projectPath) != filepath.Clean(userPath) {
+		f, err := loadMCPConfigFile(mcpScopeProject, projectPath, true)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// loadMCPConfigFile 读取单个 MCP 配置文件，文件不存在时返回空配置。
+// fromProject 表示文件由项目指定：其中的 server 标记为 Untrusted，且项目未被信任时整个文件不加载。
+func loadMCPConfigFile(scope, path string, fromProject bool) (mcpConfigFile, error) {
+	cfg, err := servermcp.LoadMCPConfig(path)
+	if err != nil {
+		return mcpConfigFile{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg == nil {
+		cfg = &servermcp.MCPConfig{}
+	}
+	if cfg.MCPServers == nil {
+		cfg.MCPServers = make(map[string]servermcp.MCPRemoteServerConfig)
+	}
+	trusted := true
+	if fromProject {
+		trusted = config.IsProjectTrusted(config.ProjectRoot())
+		for name, s := range cfg.MCPServers {
+			s.Untrusted = true
+			cfg.MCPServers[name] = s
+		}
+	}
+	return mcpConfigFile{Scope: scope, Path: path, Config: cfg, Trusted: trusted}, nil
+}
+
+// loadMCPConfig 合并所有 MCP 配置文件，没有配置任何 server 时返回 nil。
+// 被跳过的项目配置与同名 server 通过 warnings 说明。
+func loadMCPConfig() (*servermcp.MCPConfig, []string, error) {
+	files, err := mcpConfigFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	merged := &servermcp.MCPConfig{MCPServers: make(map[string]servermcp.MCPRemoteServerConfig)}
+	var warnings []string
+	for _, f := range files {
+		if !f.Trusted {
+			if n := len(f.Config.MCPServers); n > 0 {
+				warnings = append(warnings, fmt.Sprintf("项目指定的 MCP 配置 %s 中的 %d 个 server 未加载：该项目未被信任，确认可信后运行 chase-code mcp trust", f.Path, n))
+			}
+			continue
+		}
+		for _, name := range config.SortedKeys(f.Config.MCPServers) {
+			if _, exists := merged.MCPServers[name]; exists {
+				warnings = append(warnings, fmt.Sprintf("%s 中的 MCP server %q 与用户配置重名，已忽略", f.Path, name))
+				continue
+			}
+			merged.MCPServers[name] = f.Config.MCPServers[name]
+		}
+	}
+	if len(merged.MCPServers) == 0 {
+		return nil, warnings, nil
+	}
+	return merged, warnings, nil
+}
+
+// mcpServerScopes 返回每个 server 生效定义所在的配置文件，规则与 loadMCPConfig 一致。
+func mcpServerScopes(files []mcpConfigFile) map[string]mcpConfigFile {
+	out := make(map[string]mcpConfigFile)
+	for _, f := range files {
+		if !f.Trusted {
+			continue
+		}
+		for name := range f.Config.MCPServers {
+			if _, exists := out[name]; !exists {
+				out[name] = f
+			}
+		}
+	}
+	return out
+}
@@ -4,33 +4,43 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"sort"
 	"strings"
 	"time"
 
+	"chase-code/server"
 	servermcp "chase-code/server/mcp"
 	servertools "chase-code/server/tools"
 )
 
-func initMCPTools(cfgPath string, tools []servertools.ToolSpec, router *servertools.ToolRouter) ([]servertools.ToolSpec, *servertools.ToolRouter, error) {
-	cfgPath = strings.TrimSpace(cfgPath)
-	if cfgPath == "" {
-		return tools, router, nil
-	}
+// mcpClients 为当前进程创建的 MCP 客户端，退出时统一关闭（stdio server 需要结束子进程）。
+var mcpClients []servermcp.MCPClient
 
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
+// activeMCPRouter 为当前 MCP 路由，供资源引用与 prompt 命令使用；未配置 MCP 时为 nil。
+var activeMCPRouter *servermcp.MCPRouter
 
-	log.Printf("[mcp] loading config path=%s", cfgPath)
-	mcpCfg, err := servermcp.LoadMCPConfig(cfgPath)
-	if err != nil {
-		return tools, router, fmt.Errorf("加载 MCP 配置失败: %w", err)
-	}
+// applyMCPSpecs 将刷新后的 MCP 工具列表合并进当前 ToolRouter，由 initMCPTools 设置。
+var applyMCPSpecs func(specs []servertools.ToolSpec, warnings []string)
+
+// closeMCPClients 关闭所有 MCP 客户端。
+func closeMCPClients() {
+	servermcp.CloseClients(mcpClients)
+	mcpClients = nil
+	activeMCPRouter = nil
+	applyMCPSpecs = nil
+	resetMCPPromptSuggestions(nil)
+}
+
+// initMCPTools 连接 MCP 配置中的 server，并将其工具合并进 ToolRouter。
+func initMCPTools(mcpCfg *servermcp.MCPConfig, tools []servertools.ToolSpec, router *servertools.ToolRouter) ([]servertools.ToolSpec, *servertools.ToolRouter, error) {
 	if mcpCfg == nil || len(mcpCfg.MCPServers) == 0 {
-		log.Printf("[mcp] empty config, skip")
 		return tools, router, nil
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
 	keys := make([]string, 0, len(mcpCfg.MCPServers))
 	for k := range mcpCfg.MCPServers {
 		keys = append(keys, k)
@@ -38,34 +48,72 @@ func initMCPTools(cfgPath string, tools []servertools.ToolSpec, router *serverto
 	sort.Strings(keys)
 	for _, k := range keys {
 		s := mcpCfg.MCPServers[k]
-		log.Printf("[mcp] server=%s type=%s url=%s disabled=%t timeout=%ds auto_approve=%d",
+		endpoint := strings.TrimSpace(s.URL)
+		if s.TransportType() == "stdio" {
+			endpoint = strings.TrimSpace(strings.Join(append([]string{s.Command}, s.Args...), " "))
+		}
+		log.Printf("[mcp] server=%s type=%s endpoint=%s disabled=%t timeout=%ds auto_approve=%d enabled_tools=%v disabled_tools=%v",
 			k,
-			strings.TrimSpace(s.Type),
-			strings.TrimSpace(s.URL),
+			s.TransportType(),
+			endpoint,
 			s.Disabled,
 			s.Timeout,
 			len(s.AutoApprove),
+			s.EnabledTools,
+			s.DisabledTools,
 		)
 	}
 
-	clients, err := servermcp.NewMCPClientsFromConfig(mcpCfg)
-	if err != nil {
-		return tools, router, fmt.Errorf("创建 MCP 客户端失败: %w", err)
-	}
+	clients := servermcp.NewMCPClientsFromConfig(ctx, mcpCfg)
 	if len(clients) == 0 {
 		log.Printf("[mcp] no clients created, skip")
 		return tools, router, nil
 	}
+	mcpClients = append(mcpClients, servermcp.Clients(clients)...)
 
-	_, mcpSpecs, err := servermcp.MergeMCPTools(ctx, clients)
+	reserved := make([]string, 0, len(tools))
+	for _, t := range tools {
+		reserved = append(reserved, t.Name)
+	}
+	mcpRouter, mcpSpecs, warnings, err := servermcp.NewMCPRouter(ctx, clients, reserved)
 	if err != nil {
 		return tools, router, fmt.Errorf("获取 MCP tools 列表失败: %w", err)
 	}
+	for _, st := range mcpRouter.Statuses() {
+		log.Printf("[mcp] server=%s tools=%d filtered=%d schema_tokens=%d", st.Name, st.Tools, st.FilteredTools, st.SchemaTokens)
+		if st.State != servermcp.ServerReady {
+			warnings = append(warnings, fmt.Sprintf("MCP server %q 连接失败（%s）: %s", st.Name, st.State, st.LastError))
+		}
+	}
+	for _, w := range warnings {
+		log.Printf("[mcp] %s", w)
+		fmt.Fprintf(os.Stderr, "警告: %s\n", w)
+	}
+	activeMCPRouter = mcpRouter
+	resetMCPPromptSuggestions(mcpRouter)
 
-	base := len(tools)
+	base := append([]servertools.ToolSpec(nil), tools...)
 	tools = append(tools, mcpSpecs...)
-	router = servertools.NewToolRouterWithMCP(tools, servermcp.MultiMCPClient(clients))
-	log.Printf("[mcp] merged tools total=%d (mcp=%d base=%d)", len(tools), len(mcpSpecs), base)
+	router = servertools.NewToolRouterWithMCP(tools, mcpRouter)
+	autoApproved := mcpRouter.AutoApprovedTools(mcpCfg)
+	router.SetAutoApprovedTools(autoApproved)
+	log.Printf("[mcp] auto-approved tools=%d", len(autoApproved))
+	log.Printf("[mcp] merged tools total=%d (mcp=%d base=%d) mcp_schema_tokens=%d", len(tools), len(mcpSpecs), len(base), mcpRouter.SchemaTokens())
+
+	// server 重连或工具列表变化时重建工具集合，下一次 LLM 请求即携带新的工具定义。
+	applyMCPSpecs = func(specs []servertools.ToolSpec, warnings []string) {
+		for _, w := range warnings {
+			log.Printf("[mcp] %s", w)
+		}
+		log.Printf("[mcp] tools refreshed mcp=%d mcp_schema_tokens=%d", len(specs), mcpRouter.SchemaTokens())
+		router.SetSpecs(append(append([]servertools.ToolSpec(nil), base...), specs...))
+		router.SetAutoApprovedTools(mcpRouter.AutoApprovedTools(mcpCfg))
+		// 系统提示词中列出了工具清单，需随之更新，否则模型仍按旧的工具列表行事。
+		if session := currentReplSession(); session != nil {
+			session.UpdateSystemPrompt(server.BuildToolSystemPrompt(router.Specs()))
+		}
+	}
+	mcpRouter.WatchToolChanges(applyMCPSpecs)
 
 	return tools, router, nil
 }
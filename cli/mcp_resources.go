@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"chase-code/cli/tui"
+	servermcp "chase-code/server/mcp"
+)
+
+const (
+	// mcpPromptCommandPrefix 为 MCP prompt 命令前缀，完整形式为 /mcp:<server>:<prompt>。
+	mcpPromptCommandPrefix = "mcp:"
+	// mcpRequestTimeout 为资源读取、prompt 获取等交互请求的超时时间。
+	mcpRequestTimeout = 30 * time.Second
+	// mcpPromptRefreshInterval 为 prompt 补全缓存的有效期，过期后在下一次补全时后台刷新。
+	mcpPromptRefreshInterval = time.Minute
+)
+
+// mcpPromptCache 缓存 MCP prompt 补全项。补全在 UI 线程读取缓存，拉取总在后台进行。
+var mcpPromptCache struct {
+	mu         sync.Mutex
+	router     *servermcp.MCPRouter
+	items      []tui.Suggestion
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// mcpPromptSuggestion 将 MCP prompt 包装为补全项。
+type mcpPromptSuggestion struct {
+	prompt servermcp.ServerPrompt
+}
+
+func (s mcpPromptSuggestion) Name() string {
+	return mcpPromptCommandPrefix + s.prompt.Server + ":" + s.prompt.Name
+}
+func (s mcpPromptSuggestion) Aliases() []string { return nil }
+func (s mcpPromptSuggestion) Description() string {
+	desc := s.prompt.Description
+	if desc == "" {
+		desc = "MCP prompt"
+	}
+	if usage := promptArgumentUsage(s.prompt.MCPPrompt); usage != "" {
+		desc += "  " + usage
+	}
+	return desc
+}
+
+// mcpPromptSuggestions 返回缓存的 MCP prompt 补全项，实现 tui.SuggestionSource；
+// 缓存过期时在后台刷新，新结果在之后的补全中生效。MCP 尚未初始化时返回空列表。
+func mcpPromptSuggestions() []tui.Suggestion {
+	c := &mcpPromptCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.router != nil && !c.refreshing && time.Since(c.fetchedAt) > mcpPromptRefreshInterval {
+		c.refreshing = true
+		go refreshMCPPromptSuggestions(c.router)
+	}
+	return c.items
+}
+
+// resetMCPPromptSuggestions 在 MCP 路由（重新）建立或关闭时调用：清空缓存并为新路由在后台拉取一次。
+func resetMCPPromptSuggestions(router *servermcp.MCPRouter) {
+	c := &mcpPromptCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.router = router
+	c.items = nil
+	c.fetchedAt = time.Time{}
+	c.refreshing = router != nil
+	if router != nil {
+		go refreshMCPPromptSuggestions(router)
+	}
+}
+
+// refreshMCPPromptSuggestions 拉取 prompt 列表并写入缓存；路由已被替换时丢弃结果。
+func refreshMCPPromptSuggestions(router *servermcp.MCPRouter) {
+	ctx, cancel := context.WithTimeout(context.Background(), mcpRequestTimeout)
+	defer cancel()
+	prompts, warnings := router.ListPrompts(ctx)
+	for _, w := range warnings {
+		log.Printf("[mcp] %s", w)
+	}
+	items := make([]tui.Suggestion, 0, len(prompts))
+	for _, p := range prompts {
+		items = append(items, mcpPromptSuggestion{prompt: p})
+	}
+
+	c := &mcpPromptCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.router != router {
+		return
+	}
+	c.items = items
+	c.fetchedAt = time.Now()
+	c.refreshing = false
+}
+
+// isMCPPromptCommand 判断命令名是否为 mcp:<server>:<prompt>。
+func isMCPPromptCommand(name string) bool {
+	return strings.HasPrefix(name, mcpPromptCommandPrefix)
+}
+
+// handleMCPPromptCommand 处理 /mcp:<server>:<prompt> [参数]：获取渲染后的 prompt 并作为用户输入启动任务。
+// 参数可写成 key=value，也可按 prompt 声明的顺序直接给出，多余的位置参数并入最后一个参数。
+func handleMCPPromptCommand(name string, args []string) error {
+	if _, err := getOrInitReplAgent(); err != nil {
+		return err
+	}
+	if activeMCPRouter == nil {
+		return fmt.Errorf("未配置 MCP server")
+	}
+	server, promptName, ok := strings.Cut(strings.TrimPrefix(name, mcpPromptCommandPrefix), ":")
+	if !ok || server == "" || promptName == "" {
+		return fmt.Errorf("用法: /mcp:<server>:<prompt> [参数]")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mcpRequestTimeout)
+	defer cancel()
+	prompts, _ := activeMCPRouter.ListPrompts(ctx)
+	var prompt *servermcp.MCPPrompt
+	for i := range prompts {
+		if prompts[i].Server == server && prompts[i].Name == promptName {
+			prompt = &prompts[i].MCPPrompt
+			break
+		}
+	}
+	if prompt == nil {
+		return fmt.Errorf("MCP server %s 没有名为 %s 的 prompt", server, promptName)
+	}
+
+	arguments, err := parsePromptArguments(*prompt, args)
+	if err != nil {
+		return err
+	}
+	msgs, err := activeMCPRouter.GetPrompt(ctx, server, promptName, arguments)
+	if err != nil {
+		return fmt.Errorf("获取 MCP prompt 失败: %w", err)
+	}
+	text := servermcp.JoinPromptMessages(msgs)
+	if strings.TrimSpace(text) == "" {
+		return fmt.Errorf("MCP prompt %s 内容为空", promptName)
+	}
+	return startAgentTurn(text)
+}
+
+// parsePromptArguments 将命令参数映射为 prompt 参数，并校验必填项。
+func parsePromptArguments(prompt servermcp.MCPPrompt, args []string) (map[string]string, error) {
+	declared := make(map[string]bool, len(prompt.Arguments))
+	for _, a := range prompt.Arguments {
+		declared[a.Name] = true
+	}
+
+	out := make(map[string]string)
+	var positional []string
+	for _, arg := range args {
+		if k, v, ok := strings.Cut(arg, "="); ok && declared[k] {
+			out[k] = v
+			continue
+		}
+		positional = append(positional, arg)
+	}
+
+	var unfilled []string
+	for _, a := range prompt.Arguments {
+		if _, ok := out[a.Name]; !ok {
+			unfilled = append(unfilled, a.Name)
+		}
+	}
+	for i, name := range unfilled {
+		if len(positional) == 0 {
+			break
+		}
+		if i == len(unfilled)-1 {
+			out[name] = strings.Join(positional, " ")
+			positional = nil
+			break
+		}
+		out[name] = positional[0]
+		positional = positional[1:]
+	}
+	if len(positional) > 0 {
+		return nil, fmt.Errorf("prompt %s 参数过多: %s", prompt.Name, strings.Join(positional, " "))
+	}
+
+	for _, a := range prompt.Arguments {
+		if a.Required && out[a.Name] == "" {
+			return nil, fmt.Errorf("缺少必填参数 %s，用法: /mcp:...:%s %s", a.Name, prompt.Name, promptArgumentUsage(prompt))
+		}
+	}
+	return out, nil
+}
+
+// promptArgumentUsage 返回 prompt 参数的用法提示，必填参数用 <>，可选参数用 []。
+func promptArgumentUsage(prompt servermcp.MCPPrompt) string {
+	parts := make([]string, 0, len(prompt.Arguments))
+	for _, a := range prompt.Arguments {
+		if a.Required {
+			parts = append(parts, "<"+a.Name+">")
+		} else {
+			parts = append(parts, "["+a.Name+"]")
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// handleResourcesCommand 处理 /resources [server]：列出 MCP 资源及其 @server:uri 引用写法。
+func handleResourcesCommand(args []string) ([]string, error) {
+	if _, err := getOrInitReplAgent(); err != nil {
+		return nil, err
+	}
+	if activeMCPRouter == nil {
+		return []string{"未配置 MCP server"}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mcpRequestTimeout)
+	defer cancel()
+	resources, warnings := activeMCPRouter.ListResources(ctx)
+
+	var lines []string
+	for _, w := range warnings {
+		lines = append(lines, "警告: "+w)
+	}
+	for _, r := range resources {
+		if len(args) > 0 && r.Server != args[0] {
+			continue
+		}
+		line := fmt.Sprintf("- @%s:%s", r.Server, r.URI)
+		if r.Name != "" && r.Name != r.URI {
+			line += "  " + r.Name
+		}
+		if r.Description != "" {
+			line += "  " + r.Description
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return []string{"没有可用的 MCP 资源"}, nil
+	}
+	return append([]string{"MCP 资源 (在输入中使用 @server:uri 引用):"}, lines...), nil
+}
+
+// expandResourceMentions 将输入中的 @server:uri 引用展开为资源内容。
+func expandResourceMentions(ctx context.Context, line string) (string, error) {
+	if activeMCPRouter == nil {
+		return line, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, mcpRequestTimeout)
+	defer cancel()
+	return activeMCPRouter.ExpandResourceMentions(ctx, line)
+}
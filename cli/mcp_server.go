@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"chase-code/config"
+	"chase-code/server"
+	"chase-code/server/llm"
+	servermcp "chase-code/server/mcp"
+	servertools "chase-code/server/tools"
+)
+
+// runMCPServer 以 MCP server 身份通过 stdio 暴露本地工具与 chase_code_task。
+// stdout 为协议通道，运行期间的提示只能写到 stderr 或日志。
+func runMCPServer(args []string) error {
+	fs := flag.NewFlagSet("mcp-server", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	approvalStr := fs.String("approval", string(servermcp.ApprovalElicit), "需要确认的操作的处理方式: elicit|reject")
+	noTask := fs.Bool("no-task", false, "不暴露 chase_code_task 工具，仅提供 shell_command 与 apply_patch")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	policy, err := servermcp.ParseApprovalPolicy(*approvalStr)
+	if err != nil {
+		return err
+	}
+
+	router := servertools.NewToolRouter(servertools.DefaultToolSpecs())
+	router.SetAllowedCommands(config.Get().AllowedCommands)
+
+	opts := servermcp.ServeOptions{
+		Name:     "chase-code",
+		Router:   router,
+		Approval: policy,
+	}
+	if !*noTask {
+		opts.RunTask = runMCPTask
+	}
+	log.Printf("[mcp-server] serving stdio approval=%s task=%t", policy, opts.RunTask != nil)
+	return servermcp.ServeStdio(opts)
+}
+
+// runMCPTask 为每次 chase_code_task 调用创建独立会话并执行一次完整 turn。
+// 会话只挂载本地工具，不再级联连接 MCP server。
+func runMCPTask(ctx context.Context, prompt string, approve servermcp.ApprovalFunc) (string, error) {
+	model, client, err := initLLMClient()
+	if err != nil {
+		return "", err
+	}
+	router := servertools.NewToolRouter(server.BuildToolSpecsForModel(model))
+	router.SetAllowedCommands(config.Get().AllowedCommands)
+
+	sink := &mcpTaskSink{ctx: ctx, approve: approve}
+	as := server.NewSession(client, router, sink, resolveReplMaxSteps())
+	sink.approvals = as.ApprovalsChan()
+	as.Fallbacks = llm.FallbackModels(model)
+	as.ResetHistoryWithSystemPrompt(server.BuildToolSystemPrompt(router.Specs()))
+	as.AppendEnvironmentContext(server.FormatEnvironmentContext(server.DefaultEnvironmentContext()))
+
+	if err := as.RunTurn(ctx, prompt); err != nil {
+		return "", err
+	}
+	if sink.failure != "" && sink.answer == "" {
+		return "", fmt.Errorf("%s", sink.failure)
+	}
+	if strings.TrimSpace(sink.answer) == "" {
+		return "(agent 未给出回答)", nil
+	}
+	return sink.answer, nil
+}
+
+// mcpTaskSink 同步消费会话事件：收集最终回答，并把审批请求交给 MCP 客户端决定。
+// SendEvent 在会话 goroutine 中调用，审批结果写入容量为 1 的审批通道后，
+// 会话随即在 waitForDecision 中读取，因此这里无需额外的 goroutine。
+type mcpTaskSink struct {
+	ctx       context.Context
+	approve   servermcp.ApprovalFunc
+	approvals chan<- server.ApprovalDecision
+	answer    string
+	failure   string
+}
+
+// SendEvent 实现 server.EventSink。
+func (s *mcpTaskSink) SendEvent(ev server.Event) {
+	switch ev.Kind {
+	case server.EventAgentTextDone:
+		s.answer = ev.Message
+	case server.EventTurnError:
+		s.failure = ev.Message
+	case server.EventPatchApprovalRequest, server.EventToolApprovalRequest, server.EventLoopDetected:
+		if ev.RequestID == "" {
+			return
+		}
+		approved, reason := s.approve(s.ctx, describeApprovalEvent(ev))
+		if !approved {
+			log.Printf("[mcp-server] approval rejected request=%s reason=%s", ev.RequestID, reason)
+		}
+		select {
+		case s.approvals <- server.ApprovalDecision{RequestID: ev.RequestID, Approved: approved}:
+		default:
+			log.Printf("[mcp-server] approval channel busy, drop decision request=%s", ev.RequestID)
+		}
+	}
+}
+
+// describeApprovalEvent 将审批事件转换为展示给 MCP 客户端用户的说明。
+func describeApprovalEvent(ev server.Event) string {
+	var b strings.Builder
+	switch ev.Kind {
+	case server.EventPatchApprovalRequest:
+		b.WriteString("应用补丁")
+	case server.EventToolApprovalRequest:
+		fmt.Fprintf(&b, "调用工具 %s", ev.ToolName)
+	case server.EventLoopDetected:
+		fmt.Fprintf(&b, "工具 %s 被重复调用，是否继续", ev.ToolName)
+	}
+	if msg := strings.TrimSpace(ev.Message); msg != "" {
+		b.WriteString("\n" + msg)
+	}
+	if len(ev.Paths) > 0 {
+		b.WriteString("\n涉及文件: " + strings.Join(ev.Paths, ", "))
+	}
+	return b.String()
+}
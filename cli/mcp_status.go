@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	servermcp "chase-code/server/mcp"
+)
+
+// handleMCPCommand 实现 /mcp 命令：
+//   - /mcp           显示各 MCP server 的连接状态、工具数、schema token 估算、最近错误与延迟；
+//   - /mcp refresh   立即重新拉取所有 server 的工具列表。
+func handleMCPCommand(args []string) ([]string, error) {
+	if _, err := getOrInitReplAgent(); err != nil {
+		return nil, err
+	}
+	if activeMCPRouter == nil {
+		return []string{"未配置 MCP server（可通过 chase-code mcp add 添加）"}, nil
+	}
+	if len(args) == 0 {
+		return mcpStatusLines(activeMCPRouter.Statuses(), activeMCPRouter.SchemaTokens(), time.Now()), nil
+	}
+	if args[0] != "refresh" {
+		return nil, fmt.Errorf("用法: /mcp [refresh]")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), mcpRequestTimeout)
+	defer cancel()
+	specs, warnings, err := activeMCPRouter.RefreshWith(ctx, applyMCPSpecs)
+	if err != nil {
+		return nil, err
+	}
+	lines := []string{fmt.Sprintf("已刷新 MCP 工具列表，共 %d 个工具，schema 约 %d tokens", len(specs), activeMCPRouter.SchemaTokens())}
+	for _, w := range warnings {
+		lines = append(lines, "警告: "+w)
+	}
+	return lines, nil
+}
+
+// mcpStatusLines 将 server 状态格式化为展示行，末尾附上全部 MCP 工具 schema 的估算 token 数。
+func mcpStatusLines(statuses []servermcp.ServerStatus, schemaTokens int, now time.Time) []string {
+	lines := []string{"MCP servers:"}
+	for _, st := range statuses {
+		line := fmt.Sprintf("- %s (%s)  %s  tools=%d", st.Name, st.Transport, st.State, st.Tools)
+		if st.FilteredTools > 0 {
+			line += fmt.Sprintf("  filtered=%d", st.FilteredTools)
+		}
+		line += fmt.Sprintf("  schema≈%d tokens", st.SchemaTokens)
+		if st.State == servermcp.ServerReady && st.Latency > 0 {
+			line += fmt.Sprintf("  latency=%s", st.Latency.Round(time.Millisecond))
+		}
+		if st.Reconnects > 0 {
+			line += fmt.Sprintf("  reconnects=%d", st.Reconnects)
+		}
+		if st.State == servermcp.ServerUnhealthy && !st.NextRetry.IsZero() {
+			line += fmt.Sprintf("  next retry in %s", st.NextRetry.Sub(now).Round(time.Second))
+		}
+		lines = append(lines, line)
+		if st.LastError != "" {
+			lines = append(lines, fmt.Sprintf("    last error (%s): %s", st.LastErrorAt.Format("15:04:05"), st.LastError))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("tool schema total ≈ %d tokens", schemaTokens))
+	return lines
+}
@@ -0,0 +1,255 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"chase-code/config"
+	"chase-code/server/llm"
+)
+
+// useModel 将会话主模型切换为 model，并同步备用模型列表。
+func (r *replAgentSession) useModel(model *llm.LLMModel) error {
+	client, err := llm.NewLLMClient(model)
+	if err != nil {
+		return err
+	}
+	r.session.Client = client
+	r.session.Fallbacks = llm.FallbackModels(model)
+	r.model = model
+	return nil
+}
+
+// listModelLines 生成 /model 的模型列表，按别名标记当前模型。
+func listModelLines(sess *replAgentSession) []string {
+	var currentAlias string
+	if sess.model != nil {
+		currentAlias = sess.model.Alias
+	}
+
+	lines := []string{"可用模型列表:"}
+	for _, m := range llm.GetModels() {
+		prefix := "  "
+		if currentAlias != "" && strings.EqualFold(m.Alias, currentAlias) {
+			prefix = "* "
+		}
+		line := fmt.Sprintf("%s%s (%s)", prefix, m.Alias, m.Model)
+		if local, ok := m.Client.(*llm.LocalClient); ok {
+			line += fmt.Sprintf(" [local %s, tools=%s]", local.Capabilities().Backend, local.ToolMode())
+		}
+		if m.ContextWindow > 0 {
+			line += fmt.Sprintf(" ctx=%d", m.ContextWindow)
+		}
+		if params := m.Params.Describe(); params != "" {
+			line += " " + params
+		}
+		lines = append(lines, line)
+	}
+	if currentAlias != "" {
+		lines = append(lines, "", fmt.Sprintf("当前使用: %s", currentAlias))
+	}
+	if roles := sess.roleLines(); len(roles) > 0 {
+		lines = append(lines, "", "角色模型:")
+		lines = append(lines, roles...)
+	}
+	return lines
+}
+
+// bindRoleModels 按配置中的 roles 为 compact/title 等角色绑定客户端，返回需要提示的警告。
+// agent 角色在选择主模型时已生效，这里不再覆盖当前主模型。
+func (r *replAgentSession) bindRoleModels() []string {
+	models, warnings := llm.RoleModels()
+	r.roles = make(map[llm.ModelRole]*llm.LLMModel)
+	for _, role := range llm.ModelRoles {
+		if role == llm.ModelRoleAgent {
+			continue
+		}
+		model, ok := models[role]
+		if !ok {
+			r.session.SetRoleClient(role, nil)
+			continue
+		}
+		client, err := llm.NewLLMClient(model)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("角色 %s 初始化模型 %s 失败: %v", role, model.Alias, err))
+			r.session.SetRoleClient(role, nil)
+			continue
+		}
+		r.session.SetRoleClient(role, client)
+		r.roles[role] = model
+	}
+	return warnings
+}
+
+// roleLines 列出各角色当前绑定的模型，未绑定的角色使用主模型。
+func (r *replAgentSession) roleLines() []string {
+	if len(r.roles) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, role := range llm.ModelRoles {
+		if role == llm.ModelRoleAgent {
+			continue
+		}
+		if model, ok := r.roles[role]; ok {
+			lines = append(lines, fmt.Sprintf("  %s: %s (%s)", role, model.Alias, model.Model))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s: (主模型)", role))
+		}
+	}
+	return lines
+}
+
+// reloadModels 重新加载模型列表，并按别名将当前会话绑定到新的客户端实例。
+func reloadModels(sess *replAgentSession) ([]string, error) {
+	models, err := llm.ReloadModels()
+	if models == nil {
+		return nil, err
+	}
+
+	lines := []string{fmt.Sprintf("已重新加载配置，共 %d 个可用模型", len(models.All))}
+	if err != nil {
+		lines = append(lines, fmt.Sprintf("警告: %v", err))
+	}
+	lines = append(lines, rebindCurrentModel(sess)...)
+	for _, warning := range sess.bindRoleModels() {
+		lines = append(lines, fmt.Sprintf("警告: %s", warning))
+	}
+	return lines, nil
+}
+
+// rebindCurrentModel 在模型列表刷新后按别名重新绑定当前模型；别名已不存在时保留原客户端。
+func rebindCurrentModel(sess *replAgentSession) []string {
+	if sess.model == nil {
+		return nil
+	}
+	alias := sess.model.Alias
+	model, err := llm.FindModel(alias)
+	if err != nil {
+		return []string{fmt.Sprintf("当前模型 %s 已不在配置中，继续使用原有连接；可通过 /model <alias> 切换", alias)}
+	}
+	if err := sess.useModel(model); err != nil {
+		return []string{fmt.Sprintf("重新绑定模型 %s 失败: %v", alias, err)}
+	}
+	return []string{fmt.Sprintf("当前模型: %s (%s)", model.Alias, model.Model)}
+}
+
+// modelAddOptions 为 /model add 的参数。
+type modelAddOptions struct {
+	name      string
+	kind      string
+	baseURL   string
+	model     string
+	apiKey    string
+	apiKeyCmd string
+}
+
+// parseModelAddArgs 解析 /model add <name> --base-url ... --model ... 参数。
+func parseModelAddArgs(args []string) (modelAddOptions, error) {
+	usage := fmt.Errorf("用法: /model add <name> --base-url <url> --model <id> [--type completions|responses|claude|local] [--api-key <key>|--api-key-cmd <cmd>]")
+	args = splitQuotedArgs(strings.Join(args, " "))
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return modelAddOptions{}, usage
+	}
+
+	opts := modelAddOptions{name: args[0]}
+	fs := flag.NewFlagSet("model add", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&opts.kind, "type", "completions", "")
+	fs.StringVar(&opts.baseURL, "base-url", "", "")
+	fs.StringVar(&opts.model, "model", "", "")
+	fs.StringVar(&opts.apiKey, "api-key", "", "")
+	fs.StringVar(&opts.apiKeyCmd, "api-key-cmd", "", "")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() > 0 {
+		return modelAddOptions{}, usage
+	}
+	if opts.kind != "local" && (opts.baseURL == "" || opts.model == "") {
+		return modelAddOptions{}, usage
+	}
+	return opts, nil
+}
+
+// toConfigModel 将参数转换为配置文件中的模型条目。
+func (o modelAddOptions) toConfigModel() (config.Model, error) {
+	m := config.Model{Name: o.name}
+	switch o.kind {
+	case "completions":
+		m.Completions = &config.CompletionsConfig{APIKey: o.apiKey, APIKeyCmd: o.apiKeyCmd, BaseURL: o.baseURL, Model: o.model}
+	case "responses":
+		m.Responses = &config.ResponsesConfig{APIKey: o.apiKey, APIKeyCmd: o.apiKeyCmd, BaseURL: o.baseURL, Model: o.model}
+	case "claude":
+		m.Claude = &config.ClaudeConfig{APIKey: o.apiKey, APIKeyCmd: o.apiKeyCmd, BaseURL: o.baseURL, Model: o.model}
+	case "local":
+		m.Local = &config.LocalConfig{APIKey: o.apiKey, APIKeyCmd: o.apiKeyCmd, BaseURL: o.baseURL, Model: o.model}
+	default:
+		return config.Model{}, fmt.Errorf("不支持的模型类型: %s（可选 completions/responses/claude/local）", o.kind)
+	}
+	return m, nil
+}
+
+// addModel 将新端点写入用户配置并重新加载模型列表。
+func addModel(sess *replAgentSession, args []string) ([]string, error) {
+	opts, err := parseModelAddArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	m, err := opts.toConfigModel()
+	if err != nil {
+		return nil, err
+	}
+	path, err := config.SaveUserModel(m)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := []string{fmt.Sprintf("已将模型 %s 写入 %s", m.Name, path)}
+	reloaded, err := reloadModels(sess)
+	if err != nil {
+		return lines, err
+	}
+	lines = append(lines, reloaded...)
+	if _, err := llm.FindModel(m.Name); err != nil {
+		lines = append(lines, fmt.Sprintf("警告: 模型 %s 未能加载，请检查配置", m.Name))
+	} else {
+		lines = append(lines, fmt.Sprintf("使用 /model %s 切换到该模型", m.Name))
+	}
+	return lines, nil
+}
+
+// splitQuotedArgs 按空白切分参数，支持单/双引号包裹含空格的值（如 --api-key-cmd "pass show openai"）。
+func splitQuotedArgs(s string) []string {
+	var (
+		args    []string
+		current strings.Builder
+		quote   rune
+		inArg   bool
+	)
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
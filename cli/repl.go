@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -20,6 +21,10 @@ import (
 type replAgentSession struct {
 	session *server.Session
 	events  chan server.Event
+	// model 为当前会话主模型，用于 /model 标记当前项与重新加载后按别名重新绑定。
+	model *llm.LLMModel
+	// roles 为 compact/title 等角色绑定的模型，用于 /model 展示。
+	roles map[llm.ModelRole]*llm.LLMModel
 }
 
 var replAgent *replAgentSession
@@ -29,6 +34,9 @@ var (
 
 	agentRunningMu sync.Mutex
 	agentRunning   bool
+	// deferredAgentInputs 为 turn 已结束、但后台 goroutine 尚未退出时到达的输入，
+	// 由 finishAgentTurn 在同一把锁内取出并交给新的 turn。
+	deferredAgentInputs []string
 )
 
 func isAgentRunning() bool {
@@ -47,10 +55,44 @@ func tryStartAgentTurn() bool {
 	return true
 }
 
+// finishAgentTurn 结束当前 turn；若仍有未处理的输入（turn 中止时剩余的排队输入，
+// 或 turn 收尾期间到达的输入），保持运行状态并立即用这些输入开始新的 turn。
 func finishAgentTurn() {
+	session := currentReplSession()
+	agentRunningMu.Lock()
+	inputs := append(session.TakePendingInputs(), deferredAgentInputs...)
+	deferredAgentInputs = nil
+	if len(inputs) == 0 {
+		agentRunning = false
+	}
+	agentRunningMu.Unlock()
+
+	if len(inputs) > 0 {
+		goRunAgentTurn(func(sess *replAgentSession) error {
+			return sess.session.RunTurn(context.Background(), strings.Join(inputs, "\n\n"))
+		})
+	}
+}
+
+// deferAgentInput 在 turn 收尾期间暂存输入，返回 false 表示当前没有运行中的 turn。
+func deferAgentInput(line string) bool {
 	agentRunningMu.Lock()
 	defer agentRunningMu.Unlock()
-	agentRunning = false
+	if !agentRunning {
+		return false
+	}
+	deferredAgentInputs = append(deferredAgentInputs, line)
+	return true
+}
+
+// currentReplSession 返回已初始化的会话，未初始化时返回 nil。
+func currentReplSession() *server.Session {
+	replAgentMu.Lock()
+	defer replAgentMu.Unlock()
+	if replAgent == nil {
+		return nil
+	}
+	return replAgent.session
 }
 
 func getOrInitReplAgent() (*replAgentSession, error) {
@@ -73,6 +115,7 @@ func getOrInitReplAgent() (*replAgentSession, error) {
 	} else {
 		replAgent.session = session.session
 		replAgent.events = session.events
+		replAgent.model = session.model
 	}
 	return replAgent, nil
 }
@@ -105,14 +148,33 @@ func initReplAgentSession(events chan server.Event) (*replAgentSession, error) {
 	if events == nil {
 		events = make(chan server.Event, 128)
 	}
-	as := server.NewSession(client, router, server.ChanEventSink{Ch: events}, maxSteps)
+	as := server.NewSession(client, router, server.ChanEventSink{Ch: events}, resolveReplMaxSteps())
+	as.Fallbacks = llm.FallbackModels(model)
 	as.ResetHistoryWithSystemPrompt(systemPrompt)
 	as.AppendEnvironmentContext(server.FormatEnvironmentContext(server.DefaultEnvironmentContext()))
 
-	return &replAgentSession{
+	sess := &replAgentSession{
 		session: as,
 		events:  events,
-	}, nil
+		model:   model,
+	}
+	for _, warning := range sess.bindRoleModels() {
+		log.Printf("[config] %s", warning)
+	}
+	return sess, nil
+}
+
+// wrapLLMCassette 在配置了 CHASE_CODE_LLM_CASSETTE 时用 ReplayClient 包装主模型客户端。
+func wrapLLMCassette(client llm.LLMClient) (llm.LLMClient, error) {
+	cfg := config.Get()
+	if cfg.LLMCassette == "" {
+		return client, nil
+	}
+	mode, err := llm.ParseCassetteMode(cfg.LLMCassetteMode)
+	if err != nil {
+		return nil, err
+	}
+	return llm.NewReplayClient(mode, cfg.LLMCassette, client)
 }
 
 // initLLMClient 构建 LLM 配置并初始化客户端。
@@ -125,6 +187,9 @@ func initLLMClient() (*llm.LLMModel, llm.LLMClient, error) {
 	if err != nil {
 		return nil, nil, err
 	}
+	if client, err = wrapLLMCassette(client); err != nil {
+		return nil, nil, err
+	}
 	log.Printf("[config] %s", config.Get().Summary())
 	return model, client, nil
 }
@@ -135,42 +200,54 @@ func initToolRouter(model *llm.LLMModel) ([]servertools.ToolSpec, *servertools.T
 	router := servertools.NewToolRouter(tools)
 
 	// 可选：通过配置接入 MCP tools（仿照 codex 的 mcp-server 能力）
-	// 配置路径通过环境变量 CHASE_CODE_MCP_CONFIG 指定，格式为 JSON：
+	// 配置来自 CHASE_CODE_MCP_CONFIG / 配置文件中的 mcp_config 指定的文件，未指定时合并
+	// ~/.chase-code/mcp.json 与项目 .chase-code/mcp.json（项目需先经 `chase-code mcp trust` 信任），
+	// 可用 `chase-code mcp add` 管理。格式为 JSON：
 	// {
-	//   "servers": [
-	//     {"name": "fs", "command": "mcp-filesystem", "args": ["--root", "/path"], "env": ["FOO=bar"], "cwd": "/path"}
-	//   ]
+	//   "mcpServers": {
+	//     "fs": {"type": "stdio", "command": "mcp-filesystem", "args": ["--root", "/path"], "env": {"FOO": "bar"}, "cwd": "/path"},
+	//     "docs": {"type": "streamableHttp", "url": "https://example.com/mcp"}
+	//   }
 	// }
-	if cfgPath := config.Get().MCPConfigPath; cfgPath != "" {
+	mcpCfg, warnings, err := loadMCPConfig()
+	for _, w := range warnings {
+		log.Printf("[mcp] %s", w)
+		fmt.Fprintf(os.Stderr, "警告: %s\n", w)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "加载 MCP 配置失败: %v\n", err)
+	} else if mcpCfg != nil {
 		var mcpErr error
-		tools, router, mcpErr = initMCPTools(cfgPath, tools, router)
+		tools, router, mcpErr = initMCPTools(mcpCfg, tools, router)
 		if mcpErr != nil {
 			fmt.Fprintf(os.Stderr, "初始化 MCP 失败: %v\n", mcpErr)
 		}
 	}
+	router.SetAllowedCommands(config.Get().AllowedCommands)
 
 	return tools, router
 }
 
 func runRepl(initialInput string) error {
 	events := getReplEvents()
+	defer closeMCPClients()
 
 	dispatcher := func(input string, pendingApprovalID string) (tui.DispatchResult, error) {
 		return dispatchReplInput(input, pendingApprovalID)
 	}
 
-	// 将 CLICommand 列表转换为 tui.Suggestion 列表
+	// 将 CLICommand 列表转换为 tui.Suggestion 列表；MCP prompt 在会话初始化后由缓存动态提供。
 	allCmds := ListCommands()
 	suggestions := make([]tui.Suggestion, len(allCmds))
 	for i, c := range allCmds {
 		suggestions[i] = c
 	}
 
-	return tui.Run(events, initialInput, dispatcher, suggestions)
+	return tui.Run(events, initialInput, dispatcher, suggestions, mcpPromptSuggestions)
 }
 
 func isAllowedWhileAgentRunning(line string) bool {
-	if strings.EqualFold(line, "y") || strings.EqualFold(line, "s") {
+	if isApprovalShortcut(line) {
 		return true
 	}
 	if !hasCommandPrefix(line) {
@@ -181,7 +258,7 @@ func isAllowedWhileAgentRunning(line string) bool {
 		return true
 	}
 	switch cmd.name {
-	case "approve", "reject", "y", "s", "q", "quit", "exit", "help":
+	case "approve", "reject", "y", "a", "s", "q", "quit", "exit", "help":
 		return true
 	default:
 		return false
@@ -197,7 +274,13 @@ func dispatchReplInput(line string, pendingApprovalID string) (tui.DispatchResul
 	if handled, result, err := handleApprovalShortcut(line, pendingApprovalID); handled {
 		return result, err
 	}
+	if handled, err := handleContinueShortcut(line); handled {
+		return tui.DispatchResult{}, err
+	}
 
+	if isAgentRunning() && !hasCommandPrefix(line) {
+		return queueAgentInput(line)
+	}
 	if isAgentRunning() && !isAllowedWhileAgentRunning(line) {
 		return tui.DispatchResult{}, fmt.Errorf("当前有任务在执行，请先处理审批或等待完成")
 	}
@@ -208,7 +291,68 @@ func dispatchReplInput(line string, pendingApprovalID string) (tui.DispatchResul
 	return tui.DispatchResult{}, startAgentTurn(line)
 }
 
-// handleApprovalShortcut 处理 y/s 快捷审批输入。
+// queuedInputs 将运行中输入交给单个后台 worker：资源引用展开可能耗时数十秒，
+// 不能占用 dispatcher，同时需保持输入的先后顺序。
+var (
+	queuedInputsOnce sync.Once
+	queuedInputs     chan string
+)
+
+// queueAgentInput 将运行中输入的普通文本交给后台 worker，展开资源引用后加入 Session 队列，在下一步 LLM 调用前注入。
+func queueAgentInput(line string) (tui.DispatchResult, error) {
+	if _, err := getOrInitReplAgent(); err != nil {
+		return tui.DispatchResult{}, err
+	}
+	queuedInputsOnce.Do(func() {
+		queuedInputs = make(chan string, 64)
+		go runQueuedInputWorker(queuedInputs)
+	})
+	queuedInputs <- line
+	return tui.DispatchResult{}, nil
+}
+
+// runQueuedInputWorker 按顺序处理运行中输入；展开失败的输入不会发送，并以事件提示用户。
+func runQueuedInputWorker(lines <-chan string) {
+	for line := range lines {
+		sess, err := getOrInitReplAgent()
+		if err != nil {
+			log.Printf("[repl] queue input failed: %v", err)
+			continue
+		}
+		expanded, err := expandResourceMentions(context.Background(), line)
+		if err != nil {
+			sess.session.Sink.SendEvent(server.Event{
+				Kind:    server.EventUserInputRejected,
+				Time:    time.Now(),
+				Message: fmt.Sprintf("%s（%v）", line, err),
+			})
+			continue
+		}
+		if err := submitExpandedInput(sess, expanded); err != nil {
+			emitAgentTurnError(sess, err)
+		}
+	}
+}
+
+// submitExpandedInput 将已展开的输入注入运行中的 turn；turn 已结束时开始新的 turn。
+func submitExpandedInput(sess *replAgentSession, line string) error {
+	if sess.session.QueueUserInput(line) || deferAgentInput(line) {
+		return nil
+	}
+	// 两次检查之间 turn 已完全结束：直接开始新的 turn，输入已展开，不再重复展开。
+	if !tryStartAgentTurn() {
+		if deferAgentInput(line) {
+			return nil
+		}
+		return fmt.Errorf("当前有任务在执行，请稍后重试")
+	}
+	goRunAgentTurn(func(sess *replAgentSession) error {
+		return sess.session.RunTurn(context.Background(), line)
+	})
+	return nil
+}
+
+// handleApprovalShortcut 处理 y/a/s 快捷审批输入。
 func handleApprovalShortcut(line string, pendingApprovalID string) (bool, tui.DispatchResult, error) {
 	if !isApprovalShortcut(line) {
 		return false, tui.DispatchResult{}, nil
@@ -219,14 +363,35 @@ func handleApprovalShortcut(line string, pendingApprovalID string) (bool, tui.Di
 		}
 		return false, tui.DispatchResult{}, nil
 	}
-	approved := strings.EqualFold(line, "y")
-	msg, err := sendApproval(pendingApprovalID, approved)
+	msg, err := sendApprovalDecision(shortcutDecision(line, pendingApprovalID))
 	return true, tui.DispatchResult{Lines: []string{msg}}, err
 }
 
-// isApprovalShortcut 判断输入是否为 y/s 快捷审批。
+// handleContinueShortcut 在上一次任务因步数耗尽中止时，将单独输入的 c 视为 /continue。
+func handleContinueShortcut(line string) (bool, error) {
+	if !strings.EqualFold(strings.TrimSpace(line), "c") || isAgentRunning() {
+		return false, nil
+	}
+	sess, err := getOrInitReplAgent()
+	if err != nil || !sess.session.CanContinueTurn() {
+		return false, nil
+	}
+	return true, startAgentContinue(0)
+}
+
+// isApprovalShortcut 判断输入是否为 y/a/s 快捷审批。
 func isApprovalShortcut(line string) bool {
-	return strings.EqualFold(line, "y") || strings.EqualFold(line, "s")
+	return strings.EqualFold(line, "y") || strings.EqualFold(line, "a") || strings.EqualFold(line, "s")
+}
+
+// shortcutDecision 将快捷输入转换为审批结果：y 批准，a 批准并在本会话内始终允许该工具，s 拒绝。
+func shortcutDecision(key string, reqID string) server.ApprovalDecision {
+	key = strings.ToLower(strings.TrimSpace(key))
+	return server.ApprovalDecision{
+		RequestID: reqID,
+		Approved:  key == "y" || key == "a",
+		Always:    key == "a",
+	}
 }
 
 // handleReplCommand 解析 / 开头的命令并生成输出。
@@ -249,6 +414,11 @@ func handleReplCommand(line string, pendingApprovalID string) (tui.DispatchResul
 		return tui.DispatchResult{Lines: lines}, err
 	case "agent":
 		return tui.DispatchResult{}, handleAgentCommand(cmd.args)
+	case "continue":
+		return tui.DispatchResult{}, handleContinueCommand(cmd.args)
+	case "steps":
+		lines, err := handleStepsCommand(cmd.args)
+		return tui.DispatchResult{Lines: lines}, err
 	case "resume":
 		lines, err := handleResumeCommand(cmd.args)
 		return tui.DispatchResult{Lines: lines}, err
@@ -264,10 +434,19 @@ func handleReplCommand(line string, pendingApprovalID string) (tui.DispatchResul
 	case "reject":
 		lines, err := handleApprovalCommand(cmd.args, false)
 		return tui.DispatchResult{Lines: lines}, err
-	case "y", "s":
+	case "y", "a", "s":
 		lines, err := handleApprovalShortcutCommand(cmd.name, pendingApprovalID)
 		return tui.DispatchResult{Lines: lines}, err
+	case "resources":
+		lines, err := handleResourcesCommand(cmd.args)
+		return tui.DispatchResult{Lines: lines}, err
+	case "mcp":
+		lines, err := handleMCPCommand(cmd.args)
+		return tui.DispatchResult{Lines: lines}, err
 	default:
+		if isMCPPromptCommand(cmd.name) {
+			return tui.DispatchResult{}, handleMCPPromptCommand(cmd.name, cmd.args)
+		}
 		return tui.DispatchResult{}, fmt.Errorf("未知 repl 命令: %s", cmd.name)
 	}
 }
@@ -292,6 +471,8 @@ func parseReplCommand(line string) replCommand {
 // handleModelCommand 实现 /model 命令：
 //
 //   - /model           显示所有可用模型及当前使用的模型；
+//   - /model reload    重新读取配置文件并刷新模型列表；
+//   - /model add ...   添加临时端点并写入用户配置；
 //   - /model <alias>   切换到指定别名的模型。
 func handleModelCommand(args []string) ([]string, error) {
 	sess, err := getOrInitReplAgent()
@@ -300,28 +481,13 @@ func handleModelCommand(args []string) ([]string, error) {
 	}
 
 	if len(args) == 0 {
-		models := llm.GetModels()
-		current := sess.session.Client
-		var currentAlias string
-		// 尝试从 client 获取 alias，如果 client 结构体中有的话
-		// 这里的实现依赖于 client 内部保存了别名，或者我们记录在 session 中
-		// 目前 LLMModel 中有 Alias，但 Client 接口没有返回 Alias 的方法。
-		// 我们暂且通过匹配 client 来判断，或者直接显示当前 session 的 client 信息。
-
-		lines := []string{"可用模型列表:"}
-		for _, m := range models {
-			prefix := "  "
-			// 简单的启发式判断：如果 client 内存地址一致，或者是同名的
-			if m.Client == current {
-				prefix = "* "
-				currentAlias = m.Alias
-			}
-			lines = append(lines, fmt.Sprintf("%s%s (%s)", prefix, m.Alias, m.Model))
-		}
-		if currentAlias != "" {
-			lines = append(lines, "", fmt.Sprintf("当前使用: %s", currentAlias))
-		}
-		return lines, nil
+		return listModelLines(sess), nil
+	}
+	switch args[0] {
+	case "reload":
+		return reloadModels(sess)
+	case "add":
+		return addModel(sess, args[1:])
 	}
 
 	alias := args[0]
@@ -329,13 +495,9 @@ func handleModelCommand(args []string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-
-	client, err := llm.NewLLMClient(model)
-	if err != nil {
+	if err := sess.useModel(model); err != nil {
 		return nil, err
 	}
-
-	sess.session.Client = client
 	return []string{fmt.Sprintf("已切换到模型: %s (%s)", model.Alias, model.Model)}, nil
 }
 
@@ -360,6 +522,36 @@ func handleAgentCommand(args []string) error {
 	return startAgentTurn(rest)
 }
 
+// handleContinueCommand 处理 /continue [n] 命令：在步数耗尽后继续同一个 turn。
+func handleContinueCommand(args []string) error {
+	steps := 0
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("用法: /continue [步数]，步数需为正整数")
+		}
+		steps = n
+	}
+	return startAgentContinue(steps)
+}
+
+// handleStepsCommand 处理 /steps 命令：查看或设置当前会话的单次 turn 步数预算。
+func handleStepsCommand(args []string) ([]string, error) {
+	sess, err := getOrInitReplAgent()
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return []string{fmt.Sprintf("当前单次任务步数预算: %d", sess.session.MaxSteps)}, nil
+	}
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("用法: /steps [步数]，步数需为正整数")
+	}
+	sess.session.MaxSteps = n
+	return []string{fmt.Sprintf("已将单次任务步数预算设置为: %d", n)}, nil
+}
+
 // handleResumeCommand 处理 /resume 命令。
 func handleResumeCommand(args []string) ([]string, error) {
 	sess, err := getOrInitReplAgent()
@@ -368,17 +560,21 @@ func handleResumeCommand(args []string) ([]string, error) {
 	}
 
 	if len(args) == 0 {
-		ids, err := persistence.List()
+		infos, err := persistence.ListInfo()
 		if err != nil {
 			return nil, fmt.Errorf("列出会话失败: %v", err)
 		}
-		if len(ids) == 0 {
+		if len(infos) == 0 {
 			return []string{"当前没有已保存的会话"}, nil
 		}
 		lines := []string{"可用会话列表 (使用 /resume <id> 加载):"}
 		// 倒序显示，最新的在最前 (List 返回的是文件名排序，假设 ID 时间戳递增)
-		for i := len(ids) - 1; i >= 0; i-- {
-			lines = append(lines, fmt.Sprintf("- %s", ids[i]))
+		for i := len(infos) - 1; i >= 0; i-- {
+			line := fmt.Sprintf("- %s", infos[i].ID)
+			if infos[i].Title != "" {
+				line += "  " + infos[i].Title
+			}
+			lines = append(lines, line)
 		}
 		return lines, nil
 	}
@@ -414,38 +610,52 @@ func handleCompactCommand(args []string) ([]string, error) {
 	}, nil
 }
 
-// handleApprovalCommand 处理 /approve、/reject 命令。
+// handleApprovalCommand 处理 /approve、/reject 命令；/approve <id> --always 对远程工具在本会话内始终允许。
 func handleApprovalCommand(args []string, approved bool) ([]string, error) {
-	if len(args) != 1 {
+	always := approved && len(args) == 2 && args[1] == "--always"
+	if len(args) != 1 && !always {
 		if approved {
-			return nil, fmt.Errorf("用法: /approve <请求ID>")
+			return nil, fmt.Errorf("用法: /approve <请求ID> [--always]")
 		}
 		return nil, fmt.Errorf("用法: /reject <请求ID>")
 	}
-	msg, err := sendApproval(args[0], approved)
+	msg, err := sendApprovalDecision(server.ApprovalDecision{RequestID: args[0], Approved: approved, Always: always})
 	if err != nil {
 		return nil, err
 	}
 	return []string{msg}, nil
 }
 
-// handleApprovalShortcutCommand 处理 /y、/s 命令。
+// handleApprovalShortcutCommand 处理 /y、/a、/s 命令。
 func handleApprovalShortcutCommand(cmd string, pendingApprovalID string) ([]string, error) {
 	if pendingApprovalID == "" {
 		return nil, fmt.Errorf("当前没有待审批请求")
 	}
-	approved := cmd == "y"
-	msg, err := sendApproval(pendingApprovalID, approved)
+	msg, err := sendApprovalDecision(shortcutDecision(cmd, pendingApprovalID))
 	if err != nil {
 		return nil, err
 	}
 	return []string{msg}, nil
 }
 
-const maxSteps = 40
+const defaultMaxSteps = 40
 
-// startAgentTurn 异步启动一次 agent turn。
-func startAgentTurn(userInput string) error {
+// resolveReplMaxSteps 读取 CHASE_CODE_MAX_STEPS，非法或未设置时使用默认步数。
+func resolveReplMaxSteps() int {
+	raw := config.Get().MaxSteps
+	if raw == "" {
+		return defaultMaxSteps
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		log.Printf("[config] invalid CHASE_CODE_MAX_STEPS=%q, use default %d", raw, defaultMaxSteps)
+		return defaultMaxSteps
+	}
+	return n
+}
+
+// startAgentContinue 异步续跑上一次因步数耗尽中止的 turn。
+func startAgentContinue(steps int) error {
 	if !tryStartAgentTurn() {
 		return fmt.Errorf("当前有任务在执行，请先处理审批或等待完成")
 	}
@@ -454,15 +664,49 @@ func startAgentTurn(userInput string) error {
 		finishAgentTurn()
 		return err
 	}
+	if !sess.session.CanContinueTurn() {
+		finishAgentTurn()
+		return fmt.Errorf("上一次任务并未因步数耗尽而中止，无需继续")
+	}
 	go func() {
 		defer finishAgentTurn()
-		if err := runAgentTurn(context.Background(), sess, userInput); err != nil {
+		if err := sess.session.ContinueTurn(context.Background(), steps); err != nil {
 			emitAgentTurnError(sess, err)
 		}
 	}()
 	return nil
 }
 
+// startAgentTurn 异步启动一次 agent turn。
+func startAgentTurn(userInput string) error {
+	if !tryStartAgentTurn() {
+		return fmt.Errorf("当前有任务在执行，请先处理审批或等待完成")
+	}
+	if _, err := getOrInitReplAgent(); err != nil {
+		finishAgentTurn()
+		return err
+	}
+	goRunAgentTurn(func(sess *replAgentSession) error {
+		return runAgentTurn(context.Background(), sess, userInput)
+	})
+	return nil
+}
+
+// goRunAgentTurn 在后台执行 run，结束后调用 finishAgentTurn；调用方需已通过 tryStartAgentTurn 占用运行状态。
+func goRunAgentTurn(run func(sess *replAgentSession) error) {
+	go func() {
+		defer finishAgentTurn()
+		sess, err := getOrInitReplAgent()
+		if err != nil {
+			log.Printf("[repl] start agent turn failed: %v", err)
+			return
+		}
+		if err := run(sess); err != nil {
+			emitAgentTurnError(sess, err)
+		}
+	}()
+}
+
 // runAgentTurn 执行一次同步的 agent turn。
 func runAgentTurn(ctx context.Context, sess *replAgentSession, userInput string) error {
 	if sess == nil || sess.session == nil {
@@ -471,6 +715,10 @@ func runAgentTurn(ctx context.Context, sess *replAgentSession, userInput string)
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	userInput, err := expandResourceMentions(ctx, userInput)
+	if err != nil {
+		return err
+	}
 	return sess.session.RunTurn(ctx, userInput)
 }
 
@@ -513,14 +761,24 @@ func replHelpLines() []string {
   /help                显示帮助
   /q / /quit / /exit   退出 repl
   /model [alias]       查看或切换 LLM 模型
+  /model reload        重新读取配置文件并刷新模型列表
+  /model add <name> --base-url <url> --model <id>  添加模型端点并写入用户配置
   /shell <cmd>         通过用户默认 shell 执行命令
   /agent <指令>        通过 LLM+工具自动完成一步任务
+  /continue [n]        步数耗尽后继续当前任务 n 步（默认使用步数预算）
+  /steps [n]           查看或设置单次任务的步数预算
   /resume [id]         列出或恢复已保存的会话
   /compact             手动压缩当前会话上下文（释放 Token）
-  /approve <id>        批准指定补丁请求（来自 apply_patch）
-  /reject <id>         拒绝指定补丁请求
+  /approve <id> [--always]  批准指定请求（apply_patch 或 MCP 工具）；--always 本会话内始终允许该 MCP 工具
+  /reject <id>         拒绝指定请求
   /approvals           查看/设置 apply_patch 审批模式
+  /mcp [refresh]       查看 MCP server 状态（工具数、最近错误、延迟）或立即刷新工具列表
+  /resources [server]  列出 MCP 资源
+  /mcp:<server>:<prompt> [参数]  使用 MCP prompt 发起任务，参数可写为 key=value 或按顺序给出
 
 默认行为:
-  直接输入不以 / 开头的内容时，等价于 /agent <输入行>。`}
+  直接输入不以 / 开头的内容时，等价于 /agent <输入行>。
+  输入中的 @server:uri 会读取对应 MCP 资源并附加到消息中。
+  任务执行期间直接输入的内容会进入队列，在下一步 LLM 调用前注入当前任务，用于中途纠偏。
+  模型的思考内容默认折叠为一行摘要，按 ctrl+o 展开/折叠。`}
 }
@@ -18,6 +18,7 @@ import (
 	"github.com/rivo/uniseg"
 
 	"chase-code/server"
+	servertools "chase-code/server/tools"
 )
 
 const inputMaxLines = 10
@@ -34,9 +35,20 @@ type replModel struct {
 	initialInput       string
 	autoExitOnTurnDone bool
 
+	// turn 运行期间排队等待注入的用户输入，渲染在输入框上方。
+	queuedInputs []string
+	// 模型通过 update_plan 维护的任务清单，常驻在输入框上方。
+	plan []servertools.PlanItem
+
+	// 思考内容：流式阶段累积在 reasoningBuffer，结束后默认折叠为一行摘要，ctrl+o 切换展开。
+	reasoningBuffer   string
+	lastReasoning     string
+	reasoningExpanded bool
+
 	// 补全列表相关
-	allSuggestions []Suggestion
-	showList       bool
+	allSuggestions   []Suggestion
+	extraSuggestions SuggestionSource
+	showList         bool
 
 	// 流式 Markdown 渲染状态
 	streamBuffer             string // 原始流式 Markdown 累积内容
@@ -80,13 +92,15 @@ type replDispatchMsg struct {
 }
 
 // Run 启动基于 Bubble Tea 的交互终端（仅保留输入框渲染）。
-func Run(events <-chan server.Event, initialInput string, dispatcher Dispatcher, suggestions []Suggestion) error {
+// suggestions 为固定的命令补全项，extra 可选，用于追加运行期间变化的补全项。
+func Run(events <-chan server.Event, initialInput string, dispatcher Dispatcher, suggestions []Suggestion, extra SuggestionSource) error {
 	if events == nil {
 		return fmt.Errorf("事件通道未初始化")
 	}
 	imeCursor := newIMECursorTracker()
 	output := newIMECursorWriter(os.Stdout, imeCursor)
 	model := newReplModel(events, initialInput, dispatcher, suggestions, imeCursor)
+	model.extraSuggestions = extra
 	program := tea.NewProgram(model, tea.WithOutput(output))
 	_, err := program.Run()
 	return err
@@ -210,6 +224,8 @@ func (m replModel) handleKeyMsg(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 		return m, tea.Quit
 	case tea.KeyEnter:
 		return m.handleEnter()
+	case tea.KeyCtrlO:
+		return m.toggleReasoning()
 	}
 
 	return m.handleInputMsg(msg)
@@ -302,6 +318,15 @@ func (m replModel) View() string {
 
 	m.updateIMECursorTracker(true)
 	inputView := m.input.View()
+	if queued := m.queuedInputsView(); queued != "" {
+		inputView = lipgloss.JoinVertical(lipgloss.Left, queued, inputView)
+	}
+	if reasoning := m.reasoningPreviewView(); reasoning != "" {
+		inputView = lipgloss.JoinVertical(lipgloss.Left, reasoning, inputView)
+	}
+	if plan := m.planView(); plan != "" {
+		inputView = lipgloss.JoinVertical(lipgloss.Left, plan, inputView)
+	}
 	if !m.showList {
 		return inputView
 	}
@@ -312,6 +337,78 @@ func (m replModel) View() string {
 	return lipgloss.JoinVertical(lipgloss.Left, inputView, listView)
 }
 
+// queuedInputsView 渲染排队中的输入，位于输入框上方，不影响光标定位。
+func (m replModel) queuedInputsView() string {
+	if len(m.queuedInputs) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(m.queuedInputs))
+	for _, input := range m.queuedInputs {
+		line := strings.ReplaceAll(input, "\n", " ")
+		if m.windowWidth > 8 {
+			line = rw.Truncate(line, m.windowWidth-8, "…")
+		}
+		lines = append(lines, styleDim.Render("  排队中: "+line))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// planView 渲染当前任务清单，位于输入框与排队输入上方。
+func (m replModel) planView() string {
+	if len(m.plan) == 0 {
+		return ""
+	}
+	return strings.Join(formatPlanChecklist(m.plan, m.windowWidth), "\n")
+}
+
+// reasoningPreviewView 在思考流式输出期间显示最后一行作为进度提示。
+func (m replModel) reasoningPreviewView() string {
+	text := strings.TrimSpace(m.reasoningBuffer)
+	if text == "" {
+		return ""
+	}
+	lines := strings.Split(text, "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	if m.windowWidth > 12 {
+		line = rw.Truncate(line, m.windowWidth-12, "…")
+	}
+	return styleDim.Render("  思考中: " + line)
+}
+
+// toggleReasoning 切换思考内容的展开/折叠，展开时补打印最近一次的完整思考。
+func (m replModel) toggleReasoning() (tea.Model, tea.Cmd) {
+	m.reasoningExpanded = !m.reasoningExpanded
+	if m.reasoningExpanded && strings.TrimSpace(m.lastReasoning) != "" {
+		return m, printReplLinesCmd(formatReasoning(m.lastReasoning, true))
+	}
+	status := "[thinking] 思考内容将折叠显示（ctrl+o 展开）"
+	if m.reasoningExpanded {
+		status = "[thinking] 思考内容将完整显示（ctrl+o 折叠）"
+	}
+	return m, printReplLinesCmd([]string{styleDim.Render(status)})
+}
+
+// flushReasoning 在思考结束（收到其他事件）时输出思考内容。
+func (m *replModel) flushReasoning() []string {
+	text := strings.TrimSpace(m.reasoningBuffer)
+	m.reasoningBuffer = ""
+	if text == "" {
+		return nil
+	}
+	m.lastReasoning = text
+	return formatReasoning(text, m.reasoningExpanded)
+}
+
+// removeQueuedInput 移除第一条与 text 相同的排队输入。
+func (m *replModel) removeQueuedInput(text string) {
+	for i, input := range m.queuedInputs {
+		if input == text {
+			m.queuedInputs = append(m.queuedInputs[:i:i], m.queuedInputs[i+1:]...)
+			return
+		}
+	}
+}
+
 // updateIMECursorTracker 在渲染阶段同步真实光标位置，供输入法定位使用。
 func (m replModel) updateIMECursorTracker(active bool) {
 	if m.imeCursor == nil {
@@ -384,7 +481,11 @@ func (m *replModel) updateSuggestions() {
 	var matches []Suggestion
 	maxLen := 0
 
-	for _, cmd := range m.allSuggestions {
+	candidates := m.allSuggestions
+	if m.extraSuggestions != nil {
+		candidates = append(append([]Suggestion(nil), candidates...), m.extraSuggestions()...)
+	}
+	for _, cmd := range candidates {
 		matched := false
 		if strings.HasPrefix(cmd.Name(), prefix) {
 			matched = true
@@ -721,17 +822,54 @@ func printReplLinesCmd(lines []string) tea.Cmd {
 }
 
 // applyEvent 将事件写入终端输出并更新审批状态。
+// 思考增量只累积不输出，直到下一个其他事件到来时整体折叠/展开输出。
 func (m *replModel) applyEvent(ev server.Event) []string {
+	if ev.Kind == server.EventAgentReasoningDelta {
+		m.reasoningBuffer += ev.Message
+		return nil
+	}
+	lines := m.flushReasoning()
+	return append(lines, m.applyEventLines(ev)...)
+}
+
+// applyEventLines 处理非思考类事件。
+func (m *replModel) applyEventLines(ev server.Event) []string {
 	if ev.Kind == server.EventPatchApprovalRequest {
 		m.pendingApprovalID = ev.RequestID
 	}
 	if ev.Kind == server.EventPatchApprovalResult && ev.RequestID == m.pendingApprovalID {
 		m.pendingApprovalID = ""
 	}
+	if ev.Kind == server.EventToolApprovalRequest {
+		m.pendingApprovalID = ev.RequestID
+	}
+	if ev.Kind == server.EventToolApprovalResult && ev.RequestID == m.pendingApprovalID {
+		m.pendingApprovalID = ""
+	}
+	if ev.Kind == server.EventLoopDetected && ev.RequestID != "" {
+		m.pendingApprovalID = ev.RequestID
+	}
+	if ev.Kind == server.EventLoopDecision && ev.RequestID == m.pendingApprovalID {
+		m.pendingApprovalID = ""
+	}
 
 	switch ev.Kind {
+	case server.EventUserInputQueued:
+		m.queuedInputs = append(m.queuedInputs, ev.Message)
+		return nil
+	case server.EventUserInputInjected, server.EventUserInputDropped:
+		m.removeQueuedInput(ev.Message)
+	case server.EventPlanUpdated:
+		m.plan = ev.Plan
 	case server.EventAgentTextDelta:
 		return m.appendStreamDelta(ev.Message)
+	case server.EventAgentTextDiscarded:
+		// 已提交到滚动区的行无法撤回，只丢弃未提交的缓冲，并提示后续为重试输出。
+		committed := m.streamCommittedLineCount > 0
+		m.resetStreamState()
+		if !committed {
+			return nil
+		}
 	case server.EventAgentTextDone:
 		if m.streamActive {
 			lines := m.flushStreamFinal("")
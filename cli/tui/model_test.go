@@ -5,6 +5,9 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"chase-code/server"
+	servertools "chase-code/server/tools"
 )
 
 // streamLinesForTest 模拟流式渲染输出并返回去除 ANSI 的行。
@@ -138,3 +141,90 @@ func TestSanitizeLines_ComplexANSI(t *testing.T) {
 	// 如果这里失败，说明正则需要改进
 	assert.Equal(t, expected, actual)
 }
+
+// TestApplyEvent_QueuedInputs 验证排队输入在注入后从待处理列表中移除。
+func TestApplyEvent_QueuedInputs(t *testing.T) {
+	m := &replModel{}
+	m.applyEvent(server.Event{Kind: server.EventUserInputQueued, Message: "先别改测试"})
+	m.applyEvent(server.Event{Kind: server.EventUserInputQueued, Message: "用 go vet 检查"})
+	assert.Equal(t, []string{"先别改测试", "用 go vet 检查"}, m.queuedInputs)
+
+	lines := m.applyEvent(server.Event{Kind: server.EventUserInputInjected, Message: "先别改测试"})
+	assert.Equal(t, []string{"用 go vet 检查"}, m.queuedInputs)
+	assert.NotEmpty(t, lines)
+}
+
+// TestApplyEvent_PlanUpdated 验证计划事件会替换常驻清单，空计划会清空清单。
+func TestApplyEvent_PlanUpdated(t *testing.T) {
+	m := &replModel{}
+	plan := []servertools.PlanItem{
+		{Step: "阅读 session.go", Status: servertools.PlanStatusCompleted},
+		{Step: "实现 update_plan", Status: servertools.PlanStatusInProgress},
+		{Step: "补充测试", Status: servertools.PlanStatusPending},
+	}
+	lines := m.applyEvent(server.Event{Kind: server.EventPlanUpdated, Plan: plan})
+	assert.Equal(t, plan, m.plan)
+	assert.Len(t, lines, 1)
+	assert.Len(t, strings.Split(m.planView(), "\n"), 3)
+
+	m.applyEvent(server.Event{Kind: server.EventPlanUpdated})
+	assert.Empty(t, m.plan)
+	assert.Equal(t, "", m.planView())
+}
+
+// TestApplyEvent_ReasoningCollapsed 验证思考增量先累积，在下一个事件到来时折叠为一行输出。
+func TestApplyEvent_ReasoningCollapsed(t *testing.T) {
+	m := &replModel{}
+	assert.Nil(t, m.applyEvent(server.Event{Kind: server.EventAgentReasoningDelta, Message: "先读取"}))
+	assert.Nil(t, m.applyEvent(server.Event{Kind: server.EventAgentReasoningDelta, Message: " session.go"}))
+	assert.Contains(t, m.reasoningPreviewView(), "session.go")
+
+	lines := m.applyEvent(server.Event{Kind: server.EventTurnStarted})
+	assert.Equal(t, "", m.reasoningBuffer)
+	assert.Equal(t, "先读取 session.go", m.lastReasoning)
+	assert.Len(t, lines, 2)
+	assert.Contains(t, lines[0], "ctrl+o")
+
+	m.reasoningExpanded = true
+	m.applyEvent(server.Event{Kind: server.EventAgentReasoningDelta, Message: "第一行\n第二行"})
+	lines = m.applyEvent(server.Event{Kind: server.EventTurnStarted})
+	assert.Len(t, lines, 4)
+}
+
+// TestApplyEvent_TextDiscarded 验证中断的流式输出被丢弃，已提交的行之后附带重试提示。
+func TestApplyEvent_TextDiscarded(t *testing.T) {
+	m := &replModel{streamWrapWidth: 80}
+	assert.Empty(t, m.applyEvent(server.Event{Kind: server.EventAgentTextDelta, Message: "半句"}))
+	assert.Nil(t, m.applyEvent(server.Event{Kind: server.EventAgentTextDiscarded}))
+	assert.False(t, m.streamActive)
+	assert.Equal(t, "", m.streamBuffer)
+
+	assert.NotEmpty(t, m.applyEvent(server.Event{Kind: server.EventAgentTextDelta, Message: "第一行\n第二"}))
+	lines := m.applyEvent(server.Event{Kind: server.EventAgentTextDiscarded})
+	assert.Len(t, lines, 1)
+	assert.Contains(t, lines[0], "重试")
+	assert.Equal(t, "", m.streamBuffer)
+}
+
+// testSuggestion 为测试用的补全项。
+type testSuggestion string
+
+func (s testSuggestion) Name() string        { return string(s) }
+func (s testSuggestion) Description() string { return "" }
+func (s testSuggestion) Aliases() []string   { return nil }
+
+// TestUpdateSuggestions_ExtraSource 验证动态补全项在每次更新时重新读取，可以反映后台刷新的结果。
+func TestUpdateSuggestions_ExtraSource(t *testing.T) {
+	var extra []Suggestion
+	m := newReplModel(make(chan server.Event), "", nil, []Suggestion{testSuggestion("mcp")}, nil)
+	m.extraSuggestions = func() []Suggestion { return extra }
+
+	m.input.SetValue("/mcp")
+	m.updateSuggestions()
+	assert.Len(t, m.list.Items(), 1)
+
+	extra = []Suggestion{testSuggestion("mcp:docs:review")}
+	m.updateSuggestions()
+	assert.True(t, m.showList)
+	assert.Len(t, m.list.Items(), 2)
+}
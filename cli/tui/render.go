@@ -11,8 +11,10 @@ import (
 
 	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/lipgloss"
+	rw "github.com/mattn/go-runewidth"
 
 	"chase-code/server"
+	servertools "chase-code/server/tools"
 )
 
 const (
@@ -386,19 +388,93 @@ func formatEvent(ev server.Event) []string {
 		return formatTurnFinished(ev.Step, ev.Message)
 	case server.EventTurnError:
 		return formatTurnError(ev.Message)
+	case server.EventMaxStepsReached:
+		return formatMaxStepsReached(ev)
 	case server.EventToolOutputDelta:
 		return formatToolOutput(ev.ToolName, ev.Message)
 	case server.EventPatchApprovalRequest:
 		return formatPatchApprovalRequest(ev)
 	case server.EventPatchApprovalResult:
 		return formatPatchApprovalResult(ev)
+	case server.EventToolApprovalRequest:
+		return formatToolApprovalRequest(ev)
+	case server.EventToolApprovalResult:
+		return formatToolApprovalResult(ev)
 	case server.EventAgentTextDone:
 		return formatAgentText(ev.Message)
+	case server.EventModelFallback:
+		return formatModelFallback(ev.Message)
+	case server.EventAgentTextDiscarded:
+		return []string{styleDim.Render("[model] 以上输出未完成即中断，以下为重试后的回答")}
+	case server.EventUserInputInjected:
+		return formatUserInputInjected(ev.Message)
+	case server.EventUserInputDropped:
+		return []string{styleDim.Render("[queue] 会话已重置，已丢弃: " + ev.Message)}
+	case server.EventUserInputRejected:
+		return []string{styleError.Render("[queue] 输入未发送: " + ev.Message)}
+	case server.EventPlanUpdated:
+		return formatPlanUpdated(ev)
+	case server.EventLoopDetected:
+		return formatLoopDetected(ev)
+	case server.EventLoopDecision:
+		return formatLoopDecision(ev)
 	default:
 		return nil
 	}
 }
 
+// formatPlanUpdated 渲染计划更新摘要；完整清单常驻在输入框上方。
+func formatPlanUpdated(ev server.Event) []string {
+	if len(ev.Plan) == 0 {
+		return nil
+	}
+	completed := 0
+	for _, item := range ev.Plan {
+		if item.Status == servertools.PlanStatusCompleted {
+			completed++
+		}
+	}
+	line := fmt.Sprintf("[plan] 已更新 %d/%d 完成", completed, len(ev.Plan))
+	if explanation := strings.TrimSpace(ev.Message); explanation != "" {
+		line += ": " + explanation
+	}
+	return []string{styleCyan.Render(line)}
+}
+
+// formatPlanChecklist 将计划渲染为清单，每行一个步骤；maxWidth>0 时截断过长的步骤。
+func formatPlanChecklist(plan []servertools.PlanItem, maxWidth int) []string {
+	lines := make([]string, 0, len(plan))
+	for _, item := range plan {
+		step := strings.ReplaceAll(item.Step, "\n", " ")
+		if maxWidth > 6 {
+			step = rw.Truncate(step, maxWidth-6, "…")
+		}
+		switch item.Status {
+		case servertools.PlanStatusCompleted:
+			lines = append(lines, styleDim.Render("  [x] "+step))
+		case servertools.PlanStatusInProgress:
+			lines = append(lines, styleYellow.Render("  [~] "+step))
+		default:
+			lines = append(lines, "  [ ] "+step)
+		}
+	}
+	return lines
+}
+
+// formatReasoning 渲染思考内容：折叠时只输出一行摘要，展开时以暗色缩进输出全文。
+func formatReasoning(text string, expanded bool) []string {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if !expanded {
+		summary := fmt.Sprintf("[thinking] 已折叠 %d 字思考内容（ctrl+o 展开）", utf8.RuneCountInString(text))
+		return []string{styleDim.Render(summary)}
+	}
+	lines := []string{styleDim.Render("[thinking]")}
+	return append(lines, styleLines(indentLines(text, 2), styleDim)...)
+}
+
 // formatTurnStarted 渲染 turn 开始提示。
 func formatTurnStarted() []string {
 	return []string{styleMagenta.Render("[turn] 开始")}
@@ -415,6 +491,10 @@ func formatToolOutput(toolName, message string) []string {
 	if toolName == "apply_patch" {
 		return formatApplyPatchToolOutput(message)
 	}
+	if toolName == "update_plan" {
+		// 计划内容由 EventPlanUpdated 渲染为输入框上方的常驻清单。
+		return nil
+	}
 	lines := []string{styleDim.Render(fmt.Sprintf("    tool %s:", toolName))}
 	body := message
 	if !shouldShowFullToolOutput(toolName, message) {
@@ -737,6 +817,29 @@ func formatPatchApprovalResult(ev server.Event) []string {
 	return []string{styleDim.Render(fmt.Sprintf("[apply_patch] %s id=%s", ev.Message, ev.RequestID))}
 }
 
+// formatToolApprovalRequest 渲染远程（MCP）工具审批请求，展示来源 server、工具名与格式化后的参数。
+func formatToolApprovalRequest(ev server.Event) []string {
+	lines := []string{styleMagenta.Render(fmt.Sprintf("[MCP 工具审批请求] id=%s", ev.RequestID))}
+	if ev.Server != "" {
+		lines = append(lines, fmt.Sprintf("  server: %s", ev.Server))
+	}
+	lines = append(lines, fmt.Sprintf("  tool:   %s", ev.ToolName), "  参数:")
+	for _, l := range splitLines(ev.Message) {
+		lines = append(lines, "    "+l)
+	}
+	lines = append(lines, styleDim.Render(fmt.Sprintf("  直接输入 y 批准，a 本会话内始终允许该工具，s 拒绝；或使用 /approve %s [--always] / /reject %s。", ev.RequestID, ev.RequestID)))
+	return lines
+}
+
+// formatToolApprovalResult 渲染远程工具审批结果。
+func formatToolApprovalResult(ev server.Event) []string {
+	name := ev.ToolName
+	if ev.Server != "" {
+		name = ev.Server + "/" + ev.ToolName
+	}
+	return []string{styleDim.Render(fmt.Sprintf("[%s] %s id=%s", name, ev.Message, ev.RequestID))}
+}
+
 // formatAgentText 渲染最终回答内容。
 func formatAgentText(message string) []string {
 	if strings.TrimSpace(message) == "" {
@@ -746,6 +849,39 @@ func formatAgentText(message string) []string {
 	return splitLines(rendered)
 }
 
+// formatUserInputInjected 渲染排队输入被注入当前 turn 的提示。
+func formatUserInputInjected(message string) []string {
+	if strings.TrimSpace(message) == "" {
+		return nil
+	}
+	return []string{styleDim.Render("[queue] 已注入: " + message)}
+}
+
+// formatModelFallback 渲染切换备用模型提示。
+func formatModelFallback(message string) []string {
+	if strings.TrimSpace(message) == "" {
+		return nil
+	}
+	return []string{styleYellow.Render("[model] " + message)}
+}
+
+// formatLoopDetected 渲染重复工具调用警告，需要用户决定时附带操作提示。
+func formatLoopDetected(ev server.Event) []string {
+	lines := []string{styleYellow.Render("[loop] " + ev.Message)}
+	if ev.RequestID != "" {
+		lines = append(lines, styleDim.Render(fmt.Sprintf("  直接输入 y 继续执行，s 终止本次任务；或使用 /approve %s / /reject %s。", ev.RequestID, ev.RequestID)))
+	}
+	return lines
+}
+
+// formatLoopDecision 渲染用户对重复调用的决定。
+func formatLoopDecision(ev server.Event) []string {
+	if strings.TrimSpace(ev.Message) == "" {
+		return nil
+	}
+	return []string{styleDim.Render(fmt.Sprintf("[loop] %s id=%s", ev.Message, ev.RequestID))}
+}
+
 // formatTurnFinished 渲染 turn 结束提示。
 func formatTurnFinished(step int, message string) []string {
 	if message != "" {
@@ -754,6 +890,14 @@ func formatTurnFinished(step int, message string) []string {
 	return []string{styleMagenta.Render(fmt.Sprintf("[turn] 结束（step=%d）", step))}
 }
 
+// formatMaxStepsReached 渲染步数耗尽提示，并给出续跑选项。
+func formatMaxStepsReached(ev server.Event) []string {
+	return []string{
+		styleYellow.Render(fmt.Sprintf("[turn] %s（step=%d）", ev.Message, ev.Step)),
+		styleDim.Render("  直接输入 c 继续执行，/continue <n> 指定继续的步数，或输入新的指令。"),
+	}
+}
+
 // formatTurnError 渲染 turn 错误提示。
 func formatTurnError(message string) []string {
 	if strings.TrimSpace(message) == "" {
@@ -7,6 +7,10 @@ type Suggestion interface {
 	Aliases() []string
 }
 
+// SuggestionSource 返回动态补全项（如 MCP prompt），每次更新补全列表时在 UI 线程调用，
+// 实现方必须立即返回（通常是后台刷新的缓存），不能发起网络请求。
+type SuggestionSource func() []Suggestion
+
 // DispatchResult 包含命令执行后的 UI 反馈信息。
 type DispatchResult struct {
 	Lines []string // 要输出的文本行
@@ -3,11 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Config 汇总所有通过环境变量控制的运行配置。
@@ -28,14 +26,43 @@ type Config struct {
 	CocoModel          string
 	CocoBaseURL        string
 	ApplyPatchApproval string
+	LoopThreshold      string
+	LoopAction         string
+	MaxSteps           string
+	// AllowedCommands 非空时，shell 工具只允许执行以这些命令开头的命令。
+	AllowedCommands []string
+	// LLMCassette 非空时主模型经由 cassette 录制/回放（CHASE_CODE_LLM_CASSETTE），用于离线复现。
+	LLMCassette string
+	// LLMCassetteMode 为 record 或 replay（默认），对应 CHASE_CODE_LLM_CASSETTE_MODE。
+	LLMCassetteMode string
 
-	// 多模型配置支持
+	// 多模型配置支持（用户配置与项目配置合并后的结果）
 	LLMConfig *LLMConfig
+	// Files 为按优先级从低到高加载的配置文件。
+	Files []ConfigFile
+
+	// sources 记录各配置项的来源，见 Resolved。
+	sources map[string]string
 }
 
 type LLMConfig struct {
 	Model  ModelNameRef `yaml:"model"`
 	Models []Model      `yaml:"models"`
+	// Fallback 为当前模型不可用（网络错误或 5xx）时依次尝试的模型别名。
+	Fallback []string `yaml:"fallback,omitempty"`
+
+	// 以下配置项可由项目配置固定，环境变量优先。
+	// ApprovalMode 对应 CHASE_CODE_APPLY_PATCH_APPROVAL。
+	ApprovalMode string `yaml:"approval_mode,omitempty"`
+	// MCPConfig 对应 CHASE_CODE_MCP_CONFIG，相对路径基于 .chase-code 所在目录。
+	MCPConfig string `yaml:"mcp_config,omitempty"`
+	// MaxSteps 对应 CHASE_CODE_MAX_STEPS。
+	MaxSteps int `yaml:"max_steps,omitempty"`
+	// AllowedCommands 对应 CHASE_CODE_ALLOWED_COMMANDS（逗号分隔）。
+	AllowedCommands []string `yaml:"allowed_commands,omitempty"`
+
+	// Roles 将角色（agent/compact/title）映射到模型别名，未配置的角色使用主模型。
+	Roles map[string]string `yaml:"roles,omitempty"`
 }
 
 type ModelNameRef struct {
@@ -47,38 +74,143 @@ type Model struct {
 	Completions *CompletionsConfig `yaml:"completions,omitempty"`
 	Claude      *ClaudeConfig      `yaml:"claude,omitempty"`
 	Responses   *ResponsesConfig   `yaml:"responses,omitempty"`
+	Local       *LocalConfig       `yaml:"local,omitempty"`
+	// ReasoningEffort 为推理模型的思考强度（low/medium/high），留空则使用服务端默认值。
+	// claude 模型据此开启 extended thinking，并映射为对应的思考 token 预算。
+	ReasoningEffort string `yaml:"reasoning_effort,omitempty"`
+	// ReasoningSummary 控制 Responses API 是否返回思考摘要（auto/concise/detailed）。
+	ReasoningSummary string `yaml:"reasoning_summary,omitempty"`
+	// Vision 表示模型支持图片输入，开启后工具返回的图片（如 MCP 截图）会作为图片发送给模型。
+	Vision bool `yaml:"vision,omitempty"`
+	// GenerationParams 为该模型的生成参数，与 name 同级书写。
+	GenerationParams `yaml:",inline"`
+}
+
+// GenerationParams 描述单个模型的生成参数，未设置的字段不会下发，沿用服务端默认值。
+type GenerationParams struct {
+	Temperature *float64 `yaml:"temperature,omitempty"`
+	TopP        *float64 `yaml:"top_p,omitempty"`
+	// MaxTokens 为单次回复的最大输出 token 数，<=0 表示不限制。
+	MaxTokens         int64 `yaml:"max_tokens,omitempty"`
+	ParallelToolCalls *bool `yaml:"parallel_tool_calls,omitempty"`
+	// ToolChoice 为 auto/none/required，或直接填写工具名强制调用该工具。
+	ToolChoice string `yaml:"tool_choice,omitempty"`
+	Seed       *int64 `yaml:"seed,omitempty"`
+	// Headers 为每次请求附加的 HTTP 头。
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// ExtraBody 为合并进请求体的额外字段，用于厂商特有开关；key 支持 a.b 形式的嵌套路径。
+	ExtraBody map[string]any `yaml:"extra_body,omitempty"`
+}
+
+// Describe 返回生成参数的单行摘要（header 只列出名称，避免泄露凭证），未设置任何参数时返回空串。
+func (p GenerationParams) Describe() string {
+	var parts []string
+	if p.Temperature != nil {
+		parts = append(parts, fmt.Sprintf("temperature=%g", *p.Temperature))
+	}
+	if p.TopP != nil {
+		parts = append(parts, fmt.Sprintf("top_p=%g", *p.TopP))
+	}
+	if p.MaxTokens > 0 {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", p.MaxTokens))
+	}
+	if p.ParallelToolCalls != nil {
+		parts = append(parts, fmt.Sprintf("parallel_tool_calls=%t", *p.ParallelToolCalls))
+	}
+	if tc := strings.TrimSpace(p.ToolChoice); tc != "" {
+		parts = append(parts, "tool_choice="+tc)
+	}
+	if p.Seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *p.Seed))
+	}
+	if len(p.Headers) > 0 {
+		parts = append(parts, "headers="+strings.Join(SortedKeys(p.Headers), ","))
+	}
+	if len(p.ExtraBody) > 0 {
+		parts = append(parts, "extra_body="+strings.Join(SortedKeys(p.ExtraBody), ","))
+	}
+	return strings.Join(parts, " ")
+}
+
+// SortedKeys 返回 map 的有序 key 列表，保证展示与请求构造结果稳定。
+func SortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
 }
 
 type CompletionsConfig struct {
-	APIKey  string `yaml:"api_key"`
-	BaseURL string `yaml:"base_url"`
-	Model   string `yaml:"model"`
+	APIKey string `yaml:"api_key"`
+	// APIKeyCmd 在 api_key 为空时于加载配置时执行一次，取其输出作为密钥（如 pass show openai）。
+	APIKeyCmd string `yaml:"api_key_cmd,omitempty"`
+	BaseURL   string `yaml:"base_url"`
+	Model     string `yaml:"model"`
 }
 
 type ClaudeConfig struct {
-	APIKey  string `yaml:"api_key"`
-	BaseURL string `yaml:"base_url"`
-	Model   string `yaml:"model"`
+	APIKey    string `yaml:"api_key"`
+	APIKeyCmd string `yaml:"api_key_cmd,omitempty"`
+	BaseURL   string `yaml:"base_url"`
+	Model     string `yaml:"model"`
 }
 
 type ResponsesConfig struct {
-	APIKey  string `yaml:"api_key"`
-	BaseURL string `yaml:"base_url"`
-	Model   string `yaml:"model"`
+	APIKey    string `yaml:"api_key"`
+	APIKeyCmd string `yaml:"api_key_cmd,omitempty"`
+	BaseURL   string `yaml:"base_url"`
+	Model     string `yaml:"model"`
+	// State 控制多步调用时的上下文传递方式：
+	//   - 留空：每步完整回放历史（默认）；
+	//   - previous_response_id：服务端保存响应，每步只发送新增条目；
+	//   - encrypted_reasoning：store=false，并把加密的 reasoning 条目写入历史回放。
+	State string `yaml:"state,omitempty"`
+}
+
+// LocalConfig 描述本地推理服务（Ollama / llama.cpp server 等 OpenAI 兼容端点）。
+type LocalConfig struct {
+	APIKey    string `yaml:"api_key,omitempty"`
+	APIKeyCmd string `yaml:"api_key_cmd,omitempty"`
+	BaseURL   string `yaml:"base_url"`
+	// Model 为空时使用服务端模型列表中的第一个模型。
+	Model string `yaml:"model"`
+	// ToolMode 为 auto（默认，按探测结果选择）、native（function calling）或 prompt（文本 JSON 协议）。
+	ToolMode string `yaml:"tool_mode,omitempty"`
+	// ContextWindow 手动指定上下文窗口大小，>0 时覆盖探测结果。
+	ContextWindow int `yaml:"context_window,omitempty"`
 }
 
 var (
-	once sync.Once
-	cfg  Config
+	mu  sync.Mutex
+	cfg *Config
 )
 
 // Get 返回全局配置（延迟加载）。
 func Get() *Config {
-	once.Do(func() {
-		cfg = loadFromEnv()
-		cfg.LLMConfig = loadFromFile()
-	})
-	return &cfg
+	mu.Lock()
+	defer mu.Unlock()
+	if cfg == nil {
+		cfg = load()
+	}
+	return cfg
+}
+
+// Reload 重新读取环境变量与配置文件，之后的 Get 返回新配置；已持有旧指针的调用方不受影响。
+func Reload() *Config {
+	c := load()
+	mu.Lock()
+	cfg = c
+	mu.Unlock()
+	return c
+}
+
+// load 按 默认值 < 用户配置 < 项目配置 < 环境变量 的顺序构造配置。
+func load() *Config {
+	c := loadFromEnv()
+	c.applyFileSettings(loadLayeredFiles())
+	return &c
 }
 
 func loadFromEnv() Config {
@@ -98,39 +230,19 @@ func loadFromEnv() Config {
 		CocoModel:          strings.TrimSpace(os.Getenv("CHASE_CODE_COCO_MODEL")),
 		CocoBaseURL:        strings.TrimSpace(os.Getenv("CHASE_CODE_COCO_BASE_URL")),
 		ApplyPatchApproval: strings.TrimSpace(os.Getenv("CHASE_CODE_APPLY_PATCH_APPROVAL")),
+		LoopThreshold:      strings.TrimSpace(os.Getenv("CHASE_CODE_LOOP_THRESHOLD")),
+		LoopAction:         strings.TrimSpace(os.Getenv("CHASE_CODE_LOOP_ACTION")),
+		MaxSteps:           strings.TrimSpace(os.Getenv("CHASE_CODE_MAX_STEPS")),
+		AllowedCommands:    splitList(os.Getenv("CHASE_CODE_ALLOWED_COMMANDS")),
+		LLMCassette:        strings.TrimSpace(os.Getenv("CHASE_CODE_LLM_CASSETTE")),
+		LLMCassetteMode:    strings.TrimSpace(os.Getenv("CHASE_CODE_LLM_CASSETTE_MODE")),
 	}
 }
 
-func loadFromFile() *LLMConfig {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil
-	}
-
-	configPath := filepath.Join(home, ".chase-code", "config.yaml")
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		// 尝试读取 config.yml
-		configPath = filepath.Join(home, ".chase-code", "config.yml")
-		data, err = os.ReadFile(configPath)
-		if err != nil {
-			return nil
-		}
-	}
-
-	var llc LLMConfig
-	if err := yaml.Unmarshal(data, &llc); err != nil {
-		fmt.Fprintf(os.Stderr, "解析配置文件失败: %v\n", err)
-		return nil
-	}
-
-	return &llc
-}
-
 // Summary 返回可安全打印的配置摘要（会脱敏 key）。
 func (c Config) Summary() string {
 	s := fmt.Sprintf(
-		"llm_selector=%s mcp_config=%s log_file=%s openai_model=%s openai_base_url=%s openai_api_key=%s kimi_model=%s kimi_base_url=%s kimi_api_key=%s moonshot_api_key=%s coco_model=%s coco_base_url=%s coco_jwt_key=%s coco_cache_key=%s apply_patch_approval=%s",
+		"llm_selector=%s mcp_config=%s log_file=%s openai_model=%s openai_base_url=%s openai_api_key=%s kimi_model=%s kimi_base_url=%s kimi_api_key=%s moonshot_api_key=%s coco_model=%s coco_base_url=%s coco_jwt_key=%s coco_cache_key=%s apply_patch_approval=%s loop_threshold=%s loop_action=%s max_steps=%s",
 		emptyAsDefault(c.LLMProvider, "(default)"),
 		emptyAsDefault(c.MCPConfigPath, "(empty)"),
 		emptyAsDefault(c.LogFile, "(empty)"),
@@ -146,14 +258,32 @@ func (c Config) Summary() string {
 		maskSecret(c.CocoJWTKey),
 		maskSecret(c.CocoCacheKey),
 		emptyAsDefault(c.ApplyPatchApproval, "(default)"),
+		emptyAsDefault(c.LoopThreshold, "(default)"),
+		emptyAsDefault(c.LoopAction, "(default)"),
+		emptyAsDefault(c.MaxSteps, "(default)"),
 	)
 
+	s += fmt.Sprintf(" config_files=%d allowed_commands=%s", len(c.Files), emptyAsDefault(strings.Join(c.AllowedCommands, ","), "(all)"))
+	if c.LLMCassette != "" {
+		s += fmt.Sprintf(" llm_cassette=%s cassette_mode=%s", c.LLMCassette, emptyAsDefault(c.LLMCassetteMode, "replay"))
+	}
 	if c.LLMConfig != nil {
-		s += fmt.Sprintf(" file_model=%s models_count=%d", c.LLMConfig.Model.Name, len(c.LLMConfig.Models))
+		s += fmt.Sprintf(" file_model=%s models_count=%d fallback=%s", c.LLMConfig.Model.Name, len(c.LLMConfig.Models), emptyAsDefault(strings.Join(c.LLMConfig.Fallback, ","), "(empty)"))
 	}
 	return s
 }
 
+// splitList 解析逗号分隔的列表，忽略空项。
+func splitList(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
 func emptyAsDefault(v, def string) string {
 	if strings.TrimSpace(v) == "" {
 		return def
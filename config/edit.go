@@ -0,0 +1,139 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SaveUserModel 将模型条目写入用户配置文件的 models 列表，同名条目会被替换。
+// 通过 yaml.Node 编辑，保留文件中的其它配置与注释；返回写入的文件路径。
+func SaveUserModel(m Model) (string, error) {
+	if strings.TrimSpace(m.Name) == "" {
+		return "", fmt.Errorf("模型名称不能为空")
+	}
+	path := userConfigPath()
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("获取用户目录失败: %w", err)
+		}
+		path = filepath.Join(home, configDirName, "config.yaml")
+	}
+
+	doc, err := readConfigDocument(path)
+	if err != nil {
+		return "", err
+	}
+
+	var item yaml.Node
+	if err := item.Encode(m); err != nil {
+		return "", fmt.Errorf("序列化模型配置失败: %w", err)
+	}
+	models := mappingValue(doc.Content[0], "models", yaml.SequenceNode)
+	if models.Kind != yaml.SequenceNode {
+		return "", fmt.Errorf("配置文件 %s 中的 models 不是列表", path)
+	}
+	replaced := false
+	for i, existing := range models.Content {
+		if name := mappingValue(existing, "name", 0); name != nil && name.Value == m.Name {
+			models.Content[i] = &item
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		models.Content = append(models.Content, &item)
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	// 编码整个 document 节点：文件头部、尾部的注释挂在 document 上，只编码根 mapping 会丢失。
+	if err := enc.Encode(doc); err != nil {
+		return "", fmt.Errorf("序列化配置文件失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	if err := WritePrivateFile(path, buf.Bytes()); err != nil {
+		return "", fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	return path, nil
+}
+
+// WritePrivateFile 以 0600 权限写入文件：先写同目录下的临时文件再重命名替换。
+// 配置中可能含有密钥，而 os.WriteFile 不会修改已存在文件的权限，直接覆盖会沿用原来的宽松权限。
+func WritePrivateFile(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readConfigDocument 读取配置文件的 document 节点，其 Content[0] 为根 mapping；
+// 文件不存在或为空时返回只含空 mapping 的新 document。
+func readConfigDocument(path string) (*yaml.Node, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	var doc yaml.Node
+	if len(bytes.TrimSpace(data)) > 0 {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("解析配置文件失败: %w", err)
+		}
+	}
+	if doc.Kind == yaml.DocumentNode && len(doc.Content) > 0 {
+		if doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("配置文件 %s 顶层不是 mapping", path)
+		}
+		return &doc, nil
+	}
+	return &yaml.Node{
+		Kind:    yaml.DocumentNode,
+		Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+	}, nil
+}
+
+// mappingValue 返回 mapping 中 key 对应的值节点；kind 非 0 且 key 不存在时创建该类型的空节点。
+func mappingValue(m *yaml.Node, key string, kind yaml.Kind) *yaml.Node {
+	if m == nil || m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value != key {
+			continue
+		}
+		value := m.Content[i+1]
+		if kind != 0 && value.Kind == yaml.ScalarNode && value.Tag == "!!null" {
+			// `models:` 未填写内容时解析为 null，替换为空节点以便追加。
+			*value = yaml.Node{Kind: kind}
+		}
+		return value
+	}
+	if kind == 0 {
+		return nil
+	}
+	value := &yaml.Node{Kind: kind}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+	return value
+}
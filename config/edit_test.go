@@ -0,0 +1,39 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveUserModel_KeepsCommentsAndRestrictsMode(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, configDirName)
+	require.NoError(t, os.MkdirAll(dir, 0o755))
+	path := filepath.Join(dir, "config.yaml")
+	original := "# chase-code 用户配置\n\napproval_mode: ask # 行尾注释\nmodels:\n  - name: old\n    completions:\n      model: gpt-4o\n# 文件末尾注释\n"
+	require.NoError(t, os.WriteFile(path, []byte(original), 0o644))
+
+	got, err := SaveUserModel(Model{Name: "new", Claude: &ClaudeConfig{APIKey: "sk-secret", Model: "claude"}})
+	require.NoError(t, err)
+	assert.Equal(t, path, got)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	text := string(data)
+	for _, want := range []string{"# chase-code 用户配置", "# 行尾注释", "# 文件末尾注释", "name: old", "name: new", "sk-secret"} {
+		assert.Contains(t, text, want)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "已存在的配置文件写入密钥后应收紧为 0600")
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "不应残留临时文件")
+}
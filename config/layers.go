@@ -0,0 +1,451 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 配置来源，优先级从低到高：默认值 < 用户配置 < 项目配置 < 环境变量。
+const (
+	SourceDefault = "default"
+	SourceUser    = "user"
+	SourceProject = "project"
+	SourceEnv     = "env"
+)
+
+// configDirName 为用户与项目配置所在的目录名。
+const configDirName = ".chase-code"
+
+// mcpConfigFileName 为未显式指定 mcp_config 时使用的默认 MCP 配置文件名。
+const mcpConfigFileName = "mcp.json"
+
+// ConfigFile 描述一个已加载的配置文件。
+type ConfigFile struct {
+	Path   string
+	Source string
+}
+
+// ResolvedValue 为合并后的单个配置项及其来源，供 `config show --resolved` 展示。
+type ResolvedValue struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// layeredFiles 为按优先级合并后的文件配置。
+type layeredFiles struct {
+	merged  *LLMConfig
+	files   []ConfigFile
+	sources map[string]string
+}
+
+// loadLayeredFiles 依次加载用户配置与项目配置（从 git 根目录到 cwd，越近优先级越高）并合并。
+func loadLayeredFiles() layeredFiles {
+	out := layeredFiles{sources: make(map[string]string)}
+
+	userPath := userConfigPath()
+	if userPath != "" {
+		out.apply(userPath, SourceUser)
+	}
+	for _, path := range projectConfigPaths() {
+		if sameFile(path, userPath) {
+			continue
+		}
+		out.apply(path, SourceProject)
+	}
+	return out
+}
+
+// apply 加载单个配置文件并合并到已有结果之上。
+func (l *layeredFiles) apply(path, source string) {
+	llc, err := loadConfigFile(path, source == SourceUser)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "解析配置文件失败 %s: %v\n", path, err)
+		return
+	}
+	if llc == nil {
+		return
+	}
+	if source == SourceProject && !IsProjectTrusted(ProjectRoot()) {
+		dropUntrustedProjectSettings(llc, path)
+	}
+	l.files = append(l.files, ConfigFile{Path: path, Source: source})
+	label := source + " " + path
+	if l.merged == nil {
+		l.merged = &LLMConfig{}
+	}
+	l.merged.mergeFrom(llc, label, l.sources)
+}
+
+// dropUntrustedProjectSettings 清除未信任项目配置中影响审批、命令白名单与请求目的地的配置项并给出提示：
+// 否则仓库可以放开审批，或把模型的 base_url 指向自己的地址，使代码与上下文被发送到仓库指定的服务端。
+func dropUntrustedProjectSettings(llc *LLMConfig, path string) {
+	var ignored []string
+	if strings.TrimSpace(llc.Model.Name) != "" {
+		ignored = append(ignored, "model")
+		llc.Model = ModelNameRef{}
+	}
+	if len(llc.Models) > 0 {
+		ignored = append(ignored, "models")
+		llc.Models = nil
+	}
+	if len(llc.Fallback) > 0 {
+		ignored = append(ignored, "fallback")
+		llc.Fallback = nil
+	}
+	if strings.TrimSpace(llc.ApprovalMode) != "" {
+		ignored = append(ignored, "approval_mode")
+		llc.ApprovalMode = ""
+	}
+	if len(llc.AllowedCommands) > 0 {
+		ignored = append(ignored, "allowed_commands")
+		llc.AllowedCommands = nil
+	}
+	if len(llc.Roles) > 0 {
+		ignored = append(ignored, "roles")
+		llc.Roles = nil
+	}
+	if len(ignored) > 0 {
+		fmt.Fprintf(os.Stderr, "忽略项目配置 %s 中的 %s：该项目未被信任，确认可信后运行 chase-code mcp trust\n", path, strings.Join(ignored, ", "))
+	}
+}
+
+// mergeFrom 将 other 中设置过的字段覆盖到 c 上；models 按 name 整条替换或追加。
+func (c *LLMConfig) mergeFrom(other *LLMConfig, label string, sources map[string]string) {
+	if name := strings.TrimSpace(other.Model.Name); name != "" {
+		c.Model.Name = name
+		sources["model"] = label
+	}
+	for _, m := range other.Models {
+		key := "models." + m.Name
+		replaced := false
+		for i := range c.Models {
+			if c.Models[i].Name == m.Name {
+				c.Models[i] = m
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			c.Models = append(c.Models, m)
+		}
+		sources[key] = label
+	}
+	if len(other.Fallback) > 0 {
+		c.Fallback = other.Fallback
+		sources["fallback"] = label
+	}
+	if v := strings.TrimSpace(other.ApprovalMode); v != "" {
+		c.ApprovalMode = v
+		sources["approval_mode"] = label
+	}
+	if v := strings.TrimSpace(other.MCPConfig); v != "" {
+		c.MCPConfig = v
+		sources["mcp_config"] = label
+	}
+	if other.MaxSteps > 0 {
+		c.MaxSteps = other.MaxSteps
+		sources["max_steps"] = label
+	}
+	if len(other.AllowedCommands) > 0 {
+		c.AllowedCommands = other.AllowedCommands
+		sources["allowed_commands"] = label
+	}
+	for role, alias := range other.Roles {
+		if alias = strings.TrimSpace(alias); alias == "" {
+			continue
+		}
+		if c.Roles == nil {
+			c.Roles = make(map[string]string)
+		}
+		c.Roles[role] = alias
+		sources["roles."+role] = label
+	}
+}
+
+// loadConfigFile 读取并解析单个配置文件，文件不存在时返回 nil。
+// 项目配置来自仓库内容，不可信：不执行 api_key_cmd，也不展开 ${VAR}，
+// 避免打开仓库即执行任意命令，或把本机环境变量中的密钥经仓库指定的 base_url/headers 发送出去。
+func loadConfigFile(path string, trusted bool) (*LLMConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// 先解析为节点树展开 ${VAR}，避免密钥以明文形式写在配置文件里。
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if trusted {
+		expandYAMLNode(&doc)
+	} else if envRefPattern.Match(data) {
+		fmt.Fprintf(os.Stderr, "项目配置 %s 中的 ${VAR} 不会展开：仅用户配置允许引用环境变量\n", path)
+	}
+
+	var llc LLMConfig
+	if err := doc.Decode(&llc); err != nil {
+		return nil, err
+	}
+	if trusted {
+		resolveAPIKeyCommands(&llc)
+	} else {
+		dropAPIKeyCommands(&llc, path)
+	}
+	llc.MCPConfig = resolveConfigRelativePath(path, llc.MCPConfig)
+	return &llc, nil
+}
+
+// dropAPIKeyCommands 清除项目配置中的 api_key_cmd 并给出提示。
+func dropAPIKeyCommands(llc *LLMConfig, path string) {
+	for i := range llc.Models {
+		for _, secret := range llc.Models[i].apiKeyFields() {
+			if strings.TrimSpace(*secret.cmd) == "" {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "忽略项目配置 %s 中模型 %s 的 api_key_cmd：仅用户配置允许执行命令\n", path, llc.Models[i].Name)
+			*secret.cmd = ""
+		}
+	}
+}
+
+// resolveConfigRelativePath 将配置中的相对路径解析为相对 .chase-code 所在目录（项目根或 home）的绝对路径。
+func resolveConfigRelativePath(configPath, p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	return filepath.Join(filepath.Dir(filepath.Dir(configPath)), p)
+}
+
+// userConfigPath 返回用户配置文件路径，优先 config.yaml，其次 config.yml。
+func userConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return findConfigFile(filepath.Join(home, configDirName))
+}
+
+// projectConfigPaths 从 cwd 向上查找到 git 根目录，按从根到 cwd 的顺序返回存在的项目配置文件。
+// 不在 git 仓库中时只检查 cwd。
+func projectConfigPaths() []string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil
+	}
+
+	dirs := []string{cwd}
+	for dir := cwd; !isGitRoot(dir); {
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// 一直找到文件系统根目录都不是 git 仓库，退化为只检查 cwd。
+			dirs = []string{cwd}
+			break
+		}
+		dir = parent
+		dirs = append(dirs, dir)
+	}
+
+	var out []string
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if path := findConfigFile(filepath.Join(dirs[i], configDirName)); path != "" {
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+// UserMCPConfigPath 返回用户级默认 MCP 配置路径 ~/.chase-code/mcp.json（文件不一定存在）。
+func UserMCPConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, configDirName, mcpConfigFileName)
+}
+
+// ProjectMCPConfigPath 返回项目级默认 MCP 配置路径：项目根目录下的 .chase-code/mcp.json。
+func ProjectMCPConfigPath() string {
+	root := ProjectRoot()
+	if root == "" {
+		return ""
+	}
+	return filepath.Join(root, configDirName, mcpConfigFileName)
+}
+
+// ProjectRoot 返回当前项目根目录：cwd 所在的 git 根目录，不在仓库中时为 cwd。
+func ProjectRoot() string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for dir := cwd; ; {
+		if isGitRoot(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return cwd
+		}
+		dir = parent
+	}
+}
+
+// findConfigFile 在目录中查找 config.yaml 或 config.yml。
+func findConfigFile(dir string) string {
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		path := filepath.Join(dir, name)
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// isGitRoot 判断目录下是否存在 .git（目录或 worktree 文件）。
+func isGitRoot(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// sameFile 判断两个路径是否指向同一个文件。
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// applyFileSettings 在对应环境变量未设置时使用文件配置，并记录每个配置项的来源。
+func (c *Config) applyFileSettings(files layeredFiles) {
+	c.Files = files.files
+	c.LLMConfig = files.merged
+	c.sources = files.sources
+
+	var fc LLMConfig
+	if files.merged != nil {
+		fc = *files.merged
+	}
+	maxSteps := ""
+	if fc.MaxSteps > 0 {
+		maxSteps = strconv.Itoa(fc.MaxSteps)
+	}
+	c.layerSetting("approval_mode", "CHASE_CODE_APPLY_PATCH_APPROVAL", &c.ApplyPatchApproval, fc.ApprovalMode)
+	c.layerSetting("mcp_config", "CHASE_CODE_MCP_CONFIG", &c.MCPConfigPath, fc.MCPConfig)
+	c.layerSetting("max_steps", "CHASE_CODE_MAX_STEPS", &c.MaxSteps, maxSteps)
+
+	if len(c.AllowedCommands) > 0 {
+		c.sources["allowed_commands"] = SourceEnv + " CHASE_CODE_ALLOWED_COMMANDS"
+	} else {
+		c.AllowedCommands = fc.AllowedCommands
+	}
+	if c.LLMProvider != "" {
+		c.sources["model"] = SourceEnv + " CHASE_CODE_LLM_PROVIDER"
+	}
+}
+
+// layerSetting 环境变量已设置时记录其来源，否则回退到文件中的值（来源已在合并时记录）。
+func (c *Config) layerSetting(key, envName string, target *string, fileValue string) {
+	if *target != "" {
+		c.sources[key] = SourceEnv + " " + envName
+		return
+	}
+	*target = fileValue
+}
+
+// MCPConfigFromProject 表示 mcp_config 由项目配置文件指定，此时该 MCP 配置与项目 mcp.json 同样需要用户信任。
+func (c *Config) MCPConfigFromProject() bool {
+	return strings.HasPrefix(c.sourceOf("mcp_config"), SourceProject+" ")
+}
+
+// sourceOf 返回配置项来源，未记录时视为默认值。
+func (c *Config) sourceOf(key string) string {
+	if src, ok := c.sources[key]; ok {
+		return src
+	}
+	return SourceDefault
+}
+
+// Resolved 返回合并后的主要配置项及其来源，密钥类字段会脱敏。
+func (c *Config) Resolved() []ResolvedValue {
+	var fc LLMConfig
+	if c.LLMConfig != nil {
+		fc = *c.LLMConfig
+	}
+
+	model := c.LLMProvider
+	if model == "" {
+		model = fc.Model.Name
+	}
+	out := []ResolvedValue{
+		{Key: "model", Value: emptyAsDefault(model, "(default)"), Source: c.sourceOf("model")},
+		{Key: "fallback", Value: emptyAsDefault(strings.Join(fc.Fallback, ","), "(empty)"), Source: c.sourceOf("fallback")},
+		{Key: "approval_mode", Value: emptyAsDefault(c.ApplyPatchApproval, "auto"), Source: c.sourceOf("approval_mode")},
+		{Key: "mcp_config", Value: emptyAsDefault(c.MCPConfigPath, "(empty)"), Source: c.sourceOf("mcp_config")},
+		{Key: "max_steps", Value: emptyAsDefault(c.MaxSteps, "(default)"), Source: c.sourceOf("max_steps")},
+		{Key: "allowed_commands", Value: emptyAsDefault(strings.Join(c.AllowedCommands, ","), "(all)"), Source: c.sourceOf("allowed_commands")},
+	}
+
+	for _, role := range SortedKeys(fc.Roles) {
+		key := "roles." + role
+		out = append(out, ResolvedValue{Key: key, Value: fc.Roles[role], Source: c.sourceOf(key)})
+	}
+
+	names := make([]string, 0, len(fc.Models))
+	byName := make(map[string]Model, len(fc.Models))
+	for _, m := range fc.Models {
+		names = append(names, m.Name)
+		byName[m.Name] = m
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := "models." + name
+		out = append(out, ResolvedValue{Key: key, Value: byName[name].summary(), Source: c.sourceOf(key)})
+	}
+	return out
+}
+
+// summary 返回模型条目的脱敏摘要。
+func (m Model) summary() string {
+	var kind, model, baseURL, apiKey string
+	switch {
+	case m.Completions != nil:
+		kind, model, baseURL, apiKey = "completions", m.Completions.Model, m.Completions.BaseURL, m.Completions.APIKey
+	case m.Claude != nil:
+		kind, model, baseURL, apiKey = "claude", m.Claude.Model, m.Claude.BaseURL, m.Claude.APIKey
+	case m.Responses != nil:
+		kind, model, baseURL, apiKey = "responses", m.Responses.Model, m.Responses.BaseURL, m.Responses.APIKey
+	case m.Local != nil:
+		kind, model, baseURL, apiKey = "local", m.Local.Model, m.Local.BaseURL, m.Local.APIKey
+	default:
+		return "(empty)"
+	}
+	s := fmt.Sprintf("%s model=%s base_url=%s api_key=%s", kind, emptyAsDefault(model, "(default)"), emptyAsDefault(baseURL, "(default)"), maskSecret(apiKey))
+	if params := m.Describe(); params != "" {
+		s += " " + params
+	}
+	return s
+}
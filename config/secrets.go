@@ -0,0 +1,112 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// apiKeyCmdTimeout 为 api_key_cmd 的最长执行时间。
+const apiKeyCmdTimeout = 10 * time.Second
+
+// envRefPattern 匹配 ${VAR} 形式的环境变量引用；不处理 $VAR，避免误伤包含 $ 的普通字符串。
+var envRefPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// ExpandEnvRefs 将字符串中的 ${VAR} 替换为对应环境变量，未设置的变量替换为空串。
+func ExpandEnvRefs(s string) string {
+	if !strings.Contains(s, "${") {
+		return s
+	}
+	return envRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRefPattern.FindStringSubmatch(ref)[1])
+	})
+}
+
+// expandYAMLNode 递归展开 YAML 文档中所有字符串标量里的 ${VAR}。
+func expandYAMLNode(n *yaml.Node) {
+	if n == nil {
+		return
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!str" {
+		n.Value = ExpandEnvRefs(n.Value)
+	}
+	for _, child := range n.Content {
+		expandYAMLNode(child)
+	}
+}
+
+// resolveAPIKeyCommands 为配置了 api_key_cmd 且未直接填写 api_key 的模型执行命令获取密钥。
+// 命令失败时只输出警告，该模型会因缺少密钥而在调用时报错。
+func resolveAPIKeyCommands(llc *LLMConfig) {
+	for i := range llc.Models {
+		m := &llc.Models[i]
+		for _, secret := range m.apiKeyFields() {
+			if strings.TrimSpace(*secret.key) != "" || strings.TrimSpace(*secret.cmd) == "" {
+				continue
+			}
+			key, err := runAPIKeyCommand(*secret.cmd)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "模型 %s 执行 api_key_cmd 失败: %v\n", m.Name, err)
+				continue
+			}
+			*secret.key = key
+		}
+	}
+}
+
+// apiKeyField 指向某个 provider 配置中的 api_key 与 api_key_cmd。
+type apiKeyField struct {
+	key *string
+	cmd *string
+}
+
+// apiKeyFields 返回模型中已配置的 provider 的密钥字段。
+func (m *Model) apiKeyFields() []apiKeyField {
+	var out []apiKeyField
+	if m.Completions != nil {
+		out = append(out, apiKeyField{key: &m.Completions.APIKey, cmd: &m.Completions.APIKeyCmd})
+	}
+	if m.Claude != nil {
+		out = append(out, apiKeyField{key: &m.Claude.APIKey, cmd: &m.Claude.APIKeyCmd})
+	}
+	if m.Responses != nil {
+		out = append(out, apiKeyField{key: &m.Responses.APIKey, cmd: &m.Responses.APIKeyCmd})
+	}
+	if m.Local != nil {
+		out = append(out, apiKeyField{key: &m.Local.APIKey, cmd: &m.Local.APIKeyCmd})
+	}
+	return out
+}
+
+// runAPIKeyCommand 通过 shell 执行命令，返回标准输出的第一行（兼容 pass 等多行输出的工具）。
+func runAPIKeyCommand(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiKeyCmdTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("命令超时（%s）", apiKeyCmdTimeout)
+	}
+	if err != nil {
+		return "", err
+	}
+	key, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("命令输出为空")
+	}
+	return key, nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandEnvRefs(t *testing.T) {
+	t.Setenv("CHASE_TEST_KEY", "sk-123")
+	t.Setenv("CHASE_TEST_EMPTY", "")
+
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain", in: "sk-plain", want: "sk-plain"},
+		{name: "whole value", in: "${CHASE_TEST_KEY}", want: "sk-123"},
+		{name: "embedded", in: "Bearer ${CHASE_TEST_KEY}!", want: "Bearer sk-123!"},
+		{name: "repeated", in: "${CHASE_TEST_KEY}/${CHASE_TEST_KEY}", want: "sk-123/sk-123"},
+		{name: "unset becomes empty", in: "a${CHASE_TEST_UNSET_VAR}b", want: "ab"},
+		{name: "empty var", in: "${CHASE_TEST_EMPTY}", want: ""},
+		{name: "bare dollar kept", in: "$CHASE_TEST_KEY", want: "$CHASE_TEST_KEY"},
+		{name: "invalid name kept", in: "${1ABC}", want: "${1ABC}"},
+		{name: "unterminated kept", in: "${CHASE_TEST_KEY", want: "${CHASE_TEST_KEY"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ExpandEnvRefs(tc.in))
+		})
+	}
+}
+
+func TestRunAPIKeyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("依赖 sh")
+	}
+
+	cases := []struct {
+		name    string
+		command string
+		want    string
+		wantErr bool
+	}{
+		{name: "single line", command: "echo sk-abc", want: "sk-abc"},
+		{name: "first line of multi-line output", command: "printf '  sk-first  \\nlogin: me\\n'", want: "sk-first"},
+		{name: "leading blank lines trimmed", command: "printf '\\n\\nsk-late\\n'", want: "sk-late"},
+		{name: "empty output", command: "true", wantErr: true},
+		{name: "non-zero exit", command: "echo sk-abc; exit 3", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := runAPIKeyCommand(tc.command)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestResolveAPIKeyCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("依赖 sh")
+	}
+	llc := &LLMConfig{Models: []Model{
+		{Name: "cmd", Claude: &ClaudeConfig{APIKeyCmd: "echo sk-from-cmd"}},
+		{Name: "direct", Responses: &ResponsesConfig{APIKey: "sk-direct", APIKeyCmd: "echo ignored"}},
+		{Name: "failing", Completions: &CompletionsConfig{APIKeyCmd: "exit 1"}},
+	}}
+
+	resolveAPIKeyCommands(llc)
+
+	assert.Equal(t, "sk-from-cmd", llc.Models[0].Claude.APIKey)
+	assert.Equal(t, "sk-direct", llc.Models[1].Responses.APIKey, "已填写 api_key 时不执行命令")
+	assert.Empty(t, llc.Models[2].Completions.APIKey)
+}
@@ -0,0 +1,110 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// trustedProjectsFileName 为用户信任的项目列表，位于 ~/.chase-code 下。
+// 只有列在其中的项目，其 .chase-code/mcp.json 才会被加载（会启动其中的命令、连接其中的地址）。
+const trustedProjectsFileName = "trusted_projects.json"
+
+// trustedProjects 为 trusted_projects.json 的内容。
+type trustedProjects struct {
+	Projects []string `json:"projects"`
+}
+
+// trustedProjectsPath 返回信任列表文件路径。
+func trustedProjectsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, configDirName, trustedProjectsFileName), nil
+}
+
+// normalizeProjectDir 返回用于比较的项目目录：绝对路径，并尽量解析符号链接。
+func normalizeProjectDir(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	return filepath.Clean(abs), nil
+}
+
+// loadTrustedProjects 读取信任列表，文件不存在时返回空列表。
+func loadTrustedProjects(path string) (*trustedProjects, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &trustedProjects{}, nil
+		}
+		return nil, fmt.Errorf("读取信任项目列表失败: %w", err)
+	}
+	var tp trustedProjects
+	if err := json.Unmarshal(data, &tp); err != nil {
+		return nil, fmt.Errorf("解析信任项目列表 %s 失败: %w", path, err)
+	}
+	return &tp, nil
+}
+
+// IsProjectTrusted 判断项目目录是否在用户的信任列表中，读取失败时视为不信任。
+func IsProjectTrusted(dir string) bool {
+	if dir == "" {
+		return false
+	}
+	path, err := trustedProjectsPath()
+	if err != nil {
+		return false
+	}
+	want, err := normalizeProjectDir(dir)
+	if err != nil {
+		return false
+	}
+	tp, err := loadTrustedProjects(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return false
+	}
+	return slices.Contains(tp.Projects, want)
+}
+
+// SetProjectTrusted 将项目目录加入（trusted 为 false 时移出）用户的信任列表。
+func SetProjectTrusted(dir string, trusted bool) error {
+	path, err := trustedProjectsPath()
+	if err != nil {
+		return err
+	}
+	target, err := normalizeProjectDir(dir)
+	if err != nil {
+		return err
+	}
+	tp, err := loadTrustedProjects(path)
+	if err != nil {
+		return err
+	}
+	tp.Projects = slices.DeleteFunc(tp.Projects, func(p string) bool { return p == target })
+	if trusted {
+		tp.Projects = append(tp.Projects, target)
+		slices.Sort(tp.Projects)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("创建配置目录失败: %w", err)
+	}
+	data, err := json.MarshalIndent(tp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("写入信任项目列表失败: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetProjectTrusted(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	project := t.TempDir()
+	other := t.TempDir()
+
+	assert.False(t, IsProjectTrusted(project), "默认不信任任何项目")
+	assert.False(t, IsProjectTrusted(""))
+
+	require.NoError(t, SetProjectTrusted(project, true))
+	require.NoError(t, SetProjectTrusted(project, true), "重复信任不产生重复条目")
+	assert.True(t, IsProjectTrusted(project))
+	assert.True(t, IsProjectTrusted(filepath.Join(project, ".")), "路径规范化后比较")
+	assert.False(t, IsProjectTrusted(other))
+
+	tp, err := loadTrustedProjects(filepath.Join(home, configDirName, trustedProjectsFileName))
+	require.NoError(t, err)
+	assert.Len(t, tp.Projects, 1)
+
+	require.NoError(t, SetProjectTrusted(project, false))
+	assert.False(t, IsProjectTrusted(project))
+}
+
+func TestIsProjectTrusted_Symlink(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	project := t.TempDir()
+	link := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(project, link); err != nil {
+		t.Skipf("无法创建符号链接: %v", err)
+	}
+
+	require.NoError(t, SetProjectTrusted(link, true))
+	assert.True(t, IsProjectTrusted(project), "通过符号链接信任的项目按真实路径匹配")
+}
+
+func TestMCPConfigFromProject(t *testing.T) {
+	cases := []struct {
+		name   string
+		source string
+		want   bool
+	}{
+		{name: "project file", source: SourceProject + " /repo/.chase-code/config.yaml", want: true},
+		{name: "user file", source: SourceUser + " /home/me/.chase-code/config.yaml"},
+		{name: "env", source: SourceEnv + " CHASE_CODE_MCP_CONFIG"},
+		{name: "unset"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &Config{sources: map[string]string{}}
+			if tc.source != "" {
+				c.sources["mcp_config"] = tc.source
+			}
+			assert.Equal(t, tc.want, c.MCPConfigFromProject())
+		})
+	}
+}
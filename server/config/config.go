@@ -1,6 +1,10 @@
 package config
 
-import "chase-code/config"
+import (
+	"strconv"
+
+	"chase-code/config"
+)
 
 // ApprovalMode 控制工具相关操作（目前主要是 apply_patch）的审批行为。
 // 借鉴 codex-rs 中 SessionConfiguration 的思想，这里提供三种模式：
@@ -20,16 +24,40 @@ type ToolApprovalConfig struct {
 	ApplyPatch ApprovalMode
 }
 
+// LoopAction 控制检测到重复工具调用（doom loop）后的处理方式：
+//   - LoopActionInject: 向上下文注入一条纠正提示后继续执行；
+//   - LoopActionAsk: 暂停 turn 并询问用户是否继续。
+type LoopAction string
+
+const (
+	LoopActionInject LoopAction = "inject"
+	LoopActionAsk    LoopAction = "ask"
+)
+
+// DefaultLoopThreshold 是同一工具调用签名在单个 turn 内允许重复的默认次数。
+const DefaultLoopThreshold = 3
+
+// LoopDetectionConfig 描述重复工具调用检测的阈值与处理方式。
+type LoopDetectionConfig struct {
+	// Threshold 为同一签名（工具名+规范化参数+结果哈希）触发干预的重复次数，<=0 表示关闭检测。
+	Threshold int
+	Action    LoopAction
+}
+
 // SessionConfig 对应一次会话的整体配置。
 type SessionConfig struct {
-	ToolApproval ToolApprovalConfig
+	ToolApproval  ToolApprovalConfig
+	LoopDetection LoopDetectionConfig
 }
 
 // DefaultSessionConfigFromEnv 从环境变量构造默认的 SessionConfig。
 // 当前支持：
 //   - CHASE_CODE_APPLY_PATCH_APPROVAL: auto|always_ask|always_approve
+//   - CHASE_CODE_LOOP_THRESHOLD: 重复工具调用阈值，0 表示关闭（默认 3）
+//   - CHASE_CODE_LOOP_ACTION: inject|ask
 func DefaultSessionConfigFromEnv() SessionConfig {
-	modeStr := config.Get().ApplyPatchApproval
+	env := config.Get()
+	modeStr := env.ApplyPatchApproval
 	mode := ApprovalModeAuto
 	switch ApprovalMode(modeStr) {
 	case ApprovalModeAlwaysAsk, ApprovalModeAlwaysApprove:
@@ -44,5 +72,31 @@ func DefaultSessionConfigFromEnv() SessionConfig {
 		ToolApproval: ToolApprovalConfig{
 			ApplyPatch: mode,
 		},
+		LoopDetection: LoopDetectionConfig{
+			Threshold: parseLoopThreshold(env.LoopThreshold),
+			Action:    parseLoopAction(env.LoopAction),
+		},
+	}
+}
+
+// parseLoopThreshold 解析重复阈值，非法值回退为默认值。
+func parseLoopThreshold(raw string) int {
+	if raw == "" {
+		return DefaultLoopThreshold
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return DefaultLoopThreshold
+	}
+	return n
+}
+
+// parseLoopAction 解析重复调用的处理方式，未知值回退为 inject。
+func parseLoopAction(raw string) LoopAction {
+	switch LoopAction(raw) {
+	case LoopActionAsk:
+		return LoopActionAsk
+	default:
+		return LoopActionInject
 	}
 }
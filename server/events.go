@@ -1,6 +1,10 @@
 package server
 
-import "time"
+import (
+	"time"
+
+	servertools "chase-code/server/tools"
+)
 
 // EventKind 表示事件的类型，仿照 codex-rs 中的 EventMsg 做一个精简版本。
 // 后续如果需要，可以逐步扩展更多的事件种类。
@@ -12,10 +16,27 @@ const (
 	EventTurnFinished EventKind = "turn_finished"
 	// turn 执行过程中出现错误
 	EventTurnError EventKind = "turn_error"
+	// turn 因步数耗尽中止，可通过 /continue 续跑
+	EventMaxStepsReached EventKind = "max_steps_reached"
 
 	// LLM / Agent 相关
 	EventAgentTextDelta EventKind = "agent_text_delta" // 流式增量文本（当前未启用，仅预留）
 	EventAgentTextDone  EventKind = "agent_text_done"  // 一轮回答完成
+	// 流式输出中途失败并改用备用模型或完整回放重试，此前已输出的增量文本作废
+	EventAgentTextDiscarded EventKind = "agent_text_discarded"
+	// 模型思考/推理内容的流式增量，仅用于展示，不写入历史
+	EventAgentReasoningDelta EventKind = "agent_reasoning_delta"
+	// turn 运行期间用户追加的输入
+	EventUserInputQueued   EventKind = "user_input_queued"   // 输入已进入队列
+	EventUserInputInjected EventKind = "user_input_injected" // 输入已注入当前 turn
+	EventUserInputDropped  EventKind = "user_input_dropped"  // 会话被重置，排队输入被丢弃
+	EventUserInputRejected EventKind = "user_input_rejected" // 输入预处理失败（如资源引用无法读取），未进入队列
+
+	// 模型通过 update_plan 更新了任务清单
+	EventPlanUpdated EventKind = "plan_updated"
+
+	// 当前模型不可用时切换到备用模型
+	EventModelFallback EventKind = "model_fallback"
 
 	// 工具调用相关
 	EventToolOutputDelta EventKind = "tool_output_delta" // 工具输出的增量内容（当前一次性发送）
@@ -23,6 +44,14 @@ const (
 	// 补丁审批相关
 	EventPatchApprovalRequest EventKind = "patch_approval_request" // 需要用户确认的补丁
 	EventPatchApprovalResult  EventKind = "patch_approval_result"  // 审批结果（日志用）
+
+	// 远程（MCP）工具审批相关
+	EventToolApprovalRequest EventKind = "tool_approval_request" // 需要用户确认的远程工具调用
+	EventToolApprovalResult  EventKind = "tool_approval_result"  // 审批结果
+
+	// 重复工具调用检测相关
+	EventLoopDetected EventKind = "loop_detected" // 检测到重复调用；带 RequestID 时表示需要用户决定是否继续
+	EventLoopDecision EventKind = "loop_decision" // 用户对重复调用的决定
 )
 
 // Event 是从 server 发送给上层（例如 CLI）的统一事件结构。
@@ -45,6 +74,11 @@ type Event struct {
 	RequestID string `json:"request_id,omitempty"`
 	// Paths 是本次补丁涉及到的文件路径列表，用于给用户展示摘要。
 	Paths []string `json:"paths,omitempty"`
+	// Server 为远程工具所属的 MCP server，用于工具审批展示。
+	Server string `json:"server,omitempty"`
+
+	// Plan 为 EventPlanUpdated 携带的完整任务清单。
+	Plan []servertools.PlanItem `json:"plan,omitempty"`
 }
 
 // EventSink 抽象一个事件下游。
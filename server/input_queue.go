@@ -0,0 +1,142 @@
+package server
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// QueueUserInput 在 turn 运行期间追加一条用户输入。
+// 输入会在下一次 LLM 调用前以 user 消息注入当前 turn，用于中途纠偏。
+// 当前没有运行中的 turn（包括 turn 刚刚结束）时不入队并返回 false，由调用方另起新 turn。
+func (s *Session) QueueUserInput(text string) bool {
+	if s == nil {
+		return false
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return true
+	}
+
+	s.pendingMu.Lock()
+	if !s.turnActive {
+		s.pendingMu.Unlock()
+		return false
+	}
+	s.pendingInputs = append(s.pendingInputs, text)
+	pending := len(s.pendingInputs)
+	s.pendingMu.Unlock()
+
+	log.Printf("[session] queued user input pending=%d", pending)
+	s.Sink.SendEvent(Event{
+		Kind:    EventUserInputQueued,
+		Time:    time.Now(),
+		Message: text,
+	})
+	return true
+}
+
+// PendingInputs 返回尚未注入的排队输入副本。
+func (s *Session) PendingInputs() []string {
+	if s == nil {
+		return nil
+	}
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	out := make([]string, len(s.pendingInputs))
+	copy(out, s.pendingInputs)
+	return out
+}
+
+// beginTurn 标记 turn 开始运行，此后 QueueUserInput 才会接受输入。
+func (s *Session) beginTurn() {
+	s.pendingMu.Lock()
+	s.turnActive = true
+	s.pendingMu.Unlock()
+}
+
+// finishTurnIfIdle 在没有排队输入时将 turn 标记为结束并返回 true；
+// 检查与标记在同一把锁内完成，避免输入在两者之间入队后滞留到下一个 turn。
+func (s *Session) finishTurnIfIdle() bool {
+	s.pendingMu.Lock()
+	defer s.pendingMu.Unlock()
+	if len(s.pendingInputs) > 0 {
+		return false
+	}
+	s.turnActive = false
+	return true
+}
+
+// endTurn 在 turn 以任何方式结束时标记为非运行状态。
+// 出错、步数耗尽或被终止时可能仍有未注入的输入，它们留在队列中由 TakePendingInputs 交给新的 turn。
+func (s *Session) endTurn() {
+	s.pendingMu.Lock()
+	s.turnActive = false
+	s.pendingMu.Unlock()
+}
+
+// TakePendingInputs 取出 turn 结束后仍未注入的输入，并通知上层它们将作为新 turn 的输入。
+// turn 运行期间调用时返回 nil。
+func (s *Session) TakePendingInputs() []string {
+	if s == nil {
+		return nil
+	}
+	s.pendingMu.Lock()
+	if s.turnActive {
+		s.pendingMu.Unlock()
+		return nil
+	}
+	inputs := s.pendingInputs
+	s.pendingInputs = nil
+	s.pendingMu.Unlock()
+
+	for _, text := range inputs {
+		s.Sink.SendEvent(Event{
+			Kind:    EventUserInputInjected,
+			Time:    time.Now(),
+			Message: text,
+		})
+	}
+	return inputs
+}
+
+// clearPendingInputs 丢弃全部排队输入（会话被重置时），并通知上层移除展示。
+func (s *Session) clearPendingInputs() {
+	s.pendingMu.Lock()
+	inputs := s.pendingInputs
+	s.pendingInputs = nil
+	s.pendingMu.Unlock()
+
+	for _, text := range inputs {
+		s.Sink.SendEvent(Event{
+			Kind:    EventUserInputDropped,
+			Time:    time.Now(),
+			Message: text,
+		})
+	}
+}
+
+// drainPendingInputs 取出全部排队输入，写入上下文并通知上层。
+func (s *Session) drainPendingInputs(cm *ContextManager, step int) {
+	s.pendingMu.Lock()
+	inputs := s.pendingInputs
+	s.pendingInputs = nil
+	s.pendingMu.Unlock()
+
+	for _, text := range inputs {
+		cm.Record(ResponseItem{
+			Type: ResponseItemMessage,
+			Role: RoleUser,
+			Text: text,
+		})
+		s.Sink.SendEvent(Event{
+			Kind:    EventUserInputInjected,
+			Time:    time.Now(),
+			Step:    step,
+			Message: text,
+		})
+	}
+	if len(inputs) > 0 {
+		log.Printf("[agent] step=%d injected %d queued user inputs", step, len(inputs))
+	}
+}
@@ -0,0 +1,698 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	servertools "chase-code/server/tools"
+)
+
+const (
+	defaultAnthropicBaseURL   = "https://api.anthropic.com"
+	anthropicAPIVersion       = "2023-06-01"
+	defaultAnthropicMaxTokens = 8192
+	// anthropicMinThinkingBudget 为 Messages API 允许的最小思考预算。
+	anthropicMinThinkingBudget = 1024
+	anthropicMaxRetries        = 2
+	anthropicRetryBaseDelay    = 500 * time.Millisecond
+)
+
+// AnthropicClient 直接调用 Anthropic Messages API（/v1/messages）。
+// 与走 OpenAI 兼容接口相比，可以使用原生的 tool_use 内容块与 prompt caching。
+type AnthropicClient struct {
+	cfg        clientConfig
+	endpoint   string
+	httpClient *http.Client
+}
+
+// AnthropicError 表示 Messages API 返回的非 2xx 错误。
+type AnthropicError struct {
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+// Error 返回错误信息。
+func (e *AnthropicError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("anthropic api error status=%d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("anthropic api error status=%d type=%s: %s", e.StatusCode, e.Type, e.Message)
+}
+
+// retryable 判断错误是否值得在当前模型上重试（限流、过载与 5xx）。
+func (e *AnthropicError) retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= http.StatusInternalServerError
+}
+
+// NewAnthropicClient 创建一个新的 AnthropicClient。
+func NewAnthropicClient(cfg clientConfig) *AnthropicClient {
+	return &AnthropicClient{
+		cfg:        cfg,
+		endpoint:   anthropicMessagesURL(cfg.BaseURL),
+		httpClient: newHTTPClient(cfg.Timeout),
+	}
+}
+
+// anthropicMessagesURL 根据 base_url 推导 messages 接口地址，兼容是否带 /v1 后缀。
+func anthropicMessagesURL(baseURL string) string {
+	base := strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if base == "" {
+		base = defaultAnthropicBaseURL
+	}
+	if strings.HasSuffix(base, "/v1") {
+		return base + "/messages"
+	}
+	return base + "/v1/messages"
+}
+
+// ===== 请求/响应结构 =====
+
+type anthropicRequest struct {
+	Model       string               `json:"model"`
+	MaxTokens   int                  `json:"max_tokens"`
+	System      []anthropicBlock     `json:"system,omitempty"`
+	Messages    []anthropicMessage   `json:"messages"`
+	Tools       []anthropicTool      `json:"tools,omitempty"`
+	ToolChoice  *anthropicToolChoice `json:"tool_choice,omitempty"`
+	Temperature *float64             `json:"temperature,omitempty"`
+	TopP        *float64             `json:"top_p,omitempty"`
+	Thinking    *anthropicThinking   `json:"thinking,omitempty"`
+	Stream      bool                 `json:"stream,omitempty"`
+}
+
+// anthropicThinking 为 extended thinking 配置，BudgetTokens 计入 max_tokens。
+type anthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type anthropicToolChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse *bool  `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock 覆盖 text / tool_use / tool_result / thinking / redacted_thinking 等内容块。
+type anthropicBlock struct {
+	Type      string `json:"type"`
+	Text      string `json:"text,omitempty"`
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	// Data 为 redacted_thinking 的加密内容。
+	Data      string          `json:"data,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	// Content 为 tool_result 的内容：纯文本为 string，带图片时为内容块数组。
+	Content      any                    `json:"content,omitempty"`
+	Source       *anthropicImageSource  `json:"source,omitempty"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type anthropicTool struct {
+	Name         string                 `json:"name"`
+	Description  string                 `json:"description,omitempty"`
+	InputSchema  json.RawMessage        `json:"input_schema"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Role       string           `json:"role"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+}
+
+type anthropicErrorBody struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// ===== LLMClient 实现 =====
+
+// Complete 调用 Messages API 获取完整回复。
+func (c *AnthropicClient) Complete(ctx context.Context, p Prompt) (*LLMResult, error) {
+	start := time.Now()
+	resp, err := c.send(ctx, c.buildRequest(p, false))
+	if err != nil {
+		log.Printf("[llm] Anthropic API error: %v (elapsed=%s)", err, time.Since(start))
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	logRawResponse(resp.StatusCode, body)
+
+	var out anthropicResponse
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("解析 Anthropic 响应失败: %w", err)
+	}
+	log.Printf("[llm] success alias=%s model=%s stop_reason=%s elapsed=%s", c.cfg.Alias, c.cfg.Model, out.StopReason, time.Since(start))
+	return anthropicResultFromBlocks(out.Content), nil
+}
+
+// Stream 调用 Messages API 的 SSE 流式接口。
+func (c *AnthropicClient) Stream(ctx context.Context, p Prompt) *LLMStream {
+	ch := make(chan LLMEvent, 128)
+	stream := &LLMStream{C: ch}
+
+	go func() {
+		defer close(ch)
+		start := time.Now()
+		resp, err := c.send(ctx, c.buildRequest(p, true))
+		if err != nil {
+			log.Printf("[llm] stream error: %v", err)
+			ch <- LLMEvent{Kind: LLMEventError, Error: err}
+			return
+		}
+		defer resp.Body.Close()
+
+		ch <- LLMEvent{Kind: LLMEventCreated}
+		acc := newAnthropicStreamAccumulator()
+		err = readSSE(resp.Body, func(event string, data []byte) error {
+			delta, reasoning, err := acc.apply(event, data)
+			if err != nil {
+				return err
+			}
+			if reasoning != "" {
+				ch <- LLMEvent{Kind: LLMEventReasoningDelta, TextDelta: reasoning}
+			}
+			if delta != "" {
+				ch <- LLMEvent{Kind: LLMEventTextDelta, TextDelta: delta}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("[llm] stream error: %v", err)
+			ch <- LLMEvent{Kind: LLMEventError, Error: wrapNetworkError(err)}
+			return
+		}
+
+		result := anthropicResultFromBlocks(acc.blocks())
+		log.Printf("[llm] stream complete elapsed=%s len=%d tool_calls=%d stop_reason=%s", time.Since(start), len(result.Message.Content), len(result.ToolCalls), acc.stopReason)
+		ch <- LLMEvent{Kind: LLMEventCompleted, FullText: result.Message.Content, Result: result}
+	}()
+
+	return stream
+}
+
+// send 发送请求，对限流、过载与网络错误做有限次数的退避重试。
+func (c *AnthropicClient) send(ctx context.Context, req anthropicRequest) (*http.Response, error) {
+	data, _, err := marshalRequestBody(req)
+	if err == nil {
+		data, err = mergeExtraBody(data, c.cfg.Params.ExtraBody)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("序列化 Anthropic 请求失败: %w", err)
+	}
+	logRequest(c.cfg, c.endpoint, data, formatJSONForLog(data))
+
+	var lastErr error
+	for attempt := 0; attempt <= anthropicMaxRetries; attempt++ {
+		if attempt > 0 {
+			delay := anthropicRetryBaseDelay << (attempt - 1)
+			log.Printf("[llm] anthropic retry attempt=%d delay=%s err=%v", attempt, delay, lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, wrapNetworkError(ctx.Err())
+			case <-time.After(delay):
+			}
+		}
+
+		resp, err := c.doRequest(ctx, data)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		var apiErr *AnthropicError
+		if errors.As(err, &apiErr) && !apiErr.retryable() {
+			return nil, err
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+	return nil, lastErr
+}
+
+// doRequest 执行单次 HTTP 请求，非 2xx 时解析为 AnthropicError。
+func (c *AnthropicClient) doRequest(ctx context.Context, data []byte) (*http.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", c.cfg.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicAPIVersion)
+	for key, value := range c.cfg.Params.Headers {
+		httpReq.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, wrapNetworkError(err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	logRawResponse(resp.StatusCode, body)
+	return nil, parseAnthropicError(resp.StatusCode, body)
+}
+
+// parseAnthropicError 将错误响应体解析为 AnthropicError。
+func parseAnthropicError(status int, body []byte) *AnthropicError {
+	apiErr := &AnthropicError{StatusCode: status, Message: strings.TrimSpace(string(body))}
+	var payload anthropicErrorBody
+	if err := json.Unmarshal(body, &payload); err == nil && payload.Error.Message != "" {
+		apiErr.Type = payload.Error.Type
+		apiErr.Message = payload.Error.Message
+	}
+	return apiErr
+}
+
+// ===== Prompt -> 请求 =====
+
+// buildRequest 将 Prompt 转换为 Messages API 请求。
+func (c *AnthropicClient) buildRequest(p Prompt, stream bool) anthropicRequest {
+	thinking := anthropicThinkingBudget(c.cfg.ReasoningEffort) > 0
+	system, messages := buildAnthropicMessages(normalizePromptItems(p), c.cfg.Vision, thinking)
+	req := anthropicRequest{
+		Model:     c.cfg.Model,
+		MaxTokens: defaultAnthropicMaxTokens,
+		System:    system,
+		Messages:  messages,
+		Tools:     buildAnthropicTools(p.Tools),
+		Stream:    stream,
+	}
+	if len(req.Tools) > 0 {
+		req.ToolChoice = &anthropicToolChoice{Type: "auto"}
+	}
+	applyAnthropicParams(&req, c.cfg)
+	return req
+}
+
+// buildAnthropicMessages 将 ResponseItem 映射为 system 块与 user/assistant 消息。
+// Messages API 要求 user/assistant 交替出现，因此相邻同角色的内容块会被合并到同一条消息。
+// vision 为 true 时，工具结果附带的图片以 image 块放入 tool_result 内容；
+// thinking 为 true 时回放历史中的 thinking 块，它们排在对应 assistant 回复之前，合并后位于消息开头。
+func buildAnthropicMessages(items []ResponseItem, vision, thinking bool) ([]anthropicBlock, []anthropicMessage) {
+	var system []anthropicBlock
+	var messages []anthropicMessage
+
+	appendBlocks := func(role string, blocks ...anthropicBlock) {
+		if len(blocks) == 0 {
+			return
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content = append(messages[n-1].Content, blocks...)
+			return
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	for _, it := range items {
+		switch it.Type {
+		case ResponseItemReasoning:
+			// 其它 provider 产生的 reasoning 条目（如 Responses 的加密思考）无法回放，直接丢弃。
+			if block, ok := anthropicThinkingBlock(it.Raw); ok && thinking {
+				appendBlocks("assistant", block)
+			}
+		case ResponseItemMessage:
+			switch it.Role {
+			case RoleSystem:
+				if strings.TrimSpace(it.Text) != "" {
+					system = append(system, anthropicBlock{Type: "text", Text: it.Text})
+				}
+			case RoleUser:
+				if strings.TrimSpace(it.Text) != "" {
+					appendBlocks("user", anthropicBlock{Type: "text", Text: it.Text})
+				}
+			case RoleAssistant:
+				appendBlocks("assistant", buildAnthropicAssistantBlocks(it.Text, it.ToolCalls)...)
+			default:
+				log.Printf("[llm] skip %s role message in anthropic input", it.Role)
+			}
+		case ResponseItemToolCall:
+			appendBlocks("assistant", buildAnthropicAssistantBlocks("", []ToolCall{{
+				ToolName:  it.ToolName,
+				Arguments: it.ToolArguments,
+				CallID:    it.CallID,
+			}})...)
+		case ResponseItemToolResult:
+			callID := strings.TrimSpace(it.CallID)
+			if callID == "" {
+				log.Printf("[llm] skip tool result: missing tool_use_id")
+				continue
+			}
+			appendBlocks("user", anthropicBlock{
+				Type:      "tool_result",
+				ToolUseID: callID,
+				Content:   buildAnthropicToolResultContent(it, vision),
+			})
+		}
+	}
+
+	// 在 system 末尾打缓存断点，使 system prompt 与工具定义在多轮之间复用缓存。
+	if n := len(system); n > 0 {
+		system[n-1].CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+	}
+	return system, messages
+}
+
+// buildAnthropicToolResultContent 返回 tool_result 的内容，有可用图片时改为 text + image 块数组。
+func buildAnthropicToolResultContent(it ResponseItem, vision bool) any {
+	text := truncateToolOutput(it.ToolOutput)
+	var images []toolImageInput
+	if vision {
+		images = loadToolImages(it.ToolImages)
+	}
+	if len(images) == 0 {
+		if text == "" {
+			return nil
+		}
+		return text
+	}
+	blocks := make([]anthropicBlock, 0, len(images)+1)
+	if strings.TrimSpace(text) != "" {
+		blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+	}
+	for _, img := range images {
+		blocks = append(blocks, anthropicBlock{
+			Type:   "image",
+			Source: &anthropicImageSource{Type: "base64", MediaType: img.MimeType, Data: img.Data},
+		})
+	}
+	return blocks
+}
+
+// buildAnthropicAssistantBlocks 构造 assistant 的 text 与 tool_use 内容块。
+func buildAnthropicAssistantBlocks(text string, calls []ToolCall) []anthropicBlock {
+	var blocks []anthropicBlock
+	if strings.TrimSpace(text) != "" {
+		blocks = append(blocks, anthropicBlock{Type: "text", Text: text})
+	}
+	for _, tc := range calls {
+		if strings.TrimSpace(tc.ToolName) == "" || strings.TrimSpace(tc.CallID) == "" {
+			log.Printf("[llm] skip tool call: missing name or id name=%s", tc.ToolName)
+			continue
+		}
+		blocks = append(blocks, anthropicBlock{
+			Type:  "tool_use",
+			ID:    tc.CallID,
+			Name:  tc.ToolName,
+			Input: toolInputObject(tc.Arguments),
+		})
+	}
+	return blocks
+}
+
+// toolInputObject 保证 tool_use.input 为 JSON 对象。
+// custom 工具（如 apply_patch）的原始文本参数会被包装为 {"input": "..."}，与函数回退形态一致。
+func toolInputObject(args json.RawMessage) json.RawMessage {
+	args = normalizeSDKArguments(args)
+	var obj map[string]any
+	if err := json.Unmarshal(args, &obj); err == nil && obj != nil {
+		return args
+	}
+	var text string
+	if err := json.Unmarshal(args, &text); err != nil {
+		text = string(args)
+	}
+	data, err := json.Marshal(map[string]string{"input": text})
+	if err != nil {
+		return json.RawMessage("{}")
+	}
+	return data
+}
+
+// buildAnthropicTools 将内部工具定义转换为 Anthropic 工具 schema。
+// custom 形态的 apply_patch 会回退为函数工具，其余缺少参数 schema 的工具被跳过。
+func buildAnthropicTools(tools []ToolSpec) []anthropicTool {
+	var out []anthropicTool
+	for _, t := range tools {
+		t = normalizeCompletionToolSpec(t)
+		if len(t.Parameters) == 0 || string(t.Parameters) == "null" {
+			continue
+		}
+		if !json.Valid(t.Parameters) {
+			log.Printf("[llm] skip tool %s: invalid parameters", t.Name)
+			continue
+		}
+		out = append(out, anthropicTool{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: anthropicInputSchema(t.Parameters),
+		})
+	}
+	if n := len(out); n > 0 {
+		out[n-1].CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+	}
+	return out
+}
+
+// ===== 响应 -> LLMResult =====
+
+// anthropicResultFromBlocks 将响应内容块转换为 LLMResult。
+func anthropicResultFromBlocks(blocks []anthropicBlock) *LLMResult {
+	var text, reasoning strings.Builder
+	var calls []ToolCall
+	var reasoningItems []json.RawMessage
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			text.WriteString(b.Text)
+		case "thinking", "redacted_thinking":
+			reasoning.WriteString(b.Thinking)
+			raw, err := json.Marshal(anthropicBlock{Type: b.Type, Thinking: b.Thinking, Signature: b.Signature, Data: b.Data})
+			if err != nil {
+				log.Printf("[llm] marshal anthropic thinking block failed: %v", err)
+				continue
+			}
+			reasoningItems = append(reasoningItems, raw)
+		case "tool_use":
+			if strings.TrimSpace(b.Name) == "" {
+				continue
+			}
+			calls = append(calls, ToolCall{
+				Kind:      servertools.ToolKindFunction,
+				ToolName:  b.Name,
+				Arguments: normalizeSDKArguments(b.Input),
+				CallID:    strings.TrimSpace(b.ID),
+			})
+		}
+	}
+	return &LLMResult{
+		Message:        LLMMessage{Role: RoleAssistant, Content: text.String()},
+		ToolCalls:      calls,
+		Reasoning:      reasoning.String(),
+		ReasoningItems: reasoningItems,
+	}
+}
+
+// anthropicThinkingBlock 解析历史中的原始 reasoning 条目，只接受 thinking 与 redacted_thinking 块。
+func anthropicThinkingBlock(raw json.RawMessage) (anthropicBlock, bool) {
+	if len(raw) == 0 {
+		return anthropicBlock{}, false
+	}
+	var block anthropicBlock
+	if err := json.Unmarshal(raw, &block); err != nil {
+		return anthropicBlock{}, false
+	}
+	switch block.Type {
+	case "thinking", "redacted_thinking":
+		return block, true
+	}
+	return anthropicBlock{}, false
+}
+
+// ===== SSE 流式解析 =====
+
+// readSSE 逐条解析 SSE 事件并回调 handle。
+func readSSE(r io.Reader, handle func(event string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+
+	var event string
+	var data bytes.Buffer
+	flush := func() error {
+		if data.Len() == 0 {
+			event = ""
+			return nil
+		}
+		err := handle(event, data.Bytes())
+		event = ""
+		data.Reset()
+		return err
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if err := flush(); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, ":"):
+			// 注释行，忽略。
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+// anthropicStreamAccumulator 按 index 聚合流式内容块。
+type anthropicStreamAccumulator struct {
+	byIndex    map[int]*anthropicBlock
+	jsonParts  map[int]*strings.Builder
+	stopReason string
+}
+
+func newAnthropicStreamAccumulator() *anthropicStreamAccumulator {
+	return &anthropicStreamAccumulator{
+		byIndex:   make(map[int]*anthropicBlock),
+		jsonParts: make(map[int]*strings.Builder),
+	}
+}
+
+type anthropicStreamEvent struct {
+	Type         string          `json:"type"`
+	Index        int             `json:"index"`
+	ContentBlock *anthropicBlock `json:"content_block,omitempty"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		Signature   string `json:"signature"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// apply 处理一条 SSE 事件，返回需要向上层推送的文本增量与思考增量。
+func (a *anthropicStreamAccumulator) apply(event string, data []byte) (string, string, error) {
+	var ev anthropicStreamEvent
+	if err := json.Unmarshal(data, &ev); err != nil {
+		return "", "", fmt.Errorf("解析 Anthropic 流式事件失败: %w", err)
+	}
+	if ev.Type == "" {
+		ev.Type = event
+	}
+
+	switch ev.Type {
+	case "content_block_start":
+		if ev.ContentBlock == nil {
+			return "", "", nil
+		}
+		block := *ev.ContentBlock
+		block.Input = nil
+		a.byIndex[ev.Index] = &block
+		return block.Text, block.Thinking, nil
+	case "content_block_delta":
+		block, ok := a.byIndex[ev.Index]
+		if !ok {
+			block = &anthropicBlock{Type: "text"}
+			a.byIndex[ev.Index] = block
+		}
+		switch ev.Delta.Type {
+		case "text_delta":
+			block.Text += ev.Delta.Text
+			return ev.Delta.Text, "", nil
+		case "thinking_delta":
+			block.Thinking += ev.Delta.Thinking
+			return "", ev.Delta.Thinking, nil
+		case "signature_delta":
+			block.Signature += ev.Delta.Signature
+		case "input_json_delta":
+			sb, ok := a.jsonParts[ev.Index]
+			if !ok {
+				sb = &strings.Builder{}
+				a.jsonParts[ev.Index] = sb
+			}
+			sb.WriteString(ev.Delta.PartialJSON)
+		}
+	case "message_delta":
+		if ev.Delta.StopReason != "" {
+			a.stopReason = ev.Delta.StopReason
+		}
+	case "error":
+		apiErr := &AnthropicError{StatusCode: http.StatusInternalServerError}
+		if ev.Error != nil {
+			apiErr.Type = ev.Error.Type
+			apiErr.Message = ev.Error.Message
+		}
+		return "", "", apiErr
+	}
+	return "", "", nil
+}
+
+// blocks 按 index 顺序返回聚合后的内容块。
+func (a *anthropicStreamAccumulator) blocks() []anthropicBlock {
+	indices := make([]int, 0, len(a.byIndex))
+	for idx := range a.byIndex {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	out := make([]anthropicBlock, 0, len(indices))
+	for _, idx := range indices {
+		block := *a.byIndex[idx]
+		if sb, ok := a.jsonParts[idx]; ok && sb.Len() > 0 {
+			block.Input = json.RawMessage(sb.String())
+		}
+		if block.Type == "tool_use" && len(block.Input) == 0 {
+			block.Input = json.RawMessage("{}")
+		}
+		out = append(out, block)
+	}
+	return out
+}
@@ -0,0 +1,271 @@
+package llm
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	servertools "chase-code/server/tools"
+)
+
+// newTestAnthropicClient 启动本地 httptest 服务并返回指向它的 AnthropicClient。
+func newTestAnthropicClient(t *testing.T, handler http.HandlerFunc) *AnthropicClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewAnthropicClient(clientConfig{
+		Alias:   "claude",
+		Model:   "claude-test",
+		BaseURL: srv.URL,
+		APIKey:  "test-key",
+		Timeout: defaultTimeout,
+	})
+}
+
+func testAnthropicPrompt() Prompt {
+	return Prompt{
+		Tools: []ToolSpec{
+			servertools.ShellCommandToolSpec(),
+			servertools.ApplyPatchToolSpecCustom(),
+		},
+		Items: []ResponseItem{
+			{Type: ResponseItemMessage, Role: RoleSystem, Text: "system prompt"},
+			{Type: ResponseItemMessage, Role: RoleUser, Text: "列出文件"},
+			{Type: ResponseItemMessage, Role: RoleAssistant, ToolCalls: []ToolCall{{
+				Kind:      servertools.ToolKindCustom,
+				ToolName:  "apply_patch",
+				Arguments: json.RawMessage(`"*** Begin Patch\n*** End Patch"`),
+				CallID:    "toolu_1",
+			}}},
+			{Type: ResponseItemToolResult, ToolName: "apply_patch", ToolOutput: "ok", CallID: "toolu_1"},
+		},
+	}
+}
+
+// TestAnthropicClient_CompleteRequestMapping 验证请求头、消息映射与工具 schema 转换。
+func TestAnthropicClient_CompleteRequestMapping(t *testing.T) {
+	var got anthropicRequest
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/messages", r.URL.Path)
+		assert.Equal(t, "test-key", r.Header.Get("x-api-key"))
+		assert.Equal(t, anthropicAPIVersion, r.Header.Get("anthropic-version"))
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"msg_1","role":"assistant","stop_reason":"tool_use","content":[
+			{"type":"text","text":"先看看目录"},
+			{"type":"tool_use","id":"toolu_2","name":"shell_command","input":{"command":"ls"}}
+		]}`)
+	})
+
+	res, err := client.Complete(context.Background(), testAnthropicPrompt())
+	require.NoError(t, err)
+
+	assert.Equal(t, "claude-test", got.Model)
+	assert.False(t, got.Stream)
+	require.Len(t, got.System, 1)
+	assert.Equal(t, "system prompt", got.System[0].Text)
+	require.NotNil(t, got.System[0].CacheControl)
+
+	require.Len(t, got.Messages, 3)
+	assert.Equal(t, "user", got.Messages[0].Role)
+	assert.Equal(t, "assistant", got.Messages[1].Role)
+	require.Len(t, got.Messages[1].Content, 1)
+	assert.Equal(t, "tool_use", got.Messages[1].Content[0].Type)
+	assert.JSONEq(t, `{"input":"*** Begin Patch\n*** End Patch"}`, string(got.Messages[1].Content[0].Input))
+	assert.Equal(t, "user", got.Messages[2].Role)
+	assert.Equal(t, "tool_result", got.Messages[2].Content[0].Type)
+	assert.Equal(t, "toolu_1", got.Messages[2].Content[0].ToolUseID)
+
+	require.Len(t, got.Tools, 2)
+	assert.Equal(t, "apply_patch", got.Tools[1].Name)
+	assert.Contains(t, string(got.Tools[1].InputSchema), `"input"`)
+
+	assert.Equal(t, "先看看目录", res.Message.Content)
+	require.Len(t, res.ToolCalls, 1)
+	assert.Equal(t, "shell_command", res.ToolCalls[0].ToolName)
+	assert.Equal(t, "toolu_2", res.ToolCalls[0].CallID)
+	assert.JSONEq(t, `{"command":"ls"}`, string(res.ToolCalls[0].Arguments))
+}
+
+// TestAnthropicClient_Stream 验证 SSE 文本增量与 tool_use 参数的聚合。
+func TestAnthropicClient_Stream(t *testing.T) {
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`event: message_start` + "\n" + `data: {"type":"message_start","message":{"id":"msg_1","role":"assistant","content":[]}}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"你好"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"，世界"}}`,
+			`event: ping` + "\n" + `data: {"type":"ping"}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_9","name":"shell_command","input":{}}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\":"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"pwd\"}"}}`,
+			`event: message_delta` + "\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use"}}`,
+			`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+		}
+		for _, ev := range events {
+			fmt.Fprint(w, ev+"\n\n")
+		}
+	})
+
+	stream := client.Stream(context.Background(), testAnthropicPrompt())
+	var deltas []string
+	var final *LLMResult
+	for ev := range stream.C {
+		switch ev.Kind {
+		case LLMEventTextDelta:
+			deltas = append(deltas, ev.TextDelta)
+		case LLMEventCompleted:
+			final = ev.Result
+		case LLMEventError:
+			t.Fatalf("unexpected stream error: %v", ev.Error)
+		}
+	}
+
+	assert.Equal(t, []string{"你好", "，世界"}, deltas)
+	require.NotNil(t, final)
+	assert.Equal(t, "你好，世界", final.Message.Content)
+	require.Len(t, final.ToolCalls, 1)
+	assert.Equal(t, "toolu_9", final.ToolCalls[0].CallID)
+	assert.JSONEq(t, `{"command":"pwd"}`, string(final.ToolCalls[0].Arguments))
+}
+
+// TestAnthropicClient_Errors 验证 4xx 不重试、5xx 重试后可被识别为可切换模型的错误。
+func TestAnthropicClient_Errors(t *testing.T) {
+	calls := 0
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`)
+	})
+	_, err := client.Complete(context.Background(), testAnthropicPrompt())
+	var apiErr *AnthropicError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, "invalid_request_error", apiErr.Type)
+	assert.Equal(t, 1, calls)
+	assert.False(t, IsRetryableError(err))
+
+	calls = 0
+	client = newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(529)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"busy"}}`)
+	})
+	_, err = client.Complete(context.Background(), testAnthropicPrompt())
+	require.Error(t, err)
+	assert.Equal(t, anthropicMaxRetries+1, calls)
+	assert.True(t, IsRetryableError(err))
+}
+
+// TestBuildAnthropicMessages_ToolImages 验证开启 vision 时工具图片以 image 块放入 tool_result。
+func TestBuildAnthropicMessages_ToolImages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "shot.png")
+	require.NoError(t, os.WriteFile(path, []byte("png-bytes"), 0o644))
+	items := []ResponseItem{{
+		Type:       ResponseItemToolResult,
+		ToolName:   "mcp__browser__screenshot",
+		ToolOutput: "[图片 image/png 已保存到 " + path + "]",
+		CallID:     "toolu_1",
+		ToolImages: []ToolImage{{Path: path, MimeType: "image/png"}},
+	}}
+
+	_, messages := buildAnthropicMessages(items, false, false)
+	require.Len(t, messages, 1)
+	assert.IsType(t, "", messages[0].Content[0].Content)
+
+	_, messages = buildAnthropicMessages(items, true, false)
+	require.Len(t, messages, 1)
+	blocks, ok := messages[0].Content[0].Content.([]anthropicBlock)
+	require.True(t, ok)
+	require.Len(t, blocks, 2)
+	assert.Equal(t, "text", blocks[0].Type)
+	assert.Equal(t, "image", blocks[1].Type)
+	require.NotNil(t, blocks[1].Source)
+	assert.Equal(t, "image/png", blocks[1].Source.MediaType)
+	assert.Equal(t, base64.StdEncoding.EncodeToString([]byte("png-bytes")), blocks[1].Source.Data)
+}
+
+// TestAnthropicClient_Thinking 验证 extended thinking 的开启、流式解析与下一轮回放。
+func TestAnthropicClient_Thinking(t *testing.T) {
+	var got anthropicRequest
+	client := newTestAnthropicClient(t, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.Header().Set("Content-Type", "text/event-stream")
+		events := []string{
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"thinking","thinking":""}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"先看目录"}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"signature_delta","signature":"sig-1"}}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"redacted_thinking","data":"enc"}}`,
+			`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":2,"content_block":{"type":"text","text":""}}`,
+			`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":2,"delta":{"type":"text_delta","text":"好的"}}`,
+			`event: message_delta` + "\n" + `data: {"type":"message_delta","delta":{"stop_reason":"end_turn"}}`,
+		}
+		for _, ev := range events {
+			fmt.Fprint(w, ev+"\n\n")
+		}
+	})
+	client.cfg.ReasoningEffort = "medium"
+	temp := 0.2
+	client.cfg.Params.Temperature = &temp
+
+	var reasoning, text string
+	var final *LLMResult
+	for ev := range client.Stream(context.Background(), testAnthropicPrompt()).C {
+		switch ev.Kind {
+		case LLMEventReasoningDelta:
+			reasoning += ev.TextDelta
+		case LLMEventTextDelta:
+			text += ev.TextDelta
+		case LLMEventCompleted:
+			final = ev.Result
+		case LLMEventError:
+			t.Fatalf("unexpected stream error: %v", ev.Error)
+		}
+	}
+
+	require.NotNil(t, got.Thinking)
+	assert.Equal(t, 4096, got.Thinking.BudgetTokens)
+	assert.Greater(t, got.MaxTokens, got.Thinking.BudgetTokens)
+	assert.Nil(t, got.Temperature, "开启思考时不能下发 temperature")
+	assert.Equal(t, "先看目录", reasoning)
+	assert.Equal(t, "好的", text)
+	require.NotNil(t, final)
+	assert.Equal(t, "先看目录", final.Reasoning)
+	require.Len(t, final.ReasoningItems, 2)
+	assert.JSONEq(t, `{"type":"thinking","thinking":"先看目录","signature":"sig-1"}`, string(final.ReasoningItems[0]))
+	assert.JSONEq(t, `{"type":"redacted_thinking","data":"enc"}`, string(final.ReasoningItems[1]))
+
+	// 下一轮请求：thinking 块排在同一条 assistant 消息开头，Responses 的 reasoning 条目被丢弃。
+	items := []ResponseItem{
+		{Type: ResponseItemMessage, Role: RoleUser, Text: "列出文件"},
+		{Type: ResponseItemReasoning, Raw: json.RawMessage(`{"type":"reasoning","encrypted_content":"x"}`)},
+		{Type: ResponseItemReasoning, Raw: final.ReasoningItems[0]},
+		{Type: ResponseItemReasoning, Raw: final.ReasoningItems[1]},
+		{Type: ResponseItemMessage, Role: RoleAssistant, Text: "好的"},
+	}
+	_, messages := buildAnthropicMessages(items, false, true)
+	require.Len(t, messages, 2)
+	blocks := messages[1].Content
+	require.Len(t, blocks, 3)
+	assert.Equal(t, "thinking", blocks[0].Type)
+	assert.Equal(t, "sig-1", blocks[0].Signature)
+	assert.Equal(t, "redacted_thinking", blocks[1].Type)
+	assert.Equal(t, "text", blocks[2].Type)
+
+	_, messages = buildAnthropicMessages(items, false, false)
+	require.Len(t, messages[1].Content, 1, "未开启思考时不回放 thinking 块")
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/packages/respjson"
 	"github.com/openai/openai-go/shared"
 	"github.com/openai/openai-go/shared/constant"
 
@@ -27,11 +28,12 @@ type CompletionsClient struct {
 
 // NewCompletionsClient 创建一个新的 CompletionsClient。
 func NewCompletionsClient(cfg clientConfig) *CompletionsClient {
-	c := openai.NewClient(
+	opts := append([]option.RequestOption{
 		option.WithAPIKey(cfg.APIKey),
 		option.WithBaseURL(cfg.BaseURL),
 		option.WithHTTPClient(newHTTPClient(cfg.Timeout)),
-	)
+	}, generationRequestOptions(cfg)...)
+	c := openai.NewClient(opts...)
 	return &CompletionsClient{cfg: cfg, client: &c}
 }
 
@@ -76,6 +78,7 @@ func (c *CompletionsClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 
 		ch <- LLMEvent{Kind: LLMEventCreated}
 		var fullTextBuilder strings.Builder
+		var reasoningBuilder strings.Builder
 		toolCallsMap := make(map[int64]*openai.ChatCompletionChunkChoiceDeltaToolCall)
 
 		for s.Next() {
@@ -84,6 +87,10 @@ func (c *CompletionsClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 				continue
 			}
 			delta := chunk.Choices[0].Delta
+			if reasoning := extractReasoningContent(delta); reasoning != "" {
+				reasoningBuilder.WriteString(reasoning)
+				ch <- LLMEvent{Kind: LLMEventReasoningDelta, TextDelta: reasoning}
+			}
 			if delta.Content != "" {
 				fullTextBuilder.WriteString(delta.Content)
 				ch <- LLMEvent{Kind: LLMEventTextDelta, TextDelta: delta.Content}
@@ -104,6 +111,7 @@ func (c *CompletionsClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 				Role:    RoleAssistant,
 				Content: fullText,
 			},
+			Reasoning: reasoningBuilder.String(),
 		}
 
 		finalResult.ToolCalls = finalizeStreamToolCalls(toolCallsMap)
@@ -121,17 +129,43 @@ func (c *CompletionsClient) buildParams(p Prompt) openai.ChatCompletionNewParams
 		Messages: msgs,
 	}
 
+	if effort := c.cfg.ReasoningEffort; effort != "" {
+		params.ReasoningEffort = shared.ReasoningEffort(effort)
+	}
+
 	if sdkTools := c.buildTools(p.Tools); len(sdkTools) > 0 {
 		params.Tools = sdkTools
 		params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{
 			OfAuto: param.NewOpt("auto"),
 		}
 	}
+	applyCompletionsParams(&params, c.cfg)
 
-	log.Printf("build params: %s\n", utils.ToIndentJSONString(params))
+	log.Printf("build params: %s\n", redactSecrets(c.cfg, utils.ToIndentJSONString(params)))
 	return params
 }
 
+// extractReasoningContent 读取 Kimi / DeepSeek 等兼容接口在 delta 中附带的思考内容
+// （reasoning_content，部分实现使用 reasoning 字段）。
+func extractReasoningContent(delta openai.ChatCompletionChunkChoiceDelta) string {
+	return extractExtraString(delta.JSON.ExtraFields, "reasoning_content", "reasoning")
+}
+
+// extractExtraString 按顺序读取 SDK 未声明的扩展字段，返回第一个非空字符串值。
+func extractExtraString(fields map[string]respjson.Field, keys ...string) string {
+	for _, key := range keys {
+		field, ok := fields[key]
+		if !ok || !field.Valid() {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal([]byte(field.Raw()), &text); err == nil && text != "" {
+			return text
+		}
+	}
+	return ""
+}
+
 // buildMessages 将 Prompt 转换为 Chat Completions 所需的消息列表。
 func (c *CompletionsClient) buildMessages(p Prompt) []openai.ChatCompletionMessageParamUnion {
 	items := normalizePromptItems(p)
@@ -140,7 +174,18 @@ func (c *CompletionsClient) buildMessages(p Prompt) []openai.ChatCompletionMessa
 	}
 
 	var msgs []openai.ChatCompletionMessageParamUnion
+	// tool 消息不支持图片，工具图片先暂存，在连续的工具结果之后以一条 user 消息补充。
+	var pendingImages []toolImageInput
+	flushImages := func() {
+		if len(pendingImages) > 0 {
+			msgs = append(msgs, buildCompletionImageMessage(pendingImages))
+			pendingImages = nil
+		}
+	}
 	for _, it := range items {
+		if it.Type != ResponseItemToolResult {
+			flushImages()
+		}
 		switch it.Type {
 		case ResponseItemMessage:
 			msg, ok := buildCompletionMessageFromItem(it)
@@ -157,12 +202,25 @@ func (c *CompletionsClient) buildMessages(p Prompt) []openai.ChatCompletionMessa
 			msg, ok := buildCompletionToolResultMessage(it)
 			if ok {
 				msgs = append(msgs, msg)
+				if c.cfg.Vision {
+					pendingImages = append(pendingImages, loadToolImages(it.ToolImages)...)
+				}
 			}
 		}
 	}
+	flushImages()
 	return msgs
 }
 
+// buildCompletionImageMessage 将工具返回的图片包装为一条 user 消息。
+func buildCompletionImageMessage(images []toolImageInput) openai.ChatCompletionMessageParamUnion {
+	parts := []openai.ChatCompletionContentPartUnionParam{openai.TextContentPart(toolImagesNote)}
+	for _, img := range images {
+		parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{URL: img.DataURL()}))
+	}
+	return openai.UserMessage(parts)
+}
+
 // buildCompletionMessageFromItem 将普通消息转换为 SDK message。
 func buildCompletionMessageFromItem(it ResponseItem) (openai.ChatCompletionMessageParamUnion, bool) {
 	switch it.Role {
@@ -308,7 +366,7 @@ func (c *CompletionsClient) buildTools(tools []ToolSpec) []openai.ChatCompletion
 			Function: openai.FunctionDefinitionParam{
 				Name:        t.Name,
 				Description: param.NewOpt(t.Description),
-				Parameters:  paramsMap,
+				Parameters:  openAIToolParameters(paramsMap),
 			},
 		})
 	}
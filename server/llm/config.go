@@ -11,8 +11,9 @@ import (
 )
 
 var (
+	modelsMu     sync.Mutex
 	globalModels *LLMModels
-	loadOnce     sync.Once
+	loaded       bool
 	loadErr      error
 )
 
@@ -39,6 +40,10 @@ type LLMModel struct {
 	BaseURL  string
 	APIKey   string
 	CacheKey string
+	// ContextWindow 为模型上下文窗口（token 数），0 表示未知。
+	ContextWindow int
+	// Params 为配置文件中的生成参数。
+	Params config.GenerationParams
 }
 
 // LLMModels 汇总所有模型及当前选择项。
@@ -54,6 +59,17 @@ type clientConfig struct {
 	APIKey   string
 	CacheKey string
 	Timeout  time.Duration
+	// ContextWindow 由配置或本地服务探测得到，0 表示未知。
+	ContextWindow int
+	// ReasoningEffort / ReasoningSummary 为推理模型的思考配置，留空表示不下发。
+	ReasoningEffort  string
+	ReasoningSummary string
+	// ResponsesState 为 Responses API 的上下文传递方式，见 ResponsesState* 常量。
+	ResponsesState string
+	// Vision 为 true 时，工具结果中的图片会以图片输入发送给模型。
+	Vision bool
+	// Params 为温度、最大输出、tool_choice、自定义 header 等生成参数。
+	Params config.GenerationParams
 }
 
 type modelEntry struct {
@@ -73,12 +89,27 @@ func Init() error {
 
 // NewLLMModelsFromEnv 从环境变量加载所有模型，并选择当前模型。
 func NewLLMModelsFromEnv() (*LLMModels, error) {
-	loadOnce.Do(func() {
+	modelsMu.Lock()
+	defer modelsMu.Unlock()
+	if !loaded {
 		globalModels, loadErr = loadLLMModelsFromEnv()
-	})
+		loaded = true
+	}
 	return globalModels, loadErr
 }
 
+// ReloadModels 重新读取环境变量与配置文件并重建模型列表，无需重启进程。
+// 已创建的客户端不受影响，调用方需按别名重新绑定。
+func ReloadModels() (*LLMModels, error) {
+	config.Reload()
+	models, err := loadLLMModelsFromEnv()
+
+	modelsMu.Lock()
+	globalModels, loadErr, loaded = models, err, true
+	modelsMu.Unlock()
+	return models, err
+}
+
 // GetModels 返回所有已加载的模型。
 func GetModels() []*LLMModel {
 	ms, _ := NewLLMModelsFromEnv()
@@ -111,6 +142,36 @@ func FindModel(alias string) (*LLMModel, error) {
 	return nil, fmt.Errorf("未找到别名为 %s 的模型", alias)
 }
 
+// FallbackModels 按配置文件中的 fallback 列表返回备用模型。
+// 会跳过当前模型、重复项以及未成功加载的别名。
+func FallbackModels(current *LLMModel) []*LLMModel {
+	env := config.Get()
+	if env.LLMConfig == nil || len(env.LLMConfig.Fallback) == 0 {
+		return nil
+	}
+	ms, _ := NewLLMModelsFromEnv()
+	if ms == nil {
+		return nil
+	}
+
+	seen := make(map[*LLMModel]bool)
+	var out []*LLMModel
+	for _, alias := range env.LLMConfig.Fallback {
+		alias = strings.TrimSpace(alias)
+		if alias == "" {
+			continue
+		}
+		for _, m := range ms.All {
+			if !strings.EqualFold(m.Alias, alias) || m == current || seen[m] {
+				continue
+			}
+			seen[m] = true
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
 // loadLLMModelsFromEnv 实际执行模型加载逻辑。
 func loadLLMModelsFromEnv() (*LLMModels, error) {
 	env := config.Get()
@@ -130,8 +191,12 @@ func loadLLMModelsFromEnv() (*LLMModels, error) {
 	all := collectAvailableModels(entries)
 
 	desired := env.LLMProvider
-	if desired == "" && env.LLMConfig != nil && env.LLMConfig.Model.Name != "" {
-		desired = env.LLMConfig.Model.Name
+	if desired == "" && env.LLMConfig != nil {
+		// roles.agent 与 model.name 都可指定主模型，角色配置优先。
+		desired = strings.TrimSpace(env.LLMConfig.Roles[string(ModelRoleAgent)])
+		if desired == "" {
+			desired = env.LLMConfig.Model.Name
+		}
 	}
 
 	current, err := selectModel(entries, desired)
@@ -160,38 +225,60 @@ func buildFileModelEntry(m config.Model) modelEntry {
 func buildClientFromModelConfig(m config.Model) (clientConfig, LLMClient, error) {
 	switch {
 	case m.Completions != nil:
-		cfg := clientConfig{
+		cfg := withModelOptions(clientConfig{
 			Alias:   m.Name,
 			Model:   strings.TrimSpace(m.Completions.Model),
 			BaseURL: strings.TrimSpace(m.Completions.BaseURL),
 			APIKey:  strings.TrimSpace(m.Completions.APIKey),
 			Timeout: defaultTimeout,
-		}
+		}, m)
 		return cfg, NewCompletionsClient(cfg), nil
 	case m.Claude != nil:
-		cfg := clientConfig{
+		cfg := withModelOptions(clientConfig{
 			Alias:   m.Name,
 			Model:   strings.TrimSpace(m.Claude.Model),
 			BaseURL: strings.TrimSpace(m.Claude.BaseURL),
 			APIKey:  strings.TrimSpace(m.Claude.APIKey),
 			Timeout: defaultTimeout,
-		}
-		// Claude 暂走 OpenAI 兼容的 Completions 接口。
-		return cfg, NewCompletionsClient(cfg), nil
+		}, m)
+		return cfg, NewAnthropicClient(cfg), nil
 	case m.Responses != nil:
-		cfg := clientConfig{
-			Alias:   m.Name,
-			Model:   strings.TrimSpace(m.Responses.Model),
-			BaseURL: strings.TrimSpace(m.Responses.BaseURL),
-			APIKey:  strings.TrimSpace(m.Responses.APIKey),
-			Timeout: defaultTimeout,
+		state, err := parseResponsesState(m.Responses.State)
+		if err != nil {
+			return clientConfig{}, nil, fmt.Errorf("模型 %s 配置错误: %w", m.Name, err)
 		}
+		cfg := withModelOptions(clientConfig{
+			Alias:          m.Name,
+			Model:          strings.TrimSpace(m.Responses.Model),
+			BaseURL:        strings.TrimSpace(m.Responses.BaseURL),
+			APIKey:         strings.TrimSpace(m.Responses.APIKey),
+			Timeout:        defaultTimeout,
+			ResponsesState: state,
+		}, m)
 		return cfg, NewResponsesClient(cfg), nil
+	case m.Local != nil:
+		cfg := withModelOptions(clientConfig{
+			Alias:   m.Name,
+			Model:   strings.TrimSpace(m.Local.Model),
+			BaseURL: strings.TrimSpace(m.Local.BaseURL),
+			APIKey:  strings.TrimSpace(m.Local.APIKey),
+			Timeout: defaultTimeout,
+		}, m)
+		return buildLocalClient(cfg, m.Local.ToolMode, m.Local.ContextWindow)
 	default:
 		return clientConfig{}, nil, fmt.Errorf("模型 %s 缺少配置内容", m.Name)
 	}
 }
 
+// withModelOptions 将模型条目中的思考配置与生成参数写入 clientConfig。
+func withModelOptions(cfg clientConfig, m config.Model) clientConfig {
+	cfg.ReasoningEffort = strings.ToLower(strings.TrimSpace(m.ReasoningEffort))
+	cfg.ReasoningSummary = strings.ToLower(strings.TrimSpace(m.ReasoningSummary))
+	cfg.Params = m.GenerationParams
+	cfg.Vision = m.Vision
+	return cfg
+}
+
 // NewLLMModelFromEnv 返回当前选择的模型。
 func NewLLMModelFromEnv() (*LLMModel, error) {
 	models, err := NewLLMModelsFromEnv()
@@ -370,5 +457,8 @@ func modelFromConfig(cfg clientConfig, client LLMClient) *LLMModel {
 		BaseURL:  cfg.BaseURL,
 		APIKey:   cfg.APIKey,
 		CacheKey: cfg.CacheKey,
+
+		ContextWindow: cfg.ContextWindow,
+		Params:        cfg.Params,
 	}
 }
@@ -60,3 +60,14 @@ func normalizeSDKArguments(args json.RawMessage) json.RawMessage {
 func formatFunctionCallArguments(args json.RawMessage) string {
 	return string(normalizeSDKArguments(args))
 }
+
+// rawItemType 返回原始条目 JSON 的 type 字段，解析失败时返回空字符串。
+func rawItemType(raw json.RawMessage) string {
+	var head struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return ""
+	}
+	return head.Type
+}
@@ -33,7 +33,26 @@ func logRequest(cfg clientConfig, url string, data []byte, pretty string) {
 	if alias == "" {
 		alias = "(empty)"
 	}
-	log.Printf("[llm] request alias=%s model=%s url=%s body_bytes=%d body=\n%s", alias, cfg.Model, url, len(data), pretty)
+	log.Printf("[llm] request alias=%s model=%s url=%s body_bytes=%d body=\n%s", alias, cfg.Model, url, len(data), redactSecrets(cfg, pretty))
+}
+
+// minRedactLen 为需要脱敏的密钥最短长度，过短的占位值（如 local）不做替换。
+const minRedactLen = 6
+
+// redactSecrets 将日志文本中出现的 api_key、cache_key 与自定义 header 值替换为掩码。
+func redactSecrets(cfg clientConfig, text string) string {
+	secrets := []string{cfg.APIKey, cfg.CacheKey}
+	for _, v := range cfg.Params.Headers {
+		secrets = append(secrets, v)
+	}
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if len(secret) < minRedactLen {
+			continue
+		}
+		text = strings.ReplaceAll(text, secret, "***")
+	}
+	return text
 }
 
 // logRawResponse 打印原始响应体，便于排查问题。
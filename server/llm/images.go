@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"strings"
+)
+
+// toolImageMaxBytes 为单张工具图片的大小上限，超过则不作为图片输入发送。
+const toolImageMaxBytes = 5 << 20
+
+// toolImageInput 为读取后的工具图片，Data 为 base64 编码内容。
+type toolImageInput struct {
+	MimeType string
+	Data     string
+}
+
+// DataURL 返回 data:<mime>;base64,<data> 形式的图片地址。
+func (i toolImageInput) DataURL() string {
+	return "data:" + i.MimeType + ";base64," + i.Data
+}
+
+// loadToolImages 读取工具结果中已保存的图片，跳过非图片、读取失败或过大的文件。
+func loadToolImages(images []ToolImage) []toolImageInput {
+	var out []toolImageInput
+	for _, img := range images {
+		if !strings.HasPrefix(img.MimeType, "image/") {
+			continue
+		}
+		info, err := os.Stat(img.Path)
+		if err != nil {
+			log.Printf("[llm] skip tool image %s: %v", img.Path, err)
+			continue
+		}
+		if info.Size() > toolImageMaxBytes {
+			log.Printf("[llm] skip tool image %s: size=%d exceeds limit", img.Path, info.Size())
+			continue
+		}
+		data, err := os.ReadFile(img.Path)
+		if err != nil {
+			log.Printf("[llm] skip tool image %s: %v", img.Path, err)
+			continue
+		}
+		out = append(out, toolImageInput{MimeType: img.MimeType, Data: base64.StdEncoding.EncodeToString(data)})
+	}
+	return out
+}
+
+// toolImagesNote 为随图片一起发送的说明文本。
+const toolImagesNote = "以上工具调用返回的图片："
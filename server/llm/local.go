@@ -0,0 +1,518 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	servertools "chase-code/server/tools"
+)
+
+const (
+	defaultLocalBaseURL = "http://localhost:11434/v1"
+	localProbeTimeout   = 3 * time.Second
+)
+
+// LocalToolMode 控制本地模型的工具调用方式。
+type LocalToolMode string
+
+const (
+	LocalToolModeAuto   LocalToolMode = "auto"
+	LocalToolModeNative LocalToolMode = "native"
+	LocalToolModePrompt LocalToolMode = "prompt"
+)
+
+// ParseLocalToolMode 解析配置中的 tool_mode，空值视为 auto。
+func ParseLocalToolMode(raw string) (LocalToolMode, error) {
+	switch LocalToolMode(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", LocalToolModeAuto:
+		return LocalToolModeAuto, nil
+	case LocalToolModeNative:
+		return LocalToolModeNative, nil
+	case LocalToolModePrompt:
+		return LocalToolModePrompt, nil
+	default:
+		return "", fmt.Errorf("不支持的 tool_mode: %s（可选 auto/native/prompt）", raw)
+	}
+}
+
+// LocalCapabilities 是启动时对本地推理服务的探测结果。
+type LocalCapabilities struct {
+	// Backend 为 ollama、llama.cpp 或 openai-compatible。
+	Backend string
+	Models  []string
+	// ToolsKnown 为 true 时 SupportsTools 才有意义；无法判断时按原生 function calling 处理。
+	ToolsKnown    bool
+	SupportsTools bool
+	ContextWindow int
+}
+
+// LocalClient 通过 OpenAI 兼容接口访问本地模型。
+// 模型不支持 function calling 时改用文本 JSON 协议：工具定义写入 system prompt，
+// 历史中的工具调用与结果转为普通文本，回复中的 JSON 再解析回 ToolCall。
+type LocalClient struct {
+	cfg   clientConfig
+	mode  LocalToolMode
+	caps  LocalCapabilities
+	inner *CompletionsClient
+}
+
+// NewLocalClient 创建本地模型客户端，mode 为 auto 时按探测结果决定工具协议。
+func NewLocalClient(cfg clientConfig, mode LocalToolMode, caps LocalCapabilities) *LocalClient {
+	if mode == LocalToolModeAuto || mode == "" {
+		mode = LocalToolModeNative
+		if caps.ToolsKnown && !caps.SupportsTools {
+			mode = LocalToolModePrompt
+		}
+	}
+	return &LocalClient{cfg: cfg, mode: mode, caps: caps, inner: NewCompletionsClient(cfg)}
+}
+
+// ToolMode 返回实际生效的工具协议（native 或 prompt）。
+func (c *LocalClient) ToolMode() LocalToolMode {
+	return c.mode
+}
+
+// Capabilities 返回启动时的探测结果。
+func (c *LocalClient) Capabilities() LocalCapabilities {
+	return c.caps
+}
+
+// Complete 调用本地模型获取完整回复。
+func (c *LocalClient) Complete(ctx context.Context, p Prompt) (*LLMResult, error) {
+	res, err := c.inner.Complete(ctx, c.preparePrompt(p))
+	if err != nil {
+		return nil, err
+	}
+	return c.finishResult(res), nil
+}
+
+// Stream 调用本地模型的流式接口。prompt 模式下在完成事件中解析工具调用。
+func (c *LocalClient) Stream(ctx context.Context, p Prompt) *LLMStream {
+	innerStream := c.inner.Stream(ctx, c.preparePrompt(p))
+	if c.mode != LocalToolModePrompt {
+		return innerStream
+	}
+
+	ch := make(chan LLMEvent, 128)
+	go func() {
+		defer close(ch)
+		for ev := range innerStream.C {
+			if ev.Kind == LLMEventCompleted && ev.Result != nil {
+				ev.Result = c.finishResult(ev.Result)
+			}
+			ch <- ev
+		}
+	}()
+	return &LLMStream{C: ch, Err: innerStream.Err}
+}
+
+// preparePrompt 按工具协议改写 Prompt。
+func (c *LocalClient) preparePrompt(p Prompt) Prompt {
+	if c.mode != LocalToolModePrompt {
+		return p
+	}
+	return buildPromptedToolPrompt(p)
+}
+
+// finishResult 在 prompt 模式下从回复文本中解析工具调用。
+func (c *LocalClient) finishResult(res *LLMResult) *LLMResult {
+	if c.mode != LocalToolModePrompt || res == nil || len(res.ToolCalls) > 0 {
+		return res
+	}
+	calls, err := servertools.ParseToolCallsJSON(stripCodeFence(res.Message.Content))
+	if err != nil {
+		return res
+	}
+	for i := range calls {
+		calls[i].Kind = servertools.ToolKindFunction
+		calls[i].Arguments = normalizeSDKArguments(calls[i].Arguments)
+	}
+	res.ToolCalls = calls
+	return res
+}
+
+// ===== 文本 JSON 工具协议 =====
+
+// buildPromptedToolPrompt 将工具定义写入 system prompt，并把工具轨迹改写为纯文本消息。
+func buildPromptedToolPrompt(p Prompt) Prompt {
+	items := normalizePromptItems(p)
+	out := make([]ResponseItem, 0, len(items)+1)
+	protocol := buildToolProtocolText(p.Tools)
+	injected := protocol == ""
+
+	for _, it := range items {
+		if !injected && !(it.Type == ResponseItemMessage && it.Role == RoleSystem) {
+			out = append(out, ResponseItem{Type: ResponseItemMessage, Role: RoleSystem, Text: protocol})
+			injected = true
+		}
+		switch it.Type {
+		case ResponseItemMessage:
+			if it.Role == RoleAssistant && len(it.ToolCalls) > 0 {
+				out = append(out, ResponseItem{Type: ResponseItemMessage, Role: RoleAssistant, Text: joinNonEmpty(it.Text, formatPromptedToolCalls(it.ToolCalls))})
+				continue
+			}
+			out = append(out, ResponseItem{Type: ResponseItemMessage, Role: it.Role, Text: it.Text})
+		case ResponseItemToolCall:
+			out = append(out, ResponseItem{Type: ResponseItemMessage, Role: RoleAssistant, Text: formatPromptedToolCalls([]ToolCall{{
+				ToolName:  it.ToolName,
+				Arguments: it.ToolArguments,
+			}})})
+		case ResponseItemToolResult:
+			out = append(out, ResponseItem{
+				Type: ResponseItemMessage,
+				Role: RoleUser,
+				Text: fmt.Sprintf("[工具 %s 的执行结果]\n%s", it.ToolName, truncateToolOutput(it.ToolOutput)),
+			})
+		}
+	}
+	if !injected {
+		out = append(out, ResponseItem{Type: ResponseItemMessage, Role: RoleSystem, Text: protocol})
+	}
+	return Prompt{Items: out}
+}
+
+// buildToolProtocolText 生成描述工具与 JSON 调用格式的 system 文本。
+func buildToolProtocolText(tools []ToolSpec) string {
+	if len(tools) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("当前模型不支持原生 function calling，请使用以下文本协议调用工具。\n")
+	b.WriteString("需要调用工具时，整条回复只输出一个 JSON 数组，不要附加任何其他文字或代码块标记，例如：\n")
+	b.WriteString(`[{"tool_name": "shell_command", "arguments": {"command": "ls", "workdir": "."}}]`)
+	b.WriteString("\n工具执行结果会以“[工具 名称 的执行结果]”开头的用户消息返回。不需要调用工具时，直接用自然语言回答。\n\n可用工具：\n")
+	for _, t := range tools {
+		t = normalizeCompletionToolSpec(t)
+		b.WriteString("- ")
+		b.WriteString(t.Name)
+		if desc := strings.TrimSpace(t.Description); desc != "" {
+			b.WriteString(": ")
+			b.WriteString(firstLine(desc))
+		}
+		b.WriteString("\n")
+		if len(t.Parameters) > 0 {
+			b.WriteString("  参数 JSON Schema: ")
+			b.WriteString(compactJSON(t.Parameters))
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+// formatPromptedToolCalls 将工具调用格式化为协议要求的 JSON 数组。
+func formatPromptedToolCalls(calls []ToolCall) string {
+	type promptedCall struct {
+		ToolName  string          `json:"tool_name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	out := make([]promptedCall, 0, len(calls))
+	for _, call := range calls {
+		out = append(out, promptedCall{ToolName: call.ToolName, Arguments: toolInputObject(call.Arguments)})
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// stripCodeFence 去掉模型习惯性包裹在 JSON 外面的 ``` 代码块。
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	body := strings.TrimSuffix(strings.TrimPrefix(text, "```"), "```")
+	if idx := strings.IndexByte(body, '\n'); idx >= 0 && !strings.ContainsAny(body[:idx], "[{") {
+		body = body[idx+1:]
+	}
+	return strings.TrimSpace(body)
+}
+
+func firstLine(s string) string {
+	if idx := strings.IndexByte(s, '\n'); idx >= 0 {
+		return s[:idx]
+	}
+	return s
+}
+
+func compactJSON(raw json.RawMessage) string {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return string(raw)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return string(raw)
+	}
+	return string(data)
+}
+
+func joinNonEmpty(parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if strings.TrimSpace(p) != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// ===== 能力探测 =====
+
+// ProbeLocalServer 探测本地推理服务的类型、模型列表、工具能力与上下文窗口。
+// 依次尝试 Ollama 原生接口、llama.cpp 的 /props 与通用的 /v1/models。
+func ProbeLocalServer(ctx context.Context, baseURL, model, apiKey string) (LocalCapabilities, error) {
+	p := localProber{
+		base:   strings.TrimRight(strings.TrimSpace(baseURL), "/"),
+		apiKey: apiKey,
+		client: &http.Client{Timeout: localProbeTimeout},
+	}
+	p.root = strings.TrimSuffix(p.base, "/v1")
+
+	if caps, ok := p.probeOllama(ctx, model); ok {
+		return caps, nil
+	}
+	if caps, ok := p.probeLlamaCpp(ctx); ok {
+		return caps, nil
+	}
+	models, err := p.listOpenAIModels(ctx)
+	if err != nil {
+		return LocalCapabilities{}, fmt.Errorf("探测本地模型服务失败: %w", err)
+	}
+	return LocalCapabilities{Backend: "openai-compatible", Models: models}, nil
+}
+
+type localProber struct {
+	base   string
+	root   string
+	apiKey string
+	client *http.Client
+}
+
+// probeOllama 通过 /api/tags 与 /api/show 获取模型列表与能力。
+func (p localProber) probeOllama(ctx context.Context, model string) (LocalCapabilities, bool) {
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := p.getJSON(ctx, p.root+"/api/tags", &tags); err != nil {
+		return LocalCapabilities{}, false
+	}
+	caps := LocalCapabilities{Backend: "ollama"}
+	for _, m := range tags.Models {
+		caps.Models = append(caps.Models, m.Name)
+	}
+
+	model = resolveLocalModelName(model, caps.Models)
+	if model == "" {
+		return caps, true
+	}
+	var show struct {
+		Capabilities []string       `json:"capabilities"`
+		ModelInfo    map[string]any `json:"model_info"`
+		Parameters   string         `json:"parameters"`
+	}
+	if err := p.postJSON(ctx, p.root+"/api/show", map[string]string{"model": model}, &show); err != nil {
+		log.Printf("[llm] ollama show %s failed: %v", model, err)
+		return caps, true
+	}
+	if show.Capabilities != nil {
+		caps.ToolsKnown = true
+		for _, c := range show.Capabilities {
+			if c == "tools" {
+				caps.SupportsTools = true
+			}
+		}
+	}
+	caps.ContextWindow = ollamaContextWindow(show.ModelInfo, show.Parameters)
+	return caps, true
+}
+
+// ollamaContextWindow 优先使用 Modelfile 中的 num_ctx，否则使用模型自带的 context_length。
+func ollamaContextWindow(info map[string]any, parameters string) int {
+	for _, line := range strings.Split(parameters, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "num_ctx" {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	for k, v := range info {
+		if !strings.HasSuffix(k, ".context_length") {
+			continue
+		}
+		if n, ok := v.(float64); ok && n > 0 {
+			return int(n)
+		}
+	}
+	return 0
+}
+
+// probeLlamaCpp 通过 llama.cpp server 的 /props 获取上下文窗口与模板能力。
+func (p localProber) probeLlamaCpp(ctx context.Context) (LocalCapabilities, bool) {
+	var props struct {
+		DefaultGenerationSettings struct {
+			NCtx int `json:"n_ctx"`
+		} `json:"default_generation_settings"`
+		ChatTemplate     string `json:"chat_template"`
+		ChatTemplateCaps *struct {
+			SupportsTools bool `json:"supports_tools"`
+		} `json:"chat_template_caps"`
+	}
+	if err := p.getJSON(ctx, p.root+"/props", &props); err != nil {
+		return LocalCapabilities{}, false
+	}
+	caps := LocalCapabilities{
+		Backend:       "llama.cpp",
+		ContextWindow: props.DefaultGenerationSettings.NCtx,
+	}
+	switch {
+	case props.ChatTemplateCaps != nil:
+		caps.ToolsKnown = true
+		caps.SupportsTools = props.ChatTemplateCaps.SupportsTools
+	case props.ChatTemplate != "":
+		caps.ToolsKnown = true
+		caps.SupportsTools = strings.Contains(props.ChatTemplate, "tools")
+	}
+	if models, err := p.listOpenAIModels(ctx); err == nil {
+		caps.Models = models
+	}
+	return caps, true
+}
+
+// listOpenAIModels 读取 OpenAI 兼容的 /models 列表。
+func (p localProber) listOpenAIModels(ctx context.Context) ([]string, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := p.getJSON(ctx, p.base+"/models", &list); err != nil {
+		return nil, err
+	}
+	models := make([]string, 0, len(list.Data))
+	for _, m := range list.Data {
+		models = append(models, m.ID)
+	}
+	return models, nil
+}
+
+func (p localProber) getJSON(ctx context.Context, url string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	return p.do(req, out)
+}
+
+func (p localProber) postJSON(ctx context.Context, url string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(string(data)))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return p.do(req, out)
+}
+
+func (p localProber) do(req *http.Request, out any) error {
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s 返回状态码 %d", req.Method, req.URL.Path, resp.StatusCode)
+	}
+	return json.Unmarshal(body, out)
+}
+
+// resolveLocalModelName 在服务端模型列表中匹配模型名，兼容 Ollama 的 :latest 后缀；
+// 未配置模型时取列表中的第一个。
+func resolveLocalModelName(model string, available []string) string {
+	model = strings.TrimSpace(model)
+	if model == "" {
+		if len(available) > 0 {
+			return available[0]
+		}
+		return ""
+	}
+	for _, name := range available {
+		if name == model || name == model+":latest" {
+			return name
+		}
+	}
+	return model
+}
+
+// buildLocalClient 探测本地服务并创建 LocalClient。探测失败时仍返回可用客户端，
+// 工具协议按 tool_mode 决定（auto 时默认尝试原生 function calling）。
+func buildLocalClient(cfg clientConfig, rawMode string, contextWindow int) (clientConfig, LLMClient, error) {
+	mode, err := ParseLocalToolMode(rawMode)
+	if err != nil {
+		return cfg, nil, fmt.Errorf("模型 %s 配置错误: %w", cfg.Alias, err)
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultLocalBaseURL
+	}
+	probeKey := cfg.APIKey
+	if cfg.APIKey == "" {
+		// 本地服务通常不校验 key，但 SDK 要求非空。
+		cfg.APIKey = "local"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), localProbeTimeout)
+	defer cancel()
+	caps, probeErr := ProbeLocalServer(ctx, cfg.BaseURL, cfg.Model, probeKey)
+	if probeErr != nil {
+		log.Printf("[llm] local model %s probe failed: %v", cfg.Alias, probeErr)
+	}
+
+	resolved := resolveLocalModelName(cfg.Model, caps.Models)
+	if resolved == "" {
+		return cfg, nil, fmt.Errorf("模型 %s 未指定 model，且本地服务未返回可用模型", cfg.Alias)
+	}
+	if len(caps.Models) > 0 && !containsString(caps.Models, resolved) {
+		log.Printf("[llm] local model %s not found in server list %v", resolved, caps.Models)
+	}
+	cfg.Model = resolved
+
+	cfg.ContextWindow = caps.ContextWindow
+	if contextWindow > 0 {
+		cfg.ContextWindow = contextWindow
+	}
+
+	client := NewLocalClient(cfg, mode, caps)
+	log.Printf("[llm] local model alias=%s backend=%s model=%s tool_mode=%s context_window=%d",
+		cfg.Alias, caps.Backend, cfg.Model, client.ToolMode(), cfg.ContextWindow)
+	return cfg, client, nil
+}
+
+func containsString(list []string, target string) bool {
+	for _, s := range list {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	servertools "chase-code/server/tools"
+)
+
+// TestProbeLocalServer_Ollama 验证 Ollama 的模型列表、工具能力与上下文窗口探测。
+func TestProbeLocalServer_Ollama(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			fmt.Fprint(w, `{"models":[{"name":"qwen2.5-coder:latest"},{"name":"llama3:8b"}]}`)
+		case "/api/show":
+			var req map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			assert.Equal(t, "qwen2.5-coder:latest", req["model"])
+			fmt.Fprint(w, `{"capabilities":["completion"],"model_info":{"qwen2.context_length":32768},"parameters":"num_ctx 8192\nstop \"<|im_end|>\""}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	caps, err := ProbeLocalServer(context.Background(), srv.URL+"/v1", "qwen2.5-coder", "")
+	require.NoError(t, err)
+	assert.Equal(t, "ollama", caps.Backend)
+	assert.Equal(t, []string{"qwen2.5-coder:latest", "llama3:8b"}, caps.Models)
+	assert.True(t, caps.ToolsKnown)
+	assert.False(t, caps.SupportsTools)
+	assert.Equal(t, 8192, caps.ContextWindow)
+
+	client := NewLocalClient(clientConfig{Model: "qwen2.5-coder:latest", BaseURL: srv.URL + "/v1", APIKey: "local"}, LocalToolModeAuto, caps)
+	assert.Equal(t, LocalToolModePrompt, client.ToolMode())
+}
+
+// TestProbeLocalServer_LlamaCpp 验证 llama.cpp server 的 /props 探测。
+func TestProbeLocalServer_LlamaCpp(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/props":
+			fmt.Fprint(w, `{"default_generation_settings":{"n_ctx":16384},"chat_template_caps":{"supports_tools":true}}`)
+		case "/v1/models":
+			fmt.Fprint(w, `{"data":[{"id":"gguf-model"}]}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	caps, err := ProbeLocalServer(context.Background(), srv.URL+"/v1", "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "llama.cpp", caps.Backend)
+	assert.Equal(t, []string{"gguf-model"}, caps.Models)
+	assert.True(t, caps.SupportsTools)
+	assert.Equal(t, 16384, caps.ContextWindow)
+}
+
+// TestBuildPromptedToolPrompt 验证文本协议下工具轨迹被改写为纯文本，回复中的 JSON 能解析回工具调用。
+func TestBuildPromptedToolPrompt(t *testing.T) {
+	p := buildPromptedToolPrompt(Prompt{
+		Tools: []ToolSpec{servertools.ShellCommandToolSpec()},
+		Items: []ResponseItem{
+			{Type: ResponseItemMessage, Role: RoleSystem, Text: "system prompt"},
+			{Type: ResponseItemMessage, Role: RoleUser, Text: "看看目录"},
+			{Type: ResponseItemMessage, Role: RoleAssistant, ToolCalls: []ToolCall{{
+				ToolName:  "shell_command",
+				Arguments: json.RawMessage(`{"command":"ls"}`),
+				CallID:    "local-0-0",
+			}}},
+			{Type: ResponseItemToolResult, ToolName: "shell_command", ToolOutput: "main.go", CallID: "local-0-0"},
+		},
+	})
+
+	assert.Empty(t, p.Tools)
+	require.Len(t, p.Items, 5)
+	assert.Equal(t, RoleSystem, p.Items[1].Role)
+	assert.Contains(t, p.Items[1].Text, "shell_command")
+	assert.Equal(t, RoleAssistant, p.Items[3].Role)
+	assert.JSONEq(t, `[{"tool_name":"shell_command","arguments":{"command":"ls"}}]`, p.Items[3].Text)
+	assert.Equal(t, RoleUser, p.Items[4].Role)
+	assert.Contains(t, p.Items[4].Text, "main.go")
+
+	client := &LocalClient{mode: LocalToolModePrompt}
+	res := client.finishResult(&LLMResult{Message: LLMMessage{
+		Role:    RoleAssistant,
+		Content: "```json\n[{\"tool_name\":\"shell_command\",\"arguments\":{\"command\":\"pwd\"}}]\n```",
+	}})
+	require.Len(t, res.ToolCalls, 1)
+	assert.Equal(t, "shell_command", res.ToolCalls[0].ToolName)
+	assert.JSONEq(t, `{"command":"pwd"}`, string(res.ToolCalls[0].Arguments))
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"net"
+	"net/http"
 	"net/url"
 	"strings"
 	"syscall"
+
+	"github.com/openai/openai-go"
 )
 
 // NetworkError 用于标记与网络连接相关的错误。
@@ -36,6 +39,26 @@ func IsNetworkError(err error) bool {
 	return isNetworkError(err)
 }
 
+// IsRetryableError 判断错误是否属于“换个模型可能成功”的类型：网络错误或服务端 5xx。
+// SDK 内部已对这类错误做过重试，走到这里说明当前模型的重试已经耗尽。
+func IsRetryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsNetworkError(err) {
+		return true
+	}
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError
+	}
+	var anthropicErr *AnthropicError
+	if errors.As(err, &anthropicErr) {
+		return anthropicErr.StatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
 // wrapNetworkError 将网络错误包装为 NetworkError，便于上层识别。
 func wrapNetworkError(err error) error {
 	if err == nil {
@@ -0,0 +1,53 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// openAIStatusError 构造带请求/响应的 openai.Error，Error() 依赖这两个字段。
+func openAIStatusError(code int) *openai.Error {
+	return &openai.Error{
+		StatusCode: code,
+		Request:    httptest.NewRequest(http.MethodPost, "http://api.example.com/v1/responses", nil),
+		Response:   &http.Response{StatusCode: code},
+	}
+}
+
+// TestIsRetryableError 验证只有网络错误与服务端 5xx 会触发备用模型切换。
+func TestIsRetryableError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"marked network error", NetworkError{Err: errors.New("boom")}, true},
+		{"url error", &url.Error{Op: "Post", URL: "http://x", Err: errors.New("eof")}, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"deadline", fmt.Errorf("wrapped: %w", context.DeadlineExceeded), true},
+		{"message indicator", errors.New("dial tcp: lookup api.example.com: no such host"), true},
+		{"openai 500", openAIStatusError(500), true},
+		{"openai 503 wrapped", fmt.Errorf("stream: %w", openAIStatusError(503)), true},
+		{"openai 400", openAIStatusError(400), false},
+		{"openai 429", openAIStatusError(429), false},
+		{"anthropic 529", &AnthropicError{StatusCode: 529}, true},
+		{"anthropic 401", &AnthropicError{StatusCode: 401}, false},
+		{"canceled", context.Canceled, false},
+		{"plain", errors.New("invalid tool schema"), false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsRetryableError(tc.err))
+		})
+	}
+}
@@ -0,0 +1,177 @@
+package llm
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/packages/param"
+	"github.com/openai/openai-go/responses"
+	"github.com/tidwall/sjson"
+
+	"chase-code/config"
+)
+
+// toolChoiceModes 为各 API 通用的 tool_choice 取值，其余字符串视为工具名。
+var toolChoiceModes = map[string]bool{"auto": true, "none": true, "required": true}
+
+// generationRequestOptions 将自定义 header 与 extra_body 转换为 SDK 的请求选项。
+func generationRequestOptions(cfg clientConfig) []option.RequestOption {
+	var opts []option.RequestOption
+	for _, key := range config.SortedKeys(cfg.Params.Headers) {
+		opts = append(opts, option.WithHeader(key, cfg.Params.Headers[key]))
+	}
+	for _, key := range config.SortedKeys(cfg.Params.ExtraBody) {
+		opts = append(opts, option.WithJSONSet(key, cfg.Params.ExtraBody[key]))
+	}
+	return opts
+}
+
+// mergeExtraBody 将 extra_body 写入已序列化的请求体，供不经过 SDK 的客户端使用。
+func mergeExtraBody(data []byte, extra map[string]any) ([]byte, error) {
+	var err error
+	for _, key := range config.SortedKeys(extra) {
+		data, err = sjson.SetBytes(data, key, extra[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// applyCompletionsParams 将生成参数写入 Chat Completions 请求。
+// max_tokens 使用兼容性最好的 max_tokens 字段；需要 max_completion_tokens 的模型可通过 extra_body 指定。
+func applyCompletionsParams(params *openai.ChatCompletionNewParams, cfg clientConfig) {
+	gp := cfg.Params
+	if gp.Temperature != nil {
+		params.Temperature = param.NewOpt(*gp.Temperature)
+	}
+	if gp.TopP != nil {
+		params.TopP = param.NewOpt(*gp.TopP)
+	}
+	if gp.MaxTokens > 0 {
+		params.MaxTokens = param.NewOpt(gp.MaxTokens)
+	}
+	if gp.Seed != nil {
+		params.Seed = param.NewOpt(*gp.Seed)
+	}
+	if len(params.Tools) == 0 {
+		return
+	}
+	if gp.ParallelToolCalls != nil {
+		params.ParallelToolCalls = param.NewOpt(*gp.ParallelToolCalls)
+	}
+	if choice := strings.TrimSpace(gp.ToolChoice); choice != "" {
+		if toolChoiceModes[choice] {
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionUnionParam{OfAuto: param.NewOpt(choice)}
+		} else {
+			params.ToolChoice = openai.ChatCompletionToolChoiceOptionParamOfChatCompletionNamedToolChoice(
+				openai.ChatCompletionNamedToolChoiceFunctionParam{Name: choice},
+			)
+		}
+	}
+}
+
+// applyResponsesParams 将生成参数写入 Responses API 请求。Responses API 不支持 seed，配置后会被忽略。
+func applyResponsesParams(params *responses.ResponseNewParams, cfg clientConfig, toolModes map[string]toolCallMode) {
+	gp := cfg.Params
+	if gp.Temperature != nil {
+		params.Temperature = param.NewOpt(*gp.Temperature)
+	}
+	if gp.TopP != nil {
+		params.TopP = param.NewOpt(*gp.TopP)
+	}
+	if gp.MaxTokens > 0 {
+		params.MaxOutputTokens = param.NewOpt(gp.MaxTokens)
+	}
+	if gp.Seed != nil {
+		log.Printf("[llm] seed is not supported by responses api, ignored alias=%s", cfg.Alias)
+	}
+	if len(params.Tools) == 0 {
+		return
+	}
+	if gp.ParallelToolCalls != nil {
+		params.ParallelToolCalls = param.NewOpt(*gp.ParallelToolCalls)
+	}
+	choice := strings.TrimSpace(gp.ToolChoice)
+	switch {
+	case choice == "":
+	case toolChoiceModes[choice]:
+		params.ToolChoice = responses.ResponseNewParamsToolChoiceUnion{OfToolChoiceMode: param.NewOpt(responses.ToolChoiceOptions(choice))}
+	case toolModes[choice] == toolCallModeCustom:
+		// SDK 尚未提供 custom 工具的 tool_choice 类型，直接下发原始 JSON。
+		raw, _ := json.Marshal(map[string]string{"type": "custom", "name": choice})
+		params.ToolChoice = param.Override[responses.ResponseNewParamsToolChoiceUnion](json.RawMessage(raw))
+	default:
+		params.ToolChoice = responses.ResponseNewParamsToolChoiceUnion{OfFunctionTool: &responses.ToolChoiceFunctionParam{Name: choice}}
+	}
+}
+
+// applyAnthropicParams 将生成参数写入 Messages API 请求。Messages API 不支持 seed，配置后会被忽略。
+func applyAnthropicParams(req *anthropicRequest, cfg clientConfig) {
+	gp := cfg.Params
+	req.Temperature = gp.Temperature
+	req.TopP = gp.TopP
+	if gp.MaxTokens > 0 {
+		req.MaxTokens = int(gp.MaxTokens)
+	}
+	applyAnthropicToolChoice(req, gp)
+	applyAnthropicThinking(req, cfg)
+}
+
+// anthropicThinkingBudget 将 reasoning_effort 映射为 extended thinking 的 token 预算，未配置时返回 0。
+func anthropicThinkingBudget(effort string) int {
+	switch effort {
+	case "low":
+		return anthropicMinThinkingBudget
+	case "medium":
+		return 4096
+	case "high":
+		return 16384
+	}
+	return 0
+}
+
+// applyAnthropicThinking 按 reasoning_effort 开启 extended thinking。
+// 开启后 temperature/top_p 不可设置，max_tokens 需大于思考预算；强制指定工具时服务端不支持思考，保持关闭。
+func applyAnthropicThinking(req *anthropicRequest, cfg clientConfig) {
+	budget := anthropicThinkingBudget(cfg.ReasoningEffort)
+	if budget == 0 {
+		return
+	}
+	if req.ToolChoice != nil && req.ToolChoice.Type != "auto" && req.ToolChoice.Type != "none" {
+		log.Printf("[llm] skip anthropic thinking: tool_choice=%s is not supported with thinking", req.ToolChoice.Type)
+		return
+	}
+	req.Thinking = &anthropicThinking{Type: "enabled", BudgetTokens: budget}
+	if req.Temperature != nil || req.TopP != nil {
+		log.Printf("[llm] ignore temperature/top_p: not supported with anthropic thinking")
+		req.Temperature = nil
+		req.TopP = nil
+	}
+	if req.MaxTokens <= budget {
+		req.MaxTokens = budget + defaultAnthropicMaxTokens
+	}
+}
+
+// applyAnthropicToolChoice 将 tool_choice 与 parallel_tool_calls 写入请求。
+func applyAnthropicToolChoice(req *anthropicRequest, gp config.GenerationParams) {
+	if req.ToolChoice == nil {
+		return
+	}
+	switch choice := strings.TrimSpace(gp.ToolChoice); {
+	case choice == "" || choice == "auto":
+	case choice == "required":
+		req.ToolChoice.Type = "any"
+	case choice == "none":
+		req.ToolChoice.Type = "none"
+	default:
+		req.ToolChoice = &anthropicToolChoice{Type: "tool", Name: choice}
+	}
+	if gp.ParallelToolCalls != nil && !*gp.ParallelToolCalls && req.ToolChoice.Type != "none" {
+		disable := true
+		req.ToolChoice.DisableParallelToolUse = &disable
+	}
+}
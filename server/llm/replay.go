@@ -0,0 +1,378 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/openai/openai-go"
+)
+
+// CassetteMode 表示 ReplayClient 的工作模式。
+type CassetteMode string
+
+const (
+	// CassetteRecord 调用真实客户端，并将请求与响应写入 cassette 文件。
+	CassetteRecord CassetteMode = "record"
+	// CassetteReplay 只从 cassette 文件回放，不发起任何网络请求。
+	CassetteReplay CassetteMode = "replay"
+)
+
+// cassetteVersion 为 cassette 文件格式版本；2 起 PromptHash 不再包含环境上下文的具体内容。
+const cassetteVersion = 2
+
+// environmentContextPattern 匹配会话注入的 <environment_context> 块（含 cwd、shell 等本机信息）。
+var environmentContextPattern = regexp.MustCompile(`(?s)<environment_context>.*?</environment_context>`)
+
+// ParseCassetteMode 解析 cassette 模式字符串，空值视为 replay。
+func ParseCassetteMode(raw string) (CassetteMode, error) {
+	switch CassetteMode(strings.ToLower(strings.TrimSpace(raw))) {
+	case "", CassetteReplay:
+		return CassetteReplay, nil
+	case CassetteRecord:
+		return CassetteRecord, nil
+	default:
+		return "", fmt.Errorf("不支持的 cassette 模式: %s（可选 record/replay）", raw)
+	}
+}
+
+// Cassette 为录制的请求/响应集合，以 JSON 文件保存。
+type Cassette struct {
+	Version      int           `json:"version"`
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction 为一次录制的模型调用：以 Prompt 哈希为键，保存流式事件与最终结果。
+type Interaction struct {
+	PromptHash string          `json:"prompt_hash"`
+	Stream     bool            `json:"stream"`
+	Events     []RecordedEvent `json:"events,omitempty"`
+	Result     *LLMResult      `json:"result,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	ErrorDetail
+}
+
+// RecordedEvent 为 LLMEvent 的可序列化形式，错误以字符串及 ErrorDetail 保存。
+type RecordedEvent struct {
+	Kind      LLMEventKind `json:"kind"`
+	TextDelta string       `json:"text_delta,omitempty"`
+	FullText  string       `json:"full_text,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	ErrorDetail
+	Result *LLMResult `json:"result,omitempty"`
+}
+
+// 录制错误的类别，回放时据此还原出同类错误。
+const (
+	errorKindNetwork   = "network"
+	errorKindCanceled  = "canceled"
+	errorKindOpenAI    = "openai"
+	errorKindAnthropic = "anthropic"
+)
+
+// ErrorDetail 记录错误的类别与 API 状态码。只保存错误文本时回放出的是普通错误，
+// IsRetryableError 与模型回退无法识别，回放时据此还原类型化错误。
+type ErrorDetail struct {
+	ErrorKind  string `json:"error_kind,omitempty"`
+	StatusCode int    `json:"status_code,omitempty"`
+	ErrorType  string `json:"error_type,omitempty"`
+}
+
+// ReplayClient 在录制模式下代理真实客户端并写入 cassette，在回放模式下按 Prompt 哈希确定性地返回录制结果。
+// 同一 Prompt 被多次调用时按录制顺序依次回放。ReplayClient 不实现 ResponseChainer，
+// 录制与回放都发送完整上下文，保证两边的 Prompt 哈希一致。
+type ReplayClient struct {
+	mode  CassetteMode
+	path  string
+	inner LLMClient
+
+	mu       sync.Mutex
+	cassette Cassette
+	// cursor 记录回放模式下每个哈希已消费的条目数。
+	cursor map[string]int
+}
+
+// NewReplayClient 创建 cassette 客户端。录制模式需要 inner，且会覆盖已有文件；回放模式读取 path。
+func NewReplayClient(mode CassetteMode, path string, inner LLMClient) (*ReplayClient, error) {
+	c := &ReplayClient{
+		mode:     mode,
+		path:     path,
+		inner:    inner,
+		cassette: Cassette{Version: cassetteVersion},
+		cursor:   make(map[string]int),
+	}
+	switch mode {
+	case CassetteRecord:
+		if inner == nil {
+			return nil, fmt.Errorf("录制 cassette 需要真实的模型客户端")
+		}
+		if err := c.save(); err != nil {
+			return nil, err
+		}
+	case CassetteReplay:
+		cassette, err := LoadCassette(path)
+		if err != nil {
+			return nil, err
+		}
+		c.cassette = *cassette
+	default:
+		return nil, fmt.Errorf("不支持的 cassette 模式: %s", mode)
+	}
+	return c, nil
+}
+
+// LoadCassette 读取 cassette 文件。
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取 cassette 失败: %w", err)
+	}
+	var cassette Cassette
+	if err := json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("解析 cassette %s 失败: %w", path, err)
+	}
+	if cassette.Version != cassetteVersion {
+		return nil, fmt.Errorf("cassette %s 版本 %d 不受支持（当前 %d）", path, cassette.Version, cassetteVersion)
+	}
+	return &cassette, nil
+}
+
+// Mode 返回当前工作模式。
+func (c *ReplayClient) Mode() CassetteMode {
+	return c.mode
+}
+
+func (c *ReplayClient) Complete(ctx context.Context, p Prompt) (*LLMResult, error) {
+	hash := PromptHash(p)
+	if c.mode == CassetteReplay {
+		it, err := c.lookup(hash, false)
+		if err != nil {
+			return nil, err
+		}
+		if it.Error != "" {
+			return nil, replayError(it.Error, it.ErrorDetail)
+		}
+		return it.Result, nil
+	}
+
+	res, err := c.inner.Complete(ctx, p)
+	it := Interaction{PromptHash: hash, Result: res}
+	if err != nil {
+		it.Error, it.ErrorDetail = err.Error(), recordError(err)
+	}
+	if saveErr := c.record(it); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	return res, err
+}
+
+func (c *ReplayClient) Stream(ctx context.Context, p Prompt) *LLMStream {
+	hash := PromptHash(p)
+	ch := make(chan LLMEvent, 128)
+	stream := &LLMStream{C: ch}
+
+	if c.mode == CassetteReplay {
+		go func() {
+			defer close(ch)
+			it, err := c.lookup(hash, true)
+			if err != nil {
+				ch <- LLMEvent{Kind: LLMEventError, Error: err}
+				return
+			}
+			for _, ev := range it.Events {
+				ch <- ev.event()
+			}
+		}()
+		return stream
+	}
+
+	inner := c.inner.Stream(ctx, p)
+	go func() {
+		defer close(ch)
+		it := Interaction{PromptHash: hash, Stream: true}
+		for ev := range inner.C {
+			it.Events = append(it.Events, recordEvent(ev))
+			ch <- ev
+		}
+		if err := c.record(it); err != nil {
+			ch <- LLMEvent{Kind: LLMEventError, Error: err}
+		}
+	}()
+	return stream
+}
+
+// lookup 在回放模式下取出哈希对应的下一条录制结果。
+func (c *ReplayClient) lookup(hash string, stream bool) (Interaction, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	skip := c.cursor[hash]
+	for _, it := range c.cassette.Interactions {
+		if it.PromptHash != hash || it.Stream != stream {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		c.cursor[hash]++
+		return it, nil
+	}
+	return Interaction{}, fmt.Errorf("cassette %s 中没有匹配的请求 (prompt_hash=%s, stream=%v)，请重新录制", c.path, hash, stream)
+}
+
+// record 追加一条录制结果并立即写盘，进程中途退出时已录制的部分仍然可用。
+func (c *ReplayClient) record(it Interaction) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cassette.Interactions = append(c.cassette.Interactions, it)
+	return c.saveLocked()
+}
+
+// save 写入 cassette 文件。
+func (c *ReplayClient) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.saveLocked()
+}
+
+// saveLocked 在调用方已持有 mu 时写入 cassette 文件。
+func (c *ReplayClient) saveLocked() error {
+	data, err := json.MarshalIndent(c.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化 cassette 失败: %w", err)
+	}
+	if dir := filepath.Dir(c.path); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("创建 cassette 目录失败: %w", err)
+		}
+	}
+	if err := os.WriteFile(c.path, data, 0o600); err != nil {
+		return fmt.Errorf("写入 cassette 失败: %w", err)
+	}
+	return nil
+}
+
+// recordEvent 将 LLMEvent 转换为可序列化形式。
+func recordEvent(ev LLMEvent) RecordedEvent {
+	out := RecordedEvent{Kind: ev.Kind, TextDelta: ev.TextDelta, FullText: ev.FullText, Result: ev.Result}
+	if ev.Error != nil {
+		out.Error, out.ErrorDetail = ev.Error.Error(), recordError(ev.Error)
+	}
+	return out
+}
+
+// event 将录制的事件还原为 LLMEvent。
+func (e RecordedEvent) event() LLMEvent {
+	ev := LLMEvent{Kind: e.Kind, TextDelta: e.TextDelta, FullText: e.FullText, Result: e.Result}
+	if e.Error != "" {
+		ev.Error = replayError(e.Error, e.ErrorDetail)
+	}
+	return ev
+}
+
+// recordError 提取错误的类别与状态码。
+func recordError(err error) ErrorDetail {
+	var openaiErr *openai.Error
+	if errors.As(err, &openaiErr) {
+		return ErrorDetail{ErrorKind: errorKindOpenAI, StatusCode: openaiErr.StatusCode, ErrorType: openaiErr.Type}
+	}
+	var anthropicErr *AnthropicError
+	if errors.As(err, &anthropicErr) {
+		return ErrorDetail{ErrorKind: errorKindAnthropic, StatusCode: anthropicErr.StatusCode, ErrorType: anthropicErr.Type}
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorDetail{ErrorKind: errorKindCanceled}
+	}
+	if IsNetworkError(err) {
+		return ErrorDetail{ErrorKind: errorKindNetwork}
+	}
+	return ErrorDetail{}
+}
+
+// replayError 按录制的类别还原错误：错误文本与录制时一致，Unwrap 得到对应的类型化错误。
+func replayError(msg string, d ErrorDetail) error {
+	var cause error
+	switch d.ErrorKind {
+	case errorKindNetwork:
+		cause = NetworkError{Err: errors.New(msg)}
+	case errorKindCanceled:
+		cause = context.Canceled
+	case errorKindOpenAI:
+		// openai.Error.Error() 会读取 Request 与 Response，需要填充以免空指针。
+		cause = &openai.Error{
+			StatusCode: d.StatusCode,
+			Type:       d.ErrorType,
+			Message:    msg,
+			Request:    &http.Request{Method: http.MethodPost, URL: &url.URL{}},
+			Response:   &http.Response{StatusCode: d.StatusCode},
+		}
+	case errorKindAnthropic:
+		cause = &AnthropicError{StatusCode: d.StatusCode, Type: d.ErrorType, Message: msg}
+	default:
+		return errors.New(msg)
+	}
+	return &replayedError{msg: msg, cause: cause}
+}
+
+// replayedError 为回放出的错误，保留录制时的错误文本。
+type replayedError struct {
+	msg   string
+	cause error
+}
+
+// Error 返回录制时的错误文本。
+func (e *replayedError) Error() string {
+	return e.msg
+}
+
+// Unwrap 返回还原出的类型化错误。
+func (e *replayedError) Unwrap() error {
+	return e.cause
+}
+
+// PromptHash 计算 Prompt 的稳定哈希：工具按名称排序，避免 ToolRouter 中 map 遍历顺序影响结果；
+// <environment_context> 块被替换为固定占位符，使同一 cassette 可以在不同目录、不同 shell 下回放。
+func PromptHash(p Prompt) string {
+	specs := append([]ToolSpec(nil), p.Tools...)
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+
+	messages := make([]Message, len(p.Messages))
+	for i, m := range p.Messages {
+		m.Content = normalizeEnvironmentContext(m.Content)
+		messages[i] = m
+	}
+	items := make([]ResponseItem, len(p.Items))
+	for i, it := range p.Items {
+		it.Text = normalizeEnvironmentContext(it.Text)
+		items[i] = it
+	}
+
+	data, _ := json.Marshal(struct {
+		Messages           []Message      `json:"messages,omitempty"`
+		Items              []ResponseItem `json:"items,omitempty"`
+		Tools              []ToolSpec     `json:"tools,omitempty"`
+		PreviousResponseID string         `json:"previous_response_id,omitempty"`
+	}{messages, items, specs, p.PreviousResponseID})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeEnvironmentContext 将文本中的 <environment_context> 块替换为不含本机信息的占位符。
+func normalizeEnvironmentContext(text string) string {
+	if !strings.Contains(text, "<environment_context>") {
+		return text
+	}
+	return environmentContextPattern.ReplaceAllString(text, "<environment_context/>")
+}
@@ -0,0 +1,193 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// collectStream 读取流式事件直到结束，返回最终结果与错误。
+func collectStream(t *testing.T, stream *LLMStream) (string, *LLMResult, error) {
+	t.Helper()
+	var (
+		text   string
+		result *LLMResult
+		err    error
+	)
+	for ev := range stream.C {
+		switch ev.Kind {
+		case LLMEventTextDelta:
+			text += ev.TextDelta
+		case LLMEventCompleted:
+			result = ev.Result
+		case LLMEventError:
+			err = ev.Error
+		}
+	}
+	return text, result, err
+}
+
+// TestReplayClient_RecordAndReplay 验证录制的流式与非流式调用可以脱离真实客户端确定性回放。
+func TestReplayClient_RecordAndReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassettes", "turn.json")
+	first := Prompt{Items: []ResponseItem{{Type: ResponseItemMessage, Role: RoleUser, Text: "hi"}}}
+	second := Prompt{Items: []ResponseItem{{Type: ResponseItemMessage, Role: RoleUser, Text: "again"}}}
+
+	inner := NewScriptedClient(
+		ScriptedStep{Deltas: []string{"Hel", "lo"}, Text: "Hello", ToolCalls: []ToolCall{{ToolName: "shell", Arguments: json.RawMessage(`{"command":"ls"}`), CallID: "c1"}}},
+		ScriptedStep{Text: "summary"},
+	)
+	recorder, err := NewReplayClient(CassetteRecord, path, inner)
+	require.NoError(t, err)
+
+	text, res, err := collectStream(t, recorder.Stream(context.Background(), first))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", text)
+	require.NotNil(t, res)
+	_, err = recorder.Complete(context.Background(), second)
+	require.NoError(t, err)
+
+	player, err := NewReplayClient(CassetteReplay, path, nil)
+	require.NoError(t, err)
+
+	text, res, err = collectStream(t, player.Stream(context.Background(), first))
+	require.NoError(t, err)
+	assert.Equal(t, "Hello", text)
+	require.Len(t, res.ToolCalls, 1)
+	assert.Equal(t, "shell", res.ToolCalls[0].ToolName)
+	assert.JSONEq(t, `{"command":"ls"}`, string(res.ToolCalls[0].Arguments))
+
+	got, err := player.Complete(context.Background(), second)
+	require.NoError(t, err)
+	assert.Equal(t, "summary", got.Message.Content)
+
+	// 录制中没有的请求应明确报错，而不是静默返回空结果。
+	_, _, err = collectStream(t, player.Stream(context.Background(), second))
+	assert.ErrorContains(t, err, "没有匹配的请求")
+}
+
+// TestReplayClient_RepeatedPrompt 验证相同 Prompt 多次调用时按录制顺序依次回放，错误也会被回放。
+func TestReplayClient_RepeatedPrompt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "repeat.json")
+	p := Prompt{Messages: []Message{{Role: RoleUser, Content: "retry"}}}
+
+	inner := NewScriptedClient(
+		ScriptedStep{Err: NetworkError{Err: assert.AnError}},
+		ScriptedStep{Text: "ok"},
+	)
+	recorder, err := NewReplayClient(CassetteRecord, path, inner)
+	require.NoError(t, err)
+	_, err = recorder.Complete(context.Background(), p)
+	require.Error(t, err)
+	_, err = recorder.Complete(context.Background(), p)
+	require.NoError(t, err)
+
+	player, err := NewReplayClient(CassetteReplay, path, nil)
+	require.NoError(t, err)
+	_, err = player.Complete(context.Background(), p)
+	assert.ErrorContains(t, err, assert.AnError.Error())
+	res, err := player.Complete(context.Background(), p)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", res.Message.Content)
+}
+
+// TestReplayClient_TypedErrors 验证回放的错误保留类别与状态码，IsRetryableError 的判断与录制时一致。
+func TestReplayClient_TypedErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.json")
+	openaiErr := &openai.Error{
+		StatusCode: http.StatusBadRequest,
+		Type:       "invalid_request_error",
+		Request:    &http.Request{Method: http.MethodPost, URL: &url.URL{Path: "/v1/responses"}},
+		Response:   &http.Response{StatusCode: http.StatusBadRequest},
+	}
+	cases := []struct {
+		name      string
+		err       error
+		stream    bool
+		retryable bool
+	}{
+		{"anthropic 5xx", &AnthropicError{StatusCode: 529, Type: "overloaded_error", Message: "busy"}, false, true},
+		{"anthropic 4xx stream", &AnthropicError{StatusCode: 400, Type: "invalid_request_error", Message: "bad"}, true, false},
+		{"openai 4xx", openaiErr, false, false},
+		{"network stream", NetworkError{Err: assert.AnError}, true, true},
+		{"plain", assert.AnError, false, false},
+	}
+
+	steps := make([]ScriptedStep, len(cases))
+	for i, tc := range cases {
+		steps[i] = ScriptedStep{Err: tc.err}
+	}
+	recorder, err := NewReplayClient(CassetteRecord, path, NewScriptedClient(steps...))
+	require.NoError(t, err)
+	call := func(c *ReplayClient, i int) error {
+		p := Prompt{Messages: []Message{{Role: RoleUser, Content: cases[i].name}}}
+		if cases[i].stream {
+			_, _, err := collectStream(t, c.Stream(context.Background(), p))
+			return err
+		}
+		_, err := c.Complete(context.Background(), p)
+		return err
+	}
+	for i := range cases {
+		require.Error(t, call(recorder, i))
+	}
+
+	player, err := NewReplayClient(CassetteReplay, path, nil)
+	require.NoError(t, err)
+	replayed := make([]error, len(cases))
+	for i, tc := range cases {
+		replayed[i] = call(player, i)
+		require.Error(t, replayed[i], tc.name)
+		assert.Equal(t, tc.err.Error(), replayed[i].Error(), tc.name)
+		assert.Equal(t, tc.retryable, IsRetryableError(replayed[i]), tc.name)
+	}
+
+	var apiErr *AnthropicError
+	require.ErrorAs(t, replayed[0], &apiErr)
+	assert.Equal(t, 529, apiErr.StatusCode)
+	assert.Equal(t, "overloaded_error", apiErr.Type)
+}
+
+// TestPromptHash_ToolOrder 验证工具顺序不影响 Prompt 哈希。
+func TestPromptHash_ToolOrder(t *testing.T) {
+	a := Prompt{Tools: []ToolSpec{{Name: "shell"}, {Name: "apply_patch"}}}
+	b := Prompt{Tools: []ToolSpec{{Name: "apply_patch"}, {Name: "shell"}}}
+	assert.Equal(t, PromptHash(a), PromptHash(b))
+
+	c := Prompt{Tools: a.Tools, Items: []ResponseItem{{Type: ResponseItemMessage, Role: RoleUser, Text: "x"}}}
+	assert.NotEqual(t, PromptHash(a), PromptHash(c))
+}
+
+// TestScriptedClient_Exhausted 验证脚本耗尽后返回错误并记录收到的 Prompt。
+func TestScriptedClient_Exhausted(t *testing.T) {
+	client := NewScriptedClient(ScriptedStep{Text: "only"})
+	_, err := client.Complete(context.Background(), Prompt{})
+	require.NoError(t, err)
+	_, _, err = collectStream(t, client.Stream(context.Background(), Prompt{}))
+	assert.ErrorContains(t, err, "脚本已耗尽")
+	assert.Len(t, client.Prompts(), 2)
+	assert.Equal(t, 0, client.Remaining())
+}
+
+// TestPromptHash_IgnoresEnvironmentContext 验证 cwd、shell 等环境信息不影响 Prompt 哈希，其它内容仍参与计算。
+func TestPromptHash_IgnoresEnvironmentContext(t *testing.T) {
+	env := func(cwd, shell string) string {
+		return "<environment_context>\n  <cwd>" + cwd + "</cwd>\n  <shell>" + shell + "</shell>\n</environment_context>"
+	}
+	prompt := func(text string) Prompt {
+		return Prompt{Items: []ResponseItem{{Type: ResponseItemMessage, Role: RoleUser, Text: text}}}
+	}
+
+	a := prompt(env("/home/a/repo", "bash"))
+	b := prompt(env("/Users/b/src/repo", "zsh"))
+	assert.Equal(t, PromptHash(a), PromptHash(b))
+	assert.NotEqual(t, PromptHash(a), PromptHash(prompt(env("/home/a/repo", "bash")+"\n列出文件")))
+	assert.Contains(t, a.Items[0].Text, "/home/a/repo", "哈希不能修改原始 Prompt")
+}
@@ -3,6 +3,7 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"strings"
 	"time"
@@ -18,6 +19,26 @@ import (
 	"chase-code/server/utils"
 )
 
+// Responses API 的上下文传递方式。
+const (
+	ResponsesStateReplay             = ""
+	ResponsesStatePreviousResponseID = "previous_response_id"
+	ResponsesStateEncryptedReasoning = "encrypted_reasoning"
+)
+
+// parseResponsesState 校验配置中的 state 字段。
+func parseResponsesState(raw string) (string, error) {
+	state := strings.ToLower(strings.TrimSpace(raw))
+	switch state {
+	case ResponsesStateReplay, "replay", "stateless":
+		return ResponsesStateReplay, nil
+	case ResponsesStatePreviousResponseID, ResponsesStateEncryptedReasoning:
+		return state, nil
+	default:
+		return "", fmt.Errorf("不支持的 responses.state: %s（可选 previous_response_id/encrypted_reasoning）", raw)
+	}
+}
+
 // ResponsesClient 使用官方 openai-go SDK 的 Responses API 与模型交互。
 type ResponsesClient struct {
 	cfg    clientConfig
@@ -26,11 +47,12 @@ type ResponsesClient struct {
 
 // NewResponsesClient 创建一个新的 ResponsesClient。
 func NewResponsesClient(cfg clientConfig) *ResponsesClient {
-	c := openai.NewClient(
+	opts := append([]option.RequestOption{
 		option.WithAPIKey(cfg.APIKey),
 		option.WithBaseURL(cfg.BaseURL),
 		option.WithHTTPClient(newHTTPClient(cfg.Timeout)),
-	)
+	}, generationRequestOptions(cfg)...)
+	c := openai.NewClient(opts...)
 	return &ResponsesClient{cfg: cfg, client: &c}
 }
 
@@ -44,14 +66,39 @@ func (c *ResponsesClient) Complete(ctx context.Context, p Prompt) (*LLMResult, e
 	}
 
 	text, calls := c.extractOutput(resp.Output)
-	log.Printf("[llm] success alias=%s model=%s elapsed=%s", c.cfg.Alias, c.cfg.Model, time.Since(start))
+	log.Printf("[llm] success alias=%s model=%s response_id=%s elapsed=%s", c.cfg.Alias, c.cfg.Model, resp.ID, time.Since(start))
 
+	var reasoningItems []json.RawMessage
+	for _, item := range resp.Output {
+		if raw, ok := c.reasoningItemForReplay(item); ok {
+			reasoningItems = append(reasoningItems, raw)
+		}
+	}
 	return &LLMResult{
-		Message:   LLMMessage{Role: RoleAssistant, Content: text},
-		ToolCalls: calls,
+		Message:        LLMMessage{Role: RoleAssistant, Content: text},
+		ToolCalls:      calls,
+		ResponseID:     resp.ID,
+		ReasoningItems: reasoningItems,
 	}, nil
 }
 
+// ChainsResponses 实现 ResponseChainer：仅 previous_response_id 模式下启用链式调用。
+func (c *ResponsesClient) ChainsResponses() bool {
+	return c.cfg.ResponsesState == ResponsesStatePreviousResponseID
+}
+
+// reasoningItemForReplay 在加密思考模式下返回需要写回历史的 reasoning 条目。
+func (c *ResponsesClient) reasoningItemForReplay(item responses.ResponseOutputItemUnion) (json.RawMessage, bool) {
+	if c.cfg.ResponsesState != ResponsesStateEncryptedReasoning || item.Type != "reasoning" {
+		return nil, false
+	}
+	raw := item.RawJSON()
+	if raw == "" {
+		return nil, false
+	}
+	return json.RawMessage(raw), true
+}
+
 // Stream 以流式接口返回 Responses API 的输出。
 func (c *ResponsesClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 	ch := make(chan LLMEvent, 128)
@@ -65,15 +112,35 @@ func (c *ResponsesClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 
 		ch <- LLMEvent{Kind: LLMEventCreated}
 		var textBuilder strings.Builder
+		var reasoningBuilder strings.Builder
 		var toolCalls []ToolCall
+		var responseID string
+		var reasoningItems []json.RawMessage
 
 		for s.Next() {
 			ev := s.Current()
 			switch ev.Type {
+			case "response.created":
+				responseID = ev.AsResponseCreated().Response.ID
+			case "response.completed":
+				if id := ev.AsResponseCompleted().Response.ID; id != "" {
+					responseID = id
+				}
 			case "response.output_text.delta":
 				delta := ev.AsResponseOutputTextDelta().Delta
 				textBuilder.WriteString(delta)
 				ch <- LLMEvent{Kind: LLMEventTextDelta, TextDelta: delta}
+			case "response.reasoning_summary_part.added":
+				// 多段摘要之间用空行分隔。
+				if reasoningBuilder.Len() > 0 {
+					reasoningBuilder.WriteString("\n\n")
+					ch <- LLMEvent{Kind: LLMEventReasoningDelta, TextDelta: "\n\n"}
+				}
+			case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+				if delta := extractStreamEventDelta(ev.RawJSON()); delta != "" {
+					reasoningBuilder.WriteString(delta)
+					ch <- LLMEvent{Kind: LLMEventReasoningDelta, TextDelta: delta}
+				}
 			case "response.output_item.done":
 				item := ev.AsResponseOutputItemDone().Item
 
@@ -83,6 +150,9 @@ func (c *ResponsesClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 				if call, ok := c.parseToolCall(item); ok {
 					toolCalls = append(toolCalls, call)
 				}
+				if raw, ok := c.reasoningItemForReplay(item); ok {
+					reasoningItems = append(reasoningItems, raw)
+				}
 				if textBuilder.Len() == 0 && item.Type == "message" {
 					textBuilder.WriteString(c.extractText(item.Content, string(item.Role)))
 				}
@@ -97,8 +167,11 @@ func (c *ResponsesClient) Stream(ctx context.Context, p Prompt) *LLMStream {
 
 		fullText := textBuilder.String()
 		result := &LLMResult{
-			Message:   LLMMessage{Role: RoleAssistant, Content: fullText},
-			ToolCalls: toolCalls,
+			Message:        LLMMessage{Role: RoleAssistant, Content: fullText},
+			ToolCalls:      toolCalls,
+			Reasoning:      reasoningBuilder.String(),
+			ResponseID:     responseID,
+			ReasoningItems: reasoningItems,
 		}
 		log.Printf("[llm] stream complete elapsed=%s len=%d tool_calls=%d  result=%v", time.Since(start), len(fullText), len(toolCalls), result)
 		ch <- LLMEvent{Kind: LLMEventCompleted, FullText: fullText, Result: result}
@@ -118,29 +191,75 @@ func (c *ResponsesClient) buildParams(p Prompt) responses.ResponseNewParams {
 		Input: responses.ResponseNewParamsInputUnion{OfInputItemList: responses.ResponseInputParam(inputItems)},
 	}
 
+	switch c.cfg.ResponsesState {
+	case ResponsesStatePreviousResponseID:
+		params.Store = param.NewOpt(true)
+		if id := strings.TrimSpace(p.PreviousResponseID); id != "" {
+			params.PreviousResponseID = param.NewOpt(id)
+		}
+	case ResponsesStateEncryptedReasoning:
+		params.Store = param.NewOpt(false)
+		params.Include = []responses.ResponseIncludable{responses.ResponseIncludableReasoningEncryptedContent}
+	}
+
 	if key := strings.TrimSpace(c.cfg.CacheKey); key != "" {
 		params.PromptCacheKey = param.NewOpt(key)
 		params.User = param.NewOpt(key)
 	}
 
+	if c.cfg.ReasoningEffort != "" || c.cfg.ReasoningSummary != "" {
+		params.Reasoning = shared.ReasoningParam{
+			Effort:  shared.ReasoningEffort(c.cfg.ReasoningEffort),
+			Summary: shared.ReasoningSummary(c.cfg.ReasoningSummary),
+		}
+	}
+
 	if sdkTools := c.buildTools(p.Tools); len(sdkTools) > 0 {
 		params.Tools = sdkTools
 		params.ToolChoice = responses.ResponseNewParamsToolChoiceUnion{OfToolChoiceMode: param.NewOpt(responses.ToolChoiceOptionsAuto)}
 	}
+	applyResponsesParams(&params, c.cfg, toolModes)
 
-	log.Printf("build params: %s\n", utils.ToIndentJSONString(params))
+	log.Printf("build params: %s\n", redactSecrets(c.cfg, utils.ToIndentJSONString(params)))
 	return params
 }
 
+// extractStreamEventDelta 从流式事件原始 JSON 中读取 delta 文本。
+func extractStreamEventDelta(raw string) string {
+	var payload struct {
+		Delta string `json:"delta"`
+	}
+	if err := json.Unmarshal([]byte(raw), &payload); err != nil {
+		return ""
+	}
+	return payload.Delta
+}
+
 // buildInputItems 将内部 ResponseItem 列表转换为 Responses API 的输入项。
 func (c *ResponsesClient) buildInputItems(items []ResponseItem, toolModes map[string]toolCallMode) []responses.ResponseInputItemUnionParam {
 	if len(items) == 0 {
 		return nil
 	}
 	inputItems := make([]responses.ResponseInputItemUnionParam, 0, len(items))
+	// function_call_output 只接受文本，工具图片在连续的工具结果之后以一条 user 消息补充。
+	var pendingImages []toolImageInput
+	flushImages := func() {
+		if len(pendingImages) > 0 {
+			inputItems = append(inputItems, buildResponsesImageMessage(pendingImages))
+			pendingImages = nil
+		}
+	}
 
 	for _, it := range items {
+		if it.Type != ResponseItemToolResult {
+			flushImages()
+		}
 		switch it.Type {
+		case ResponseItemReasoning:
+			// 仅在加密思考模式下回放，其余模式（或其它 provider 产生的条目）直接丢弃。
+			if c.cfg.ResponsesState == ResponsesStateEncryptedReasoning && rawItemType(it.Raw) == "reasoning" {
+				inputItems = append(inputItems, param.Override[responses.ResponseInputItemUnionParam](it.Raw))
+			}
 		case ResponseItemMessage:
 			if it.Role == RoleTool {
 				log.Printf("[llm] skip tool role message in responses input")
@@ -169,12 +288,27 @@ func (c *ResponsesClient) buildInputItems(items []ResponseItem, toolModes map[st
 				continue
 			}
 			inputItems = append(inputItems, buildToolCallOutputParam(it.ToolName, callID, truncateToolOutput(it.ToolOutput), toolModes))
+			if c.cfg.Vision {
+				pendingImages = append(pendingImages, loadToolImages(it.ToolImages)...)
+			}
 		}
 	}
+	flushImages()
 
 	return inputItems
 }
 
+// buildResponsesImageMessage 将工具返回的图片包装为一条 user 输入消息。
+func buildResponsesImageMessage(images []toolImageInput) responses.ResponseInputItemUnionParam {
+	content := responses.ResponseInputMessageContentListParam{responses.ResponseInputContentParamOfInputText(toolImagesNote)}
+	for _, img := range images {
+		part := responses.ResponseInputContentParamOfInputImage(responses.ResponseInputImageDetailAuto)
+		part.OfInputImage.ImageURL = param.NewOpt(img.DataURL())
+		content = append(content, part)
+	}
+	return responses.ResponseInputItemParamOfMessage(content, responses.EasyInputMessageRoleUser)
+}
+
 type toolCallMode string
 
 const (
@@ -310,7 +444,9 @@ func (c *ResponsesClient) buildTools(tools []ToolSpec) []responses.ToolUnionPara
 			log.Printf("[llm] skip tool %s: invalid parameters: %v", t.Name, err)
 			continue
 		}
-		strictValue := true
+		paramsMap = openAIToolParameters(paramsMap)
+		// 未显式指定时（如 MCP 工具）仅在 schema 满足 strict 要求时开启，避免请求被整体拒绝。
+		strictValue := strictSchemaCompatible(paramsMap)
 		if t.Strict != nil {
 			strictValue = *t.Strict
 		}
@@ -0,0 +1,175 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"chase-code/config"
+	servertools "chase-code/server/tools"
+)
+
+// newTestResponsesClient 启动本地 httptest 服务并返回指定 state 的 ResponsesClient。
+func newTestResponsesClient(t *testing.T, state string, handler http.HandlerFunc) *ResponsesClient {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return NewResponsesClient(clientConfig{
+		Alias:          "responses",
+		Model:          "gpt-test",
+		BaseURL:        srv.URL,
+		APIKey:         "test-key",
+		Timeout:        defaultTimeout,
+		ResponsesState: state,
+	})
+}
+
+// TestResponsesClient_PreviousResponseID 验证链式模式下只发送增量条目并带上 previous_response_id。
+func TestResponsesClient_PreviousResponseID(t *testing.T) {
+	var got map[string]any
+	client := newTestResponsesClient(t, ResponsesStatePreviousResponseID, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"resp_2","object":"response","output":[{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"done"}]}]}`)
+	})
+	assert.True(t, client.ChainsResponses())
+
+	res, err := client.Complete(context.Background(), Prompt{
+		PreviousResponseID: "resp_1",
+		Items: []ResponseItem{
+			{Type: ResponseItemToolResult, ToolName: "shell_command", ToolOutput: "ok", CallID: "call_1"},
+		},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "resp_1", got["previous_response_id"])
+	assert.Equal(t, true, got["store"])
+	input, _ := got["input"].([]any)
+	require.Len(t, input, 1)
+	assert.Equal(t, "function_call_output", input[0].(map[string]any)["type"])
+	assert.Equal(t, "resp_2", res.ResponseID)
+	assert.Equal(t, "done", res.Message.Content)
+}
+
+// TestResponsesClient_EncryptedReasoning 验证加密思考条目被捕获并在下一次请求中原样回放。
+func TestResponsesClient_EncryptedReasoning(t *testing.T) {
+	var got map[string]any
+	client := newTestResponsesClient(t, ResponsesStateEncryptedReasoning, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"resp_1","object":"response","output":[
+			{"type":"reasoning","id":"rs_1","summary":[],"encrypted_content":"enc"},
+			{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"hi"}]}
+		]}`)
+	})
+	assert.False(t, client.ChainsResponses())
+
+	res, err := client.Complete(context.Background(), Prompt{Items: []ResponseItem{
+		{Type: ResponseItemMessage, Role: RoleUser, Text: "hello"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, false, got["store"])
+	assert.Equal(t, []any{"reasoning.encrypted_content"}, got["include"])
+	require.Len(t, res.ReasoningItems, 1)
+	assert.Contains(t, string(res.ReasoningItems[0]), `"encrypted_content":"enc"`)
+
+	_, err = client.Complete(context.Background(), Prompt{Items: []ResponseItem{
+		{Type: ResponseItemMessage, Role: RoleUser, Text: "hello"},
+		{Type: ResponseItemReasoning, Raw: res.ReasoningItems[0]},
+		{Type: ResponseItemMessage, Role: RoleAssistant, Text: "hi"},
+	}})
+	require.NoError(t, err)
+	input, _ := got["input"].([]any)
+	require.Len(t, input, 3)
+	reasoning := input[1].(map[string]any)
+	assert.Equal(t, "reasoning", reasoning["type"])
+	assert.Equal(t, "enc", reasoning["encrypted_content"])
+}
+
+// TestResponsesClient_GenerationParams 验证生成参数、自定义 header 与 extra_body 被透传到请求中。
+func TestResponsesClient_GenerationParams(t *testing.T) {
+	var got map[string]any
+	var header string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("X-Trace")
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"resp_1","object":"response","output":[]}`)
+	}))
+	t.Cleanup(srv.Close)
+
+	temperature := 0.2
+	parallel := false
+	client := NewResponsesClient(clientConfig{
+		Model:   "gpt-test",
+		BaseURL: srv.URL,
+		APIKey:  "test-key",
+		Timeout: defaultTimeout,
+		Params: config.GenerationParams{
+			Temperature:       &temperature,
+			MaxTokens:         1024,
+			ParallelToolCalls: &parallel,
+			ToolChoice:        "shell_command",
+			Headers:           map[string]string{"X-Trace": "abc"},
+			ExtraBody:         map[string]any{"text.verbosity": "low"},
+		},
+	})
+
+	_, err := client.Complete(context.Background(), Prompt{
+		Tools: []ToolSpec{servertools.ShellCommandToolSpec()},
+		Items: []ResponseItem{{Type: ResponseItemMessage, Role: RoleUser, Text: "hi"}},
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "abc", header)
+	assert.Equal(t, 0.2, got["temperature"])
+	assert.Equal(t, float64(1024), got["max_output_tokens"])
+	assert.Equal(t, false, got["parallel_tool_calls"])
+	assert.Equal(t, map[string]any{"type": "function", "name": "shell_command"}, got["tool_choice"])
+	assert.Equal(t, map[string]any{"verbosity": "low"}, got["text"])
+}
+
+// TestResponsesClient_ToolSchemaStrict 验证未显式指定 strict 的工具按 schema 是否兼容决定 strict，并为 array 补全 items。
+func TestResponsesClient_ToolSchemaStrict(t *testing.T) {
+	var got map[string]any
+	client := newTestResponsesClient(t, ResponsesStateReplay, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		require.NoError(t, json.Unmarshal(body, &got))
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id":"resp_1","object":"response","output":[]}`)
+	})
+
+	_, err := client.Complete(context.Background(), Prompt{
+		Tools: []ToolSpec{
+			servertools.ShellCommandToolSpec(),
+			{Kind: servertools.ToolKindCustom, Name: "mcp__a__strict", Parameters: json.RawMessage(`{"type":"object","properties":{"q":{"type":"string"}},"required":["q"],"additionalProperties":false}`)},
+			{Kind: servertools.ToolKindCustom, Name: "mcp__a__loose", Parameters: json.RawMessage(`{"type":"object","properties":{"tags":{"type":"array"},"q":{"type":"string","maxLength":10}}}`)},
+		},
+		Items: []ResponseItem{{Type: ResponseItemMessage, Role: RoleUser, Text: "hi"}},
+	})
+	require.NoError(t, err)
+
+	tools, _ := got["tools"].([]any)
+	require.Len(t, tools, 3)
+	byName := make(map[string]map[string]any)
+	for _, raw := range tools {
+		tool := raw.(map[string]any)
+		byName[tool["name"].(string)] = tool
+	}
+	assert.Equal(t, false, byName["shell_command"]["strict"])
+	assert.Equal(t, true, byName["mcp__a__strict"]["strict"])
+	loose := byName["mcp__a__loose"]
+	assert.Equal(t, false, loose["strict"])
+	tags := loose["parameters"].(map[string]any)["properties"].(map[string]any)["tags"].(map[string]any)
+	assert.Equal(t, map[string]any{}, tags["items"])
+}
@@ -0,0 +1,60 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+
+	"chase-code/config"
+)
+
+// ModelRole 表示模型在会话中承担的角色，不同角色可以绑定不同成本的模型。
+type ModelRole string
+
+const (
+	// ModelRoleAgent 为主循环使用的模型。
+	ModelRoleAgent ModelRole = "agent"
+	// ModelRoleCompact 用于上下文压缩摘要。
+	ModelRoleCompact ModelRole = "compact"
+	// ModelRoleTitle 用于生成会话标题。
+	ModelRoleTitle ModelRole = "title"
+)
+
+// ModelRoles 为全部可配置的角色。
+var ModelRoles = []ModelRole{ModelRoleAgent, ModelRoleCompact, ModelRoleTitle}
+
+// parseModelRole 校验配置中的角色名称。
+func parseModelRole(raw string) (ModelRole, bool) {
+	role := ModelRole(strings.ToLower(strings.TrimSpace(raw)))
+	for _, r := range ModelRoles {
+		if r == role {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// RoleModels 按配置文件中的 roles 返回各角色绑定的模型。
+// 未配置的角色不出现在结果中；未知角色或找不到的别名以 warnings 返回，不影响其它角色。
+func RoleModels() (map[ModelRole]*LLMModel, []string) {
+	env := config.Get()
+	if env.LLMConfig == nil || len(env.LLMConfig.Roles) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[ModelRole]*LLMModel)
+	var warnings []string
+	for name, alias := range env.LLMConfig.Roles {
+		role, ok := parseModelRole(name)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("未知的模型角色: %s", name))
+			continue
+		}
+		model, err := FindModel(alias)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("角色 %s 的模型不可用: %v", role, err))
+			continue
+		}
+		out[role] = model
+	}
+	return out, warnings
+}
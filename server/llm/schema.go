@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// strictUnsupportedKeywords 为 OpenAI strict 模式不支持的 JSON Schema 关键字。
+var strictUnsupportedKeywords = []string{
+	"minLength", "maxLength", "pattern", "format",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum", "multipleOf",
+	"minItems", "maxItems", "uniqueItems", "contains", "prefixItems",
+	"patternProperties", "propertyNames", "minProperties", "maxProperties",
+	"unevaluatedProperties", "unevaluatedItems", "dependentRequired", "dependentSchemas",
+	"if", "then", "else", "not", "allOf", "oneOf", "default", "const",
+}
+
+// openAIToolParameters 按 OpenAI 的额外限制修正工具参数 schema：array 必须带 items，
+// 缺失时补一个不受限的 items，否则请求会被拒绝（array schema missing items）。
+func openAIToolParameters(schema map[string]any) map[string]any {
+	fixOpenAIArrays(schema)
+	return schema
+}
+
+// fixOpenAIArrays 递归为缺少 items 的 array 补全 items。
+func fixOpenAIArrays(v any) {
+	switch t := v.(type) {
+	case map[string]any:
+		if schemaHasType(t, "array") {
+			if _, ok := t["items"]; !ok {
+				t["items"] = map[string]any{}
+			}
+		}
+		for _, child := range t {
+			fixOpenAIArrays(child)
+		}
+	case []any:
+		for _, child := range t {
+			fixOpenAIArrays(child)
+		}
+	}
+}
+
+// strictSchemaCompatible 判断 schema 能否以 strict 模式提交给 OpenAI Responses：
+// 每个 object 都需声明 additionalProperties=false 且全部字段 required，且不能使用 strict 不支持的关键字。
+func strictSchemaCompatible(v any) bool {
+	switch t := v.(type) {
+	case map[string]any:
+		for _, k := range strictUnsupportedKeywords {
+			if _, ok := t[k]; ok {
+				return false
+			}
+		}
+		if _, ok := t["$ref"]; ok {
+			return false
+		}
+		if schemaHasType(t, "object") {
+			if ap, ok := t["additionalProperties"].(bool); !ok || ap {
+				return false
+			}
+			props, _ := t["properties"].(map[string]any)
+			required := make(map[string]bool)
+			list, _ := t["required"].([]any)
+			for _, r := range list {
+				if name, ok := r.(string); ok {
+					required[name] = true
+				}
+			}
+			for name := range props {
+				if !required[name] {
+					return false
+				}
+			}
+		}
+		for k, child := range t {
+			// properties 的键是字段名而非关键字，直接检查各字段的 schema。
+			if k == "properties" {
+				props, _ := child.(map[string]any)
+				for _, p := range props {
+					if !strictSchemaCompatible(p) {
+						return false
+					}
+				}
+				continue
+			}
+			if !strictSchemaCompatible(child) {
+				return false
+			}
+		}
+	case []any:
+		for _, child := range t {
+			if !strictSchemaCompatible(child) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// anthropicInputSchema 按 Anthropic 的要求修正 input_schema：顶层必须显式声明 type=object。
+func anthropicInputSchema(raw json.RawMessage) json.RawMessage {
+	var schema map[string]any
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return raw
+	}
+	if _, ok := schema["type"]; ok {
+		return raw
+	}
+	schema["type"] = "object"
+	fixed, err := json.Marshal(schema)
+	if err != nil {
+		log.Printf("[llm] marshal anthropic input_schema failed: %v", err)
+		return raw
+	}
+	return fixed
+}
+
+// schemaHasType 判断 schema 的 type（字符串或数组形式）是否包含 typ。
+func schemaHasType(schema map[string]any, typ string) bool {
+	switch t := schema["type"].(type) {
+	case string:
+		return t == typ
+	case []any:
+		for _, item := range t {
+			if item == typ {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ScriptedStep 描述 ScriptedClient 按顺序返回的一次模型回复。
+type ScriptedStep struct {
+	// Text 为 assistant 回复正文。
+	Text string
+	// Deltas 为流式输出的分片，为空时整段 Text 作为一个分片发送。
+	Deltas []string
+	// Reasoning 为思考内容，流式调用时以 reasoning_delta 事件发送。
+	Reasoning string
+	// ToolCalls 为本次回复携带的工具调用。
+	ToolCalls []ToolCall
+	// Err 非空时本次调用直接返回该错误。
+	Err error
+	// StreamErr 非空时流式调用在发送完 Deltas 后以该错误结束，模拟输出中途断开。
+	StreamErr error
+	// Check 可选，用于在返回前断言收到的 Prompt，返回错误时本次调用失败。
+	Check func(p Prompt) error
+}
+
+// ScriptedClient 是按脚本顺序返回固定回复的 LLMClient，用于离线单元测试。
+// 它会记录每次调用收到的 Prompt，便于断言上层拼装的上下文。
+type ScriptedClient struct {
+	mu      sync.Mutex
+	steps   []ScriptedStep
+	next    int
+	prompts []Prompt
+}
+
+// NewScriptedClient 创建按 steps 顺序应答的客户端。
+func NewScriptedClient(steps ...ScriptedStep) *ScriptedClient {
+	return &ScriptedClient{steps: steps}
+}
+
+// Prompts 返回目前为止收到的全部 Prompt。
+func (c *ScriptedClient) Prompts() []Prompt {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Prompt(nil), c.prompts...)
+}
+
+// Remaining 返回尚未消费的脚本步数。
+func (c *ScriptedClient) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.steps) - c.next
+}
+
+// nextStep 记录 Prompt 并取出下一步脚本。
+func (c *ScriptedClient) nextStep(p Prompt) (ScriptedStep, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompts = append(c.prompts, p)
+	if c.next >= len(c.steps) {
+		return ScriptedStep{}, fmt.Errorf("脚本已耗尽: 第 %d 次调用没有预设回复", len(c.prompts))
+	}
+	step := c.steps[c.next]
+	c.next++
+	if step.Check != nil {
+		if err := step.Check(p); err != nil {
+			return ScriptedStep{}, err
+		}
+	}
+	return step, step.Err
+}
+
+// result 将脚本步骤转换为 LLMResult。
+func (s ScriptedStep) result() *LLMResult {
+	return &LLMResult{
+		Message:   LLMMessage{Role: RoleAssistant, Content: s.Text},
+		ToolCalls: append([]ToolCall(nil), s.ToolCalls...),
+		Reasoning: s.Reasoning,
+	}
+}
+
+func (c *ScriptedClient) Complete(ctx context.Context, p Prompt) (*LLMResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	step, err := c.nextStep(p)
+	if err != nil {
+		return nil, err
+	}
+	return step.result(), nil
+}
+
+func (c *ScriptedClient) Stream(ctx context.Context, p Prompt) *LLMStream {
+	ch := make(chan LLMEvent, 16)
+	stream := &LLMStream{C: ch}
+
+	go func() {
+		defer close(ch)
+		if err := ctx.Err(); err != nil {
+			ch <- LLMEvent{Kind: LLMEventError, Error: err}
+			return
+		}
+		step, err := c.nextStep(p)
+		if err != nil {
+			ch <- LLMEvent{Kind: LLMEventError, Error: err}
+			return
+		}
+
+		ch <- LLMEvent{Kind: LLMEventCreated}
+		if step.Reasoning != "" {
+			ch <- LLMEvent{Kind: LLMEventReasoningDelta, TextDelta: step.Reasoning}
+		}
+		deltas := step.Deltas
+		if len(deltas) == 0 && step.Text != "" {
+			deltas = []string{step.Text}
+		}
+		for _, d := range deltas {
+			ch <- LLMEvent{Kind: LLMEventTextDelta, TextDelta: d}
+		}
+		if step.StreamErr != nil {
+			ch <- LLMEvent{Kind: LLMEventError, Error: step.StreamErr}
+			return
+		}
+		ch <- LLMEvent{Kind: LLMEventCompleted, FullText: step.Text, Result: step.result()}
+	}()
+
+	return stream
+}
@@ -35,6 +35,9 @@ const (
 	ResponseItemMessage    ResponseItemType = "message"
 	ResponseItemToolCall   ResponseItemType = "tool_call"
 	ResponseItemToolResult ResponseItemType = "tool_result"
+	// ResponseItemReasoning 为 provider 返回的原始 reasoning 条目（如 Responses API 的加密思考、Anthropic 的 thinking 块），
+	// 只由产生它的客户端回放，其它客户端忽略。
+	ResponseItemReasoning ResponseItemType = "reasoning"
 )
 
 // ResponseItem 是“对话+工具调用”的统一表示。
@@ -51,6 +54,13 @@ type ResponseItem struct {
 	ToolArguments json.RawMessage `json:"tool_arguments,omitempty"`
 	ToolOutput    string          `json:"tool_output,omitempty"`
 	CallID        string          `json:"call_id,omitempty"`
+	// ToolImages 为工具结果附带的本地图片，仅对支持视觉输入的模型作为图片发送。
+	ToolImages []tools.ToolImage `json:"tool_images,omitempty"`
+	// ToolStructured 为远程工具返回的 structuredContent 原文。
+	ToolStructured json.RawMessage `json:"tool_structured,omitempty"`
+
+	// Raw 保存 provider 的原始条目 JSON，目前用于 ResponseItemReasoning。
+	Raw json.RawMessage `json:"raw,omitempty"`
 }
 
 // Prompt 对应一次调用的完整输入。
@@ -60,11 +70,14 @@ type Prompt struct {
 	Messages []Message
 	Tools    []tools.ToolSpec `json:"-"`
 	Items    []ResponseItem   `json:"-"`
+	// PreviousResponseID 非空时，Items 只包含该响应之后新增的条目，由服务端拼接之前的上下文。
+	PreviousResponseID string `json:"-"`
 }
 
 // 为方便其它包使用，直接公开 tools 包里的类型。
 type ToolSpec = tools.ToolSpec
 type ToolCall = tools.ToolCall
+type ToolImage = tools.ToolImage
 
 // 工具输出截断相关常量，可按需调整。
 const (
@@ -105,11 +118,13 @@ func truncateToolOutput(s string) string {
 type LLMEventKind string
 
 const (
-	LLMEventCreated    LLMEventKind = "created"
-	LLMEventTextDelta  LLMEventKind = "text_delta"
-	LLMEventCompleted  LLMEventKind = "completed"
-	LLMEventRateLimits LLMEventKind = "rate_limits"
-	LLMEventError      LLMEventKind = "error"
+	LLMEventCreated   LLMEventKind = "created"
+	LLMEventTextDelta LLMEventKind = "text_delta"
+	// LLMEventReasoningDelta 为思考/推理内容的增量，文本同样放在 TextDelta 中。
+	LLMEventReasoningDelta LLMEventKind = "reasoning_delta"
+	LLMEventCompleted      LLMEventKind = "completed"
+	LLMEventRateLimits     LLMEventKind = "rate_limits"
+	LLMEventError          LLMEventKind = "error"
 )
 
 type LLMEvent struct {
@@ -136,6 +151,19 @@ type LLMMessage struct {
 type LLMResult struct {
 	Message   LLMMessage
 	ToolCalls []ToolCall
+	// ResponseID 为服务端响应 ID（Responses API），用于 previous_response_id 链式调用。
+	ResponseID string
+	// ReasoningItems 为需要写回历史回放的原始 reasoning 条目（加密思考模式或 Anthropic thinking 块）。
+	ReasoningItems []json.RawMessage
+	// Reasoning 为本次回复的思考内容，仅用于展示与日志，不会写回对话历史：
+	// 部分兼容接口（如 DeepSeek reasoner）在输入中带回 reasoning_content 会直接报错。
+	Reasoning string
+}
+
+// ResponseChainer 由支持服务端会话链（previous_response_id）的客户端实现。
+// ChainsResponses 返回 true 时，上层可以只发送新增条目并带上上一次的 ResponseID。
+type ResponseChainer interface {
+	ChainsResponses() bool
 }
 
 // LLMClient 抽象一个“模型客户端”，参考 codex 的 ModelClient：
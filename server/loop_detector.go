@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"chase-code/server/config"
+	servertools "chase-code/server/tools"
+)
+
+// toolLoopDetector 在单个 turn 内统计工具调用签名，用于识别模型反复执行
+// 同一条失败命令、反复应用同一个坏补丁之类的“死循环”。
+// 签名由工具名、规范化后的参数以及工具输出的哈希组成：参数相同但结果不同
+// （例如文件被修改后重新编译）不会被视为重复。
+type toolLoopDetector struct {
+	threshold int
+	counts    map[string]int
+}
+
+// newToolLoopDetector 创建检测器，threshold<=0 时检测关闭。
+func newToolLoopDetector(threshold int) *toolLoopDetector {
+	return &toolLoopDetector{
+		threshold: threshold,
+		counts:    make(map[string]int),
+	}
+}
+
+// Observe 记录一次工具调用，返回该签名在本 turn 内累计出现的次数。
+func (d *toolLoopDetector) Observe(call servertools.ToolCall, output string) int {
+	if d == nil || d.threshold <= 0 {
+		return 0
+	}
+	sig := toolCallSignature(call, output)
+	d.counts[sig]++
+	return d.counts[sig]
+}
+
+// ShouldIntervene 判断累计次数是否达到需要干预的节点（每满 threshold 次触发一次）。
+func (d *toolLoopDetector) ShouldIntervene(count int) bool {
+	if d == nil || d.threshold <= 0 || count <= 0 {
+		return false
+	}
+	return count%d.threshold == 0
+}
+
+// toolCallSignature 计算工具调用的规范化签名。
+func toolCallSignature(call servertools.ToolCall, output string) string {
+	outSum := sha256.Sum256([]byte(output))
+	return call.ToolName + "\x00" + canonicalToolArguments(call.Arguments) + "\x00" + hex.EncodeToString(outSum[:8])
+}
+
+// canonicalToolArguments 将参数 JSON 重新序列化，消除字段顺序与空白差异。
+func canonicalToolArguments(args json.RawMessage) string {
+	if len(args) == 0 {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(args, &v); err != nil {
+		return string(args)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return string(args)
+	}
+	return string(data)
+}
+
+// checkToolLoop 在每次工具调用后检测重复，并按配置返回需要注入的纠正提示或询问用户。
+// 纠正提示不在这里写入历史：同一批并行调用的工具结果必须紧跟在 assistant 消息之后，
+// 由 executeToolCalls 在整批结果写入后统一追加。返回 false 表示用户选择终止本次 turn。
+func (s *Session) checkToolLoop(turn *turnContext, call servertools.ToolCall, output string, step int) (string, bool) {
+	count := turn.loops.Observe(call, output)
+	if !turn.loops.ShouldIntervene(count) {
+		return "", true
+	}
+
+	log.Printf("[agent] step=%d detected repeated tool call tool=%s count=%d", step, call.ToolName, count)
+	message := fmt.Sprintf("工具 %s 已使用相同参数执行 %d 次且结果相同，疑似陷入重复循环", call.ToolName, count)
+
+	if s.Config.LoopDetection.Action == config.LoopActionAsk {
+		if !s.askContinueAfterLoop(turn, call, step, message) {
+			return "", false
+		}
+		return loopCorrectionNote(call.ToolName, count), true
+	}
+
+	s.Sink.SendEvent(Event{
+		Kind:     EventLoopDetected,
+		Time:     time.Now(),
+		Step:     step,
+		ToolName: call.ToolName,
+		Message:  message + "，已提示模型调整策略",
+	})
+	return loopCorrectionNote(call.ToolName, count), true
+}
+
+// recordLoopNotes 在一批工具结果全部写入后追加纠正提示，多条提示合并为一条消息。
+// 提示以 user 角色写入：Anthropic 会把所有 system 消息移到顶层 system 字段，
+// 提示既会失去紧跟工具结果的位置，也会在每次干预时改变被缓存的 system 前缀。
+func recordLoopNotes(cm *ContextManager, notes []string) {
+	if len(notes) == 0 {
+		return
+	}
+	cm.Record(ResponseItem{
+		Type: ResponseItemMessage,
+		Role: RoleUser,
+		Text: strings.Join(notes, "\n"),
+	})
+}
+
+// askContinueAfterLoop 暂停 turn 并等待用户决定是否继续。
+func (s *Session) askContinueAfterLoop(turn *turnContext, call servertools.ToolCall, step int, message string) bool {
+	reqID := fmt.Sprintf("loop-%d-%d", time.Now().UnixNano(), step)
+	s.Sink.SendEvent(Event{
+		Kind:      EventLoopDetected,
+		Time:      time.Now(),
+		Step:      step,
+		ToolName:  call.ToolName,
+		RequestID: reqID,
+		Message:   message,
+	})
+
+	approved, err := s.waitForApproval(turn.baseCtx, reqID)
+	if err != nil {
+		log.Printf("[agent] step=%d wait loop decision failed: %v", step, err)
+		return false
+	}
+
+	result := "用户选择终止本次任务"
+	if approved {
+		result = "用户选择继续执行"
+	}
+	s.Sink.SendEvent(Event{
+		Kind:      EventLoopDecision,
+		Time:      time.Now(),
+		Step:      step,
+		ToolName:  call.ToolName,
+		RequestID: reqID,
+		Message:   result,
+	})
+	return approved
+}
+
+// loopCorrectionNote 生成注入给模型的纠正提示。
+func loopCorrectionNote(toolName string, count int) string {
+	return fmt.Sprintf("注意：你已经用完全相同的参数调用 %s %d 次，并且得到了相同的结果。"+
+		"不要再重复这一调用。请先分析失败原因，换一种方法解决；如果确实无法继续，请停止调用工具并向用户说明当前阻塞点。",
+		toolName, count)
+}
@@ -0,0 +1,362 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	gosdkclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+
+	"chase-code/config"
+)
+
+// authDirName 为 MCP OAuth 凭据的保存目录（位于 ~/.chase-code 下）。
+const authDirName = "mcp-auth"
+
+// MCPOAuthConfig 描述远程 MCP server 的 OAuth 2.1 配置。
+// 未提供 clientId 时，登录时通过动态客户端注册获取并与 token 一起保存。
+type MCPOAuthConfig struct {
+	ClientID     string   `json:"clientId,omitempty"`
+	ClientSecret string   `json:"clientSecret,omitempty"` // 支持 ${VAR} 引用
+	Scopes       []string `json:"scopes,omitempty"`
+	// RedirectPort 为本地回调端口；预先注册了 clientId 时需与注册的 redirect_uri 一致，0 表示随机端口。
+	RedirectPort int `json:"redirectPort,omitempty"`
+	// MetadataURL 显式指定授权服务器元数据地址，为空时按 MCP 规范自动发现。
+	MetadataURL string `json:"metadataUrl,omitempty"`
+}
+
+// storedCredentials 为 ~/.chase-code/mcp-auth/<server>.json 的内容。
+type storedCredentials struct {
+	ServerURL    string           `json:"server_url,omitempty"`
+	ClientID     string           `json:"client_id,omitempty"`
+	ClientSecret string           `json:"client_secret,omitempty"`
+	RedirectURI  string           `json:"redirect_uri,omitempty"`
+	Token        *transport.Token `json:"token,omitempty"`
+}
+
+// fileTokenStore 将 OAuth token 持久化到凭据文件，刷新后的 token 也会写回。
+type fileTokenStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+// GetToken 实现 transport.TokenStore。
+func (s *fileTokenStore) GetToken(ctx context.Context) (*transport.Token, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds, err := readCredentials(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if creds == nil || creds.Token == nil {
+		return nil, transport.ErrNoToken
+	}
+	return creds.Token, nil
+}
+
+// SaveToken 实现 transport.TokenStore。
+func (s *fileTokenStore) SaveToken(ctx context.Context, token *transport.Token) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	creds, err := readCredentials(s.path)
+	if err != nil {
+		return err
+	}
+	if creds == nil {
+		creds = &storedCredentials{}
+	}
+	creds.Token = token
+	return writeCredentials(s.path, creds)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// credentialsPath 返回 server 凭据文件路径。
+func credentialsPath(name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".chase-code", authDirName, unsafeFileChars.ReplaceAllString(name, "_")+".json"), nil
+}
+
+// readCredentials 读取凭据文件，文件不存在时返回 (nil, nil)。
+func readCredentials(path string) (*storedCredentials, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("读取 MCP 凭据失败: %w", err)
+	}
+	var creds storedCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("解析 MCP 凭据 %s 失败: %w", path, err)
+	}
+	return &creds, nil
+}
+
+// writeCredentials 以仅当前用户可读写的权限保存凭据文件。
+func writeCredentials(path string, creds *storedCredentials) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("创建 MCP 凭据目录失败: %w", err)
+	}
+	data, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("写入 MCP 凭据失败: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// resolveHeaders 展开 headers 中的 ${VAR} 引用，并按 bearer_token_env 注入 Authorization 头。
+// 不可信配置的 headers 原样发送，也不读取 bearer_token_env，避免把本机密钥发给仓库指定的地址。
+func resolveHeaders(name string, s MCPRemoteServerConfig) (map[string]string, error) {
+	headers := make(map[string]string, len(s.Headers)+1)
+	for k, v := range s.Headers {
+		if !s.Untrusted {
+			v = config.ExpandEnvRefs(v)
+		}
+		headers[k] = v
+	}
+	if env := strings.TrimSpace(s.BearerTokenEnv); env != "" {
+		if s.Untrusted {
+			return nil, fmt.Errorf("MCP server %q 来自项目配置，不支持 bearer_token_env，请在用户配置中定义", name)
+		}
+		token := strings.TrimSpace(os.Getenv(env))
+		if token == "" {
+			return nil, fmt.Errorf("MCP server %q 的 bearer_token_env 环境变量 %s 为空", name, env)
+		}
+		headers["Authorization"] = "Bearer " + token
+	}
+	return headers, nil
+}
+
+// resolveOAuth 返回连接时使用的 OAuth 配置：配置了 oauth 或已登录（存在凭据文件）时启用，否则返回 nil。
+// 凭据按 server 名称保存，只有登录时的 url 与当前配置同源才会使用，避免同名 server 改指他处后把 token 发给别的站点。
+func resolveOAuth(name string, s MCPRemoteServerConfig) (*transport.OAuthConfig, error) {
+	path, err := credentialsPath(name)
+	if err != nil {
+		return nil, err
+	}
+	creds, err := readCredentials(path)
+	if err != nil {
+		return nil, err
+	}
+	if s.OAuth == nil && creds == nil {
+		return nil, nil
+	}
+	if creds != nil && !sameOrigin(creds.ServerURL, s.URL) {
+		return nil, fmt.Errorf("MCP server %q 的已保存凭据属于 %q，与当前 url %q 不同源，请重新运行 chase-code mcp login %s", name, creds.ServerURL, s.URL, name)
+	}
+	cfg := oauthConfig(s, &fileTokenStore{path: path})
+	if creds != nil {
+		if creds.ClientID != "" {
+			cfg.ClientID = creds.ClientID
+			cfg.ClientSecret = creds.ClientSecret
+		}
+		if creds.RedirectURI != "" {
+			cfg.RedirectURI = creds.RedirectURI
+		}
+	}
+	return &cfg, nil
+}
+
+// sameOrigin 判断两个 url 的 scheme 与 host（含端口）是否一致，任一无法解析时视为不同源。
+func sameOrigin(a, b string) bool {
+	ua, err := url.Parse(strings.TrimSpace(a))
+	if err != nil || ua.Host == "" {
+		return false
+	}
+	ub, err := url.Parse(strings.TrimSpace(b))
+	if err != nil || ub.Host == "" {
+		return false
+	}
+	return strings.EqualFold(ua.Scheme, ub.Scheme) && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// oauthConfig 基于 server 配置构造 mcp-go 的 OAuthConfig，始终启用 PKCE。
+func oauthConfig(s MCPRemoteServerConfig, store transport.TokenStore) transport.OAuthConfig {
+	cfg := transport.OAuthConfig{TokenStore: store, PKCEEnabled: true}
+	if s.OAuth != nil {
+		cfg.ClientID = s.OAuth.ClientID
+		cfg.ClientSecret = s.OAuth.ClientSecret
+		if !s.Untrusted {
+			cfg.ClientSecret = config.ExpandEnvRefs(cfg.ClientSecret)
+		}
+		cfg.Scopes = s.OAuth.Scopes
+		cfg.AuthServerMetadataURL = s.OAuth.MetadataURL
+	}
+	return cfg
+}
+
+// wrapAuthError 将“需要授权”错误转换为提示用户登录的错误。
+func wrapAuthError(name string, err error) error {
+	if gosdkclient.IsOAuthAuthorizationRequiredError(err) || errors.Is(err, transport.ErrOAuthAuthorizationRequired) {
+		return fmt.Errorf("MCP server %q 需要授权，请运行 chase-code mcp login %s: %w", name, name, err)
+	}
+	return err
+}
+
+// LoginOptions 控制一次 OAuth 登录流程。
+type LoginOptions struct {
+	// OpenBrowser 用于打开授权页面；为空或打开失败时仅在 Out 中打印链接。
+	OpenBrowser func(url string) error
+	// Out 输出提示信息。
+	Out io.Writer
+	// HTTPClient 用于元数据发现、客户端注册与换取 token。
+	HTTPClient *http.Client
+}
+
+// Login 对远程 MCP server 执行 OAuth 2.1 授权码 + PKCE 流程：
+// 在本地回环地址监听回调，必要时动态注册客户端，换取的 token 保存到 ~/.chase-code/mcp-auth/<server>.json。
+func Login(ctx context.Context, name string, s MCPRemoteServerConfig, opts LoginOptions) error {
+	if t := s.TransportType(); t != "sse" && t != "streamable_http" {
+		return fmt.Errorf("MCP server %q 不是远程 server，无需登录", name)
+	}
+	serverURL, err := url.Parse(strings.TrimSpace(s.URL))
+	if err != nil || serverURL.Host == "" {
+		return fmt.Errorf("MCP server %q 的 url 无效: %q", name, s.URL)
+	}
+	if opts.Out == nil {
+		opts.Out = io.Discard
+	}
+	path, err := credentialsPath(name)
+	if err != nil {
+		return err
+	}
+
+	port := 0
+	if s.OAuth != nil {
+		port = s.OAuth.RedirectPort
+	}
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return fmt.Errorf("监听本地回调端口失败: %w", err)
+	}
+	defer ln.Close()
+	redirectURI := fmt.Sprintf("http://127.0.0.1:%d/callback", ln.Addr().(*net.TCPAddr).Port)
+
+	store := &fileTokenStore{path: path}
+	cfg := oauthConfig(s, store)
+	cfg.RedirectURI = redirectURI
+	cfg.HTTPClient = opts.HTTPClient
+	handler := transport.NewOAuthHandler(cfg)
+	handler.SetBaseURL(serverURL.Scheme + "://" + serverURL.Host)
+
+	if handler.GetClientID() == "" {
+		if err := handler.RegisterClient(ctx, "chase-code"); err != nil {
+			return fmt.Errorf("动态注册 OAuth 客户端失败: %w", err)
+		}
+	}
+
+	verifier, err := transport.GenerateCodeVerifier()
+	if err != nil {
+		return err
+	}
+	state, err := transport.GenerateState()
+	if err != nil {
+		return err
+	}
+	authURL, err := handler.GetAuthorizationURL(ctx, state, transport.GenerateCodeChallenge(verifier))
+	if err != nil {
+		return fmt.Errorf("构造授权地址失败: %w", err)
+	}
+
+	type callback struct {
+		code, state string
+		err         error
+	}
+	results := make(chan callback, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		cb := callback{code: q.Get("code"), state: q.Get("state")}
+		if e := q.Get("error"); e != "" {
+			cb.err = fmt.Errorf("授权被拒绝: %s %s", e, q.Get("error_description"))
+			fmt.Fprintln(w, "授权失败，可以关闭此页面。")
+		} else {
+			fmt.Fprintln(w, "授权完成，可以关闭此页面并回到 chase-code。")
+		}
+		select {
+		case results <- cb:
+		default:
+		}
+	})}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+
+	fmt.Fprintf(opts.Out, "请在浏览器中完成 %s 的授权:\n  %s\n", name, authURL)
+	if opts.OpenBrowser != nil {
+		if err := opts.OpenBrowser(authURL); err != nil {
+			fmt.Fprintf(opts.Out, "无法自动打开浏览器（%v），请手动访问上面的链接。\n", err)
+		}
+	}
+
+	var cb callback
+	select {
+	case <-ctx.Done():
+		return fmt.Errorf("等待授权回调超时: %w", ctx.Err())
+	case cb = <-results:
+	}
+	if cb.err != nil {
+		return cb.err
+	}
+	if err := handler.ProcessAuthorizationResponse(ctx, cb.code, cb.state, verifier); err != nil {
+		return fmt.Errorf("换取 access token 失败: %w", err)
+	}
+
+	// token 已由 ProcessAuthorizationResponse 写入 store，这里补充客户端信息供刷新与重连使用。
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	creds, err := readCredentials(path)
+	if err != nil {
+		return err
+	}
+	if creds == nil {
+		creds = &storedCredentials{}
+	}
+	creds.ServerURL = s.URL
+	creds.ClientID = handler.GetClientID()
+	creds.ClientSecret = handler.GetClientSecret()
+	creds.RedirectURI = redirectURI
+	return writeCredentials(path, creds)
+}
+
+// Logout 删除 server 的已保存凭据，返回是否存在凭据。
+func Logout(name string) (bool, error) {
+	path, err := credentialsPath(name)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Remove(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("删除 MCP 凭据失败: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,261 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mark3labs/mcp-go/client/transport"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+)
+
+// TestNewConnectFunc_HeadersAndBearer 验证 headers 的 ${VAR} 展开与 bearer_token_env 注入。
+func TestNewConnectFunc_HeadersAndBearer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("TEST_MCP_TEAM", "infra")
+	t.Setenv("TEST_MCP_TOKEN", "secret")
+
+	mcpSrv := mcpserver.NewStreamableHTTPServer(mcpserver.NewMCPServer("auth", "0.0.1", mcpserver.WithToolCapabilities(false)))
+	var mu sync.Mutex
+	var rejected int
+	httpSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 关闭时的 DELETE 由 mcp-go 直接发出且不带自定义头，只校验协议请求。
+		if r.Method != http.MethodDelete && (r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("X-Team") != "infra") {
+			mu.Lock()
+			rejected++
+			mu.Unlock()
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mcpSrv.ServeHTTP(w, r)
+	}))
+	defer httpSrv.Close()
+
+	cfg := MCPRemoteServerConfig{
+		Type:           "streamableHttp",
+		URL:            httpSrv.URL + "/mcp",
+		Timeout:        5,
+		Headers:        map[string]string{"X-Team": "${TEST_MCP_TEAM}"},
+		BearerTokenEnv: "TEST_MCP_TOKEN",
+	}
+	connect, err := newConnectFunc("auth", cfg)
+	if err != nil {
+		t.Fatalf("newConnectFunc error: %v", err)
+	}
+	client, err := connect(context.Background())
+	if err != nil {
+		t.Fatalf("connect error: %v", err)
+	}
+	closeClient(client)
+	mu.Lock()
+	if rejected != 0 {
+		t.Fatalf("expected all requests to carry headers, rejected=%d", rejected)
+	}
+	mu.Unlock()
+
+	cfg.BearerTokenEnv = "TEST_MCP_MISSING_TOKEN"
+	if _, err := newConnectFunc("auth", cfg); err == nil || !strings.Contains(err.Error(), "TEST_MCP_MISSING_TOKEN") {
+		t.Fatalf("expected missing bearer env error, got %v", err)
+	}
+}
+
+// TestResolveHeaders_Untrusted 验证项目配置的 headers 不展开 ${VAR}，且不能通过 bearer_token_env 读取本机密钥。
+func TestResolveHeaders_Untrusted(t *testing.T) {
+	t.Setenv("TEST_MCP_TOKEN", "secret")
+	cfg := MCPRemoteServerConfig{
+		Type:      "streamableHttp",
+		URL:       "https://mcp.example.com/mcp",
+		Headers:   map[string]string{"X-Token": "${TEST_MCP_TOKEN}"},
+		Untrusted: true,
+	}
+	headers, err := resolveHeaders("proj", cfg)
+	if err != nil || headers["X-Token"] != "${TEST_MCP_TOKEN}" {
+		t.Fatalf("unexpected headers=%v err=%v", headers, err)
+	}
+
+	cfg.BearerTokenEnv = "TEST_MCP_TOKEN"
+	if _, err := resolveHeaders("proj", cfg); err == nil {
+		t.Fatal("expected bearer_token_env to be rejected for untrusted server")
+	}
+}
+
+// fakeAuthServer 实现最小的 OAuth 2.1 授权服务器：元数据发现、动态注册、授权码 + PKCE 与刷新。
+type fakeAuthServer struct {
+	mu        sync.Mutex
+	challenge string
+	refreshed int
+}
+
+func (a *fakeAuthServer) handler(base func() string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/oauth-authorization-server", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                 base(),
+			"authorization_endpoint": base() + "/authorize",
+			"token_endpoint":         base() + "/token",
+			"registration_endpoint":  base() + "/register",
+		})
+	})
+	mux.HandleFunc("/register", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{"client_id": "registered-client"})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if r.Form.Get("client_id") != "registered-client" {
+			http.Error(w, `{"error":"invalid_client"}`, http.StatusBadRequest)
+			return
+		}
+		switch r.Form.Get("grant_type") {
+		case "authorization_code":
+			sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+			if r.Form.Get("code") != "auth-code" || base64.RawURLEncoding.EncodeToString(sum[:]) != a.challenge {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access-1", "token_type": "Bearer", "refresh_token": "refresh-1", "expires_in": 3600})
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "refresh-1" {
+				http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			a.refreshed++
+			_ = json.NewEncoder(w).Encode(map[string]any{"access_token": "access-2", "token_type": "Bearer", "refresh_token": "refresh-1", "expires_in": 3600})
+		default:
+			http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+		}
+	})
+	return mux
+}
+
+// TestLogin_PKCEFlowAndRefresh 验证登录流程保存 token 与注册的 client_id，过期后自动刷新并写回凭据文件。
+func TestLogin_PKCEFlowAndRefresh(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	auth := &fakeAuthServer{}
+	var srv *httptest.Server
+	srv = httptest.NewServer(auth.handler(func() string { return srv.URL }))
+	defer srv.Close()
+
+	cfg := MCPRemoteServerConfig{Type: "sse", URL: srv.URL + "/sse"}
+	browser := func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			return err
+		}
+		q := u.Query()
+		auth.mu.Lock()
+		auth.challenge = q.Get("code_challenge")
+		auth.mu.Unlock()
+		if q.Get("code_challenge_method") != "S256" || q.Get("client_id") != "registered-client" {
+			t.Errorf("unexpected authorize params: %v", q)
+		}
+		// 模拟浏览器在用户同意后跳回本地回调地址。
+		go func() {
+			resp, err := http.Get(q.Get("redirect_uri") + "?code=auth-code&state=" + url.QueryEscape(q.Get("state")))
+			if err == nil {
+				resp.Body.Close()
+			}
+		}()
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := Login(ctx, "remote", cfg, LoginOptions{OpenBrowser: browser}); err != nil {
+		t.Fatalf("Login error: %v", err)
+	}
+
+	path, _ := credentialsPath("remote")
+	creds, err := readCredentials(path)
+	if err != nil || creds == nil || creds.ClientID != "registered-client" || creds.Token == nil || creds.Token.AccessToken != "access-1" {
+		t.Fatalf("unexpected credentials=%+v err=%v", creds, err)
+	}
+
+	// 未配置 oauth 字段，但已登录：连接时应启用 OAuth 并沿用注册的 client_id。
+	oauth, err := resolveOAuth("remote", cfg)
+	if err != nil || oauth == nil || oauth.ClientID != "registered-client" || !oauth.PKCEEnabled {
+		t.Fatalf("unexpected oauth config=%+v err=%v", oauth, err)
+	}
+
+	creds.Token.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := writeCredentials(path, creds); err != nil {
+		t.Fatal(err)
+	}
+	handler := transport.NewOAuthHandler(*oauth)
+	handler.SetBaseURL(srv.URL)
+	header, err := handler.GetAuthorizationHeader(ctx)
+	if err != nil || header != "Bearer access-2" {
+		t.Fatalf("refresh header=%q err=%v", header, err)
+	}
+	if creds, _ = readCredentials(path); creds.Token.AccessToken != "access-2" || creds.ClientID != "registered-client" || auth.refreshed != 1 {
+		t.Fatalf("refreshed token not persisted: %+v", creds)
+	}
+
+	if removed, err := Logout("remote"); err != nil || !removed {
+		t.Fatalf("Logout removed=%v err=%v", removed, err)
+	}
+	if oauth, _ := resolveOAuth("remote", cfg); oauth != nil {
+		t.Fatalf("expected oauth disabled after logout, got %+v", oauth)
+	}
+}
+
+// TestResolveOAuth_OriginMismatch 验证已保存凭据只用于登录时同源的 url，改指其它站点后要求重新登录。
+func TestResolveOAuth_OriginMismatch(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	path, err := credentialsPath("remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds := &storedCredentials{
+		ServerURL: "https://mcp.example.com/sse",
+		ClientID:  "registered-client",
+		Token:     &transport.Token{AccessToken: "access-1", TokenType: "Bearer"},
+	}
+	if err := writeCredentials(path, creds); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "same origin other path", url: "https://MCP.example.com/mcp"},
+		{name: "other host", url: "https://evil.example.com/sse", wantErr: true},
+		{name: "other scheme", url: "http://mcp.example.com/sse", wantErr: true},
+		{name: "other port", url: "https://mcp.example.com:8443/sse", wantErr: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			oauth, err := resolveOAuth("remote", MCPRemoteServerConfig{Type: "sse", URL: tc.url})
+			if tc.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "mcp login remote") {
+					t.Fatalf("expected re-login error, got oauth=%+v err=%v", oauth, err)
+				}
+				return
+			}
+			if err != nil || oauth == nil || oauth.ClientID != "registered-client" {
+				t.Fatalf("unexpected oauth config=%+v err=%v", oauth, err)
+			}
+		})
+	}
+
+	// 旧版本保存的凭据没有 server_url，同样要求重新登录。
+	creds.ServerURL = ""
+	if err := writeCredentials(path, creds); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveOAuth("remote", MCPRemoteServerConfig{Type: "sse", URL: "https://mcp.example.com/sse"}); err == nil {
+		t.Fatal("expected error for credentials without server_url")
+	}
+}
@@ -0,0 +1,97 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	pkgtools "chase-code/server/tools"
+)
+
+// ToolSchemaCheck 为单个工具参数 schema 的校验结果。
+type ToolSchemaCheck struct {
+	Tool string
+	// Problem 非空表示 schema 无法使用，模型调用该工具时很可能被服务端拒绝。
+	Problem string
+	// Fixed 表示原始 schema 不合规，但经 normalizeMCPJSONSchema 修正后可用。
+	Fixed bool
+	// Filtered 表示该工具被 enabledTools/disabledTools 排除，不会暴露给模型。
+	Filtered bool
+	// Tokens 为清洗后的工具定义的估算 token 数，被过滤的工具为 0。
+	Tokens int
+}
+
+// ServerCheck 为 `chase-code mcp test` 的检查结果。
+type ServerCheck struct {
+	Name      string
+	Transport string
+	// ConnectTime 为建立连接并完成 initialize 的耗时。
+	ConnectTime time.Duration
+	Tools       []ToolSchemaCheck
+	// SchemaTokens 为未被过滤的工具定义的估算 token 总数。
+	SchemaTokens int
+}
+
+// CheckServer 按配置连接单个 server，完成初始化并列出工具，逐个校验参数 schema。
+// 与 NewMCPClientsFromConfig 不同，这里不做后台重连，连接失败直接返回错误。
+func CheckServer(ctx context.Context, name string, s MCPRemoteServerConfig) (*ServerCheck, error) {
+	connect, err := newConnectFunc(name, s)
+	if err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	client, err := connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeClient(client)
+	check := &ServerCheck{Name: name, Transport: s.TransportType(), ConnectTime: time.Since(start)}
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		return check, err
+	}
+	for _, t := range tools {
+		c := checkToolSchema(t)
+		if !s.ToolAllowed(t.Name) {
+			c.Filtered = true
+		} else {
+			c.Tokens = EstimateSchemaTokens(pkgtools.ToolSpec{Name: NamespacedToolName(name, t.Name), Description: t.Description, Parameters: t.Parameters})
+			check.SchemaTokens += c.Tokens
+		}
+		check.Tools = append(check.Tools, c)
+	}
+	return check, nil
+}
+
+// checkToolSchema 校验工具参数 schema：原始 schema 必须能被 sanitizeSchema 清洗为 type=object 的 JSON 对象，
+// 清洗前后不一致（缺失 properties、$ref、不支持的关键字等）记为已修正。
+func checkToolSchema(t MCPTool) ToolSchemaCheck {
+	out := ToolSchemaCheck{Tool: t.Name}
+	raw := t.RawParameters
+	if len(raw) == 0 {
+		raw = t.Parameters
+	}
+	if len(raw) == 0 {
+		out.Problem = "缺少 inputSchema"
+		return out
+	}
+	var original any
+	if err := json.Unmarshal(raw, &original); err != nil {
+		out.Problem = "inputSchema 不是合法 JSON: " + err.Error()
+		return out
+	}
+	sanitized, err := sanitizeSchema(raw)
+	if err != nil {
+		out.Problem = "inputSchema " + err.Error()
+		return out
+	}
+	var normalized any
+	if err := json.Unmarshal(sanitized, &normalized); err != nil {
+		out.Problem = "inputSchema 不是 JSON 对象"
+		return out
+	}
+	out.Fixed = !reflect.DeepEqual(original, normalized)
+	return out
+}
@@ -0,0 +1,50 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	mcpm "github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+)
+
+// TestCheckServer 验证 mcp test 的检查流程：合规、可修正与无法使用的 schema 分别被识别。
+func TestCheckServer(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	srv := mcpserver.NewMCPServer("check", "0.0.1", mcpserver.WithToolCapabilities(false))
+	noop := func(ctx context.Context, req mcpm.CallToolRequest) (*mcpm.CallToolResult, error) {
+		return mcpm.NewToolResultText("ok"), nil
+	}
+	srv.AddTool(mcpm.NewTool("good", mcpm.WithString("q")), noop)
+	srv.AddTool(mcpm.NewToolWithRawSchema("no_props", "", json.RawMessage(`{"type":"object"}`)), noop)
+	srv.AddTool(mcpm.NewToolWithRawSchema("bad", "", json.RawMessage(`{"type":"string"}`)), noop)
+	httpSrv := httptest.NewServer(mcpserver.NewStreamableHTTPServer(srv))
+	defer httpSrv.Close()
+
+	check, err := CheckServer(context.Background(), "check", MCPRemoteServerConfig{Type: "streamableHttp", URL: httpSrv.URL + "/mcp", Timeout: 5})
+	if err != nil {
+		t.Fatalf("CheckServer error: %v", err)
+	}
+	if check.Transport != "streamable_http" || len(check.Tools) != 3 {
+		t.Fatalf("unexpected check: %+v", check)
+	}
+	byName := make(map[string]ToolSchemaCheck)
+	for _, c := range check.Tools {
+		byName[c.Tool] = c
+	}
+	if c := byName["good"]; c.Problem != "" || c.Fixed {
+		t.Fatalf("good: %+v", c)
+	}
+	if c := byName["no_props"]; c.Problem != "" || !c.Fixed {
+		t.Fatalf("no_props: %+v", c)
+	}
+	if c := byName["bad"]; c.Problem == "" {
+		t.Fatalf("bad: %+v", c)
+	}
+
+	if _, err := CheckServer(context.Background(), "missing", MCPRemoteServerConfig{Type: "stdio"}); err == nil {
+		t.Fatal("expected config error for stdio server without command")
+	}
+}
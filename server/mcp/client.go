@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"log"
+	"time"
 
 	gosdkclient "github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/mcp"
@@ -16,7 +17,9 @@ import (
 type MCPTool struct {
 	Name        string          // 工具名称，在调用时作为唯一标识
 	Description string          // 简要描述，最终会出现在 ToolSpec.Description 中
-	Parameters  json.RawMessage // JSON Schema 或参数描述，透传给 ToolSpec.Parameters
+	Parameters  json.RawMessage // 经 normalizeMCPJSONSchema 清洗后的 JSON Schema，透传给 ToolSpec.Parameters
+	// RawParameters 为 server 返回的原始 schema，供 `chase-code mcp test` 对比清洗前后的差异。
+	RawParameters json.RawMessage
 }
 
 // MCPClient 抽象了一个可以调用 MCP 工具的客户端。
@@ -49,6 +52,9 @@ func ToolSpecsFromMCP(tools []MCPTool) []pkgtools.ToolSpec {
 // 实现了当前 mcp 包中的 MCPClient 接口，方便与 ToolRouter 集成。
 type GoSDKMCPClient struct {
 	inner gosdkclient.MCPClient
+	// timeout 为单次请求的超时，0 表示只受调用方 ctx 约束。远程 server 不能用 http.Client.Timeout 限时，
+	// 它同样作用于常驻的通知流。
+	timeout time.Duration
 }
 
 // NewGoSDKMCPClient 将 go-sdk 的 MCPClient 包装为 mcp.MCPClient。
@@ -56,12 +62,34 @@ func NewGoSDKMCPClient(inner gosdkclient.MCPClient) MCPClient {
 	return &GoSDKMCPClient{inner: inner}
 }
 
-// ListTools 调用 go-sdk 的 ListTools API，并转换成简化的 MCPTool 描述。
+// requestContext 按 timeout 为单次请求派生 ctx。
+func (c *GoSDKMCPClient) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// Close 关闭底层连接。
+func (c *GoSDKMCPClient) Close() error {
+	if c == nil || c.inner == nil {
+		return nil
+	}
+	return c.inner.Close()
+}
+
+// ListTools 调用 go-sdk 的 ListTools API，并转换成简化的 MCPTool 描述；
+// server 未声明 tools 能力（如只提供资源或 prompt）时返回空列表。
 func (c *GoSDKMCPClient) ListTools(ctx context.Context) ([]MCPTool, error) {
 	if c == nil || c.inner == nil {
 		return nil, nil
 	}
+	if caps, ok := c.capabilities(); ok && caps.Tools == nil {
+		return nil, nil
+	}
 
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	res, err := c.inner.ListTools(ctx, mcp.ListToolsRequest{})
 	if err != nil {
 		return nil, err
@@ -76,56 +104,131 @@ func (c *GoSDKMCPClient) ListTools(ctx context.Context) ([]MCPTool, error) {
 			log.Println(err)
 			continue
 		}
-		params = b
+		params = normalizeMCPJSONSchema(b)
 
 		out = append(out, MCPTool{
-			Name:        t.Name,
-			Description: t.Description,
-			Parameters:  params,
+			Name:          t.Name,
+			Description:   t.Description,
+			Parameters:    params,
+			RawParameters: b,
 		})
 	}
 	return out, nil
 }
 
-// normalizeMCPJSONSchema 尝试修正来自 MCP server 的 JSON Schema，
-// 以满足 OpenAI Responses tools 的校验要求。
-// 目前主要处理类似 `{ "type": "object" }` 但缺少 `properties`
-// 的场景，否则会触发 "object schema missing properties" 错误。
-func normalizeMCPJSONSchema(raw json.RawMessage) json.RawMessage {
-	if len(raw) == 0 {
-		return raw
+// Ping 发送 MCP ping 请求，用于健康检查。
+func (c *GoSDKMCPClient) Ping(ctx context.Context) error {
+	if c == nil || c.inner == nil {
+		return nil
 	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	return c.inner.Ping(ctx)
+}
 
-	var m map[string]any
-	if err := json.Unmarshal(raw, &m); err != nil {
-		// 无法解析时直接透传，避免静默丢弃。
-		log.Printf("[mcp] 无法解析工具参数 schema，直接透传原始数据: %v", err)
-		return raw
+// OnToolsChanged 在收到 notifications/tools/list_changed 时调用 fn；底层客户端不支持通知时忽略。
+func (c *GoSDKMCPClient) OnToolsChanged(fn func()) {
+	if c == nil || c.inner == nil {
+		return
 	}
-
-	typ, _ := m["type"].(string)
-	if typ == "object" {
-		_, hasProps := m["properties"]
-		if !hasProps {
-			// 对于不带任何字段的工具（如 GetCurrentTime），这里补一个空 properties，
-			// 以通过 Responses 的 JSON Schema 校验。
-			m["properties"] = map[string]any{}
+	notifier, ok := c.inner.(interface {
+		OnNotification(handler func(notification mcp.JSONRPCNotification))
+	})
+	if !ok {
+		return
+	}
+	notifier.OnNotification(func(n mcp.JSONRPCNotification) {
+		if n.Method == mcp.MethodNotificationToolsListChanged {
+			log.Printf("[mcp] tools list changed")
+			fn()
 		}
+	})
+}
+
+// capabilities 返回 server 在初始化时声明的能力；底层客户端无法提供时返回 false。
+func (c *GoSDKMCPClient) capabilities() (mcp.ServerCapabilities, bool) {
+	withCaps, ok := c.inner.(interface {
+		GetServerCapabilities() mcp.ServerCapabilities
+	})
+	if !ok {
+		return mcp.ServerCapabilities{}, false
 	}
+	return withCaps.GetServerCapabilities(), true
+}
 
-	fixed, err := json.Marshal(m)
+// ListResources 调用 go-sdk 的 ListResources API（自动翻页）；server 未声明 resources 能力时返回空列表。
+func (c *GoSDKMCPClient) ListResources(ctx context.Context) ([]MCPResource, error) {
+	if c == nil || c.inner == nil {
+		return nil, nil
+	}
+	if caps, ok := c.capabilities(); ok && caps.Resources == nil {
+		return nil, nil
+	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	res, err := c.inner.ListResources(ctx, mcp.ListResourcesRequest{})
 	if err != nil {
-		log.Printf("[mcp] 序列化修正后的 schema 失败，回退到原始数据: %v", err)
-		return raw
+		return nil, err
 	}
-	return fixed
+	return convertResources(res.Resources), nil
 }
 
-// CallTool 调用 go-sdk 的 CallTool API，并将返回内容序列化为字符串。
-func (c *GoSDKMCPClient) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+// ReadResource 读取资源并将内容渲染为文本。
+func (c *GoSDKMCPClient) ReadResource(ctx context.Context, uri string) (string, error) {
 	if c == nil || c.inner == nil {
 		return "", nil
 	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	res, err := c.inner.ReadResource(ctx, mcp.ReadResourceRequest{Params: mcp.ReadResourceParams{URI: uri}})
+	if err != nil {
+		return "", err
+	}
+	return renderResourceContents(res.Contents), nil
+}
+
+// ListPrompts 调用 go-sdk 的 ListPrompts API（自动翻页）；server 未声明 prompts 能力时返回空列表。
+func (c *GoSDKMCPClient) ListPrompts(ctx context.Context) ([]MCPPrompt, error) {
+	if c == nil || c.inner == nil {
+		return nil, nil
+	}
+	if caps, ok := c.capabilities(); ok && caps.Prompts == nil {
+		return nil, nil
+	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	res, err := c.inner.ListPrompts(ctx, mcp.ListPromptsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return convertPrompts(res.Prompts), nil
+}
+
+// GetPrompt 获取 prompt 并将消息内容渲染为文本。
+func (c *GoSDKMCPClient) GetPrompt(ctx context.Context, name string, arguments map[string]string) ([]MCPPromptMessage, error) {
+	if c == nil || c.inner == nil {
+		return nil, nil
+	}
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
+	res, err := c.inner.GetPrompt(ctx, mcp.GetPromptRequest{Params: mcp.GetPromptParams{Name: name, Arguments: arguments}})
+	if err != nil {
+		return nil, err
+	}
+	return renderPromptMessages(res.Messages), nil
+}
+
+// CallTool 调用工具并返回渲染后的纯文本输出。
+func (c *GoSDKMCPClient) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+	res, err := c.CallToolResult(ctx, name, arguments)
+	return res.Output, err
+}
+
+// CallToolResult 调用 go-sdk 的 CallTool API，并通过 ConvertCallToolResult 转换内容；isError 结果返回错误。
+func (c *GoSDKMCPClient) CallToolResult(ctx context.Context, name string, arguments json.RawMessage) (pkgtools.ToolResult, error) {
+	if c == nil || c.inner == nil {
+		return pkgtools.ToolResult{}, nil
+	}
 
 	req := mcp.CallToolRequest{
 		Params: mcp.CallToolParams{
@@ -134,24 +237,11 @@ func (c *GoSDKMCPClient) CallTool(ctx context.Context, name string, arguments js
 		},
 	}
 
+	ctx, cancel := c.requestContext(ctx)
+	defer cancel()
 	res, err := c.inner.CallTool(ctx, req)
 	if err != nil {
-		return "", err
+		return pkgtools.ToolResult{}, err
 	}
-
-	// 优先尝试从 content 中提取文本；若没有，则回退到 structuredContent 或整体 JSON。
-	if len(res.Content) > 0 {
-		if b, err := json.Marshal(res.Content); err == nil {
-			return string(b), nil
-		}
-	}
-
-	if res.StructuredContent != nil {
-		if b, err := json.Marshal(res.StructuredContent); err == nil {
-			return string(b), nil
-		}
-	}
-
-	// 没有任何内容时返回空串
-	return "", nil
+	return ConvertCallToolResult(res)
 }
@@ -6,19 +6,22 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	gosdkclient "github.com/mark3labs/mcp-go/client"
 	"github.com/mark3labs/mcp-go/client/transport"
 	"github.com/mark3labs/mcp-go/mcp"
 
+	"chase-code/config"
 	"chase-code/server/tools"
 )
 
-// MCPRemoteServerConfig 描述一个通过 HTTP/SSE 方式连接的 MCP 服务。
+// MCPRemoteServerConfig 描述一个 MCP 服务，支持 HTTP/SSE 远程服务与 stdio 本地子进程。
 // 示例 JSON 配置（多个 mcpServers）:
 //
 //	{
@@ -28,16 +31,97 @@ import (
 //	      "disabled": false,
 //	      "timeout": 60,
 //	      "type": "streamableHttp",
-//	      "url": "https://example.com/streamable"
+//	      "url": "https://example.com/streamable",
+//	      "enabledTools": ["search_*", "get_issue"],
+//	      "disabledTools": ["*_delete"]
+//	    },
+//	    "internal": {
+//	      "type": "streamableHttp",
+//	      "url": "https://mcp.internal.example.com/mcp",
+//	      "headers": {"X-Team": "${TEAM_ID}"},
+//	      "bearer_token_env": "INTERNAL_MCP_TOKEN"
+//	    },
+//	    "oauth-demo": {
+//	      "type": "sse",
+//	      "url": "https://example.com/sse",
+//	      "oauth": {"scopes": ["read"]}
+//	    },
+//	    "fs": {
+//	      "type": "stdio",
+//	      "command": "npx",
+//	      "args": ["-y", "@modelcontextprotocol/server-filesystem", "/path"],
+//	      "env": {"FOO": "bar"},
+//	      "cwd": "/path"
 //	    }
 //	  }
 //	}
+//
+// 配置了 command 且未指定 type 时视为 stdio。
+// enabledTools/disabledTools 为按原始工具名匹配的 glob（path.Match 语法），用于裁剪暴露给模型的工具：
+// 配置了 enabledTools 时只保留匹配的工具，命中 disabledTools 的工具总是被排除。
+// 远程 server 需要 OAuth 时先运行 chase-code mcp login <server>，登录后即使未配置 oauth 也会携带已保存的 token。
 type MCPRemoteServerConfig struct {
 	AutoApprove []string `json:"autoApprove,omitempty"`
 	Disabled    bool     `json:"disabled,omitempty"`
 	Timeout     int      `json:"timeout,omitempty"` // 秒
 	Type        string   `json:"type"`
-	URL         string   `json:"url"`
+	URL         string   `json:"url,omitempty"`
+
+	EnabledTools  []string `json:"enabledTools,omitempty"`
+	DisabledTools []string `json:"disabledTools,omitempty"`
+
+	// 以下字段仅用于远程 server：自定义请求头（值支持 ${VAR} 引用）、
+	// 从环境变量读取的 bearer token，以及 OAuth 2.1 配置。
+	Headers        map[string]string `json:"headers,omitempty"`
+	BearerTokenEnv string            `json:"bearer_token_env,omitempty"`
+	OAuth          *MCPOAuthConfig   `json:"oauth,omitempty"`
+
+	// 以下字段仅用于 stdio：启动命令、参数、额外环境变量（值支持 ${VAR} 引用）与工作目录。
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	Cwd     string            `json:"cwd,omitempty"`
+
+	// Untrusted 由加载方设置，表示配置来自项目目录等不可信来源，不写入文件：
+	// 此时配置值中的 ${VAR} 不会展开、bearer_token_env 不可用，避免把本机环境变量中的密钥交给仓库指定的命令或地址；
+	// autoApprove 也会被忽略。
+	Untrusted bool `json:"-"`
+}
+
+// TransportType 返回规范化后的连接方式，无法识别时返回空串。
+func (s MCPRemoteServerConfig) TransportType() string {
+	if strings.TrimSpace(s.Type) == "" && strings.TrimSpace(s.Command) != "" {
+		return "stdio"
+	}
+	return normalizeMCPType(s.Type)
+}
+
+// ToolAllowed 判断原始工具名是否通过 enabledTools/disabledTools 过滤，非法的 glob 视为不匹配。
+func (s MCPRemoteServerConfig) ToolAllowed(tool string) bool {
+	if matchAnyGlob(s.DisabledTools, tool) {
+		return false
+	}
+	return len(s.EnabledTools) == 0 || matchAnyGlob(s.EnabledTools, tool)
+}
+
+// ValidateToolFilters 校验 enabledTools/disabledTools 中的 glob 语法。
+func (s MCPRemoteServerConfig) ValidateToolFilters(name string) error {
+	for _, pattern := range append(append([]string{}, s.EnabledTools...), s.DisabledTools...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("MCP server %q 工具过滤规则 %q 不合法: %w", name, pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchAnyGlob 判断 name 是否匹配任一 glob。
+func matchAnyGlob(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
 }
 
 // MCPConfig 是顶层 MCP 配置。
@@ -72,68 +156,245 @@ func LoadMCPConfig(path string) (*MCPConfig, error) {
 	return &cfg, nil
 }
 
+// SaveMCPConfig 将 MCPConfig 以缩进 JSON 写回文件，必要时创建所在目录。
+// 文件已存在时在原内容上合并：顶层其它字段、各 server 中本程序不认识的字段（如其它工具写入的扩展配置）
+// 原样保留，只替换已知字段并增删 server。配置中可能含有 headers 等密钥，文件权限为 0600。
+func SaveMCPConfig(path string, cfg *MCPConfig) error {
+	if cfg == nil {
+		cfg = &MCPConfig{}
+	}
+	doc, err := readRawMCPConfig(path)
+	if err != nil {
+		return err
+	}
+	var oldServers map[string]json.RawMessage
+	if raw, ok := doc["mcpServers"]; ok {
+		if err := json.Unmarshal(raw, &oldServers); err != nil {
+			return fmt.Errorf("解析 MCP 配置 %s 中的 mcpServers 失败: %w", path, err)
+		}
+	}
+
+	servers := make(map[string]json.RawMessage, len(cfg.MCPServers))
+	for name, s := range cfg.MCPServers {
+		raw, err := mergeServerJSON(oldServers[name], s)
+		if err != nil {
+			return fmt.Errorf("序列化 MCP server %q 配置失败: %w", name, err)
+		}
+		servers[name] = raw
+	}
+	if len(servers) > 0 {
+		raw, err := json.Marshal(servers)
+		if err != nil {
+			return fmt.Errorf("序列化 MCP 配置失败: %w", err)
+		}
+		doc["mcpServers"] = raw
+	} else {
+		delete(doc, "mcpServers")
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化 MCP 配置失败: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("创建 MCP 配置目录失败: %w", err)
+	}
+	if err := config.WritePrivateFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("写入 MCP 配置失败: %w", err)
+	}
+	return nil
+}
+
+// readRawMCPConfig 以字段级 RawMessage 读取 MCP 配置文件，文件不存在或为空时返回空 map。
+func readRawMCPConfig(path string) (map[string]json.RawMessage, error) {
+	doc := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return doc, nil
+		}
+		return nil, fmt.Errorf("读取 MCP 配置失败: %w", err)
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return doc, nil
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("解析 MCP 配置 JSON 失败: %w", err)
+	}
+	return doc, nil
+}
+
+// mergeServerJSON 将 server 配置写到文件中原有的 JSON 对象上。
+// 原对象里能被 MCPRemoteServerConfig 解析并重新输出的字段视为已知字段，由 s 的值替换（为空时删除）；
+// 其余字段原样保留。old 为空时直接输出 s。
+func mergeServerJSON(old json.RawMessage, s MCPRemoteServerConfig) (json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+	if len(old) > 0 {
+		if err := json.Unmarshal(old, &fields); err != nil {
+			return nil, err
+		}
+		var parsed MCPRemoteServerConfig
+		if err := json.Unmarshal(old, &parsed); err != nil {
+			return nil, err
+		}
+		known, err := marshalFields(parsed)
+		if err != nil {
+			return nil, err
+		}
+		for k := range known {
+			delete(fields, k)
+		}
+	}
+	current, err := marshalFields(s)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range current {
+		fields[k] = v
+	}
+	return json.Marshal(fields)
+}
+
+// marshalFields 将 server 配置序列化为字段名到 JSON 值的映射。
+func marshalFields(s MCPRemoteServerConfig) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
 type startableMCPClient interface {
 	Start(ctx context.Context) error
 }
 
-// NewMCPClientsFromConfig 基于 MCPConfig 创建一组 MCPClient 适配器。
-// 支持 stdio / sse / streamable_http 三种连接方式。
-func NewMCPClientsFromConfig(cfg *MCPConfig) ([]MCPClient, error) {
+// NewMCPClientsFromConfig 基于 MCPConfig 为每个启用的 server 创建 ManagedClient，按名称排序返回。
+// 支持 stdio / sse / streamable_http 三种连接方式。各 server 并行、独立地连接：
+// 连接失败的 server 在后台按退避重连，配置错误的 server 标记为 failed，都不会影响其它 server。
+func NewMCPClientsFromConfig(ctx context.Context, cfg *MCPConfig) []ServerClient {
 	if cfg == nil || len(cfg.MCPServers) == 0 {
-		return nil, nil
+		return nil
 	}
 
-	clients := make([]MCPClient, 0, len(cfg.MCPServers))
-	if len(cfg.MCPServers) > 0 {
-		keys := make([]string, 0, len(cfg.MCPServers))
-		for k := range cfg.MCPServers {
+	keys := make([]string, 0, len(cfg.MCPServers))
+	for k, s := range cfg.MCPServers {
+		if !s.Disabled {
 			keys = append(keys, k)
 		}
-		sort.Strings(keys)
-		for _, name := range keys {
-			s := cfg.MCPServers[name]
-			if s.Disabled {
-				continue
+	}
+	sort.Strings(keys)
+
+	clients := make([]ServerClient, len(keys))
+	var wg sync.WaitGroup
+	for i, name := range keys {
+		s := cfg.MCPServers[name]
+		mcpType := s.TransportType()
+		connect, err := newConnectFunc(name, s)
+		if err != nil {
+			clients[i] = ServerClient{Name: name, Client: newFailedClient(name, mcpType, err), Config: s}
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			clients[i] = ServerClient{Name: name, Client: newManagedClient(ctx, name, mcpType, resolveTimeout(s.Timeout), connect), Config: s}
+		}()
+	}
+	wg.Wait()
+	return clients
+}
+
+// newConnectFunc 校验单个 server 的配置并返回建立连接的函数。
+func newConnectFunc(name string, s MCPRemoteServerConfig) (connectFunc, error) {
+	mcpType := s.TransportType()
+	if mcpType == "" {
+		return nil, fmt.Errorf("MCP server %q type 不支持: %q", name, s.Type)
+	}
+	if err := s.ValidateToolFilters(name); err != nil {
+		return nil, err
+	}
+	if mcpType == "stdio" {
+		if strings.TrimSpace(s.Command) == "" {
+			return nil, fmt.Errorf("MCP server %q 缺少 command 字段", name)
+		}
+		// 重启预算在多次重连之间共享：ManagedClient 重建客户端不会清零连续崩溃次数。
+		budget := &stdioRestartBudget{}
+		return func(context.Context) (MCPClient, error) {
+			client, err := newStdioMCPClient(name, s, budget)
+			if err != nil {
+				return nil, err
 			}
-			if strings.TrimSpace(s.URL) == "" {
-				return nil, fmt.Errorf("MCP server %q 缺少 url 字段", name)
+			return client, nil
+		}, nil
+	}
+	if strings.TrimSpace(s.URL) == "" {
+		return nil, fmt.Errorf("MCP server %q 缺少 url 字段", name)
+	}
+	timeout := resolveTimeout(s.Timeout)
+	headers, err := resolveHeaders(name, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context) (MCPClient, error) {
+		// 每次连接重新读取凭据，使 mcp login 之后的重连无需重启即可生效。
+		oauth, err := resolveOAuth(name, s)
+		if err != nil {
+			return nil, err
+		}
+		var client *gosdkclient.Client
+		switch mcpType {
+		case "sse":
+			opts := []transport.ClientOption{
+				transport.WithHTTPClient(streamingHTTPClient(timeout)),
+				transport.WithHeaders(headers),
 			}
-			mcpType := normalizeMCPType(s.Type)
-			if mcpType == "" {
-				return nil, fmt.Errorf("MCP server %q type 不支持: %q", name, s.Type)
+			if oauth != nil {
+				opts = append(opts, transport.WithOAuth(*oauth))
 			}
-			timeout := resolveTimeout(s.Timeout)
-
-			var client *gosdkclient.Client
-			switch mcpType {
-			case "sse":
-				httpClient := &http.Client{Timeout: timeout}
-				var err error
-				client, err = gosdkclient.NewSSEMCPClient(s.URL, gosdkclient.WithHTTPClient(httpClient))
-				if err != nil {
-					return nil, fmt.Errorf("创建 MCP SSE client %q 失败: %w", name, err)
-				}
-			case "streamable_http":
-				var err error
-				client, err = gosdkclient.NewStreamableHttpClient(s.URL, transport.WithHTTPTimeout(timeout))
-				if err != nil {
-					return nil, fmt.Errorf("创建 MCP HTTP client %q 失败: %w", name, err)
-				}
-			default:
-				return nil, fmt.Errorf("MCP server %q type 不支持: %q", name, s.Type)
+			client, err = gosdkclient.NewSSEMCPClient(s.URL, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("创建 MCP SSE client %q 失败: %w", name, err)
 			}
-
-			if err := initMCPClient(context.Background(), name, client, timeout); err != nil {
-				return nil, err
+		case "streamable_http":
+			// 持续监听 GET 流，才能收到 tools/list_changed 等服务端通知。
+			opts := []transport.StreamableHTTPCOption{
+				transport.WithHTTPBasicClient(streamingHTTPClient(timeout)),
+				transport.WithContinuousListening(),
+				transport.WithHTTPHeaders(headers),
+			}
+			if oauth != nil {
+				opts = append(opts, transport.WithHTTPOAuth(*oauth))
+			}
+			client, err = gosdkclient.NewStreamableHttpClient(s.URL, opts...)
+			if err != nil {
+				return nil, fmt.Errorf("创建 MCP HTTP client %q 失败: %w", name, err)
 			}
-			clients = append(clients, NewGoSDKMCPClient(client))
 		}
-	}
-	return clients, nil
+		if err := initMCPClient(ctx, name, client, timeout); err != nil {
+			client.Close()
+			return nil, wrapAuthError(name, err)
+		}
+		return &GoSDKMCPClient{inner: client, timeout: timeout}, nil
+	}, nil
+}
+
+// streamingHTTPClient 返回只限制等待响应头时间的 HTTP client：http.Client.Timeout 包含读取 body，
+// 会按时切断常驻的 SSE/GET 通知流；单次请求的超时由 GoSDKMCPClient 通过 ctx 控制。
+func streamingHTTPClient(timeout time.Duration) *http.Client {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = timeout
+	return &http.Client{Transport: t}
 }
 
 func normalizeMCPType(raw string) string {
 	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "stdio":
+		return "stdio"
 	case "sse":
 		return "sse"
 	case "streamablehttp", "streamable_http", "streamable-http":
@@ -154,16 +415,18 @@ func initMCPClient(ctx context.Context, name string, client *gosdkclient.Client,
 	if client == nil {
 		return fmt.Errorf("MCP client %q 为空", name)
 	}
+	// SSE 流与 streamable HTTP 的 GET 监听继承 Start 的 ctx，必须与 client 同生命周期（由 Close 结束），
+	// 否则初始化返回后即被取消，再也收不到 tools/list_changed 等服务端通知；超时只作用于握手。
+	if startable, ok := any(client).(startableMCPClient); ok {
+		if err := startable.Start(context.Background()); err != nil {
+			return fmt.Errorf("启动 MCP client %q 失败: %w", name, err)
+		}
+	}
 	if timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
-	if startable, ok := any(client).(startableMCPClient); ok {
-		if err := startable.Start(ctx); err != nil {
-			return fmt.Errorf("启动 MCP client %q 失败: %w", name, err)
-		}
-	}
 	initReq := mcp.InitializeRequest{
 		Params: mcp.InitializeParams{
 			ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
@@ -0,0 +1,457 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"chase-code/server/tools"
+)
+
+const (
+	// healthCheckInterval 为已连接 server 的定期 ping 间隔。
+	healthCheckInterval = 30 * time.Second
+	// reconnectBaseDelay / reconnectMaxDelay 为重连退避的初始与最大间隔，每次失败翻倍。
+	reconnectBaseDelay = time.Second
+	reconnectMaxDelay  = time.Minute
+)
+
+// ServerState 表示 MCP server 的连接状态。
+type ServerState string
+
+const (
+	ServerConnecting ServerState = "connecting"
+	ServerReady      ServerState = "ready"
+	// ServerUnhealthy 表示连接失败或健康检查失败，后台正在按退避重连。
+	ServerUnhealthy ServerState = "unhealthy"
+	// ServerFailed 表示配置错误、stdio server 反复崩溃等无法通过重连恢复的失败。
+	ServerFailed ServerState = "failed"
+	ServerClosed ServerState = "closed"
+)
+
+// ServerStatus 为单个 MCP server 的运行状态快照，供 /mcp 命令展示。
+type ServerStatus struct {
+	Name      string
+	Transport string
+	State     ServerState
+	// Tools 为最近一次成功拉取的工具数量。
+	Tools int
+	// FilteredTools 为被 enabledTools/disabledTools 过滤掉的工具数量。
+	FilteredTools int
+	// SchemaTokens 为暴露给模型的工具定义的估算 token 数。
+	SchemaTokens int
+	LastError    string
+	LastErrorAt  time.Time
+	// Latency 为最近一次成功请求（ping 或调用）的耗时。
+	Latency     time.Duration
+	ConnectedAt time.Time
+	// Reconnects 为启动后成功重连的次数。
+	Reconnects int
+	// NextRetry 为 unhealthy 状态下下一次重连的时间。
+	NextRetry time.Time
+}
+
+// connectFunc 建立一次到 server 的连接并完成初始化。
+type connectFunc func(ctx context.Context) (MCPClient, error)
+
+// pinger 由支持 MCP ping 的客户端实现。
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// toolsChangedNotifier 由能接收 notifications/tools/list_changed 的客户端实现。
+type toolsChangedNotifier interface {
+	OnToolsChanged(fn func())
+}
+
+// ManagedClient 包装单个 MCP server 的连接，负责健康检查与断线重连：
+//   - 连接失败或 ping 失败时标记为 unhealthy，并在后台按指数退避重连；
+//   - 调用失败时立即触发一次健康检查；
+//   - 重连成功或收到 tools/list_changed 通知时调用 onChange，供上层刷新工具列表。
+//
+// server 不可用期间的调用直接返回错误，不会阻塞其它 server。
+type ManagedClient struct {
+	name    string
+	connect connectFunc
+	timeout time.Duration
+
+	mu       sync.Mutex
+	inner    MCPClient
+	status   ServerStatus
+	backoff  time.Duration
+	onChange func()
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// newManagedClient 创建 ManagedClient 并尝试首次连接；首次连接失败不会返回错误，而是转入后台重连。
+func newManagedClient(ctx context.Context, name, transport string, timeout time.Duration, connect connectFunc) *ManagedClient {
+	m := &ManagedClient{
+		name:    name,
+		connect: connect,
+		timeout: timeout,
+		status:  ServerStatus{Name: name, Transport: transport, State: ServerConnecting},
+		backoff: reconnectBaseDelay,
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	m.reconnect(ctx, false)
+	go m.loop()
+	return m
+}
+
+// newFailedClient 返回一个因配置错误而永久失败的 ManagedClient，所有调用都返回该错误。
+func newFailedClient(name, transport string, err error) *ManagedClient {
+	m := &ManagedClient{
+		name: name,
+		status: ServerStatus{
+			Name:        name,
+			Transport:   transport,
+			State:       ServerFailed,
+			LastError:   err.Error(),
+			LastErrorAt: time.Now(),
+		},
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	close(m.done)
+	return m
+}
+
+// Name 返回 server 名称。
+func (m *ManagedClient) Name() string {
+	return m.name
+}
+
+// Status 返回当前状态快照。
+func (m *ManagedClient) Status() ServerStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.status
+}
+
+// SetOnChange 设置工具列表可能变化（重连成功或收到 list_changed 通知）时的回调。
+func (m *ManagedClient) SetOnChange(fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onChange = fn
+}
+
+// loop 在后台执行健康检查与退避重连，直到 Close。
+func (m *ManagedClient) loop() {
+	defer close(m.done)
+	for {
+		m.mu.Lock()
+		if m.status.State == ServerFailed {
+			// 已放弃重连（如 stdio server 连续崩溃达到上限），只等待 Close。
+			m.mu.Unlock()
+			<-m.stop
+			return
+		}
+		healthy := m.inner != nil
+		delay := healthCheckInterval
+		if !healthy {
+			delay = m.backoff
+			m.status.NextRetry = time.Now().Add(delay)
+		}
+		m.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-m.stop:
+			timer.Stop()
+			return
+		case <-m.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout)
+		if healthy {
+			m.checkHealth(ctx)
+		} else {
+			m.reconnect(ctx, true)
+		}
+		cancel()
+	}
+}
+
+// checkHealth ping 当前连接，失败时断开并转入重连。
+func (m *ManagedClient) checkHealth(ctx context.Context) {
+	m.mu.Lock()
+	inner := m.inner
+	m.mu.Unlock()
+	if inner == nil {
+		return
+	}
+	p, ok := inner.(pinger)
+	if !ok {
+		return
+	}
+	start := time.Now()
+	if err := p.Ping(ctx); err != nil {
+		log.Printf("[mcp] server=%s health check failed: %v", m.name, err)
+		m.markUnhealthy(inner, err)
+		return
+	}
+	m.recordLatency(time.Since(start))
+}
+
+// reconnect 建立新连接；notify 为 true 时在成功后调用 onChange。
+func (m *ManagedClient) reconnect(ctx context.Context, notify bool) {
+	start := time.Now()
+	inner, err := m.connect(ctx)
+
+	m.mu.Lock()
+	if m.status.State == ServerClosed {
+		m.mu.Unlock()
+		if inner != nil {
+			closeClient(inner)
+		}
+		return
+	}
+	if err != nil {
+		m.status.State = ServerUnhealthy
+		if errors.Is(err, errRestartLimit) {
+			m.status.State = ServerFailed
+		}
+		m.status.LastError = err.Error()
+		m.status.LastErrorAt = time.Now()
+		if notify {
+			m.backoff = min(m.backoff*2, reconnectMaxDelay)
+		}
+		m.mu.Unlock()
+		log.Printf("[mcp] server=%s connect failed: %v", m.name, err)
+		return
+	}
+	if n, ok := inner.(toolsChangedNotifier); ok {
+		n.OnToolsChanged(m.notifyChange)
+	}
+	m.inner = inner
+	m.backoff = reconnectBaseDelay
+	m.status.State = ServerReady
+	m.status.Latency = time.Since(start)
+	m.status.ConnectedAt = time.Now()
+	m.status.NextRetry = time.Time{}
+	if notify {
+		m.status.Reconnects++
+	}
+	m.mu.Unlock()
+	log.Printf("[mcp] server=%s connected elapsed=%s", m.name, time.Since(start))
+
+	if notify {
+		m.notifyChange()
+	}
+}
+
+// markUnhealthy 断开出错的连接并唤醒后台重连；inner 已被替换时忽略。
+// 错误表明 server 已达到重启上限时标记为 ServerFailed，不再重连。
+func (m *ManagedClient) markUnhealthy(inner MCPClient, err error) {
+	m.mu.Lock()
+	if m.inner != inner || m.status.State == ServerClosed {
+		m.mu.Unlock()
+		return
+	}
+	m.inner = nil
+	m.status.State = ServerUnhealthy
+	if errors.Is(err, errRestartLimit) {
+		m.status.State = ServerFailed
+	}
+	m.status.LastError = err.Error()
+	m.status.LastErrorAt = time.Now()
+	m.mu.Unlock()
+
+	closeClient(inner)
+	m.poke()
+}
+
+// notifyChange 调用 onChange 回调。
+func (m *ManagedClient) notifyChange() {
+	m.mu.Lock()
+	fn := m.onChange
+	m.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+// poke 唤醒后台循环，立即执行一次健康检查或重连。
+func (m *ManagedClient) poke() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// recordLatency 记录一次成功请求的耗时。
+func (m *ManagedClient) recordLatency(d time.Duration) {
+	m.mu.Lock()
+	m.status.Latency = d
+	m.mu.Unlock()
+}
+
+// client 返回当前可用连接，server 不可用时返回带最近错误的提示。
+func (m *ManagedClient) client() (MCPClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.inner != nil {
+		return m.inner, nil
+	}
+	switch m.status.State {
+	case ServerClosed:
+		return nil, fmt.Errorf("MCP server %q 已关闭", m.name)
+	case ServerFailed:
+		return nil, fmt.Errorf("MCP server %q 已停用: %s", m.name, m.status.LastError)
+	default:
+		return nil, fmt.Errorf("MCP server %q 当前不可用（%s），正在后台重连", m.name, m.status.LastError)
+	}
+}
+
+// call 在当前连接上执行 fn 并记录耗时；失败时触发一次健康检查。
+func (m *ManagedClient) call(fn func(inner MCPClient) error) error {
+	inner, err := m.client()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := fn(inner); err != nil {
+		m.poke()
+		return err
+	}
+	m.recordLatency(time.Since(start))
+	return nil
+}
+
+// ListTools 列出工具并记录数量。
+func (m *ManagedClient) ListTools(ctx context.Context) ([]MCPTool, error) {
+	var out []MCPTool
+	err := m.call(func(inner MCPClient) error {
+		var err error
+		out, err = inner.ListTools(ctx)
+		return err
+	})
+	if err == nil {
+		m.mu.Lock()
+		m.status.Tools = len(out)
+		m.mu.Unlock()
+	}
+	return out, err
+}
+
+// CallTool 调用工具并返回纯文本输出。
+func (m *ManagedClient) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+	res, err := m.CallToolResult(ctx, name, arguments)
+	return res.Output, err
+}
+
+// CallToolResult 调用工具；底层客户端支持结构化结果时保留图片与 structuredContent。
+func (m *ManagedClient) CallToolResult(ctx context.Context, name string, arguments json.RawMessage) (tools.ToolResult, error) {
+	var out tools.ToolResult
+	err := m.call(func(inner MCPClient) error {
+		if rich, ok := inner.(tools.ResultToolCaller); ok {
+			var err error
+			out, err = rich.CallToolResult(ctx, name, arguments)
+			return err
+		}
+		text, err := inner.CallTool(ctx, name, arguments)
+		out = tools.ToolResult{Output: text}
+		return err
+	})
+	return out, err
+}
+
+// ListResources 列出资源；底层客户端不支持时返回空列表。
+func (m *ManagedClient) ListResources(ctx context.Context) ([]MCPResource, error) {
+	var out []MCPResource
+	err := m.call(func(inner MCPClient) error {
+		rc, ok := inner.(MCPResourceClient)
+		if !ok {
+			return nil
+		}
+		var err error
+		out, err = rc.ListResources(ctx)
+		return err
+	})
+	return out, err
+}
+
+// ReadResource 读取资源。
+func (m *ManagedClient) ReadResource(ctx context.Context, uri string) (string, error) {
+	var out string
+	err := m.call(func(inner MCPClient) error {
+		rc, ok := inner.(MCPResourceClient)
+		if !ok {
+			return fmt.Errorf("MCP server %s 不支持读取资源", m.name)
+		}
+		var err error
+		out, err = rc.ReadResource(ctx, uri)
+		return err
+	})
+	return out, err
+}
+
+// ListPrompts 列出 prompt；底层客户端不支持时返回空列表。
+func (m *ManagedClient) ListPrompts(ctx context.Context) ([]MCPPrompt, error) {
+	var out []MCPPrompt
+	err := m.call(func(inner MCPClient) error {
+		pc, ok := inner.(MCPPromptClient)
+		if !ok {
+			return nil
+		}
+		var err error
+		out, err = pc.ListPrompts(ctx)
+		return err
+	})
+	return out, err
+}
+
+// GetPrompt 获取并渲染 prompt。
+func (m *ManagedClient) GetPrompt(ctx context.Context, name string, arguments map[string]string) ([]MCPPromptMessage, error) {
+	var out []MCPPromptMessage
+	err := m.call(func(inner MCPClient) error {
+		pc, ok := inner.(MCPPromptClient)
+		if !ok {
+			return fmt.Errorf("MCP server %s 不支持 prompts", m.name)
+		}
+		var err error
+		out, err = pc.GetPrompt(ctx, name, arguments)
+		return err
+	})
+	return out, err
+}
+
+// Close 停止后台循环并关闭当前连接。
+func (m *ManagedClient) Close() error {
+	m.mu.Lock()
+	if m.status.State == ServerClosed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.status.State = ServerClosed
+	inner := m.inner
+	m.inner = nil
+	m.mu.Unlock()
+
+	close(m.stop)
+	<-m.done
+	if inner != nil {
+		return closeClient(inner)
+	}
+	return nil
+}
+
+// closeClient 关闭实现了 io.Closer 的客户端。
+func closeClient(c MCPClient) error {
+	if closer, ok := c.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	tools2 "chase-code/server/tools"
+)
+
+// TestManagedClient_ReconnectsWithBackoff 验证首次连接失败不影响创建，后台重连成功后触发工具刷新。
+func TestManagedClient_ReconnectsWithBackoff(t *testing.T) {
+	attempts := 0
+	connect := func(ctx context.Context) (MCPClient, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return &fakeMCPClient{
+			tools:   []MCPTool{{Name: "search"}},
+			callOut: map[string]string{"search": "ok"},
+		}, nil
+	}
+	ctx := context.Background()
+	m := newManagedClient(ctx, "flaky", "streamable_http", 5*time.Second, connect)
+	defer m.Close()
+
+	st := m.Status()
+	if st.State != ServerUnhealthy || st.LastError != "connection refused" {
+		t.Fatalf("unexpected initial status: %+v", st)
+	}
+	if _, err := m.CallTool(ctx, "search", json.RawMessage(`{}`)); err == nil || !strings.Contains(err.Error(), "当前不可用") {
+		t.Fatalf("call on unhealthy server should fail fast, got %v", err)
+	}
+
+	router, specs, warnings, err := NewMCPRouter(ctx, []ServerClient{{Name: "flaky", Client: m}}, nil)
+	if err != nil || len(specs) != 0 || len(warnings) != 1 {
+		t.Fatalf("router should skip unhealthy server with a warning: specs=%v warnings=%v err=%v", specs, warnings, err)
+	}
+	changed := make(chan []tools2.ToolSpec, 1)
+	router.WatchToolChanges(func(specs []tools2.ToolSpec, warnings []string) {
+		changed <- specs
+	})
+
+	select {
+	case specs := <-changed:
+		if len(specs) != 1 || specs[0].Name != "mcp__flaky__search" {
+			t.Fatalf("unexpected specs after reconnect: %+v", specs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server did not reconnect")
+	}
+	st = m.Status()
+	if st.State != ServerReady || st.Reconnects != 1 || st.Tools != 1 {
+		t.Fatalf("unexpected status after reconnect: %+v", st)
+	}
+	if out, err := router.CallTool(ctx, "mcp__flaky__search", nil); err != nil || out != "ok" {
+		t.Fatalf("CallTool after reconnect out=%q err=%v", out, err)
+	}
+
+	m.Close()
+	if m.Status().State != ServerClosed {
+		t.Fatalf("expected closed state")
+	}
+}
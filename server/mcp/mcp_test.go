@@ -1,11 +1,16 @@
 package mcp
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -140,6 +145,71 @@ func TestLoadMCPConfig_EmptyAndMissing(t *testing.T) {
 	}
 }
 
+// TestSaveMCPConfig_PreservesUnknownFields 验证 add/remove/enable 等编辑后，文件中不认识的字段原样保留，且权限为 0600。
+func TestSaveMCPConfig_PreservesUnknownFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mcp.json")
+	original := `{
+  "$schema": "https://example.com/mcp.schema.json",
+  "mcpServers": {
+    "keep": {"command": "keep-cmd", "disabled": true, "x-vendor": {"color": "blue"}},
+    "drop": {"command": "drop-cmd"}
+  }
+}`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	cfg, err := LoadMCPConfig(path)
+	if err != nil {
+		t.Fatalf("LoadMCPConfig error: %v", err)
+	}
+	keep := cfg.MCPServers["keep"]
+	keep.Disabled = false
+	cfg.MCPServers["keep"] = keep
+	delete(cfg.MCPServers, "drop")
+	cfg.MCPServers["added"] = MCPRemoteServerConfig{Type: "stdio", Command: "added-cmd"}
+	if err := SaveMCPConfig(path, cfg); err != nil {
+		t.Fatalf("SaveMCPConfig error: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read config: %v", err)
+	}
+	var got struct {
+		Schema     string                                `json:"$schema"`
+		MCPServers map[string]map[string]json.RawMessage `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("saved config is not valid JSON: %v\n%s", err, data)
+	}
+	if got.Schema != "https://example.com/mcp.schema.json" {
+		t.Fatalf("top-level unknown field lost:\n%s", data)
+	}
+	if _, ok := got.MCPServers["drop"]; ok {
+		t.Fatalf("removed server should be deleted:\n%s", data)
+	}
+	kept := got.MCPServers["keep"]
+	var vendor bytes.Buffer
+	if err := json.Compact(&vendor, kept["x-vendor"]); err != nil || vendor.String() != `{"color":"blue"}` {
+		t.Fatalf("server-level unknown field lost:\n%s", data)
+	}
+	if _, ok := kept["disabled"]; ok {
+		t.Fatalf("enabled server should drop disabled flag:\n%s", data)
+	}
+	if string(kept["command"]) != `"keep-cmd"` || string(got.MCPServers["added"]["command"]) != `"added-cmd"` {
+		t.Fatalf("unexpected servers:\n%s", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat config: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Fatalf("config mode should be 0600, got %o", perm)
+	}
+}
+
 // getFreePort 返回一个可用的本地 TCP 端口，用于测试 HTTP/SSE 服务器。
 func getFreePort(t *testing.T) int {
 	ln, err := net.Listen("tcp", ":0")
@@ -257,3 +327,204 @@ func TestHTTPTimeMCPServer(t *testing.T) {
 	_ = httpSrv.Shutdown(shutdownCtx)
 	<-done
 }
+
+func TestNamespacedToolName(t *testing.T) {
+	if got := NamespacedToolName("fs", "read_file"); got != "mcp__fs__read_file" {
+		t.Fatalf("unexpected name: %s", got)
+	}
+	if got := NamespacedToolName("my.server", "git status"); got != "mcp__my_server__git_status" {
+		t.Fatalf("invalid chars should be replaced, got %s", got)
+	}
+
+	long1 := NamespacedToolName("server", strings.Repeat("x", 80)+"a")
+	long2 := NamespacedToolName("server", strings.Repeat("x", 80)+"b")
+	if len(long1) != maxToolNameLen || len(long2) != maxToolNameLen {
+		t.Fatalf("long names should be truncated to %d, got %d/%d", maxToolNameLen, len(long1), len(long2))
+	}
+	if long1 == long2 {
+		t.Fatalf("truncated names should stay distinct: %s", long1)
+	}
+}
+
+func TestMCPRouter_RoutesToOwningServer(t *testing.T) {
+	ctx := context.Background()
+
+	git := &fakeMCPClient{
+		tools:   []MCPTool{{Name: "status"}, {Name: "log"}},
+		callOut: map[string]string{"status": "from-git"},
+	}
+	svn := &fakeMCPClient{
+		tools:   []MCPTool{{Name: "status"}},
+		callOut: map[string]string{"status": "from-svn"},
+	}
+	// a.b 与 a_b 清洗后同名，应检测为冲突；shell_command 与内置工具冲突。
+	dup := &fakeMCPClient{tools: []MCPTool{{Name: "status"}}}
+	builtin := &fakeMCPClient{tools: []MCPTool{{Name: "x"}}}
+
+	router, specs, warnings, err := NewMCPRouter(ctx, []ServerClient{
+		{Name: "git", Client: git},
+		{Name: "svn", Client: svn},
+		{Name: "a.b", Client: dup},
+		{Name: "a_b", Client: dup},
+		{Name: "shell", Client: builtin},
+	}, []string{"shell_command", "mcp__shell__x"})
+	if err != nil {
+		t.Fatalf("NewMCPRouter error: %v", err)
+	}
+
+	var names []string
+	for _, s := range specs {
+		names = append(names, s.Name)
+	}
+	want := []string{"mcp__a_b__status", "mcp__git__log", "mcp__git__status", "mcp__svn__status"}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Fatalf("unexpected specs: %v", names)
+	}
+	if len(warnings) != 2 {
+		t.Fatalf("expect 2 collision warnings, got %v", warnings)
+	}
+
+	out, err := router.CallTool(ctx, "mcp__svn__status", nil)
+	if err != nil || out != "from-svn" {
+		t.Fatalf("expect call routed to svn, got %q err=%v", out, err)
+	}
+	out, err = router.CallTool(ctx, "mcp__git__status", nil)
+	if err != nil || out != "from-git" {
+		t.Fatalf("expect call routed to git, got %q err=%v", out, err)
+	}
+	if server, tool, ok := router.Resolve("mcp__git__log"); !ok || server != "git" || tool != "log" {
+		t.Fatalf("unexpected resolve result: %s/%s %v", server, tool, ok)
+	}
+	if _, err := router.CallTool(ctx, "status", nil); err == nil {
+		t.Fatalf("un-namespaced name should not be routed")
+	}
+}
+
+func TestMCPRouter_AutoApprovedTools(t *testing.T) {
+	fs := &fakeMCPClient{tools: []MCPTool{{Name: "read_file"}, {Name: "write_file"}}}
+	router, _, _, err := NewMCPRouter(context.Background(), []ServerClient{{Name: "fs", Client: fs}}, nil)
+	if err != nil {
+		t.Fatalf("NewMCPRouter error: %v", err)
+	}
+	cfg := &MCPConfig{MCPServers: map[string]MCPRemoteServerConfig{
+		"fs": {AutoApprove: []string{"read_file", "missing"}},
+	}}
+	got := router.AutoApprovedTools(cfg)
+	if strings.Join(got, ",") != "mcp__fs__read_file" {
+		t.Fatalf("unexpected auto-approved tools: %v", got)
+	}
+
+	// 项目配置中的 autoApprove 不生效。
+	cfg.MCPServers["fs"] = MCPRemoteServerConfig{AutoApprove: []string{"read_file"}, Untrusted: true}
+	if got := router.AutoApprovedTools(cfg); len(got) != 0 {
+		t.Fatalf("untrusted autoApprove should be ignored, got %v", got)
+	}
+}
+
+func TestMCPRouter_ToolFilters(t *testing.T) {
+	gh := &fakeMCPClient{tools: []MCPTool{
+		{Name: "search_issues", Parameters: json.RawMessage(`{"type":"object","properties":{}}`)},
+		{Name: "search_code"},
+		{Name: "get_issue"},
+		{Name: "delete_repo"},
+	}}
+	router, specs, _, err := NewMCPRouter(context.Background(), []ServerClient{{
+		Name:   "gh",
+		Client: gh,
+		Config: MCPRemoteServerConfig{EnabledTools: []string{"search_*", "get_issue"}, DisabledTools: []string{"search_code"}},
+	}}, nil)
+	if err != nil {
+		t.Fatalf("NewMCPRouter error: %v", err)
+	}
+	var names []string
+	for _, s := range specs {
+		names = append(names, s.Name)
+	}
+	if strings.Join(names, ",") != "mcp__gh__get_issue,mcp__gh__search_issues" {
+		t.Fatalf("unexpected specs: %v", names)
+	}
+	if _, err := router.CallTool(context.Background(), "mcp__gh__delete_repo", nil); err == nil {
+		t.Fatalf("filtered tool should not be routed")
+	}
+	st := router.Statuses()
+	if len(st) != 1 || st[0].FilteredTools != 2 || st[0].SchemaTokens == 0 || st[0].SchemaTokens != router.SchemaTokens() {
+		t.Fatalf("unexpected statuses: %+v total=%d", st, router.SchemaTokens())
+	}
+
+	bad := MCPRemoteServerConfig{Type: "stdio", Command: "x", EnabledTools: []string{"[a-"}}
+	if _, err := newConnectFunc("bad", bad); err == nil {
+		t.Fatalf("expect error for invalid glob")
+	}
+}
+
+// gatedMCPClient 的 ListTools 在 gate 放行前阻塞，返回调用时刻的工具列表，用于构造并发刷新。
+type gatedMCPClient struct {
+	fakeMCPClient
+	gate    chan struct{}
+	current func() []MCPTool
+}
+
+func (g *gatedMCPClient) ListTools(ctx context.Context) ([]MCPTool, error) {
+	<-g.gate
+	return g.current(), nil
+}
+
+// TestMCPRouter_ScheduleRefreshSerialized 验证变更刷新串行执行且合并排队的通知，回调最终收到最新的工具列表。
+func TestMCPRouter_ScheduleRefreshSerialized(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		version = 1
+	)
+	gate := make(chan struct{})
+	close(gate)
+	client := &gatedMCPClient{gate: gate, current: func() []MCPTool {
+		mu.Lock()
+		defer mu.Unlock()
+		return []MCPTool{{Name: fmt.Sprintf("v%d", version)}}
+	}}
+	router, _, _, err := NewMCPRouter(context.Background(), []ServerClient{{Name: "srv", Client: client}}, nil)
+	if err != nil {
+		t.Fatalf("NewMCPRouter error: %v", err)
+	}
+
+	client.gate = make(chan struct{})
+	var (
+		calls    []string
+		inflight int
+		maxSeen  int
+	)
+	done := make(chan struct{}, 4)
+	fn := func(specs []tools2.ToolSpec, warnings []string) {
+		mu.Lock()
+		inflight++
+		if inflight > maxSeen {
+			maxSeen = inflight
+		}
+		calls = append(calls, specs[0].Name)
+		inflight--
+		mu.Unlock()
+		done <- struct{}{}
+	}
+
+	router.scheduleRefresh("srv", time.Second, fn)
+	for i := 2; i <= 4; i++ {
+		mu.Lock()
+		version = i
+		mu.Unlock()
+		router.scheduleRefresh("srv", time.Second, fn)
+	}
+	close(client.gate)
+
+	<-done
+	<-done
+	select {
+	case <-done:
+		t.Fatalf("queued notifications should be coalesced into one refresh, got %v", calls)
+	case <-time.After(100 * time.Millisecond):
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxSeen != 1 || calls[len(calls)-1] != "mcp__srv__v4" {
+		t.Fatalf("unexpected refresh results: calls=%v max_inflight=%d", calls, maxSeen)
+	}
+}
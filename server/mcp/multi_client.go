@@ -11,6 +11,7 @@ import (
 //   - CallTool: 依次尝试所有客户端，直到第一个调用成功或全部失败
 //
 // 这与 codex 的 "多 MCP server" 模式类似，但实现上更简化。
+// 多个 server 暴露同名工具时会调用到错误的 server，接入 ToolRouter 时应使用按名称路由的 MCPRouter。
 type MultiMCPClient []MCPClient
 
 func (m MultiMCPClient) ListTools(ctx context.Context) ([]MCPTool, error) {
@@ -0,0 +1,293 @@
+package mcp
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// MCPResource 描述 MCP server 暴露的一条资源。
+type MCPResource struct {
+	URI         string
+	Name        string
+	Description string
+	MIMEType    string
+}
+
+// MCPPromptArgument 描述 prompt 模板的一个参数。
+type MCPPromptArgument struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// MCPPrompt 描述 MCP server 暴露的一个 prompt 模板。
+type MCPPrompt struct {
+	Name        string
+	Description string
+	Arguments   []MCPPromptArgument
+}
+
+// MCPPromptMessage 为 GetPrompt 渲染出的一条消息，非文本内容已转换为文字说明。
+type MCPPromptMessage struct {
+	Role string
+	Text string
+}
+
+// MCPResourceClient 由支持 resources 能力的客户端实现。
+type MCPResourceClient interface {
+	ListResources(ctx context.Context) ([]MCPResource, error)
+	// ReadResource 读取资源并返回文本内容，二进制内容以说明代替。
+	ReadResource(ctx context.Context, uri string) (string, error)
+}
+
+// MCPPromptClient 由支持 prompts 能力的客户端实现。
+type MCPPromptClient interface {
+	ListPrompts(ctx context.Context) ([]MCPPrompt, error)
+	GetPrompt(ctx context.Context, name string, arguments map[string]string) ([]MCPPromptMessage, error)
+}
+
+// ServerResource 为带 server 名称的资源。
+type ServerResource struct {
+	Server string
+	MCPResource
+}
+
+// ServerPrompt 为带 server 名称的 prompt。
+type ServerPrompt struct {
+	Server string
+	MCPPrompt
+}
+
+// convertResources 将 SDK 的资源列表转换为 MCPResource。
+func convertResources(list []mcp.Resource) []MCPResource {
+	out := make([]MCPResource, 0, len(list))
+	for _, r := range list {
+		out = append(out, MCPResource{URI: r.URI, Name: r.Name, Description: r.Description, MIMEType: r.MIMEType})
+	}
+	return out
+}
+
+// convertPrompts 将 SDK 的 prompt 列表转换为 MCPPrompt。
+func convertPrompts(list []mcp.Prompt) []MCPPrompt {
+	out := make([]MCPPrompt, 0, len(list))
+	for _, p := range list {
+		prompt := MCPPrompt{Name: p.Name, Description: p.Description}
+		for _, a := range p.Arguments {
+			prompt.Arguments = append(prompt.Arguments, MCPPromptArgument{Name: a.Name, Description: a.Description, Required: a.Required})
+		}
+		out = append(out, prompt)
+	}
+	return out
+}
+
+// renderResourceContents 将资源内容渲染为文本：文本内容原样拼接，二进制内容只注明 URI 与大小。
+func renderResourceContents(contents []mcp.ResourceContents) string {
+	parts := make([]string, 0, len(contents))
+	for _, c := range contents {
+		switch v := c.(type) {
+		case mcp.TextResourceContents:
+			parts = append(parts, v.Text)
+		case *mcp.TextResourceContents:
+			parts = append(parts, v.Text)
+		default:
+			parts = append(parts, describeEmbeddedResource(c))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// renderPromptMessages 将 prompt 消息转换为 MCPPromptMessage，图片等非文本内容以说明代替。
+func renderPromptMessages(msgs []mcp.PromptMessage) []MCPPromptMessage {
+	out := make([]MCPPromptMessage, 0, len(msgs))
+	for _, m := range msgs {
+		var text string
+		switch v := m.Content.(type) {
+		case mcp.TextContent:
+			text = v.Text
+		case *mcp.TextContent:
+			text = v.Text
+		case mcp.ImageContent:
+			text = fmt.Sprintf("[图片 %s，未展示]", v.MIMEType)
+		case mcp.AudioContent:
+			text = fmt.Sprintf("[音频 %s，未展示]", v.MIMEType)
+		case mcp.EmbeddedResource:
+			text = describeEmbeddedResource(v.Resource)
+		case mcp.ResourceLink:
+			text = describeResourceLink(v)
+		}
+		out = append(out, MCPPromptMessage{Role: string(m.Role), Text: text})
+	}
+	return out
+}
+
+// JoinPromptMessages 将 prompt 消息拼接为一条用户输入；只有单一 user 角色时不加角色前缀。
+func JoinPromptMessages(msgs []MCPPromptMessage) string {
+	onlyUser := true
+	for _, m := range msgs {
+		if m.Role != string(mcp.RoleUser) {
+			onlyUser = false
+			break
+		}
+	}
+	parts := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		if strings.TrimSpace(m.Text) == "" {
+			continue
+		}
+		if onlyUser {
+			parts = append(parts, m.Text)
+		} else {
+			parts = append(parts, fmt.Sprintf("[%s]\n%s", m.Role, m.Text))
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// ListResources 汇总所有支持 resources 的 server 的资源列表；单个 server 失败时记录到 warnings 并继续。
+func (r *MCPRouter) ListResources(ctx context.Context) ([]ServerResource, []string) {
+	var (
+		out      []ServerResource
+		warnings []string
+	)
+	for _, s := range r.servers {
+		rc, ok := s.Client.(MCPResourceClient)
+		if !ok {
+			continue
+		}
+		list, err := rc.ListResources(ctx)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("获取 MCP server %q 的资源列表失败: %v", s.Name, err))
+			continue
+		}
+		for _, res := range list {
+			out = append(out, ServerResource{Server: s.Name, MCPResource: res})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Server != out[j].Server {
+			return out[i].Server < out[j].Server
+		}
+		return out[i].URI < out[j].URI
+	})
+	return out, warnings
+}
+
+// ReadResource 读取指定 server 上的资源。
+func (r *MCPRouter) ReadResource(ctx context.Context, server, uri string) (string, error) {
+	client, ok := r.server(server)
+	if !ok {
+		return "", fmt.Errorf("未知 MCP server: %s", server)
+	}
+	rc, ok := client.(MCPResourceClient)
+	if !ok {
+		return "", fmt.Errorf("MCP server %s 不支持读取资源", server)
+	}
+	return rc.ReadResource(ctx, uri)
+}
+
+// ListPrompts 汇总所有支持 prompts 的 server 的 prompt 列表；单个 server 失败时记录到 warnings 并继续。
+func (r *MCPRouter) ListPrompts(ctx context.Context) ([]ServerPrompt, []string) {
+	var (
+		out      []ServerPrompt
+		warnings []string
+	)
+	for _, s := range r.servers {
+		pc, ok := s.Client.(MCPPromptClient)
+		if !ok {
+			continue
+		}
+		list, err := pc.ListPrompts(ctx)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("获取 MCP server %q 的 prompt 列表失败: %v", s.Name, err))
+			continue
+		}
+		for _, p := range list {
+			out = append(out, ServerPrompt{Server: s.Name, MCPPrompt: p})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Server != out[j].Server {
+			return out[i].Server < out[j].Server
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, warnings
+}
+
+// GetPrompt 获取指定 server 上的 prompt 并按参数渲染。
+func (r *MCPRouter) GetPrompt(ctx context.Context, server, name string, arguments map[string]string) ([]MCPPromptMessage, error) {
+	client, ok := r.server(server)
+	if !ok {
+		return nil, fmt.Errorf("未知 MCP server: %s", server)
+	}
+	pc, ok := client.(MCPPromptClient)
+	if !ok {
+		return nil, fmt.Errorf("MCP server %s 不支持 prompts", server)
+	}
+	return pc.GetPrompt(ctx, name, arguments)
+}
+
+// resourceMentionPattern 匹配行首或空白后的 @server:uri 引用，URI 在空白或全角标点处结束。
+var resourceMentionPattern = regexp.MustCompile(`(^|\s)@([^\s:@]+):([^\s，。；！？、）]+)`)
+
+// ResourceMention 为输入中的一次 @server:uri 引用。
+type ResourceMention struct {
+	Server string
+	URI    string
+}
+
+// ParseResourceMentions 提取输入中的 @server:uri 引用（去重，保持出现顺序）；URI 末尾的 ASCII 标点会被去掉。
+func ParseResourceMentions(text string) []ResourceMention {
+	var out []ResourceMention
+	seen := make(map[ResourceMention]bool)
+	for _, m := range resourceMentionPattern.FindAllStringSubmatch(text, -1) {
+		uri := strings.TrimRight(m[3], ",.;!?)")
+		if uri == "" {
+			continue
+		}
+		mention := ResourceMention{Server: m[2], URI: uri}
+		if !seen[mention] {
+			seen[mention] = true
+			out = append(out, mention)
+		}
+	}
+	return out
+}
+
+// ExpandResourceMentions 读取输入中 @server:uri 引用的资源，并以 <mcp_resource> 块附加在输入末尾。
+// 不属于已配置 server 的 @ 引用保持原样（如邮箱地址）；资源读取失败时返回错误。
+func (r *MCPRouter) ExpandResourceMentions(ctx context.Context, text string) (string, error) {
+	if r == nil {
+		return text, nil
+	}
+	var blocks []string
+	for _, m := range ParseResourceMentions(text) {
+		if _, ok := r.server(m.Server); !ok {
+			continue
+		}
+		content, err := r.ReadResource(ctx, m.Server, m.URI)
+		if err != nil {
+			return "", fmt.Errorf("读取资源 @%s:%s 失败: %w", m.Server, m.URI, err)
+		}
+		blocks = append(blocks, fmt.Sprintf("<mcp_resource server=%q uri=%q>\n%s\n</mcp_resource>", m.Server, m.URI, content))
+	}
+	if len(blocks) == 0 {
+		return text, nil
+	}
+	return text + "\n\n" + strings.Join(blocks, "\n\n"), nil
+}
+
+// server 返回指定名称的客户端。
+func (r *MCPRouter) server(name string) (MCPClient, bool) {
+	for _, s := range r.servers {
+		if s.Name == name {
+			return s.Client, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,92 @@
+package mcp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	mcpm "github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+)
+
+// newInProcessServerClient 以进程内传输连接 srv 并完成初始化。
+func newInProcessServerClient(t *testing.T, name string, srv *mcpserver.MCPServer) ServerClient {
+	t.Helper()
+	client, err := mcpclient.NewInProcessClient(srv)
+	if err != nil {
+		t.Fatalf("NewInProcessClient error: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if err := initMCPClient(context.Background(), name, client, 0); err != nil {
+		t.Fatalf("init %s: %v", name, err)
+	}
+	return ServerClient{Name: name, Client: NewGoSDKMCPClient(client)}
+}
+
+// TestMCPRouter_ResourcesAndPrompts 验证资源列表/读取、prompt 获取、@server:uri 展开，以及未声明能力的 server 被跳过。
+func TestMCPRouter_ResourcesAndPrompts(t *testing.T) {
+	docs := mcpserver.NewMCPServer("docs", "0.0.1",
+		mcpserver.WithResourceCapabilities(false, false),
+		mcpserver.WithPromptCapabilities(false),
+	)
+	docs.AddResource(mcpm.NewResource("file:///readme.md", "readme", mcpm.WithMIMEType("text/markdown")),
+		func(ctx context.Context, req mcpm.ReadResourceRequest) ([]mcpm.ResourceContents, error) {
+			return []mcpm.ResourceContents{mcpm.TextResourceContents{URI: req.Params.URI, MIMEType: "text/markdown", Text: "# 说明"}}, nil
+		})
+	docs.AddPrompt(mcpm.NewPrompt("review", mcpm.WithPromptDescription("代码评审"), mcpm.WithArgument("file", mcpm.RequiredArgument())),
+		func(ctx context.Context, req mcpm.GetPromptRequest) (*mcpm.GetPromptResult, error) {
+			return mcpm.NewGetPromptResult("", []mcpm.PromptMessage{
+				mcpm.NewPromptMessage(mcpm.RoleUser, mcpm.NewTextContent("请评审 "+req.Params.Arguments["file"])),
+			}), nil
+		})
+	plain := mcpserver.NewMCPServer("plain", "0.0.1")
+
+	router, _, _, err := NewMCPRouter(context.Background(), []ServerClient{
+		newInProcessServerClient(t, "docs", docs),
+		newInProcessServerClient(t, "plain", plain),
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewMCPRouter error: %v", err)
+	}
+	ctx := context.Background()
+
+	resources, warnings := router.ListResources(ctx)
+	if len(warnings) != 0 || len(resources) != 1 || resources[0].Server != "docs" || resources[0].URI != "file:///readme.md" {
+		t.Fatalf("unexpected resources=%+v warnings=%v", resources, warnings)
+	}
+
+	prompts, warnings := router.ListPrompts(ctx)
+	if len(warnings) != 0 || len(prompts) != 1 || prompts[0].Name != "review" || !prompts[0].Arguments[0].Required {
+		t.Fatalf("unexpected prompts=%+v warnings=%v", prompts, warnings)
+	}
+	msgs, err := router.GetPrompt(ctx, "docs", "review", map[string]string{"file": "main.go"})
+	if err != nil || JoinPromptMessages(msgs) != "请评审 main.go" {
+		t.Fatalf("GetPrompt msgs=%+v err=%v", msgs, err)
+	}
+
+	expanded, err := router.ExpandResourceMentions(ctx, "看看 @docs:file:///readme.md，联系 a@b.com")
+	if err != nil {
+		t.Fatalf("ExpandResourceMentions error: %v", err)
+	}
+	if !strings.Contains(expanded, "<mcp_resource server=\"docs\" uri=\"file:///readme.md\">\n# 说明\n</mcp_resource>") {
+		t.Fatalf("unexpected expansion: %s", expanded)
+	}
+	if _, err := router.ExpandResourceMentions(ctx, "@docs:file:///missing.md"); err == nil {
+		t.Fatalf("expected error for missing resource")
+	}
+}
+
+// TestParseResourceMentions 验证引用解析的边界：邮箱、末尾标点与去重。
+func TestParseResourceMentions(t *testing.T) {
+	got := ParseResourceMentions("@fs:file:///a.go, 和 @fs:file:///a.go 以及 x@y:z @db:table/users。")
+	want := []ResourceMention{{Server: "fs", URI: "file:///a.go"}, {Server: "db", URI: "table/users"}}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %+v, want %+v", got, want)
+		}
+	}
+}
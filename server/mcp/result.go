@@ -0,0 +1,146 @@
+package mcp
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+
+	"chase-code/server/tools"
+)
+
+// imageDirName 为 MCP 工具返回图片的保存目录（位于 ~/.chase-code 下）。
+const imageDirName = "mcp-images"
+
+// ConvertCallToolResult 将 MCP CallToolResult 转换为 ToolResult：
+//   - text 内容拼接为纯文本；
+//   - 图片解码后保存到 ~/.chase-code/mcp-images，并在文本中注明路径；
+//   - 内嵌资源与资源链接以文字说明，文本资源直接附上内容；
+//   - structuredContent 保存在 Structured 字段，无其它内容时同时作为输出文本；
+//   - isError 为 true 时返回错误，错误信息为工具输出文本。
+func ConvertCallToolResult(res *mcp.CallToolResult) (tools.ToolResult, error) {
+	if res == nil {
+		return tools.ToolResult{}, nil
+	}
+
+	var (
+		out   tools.ToolResult
+		parts []string
+	)
+	for _, c := range res.Content {
+		switch v := c.(type) {
+		case mcp.TextContent:
+			parts = append(parts, v.Text)
+		case *mcp.TextContent:
+			parts = append(parts, v.Text)
+		case mcp.ImageContent:
+			parts = append(parts, saveImageContent(v, &out))
+		case *mcp.ImageContent:
+			parts = append(parts, saveImageContent(*v, &out))
+		case mcp.AudioContent:
+			parts = append(parts, fmt.Sprintf("[音频 %s，%d 字节，未展示]", v.MIMEType, base64.StdEncoding.DecodedLen(len(v.Data))))
+		case mcp.EmbeddedResource:
+			parts = append(parts, describeEmbeddedResource(v.Resource))
+		case mcp.ResourceLink:
+			parts = append(parts, describeResourceLink(v))
+		default:
+			if b, err := json.Marshal(c); err == nil {
+				parts = append(parts, string(b))
+			}
+		}
+	}
+
+	if res.StructuredContent != nil {
+		if b, err := json.Marshal(res.StructuredContent); err == nil {
+			out.Structured = b
+			if len(parts) == 0 {
+				parts = append(parts, string(b))
+			}
+		}
+	}
+
+	out.Output = strings.Join(parts, "\n")
+	if res.IsError {
+		msg := strings.TrimSpace(out.Output)
+		if msg == "" {
+			msg = "工具返回 isError 但没有错误信息"
+		}
+		return tools.ToolResult{}, fmt.Errorf("%s", msg)
+	}
+	return out, nil
+}
+
+// saveImageContent 保存图片并返回写入输出文本的说明。
+func saveImageContent(img mcp.ImageContent, out *tools.ToolResult) string {
+	path, err := saveImage(img.Data, img.MIMEType)
+	if err != nil {
+		return fmt.Sprintf("[图片 %s，保存失败: %v]", img.MIMEType, err)
+	}
+	out.Images = append(out.Images, tools.ToolImage{Path: path, MimeType: img.MIMEType})
+	return fmt.Sprintf("[图片 %s 已保存到 %s]", img.MIMEType, path)
+}
+
+// saveImage 解码 base64 图片并按内容哈希命名保存，相同图片只写一次。
+func saveImage(data, mimeType string) (string, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", fmt.Errorf("解码图片失败: %w", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(home, ".chase-code", imageDirName)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	ext := ".bin"
+	if exts, _ := mime.ExtensionsByType(mimeType); len(exts) > 0 {
+		ext = exts[0]
+	}
+	sum := sha256.Sum256(raw)
+	path := filepath.Join(dir, hex.EncodeToString(sum[:])[:16]+ext)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.WriteFile(path, raw, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// describeEmbeddedResource 描述内嵌资源：文本资源附上内容，二进制资源只注明大小。
+func describeEmbeddedResource(r mcp.ResourceContents) string {
+	switch v := r.(type) {
+	case mcp.TextResourceContents:
+		return fmt.Sprintf("[资源 %s]\n%s", v.URI, v.Text)
+	case *mcp.TextResourceContents:
+		return fmt.Sprintf("[资源 %s]\n%s", v.URI, v.Text)
+	case mcp.BlobResourceContents:
+		return fmt.Sprintf("[二进制资源 %s (%s)，%d 字节]", v.URI, v.MIMEType, base64.StdEncoding.DecodedLen(len(v.Blob)))
+	case *mcp.BlobResourceContents:
+		return fmt.Sprintf("[二进制资源 %s (%s)，%d 字节]", v.URI, v.MIMEType, base64.StdEncoding.DecodedLen(len(v.Blob)))
+	default:
+		return "[未知类型的资源]"
+	}
+}
+
+// describeResourceLink 描述资源链接。
+func describeResourceLink(l mcp.ResourceLink) string {
+	s := fmt.Sprintf("[资源链接 %s", l.URI)
+	if l.Name != "" {
+		s += " " + l.Name
+	}
+	if l.Description != "" {
+		s += ": " + l.Description
+	}
+	return s + "]"
+}
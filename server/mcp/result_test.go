@@ -0,0 +1,62 @@
+package mcp
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mcpm "github.com/mark3labs/mcp-go/mcp"
+)
+
+// TestConvertCallToolResult 验证文本拼接、图片落盘、资源说明与 structuredContent 保留。
+func TestConvertCallToolResult(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	png := []byte("\x89PNG\r\n\x1a\nfake")
+
+	res, err := ConvertCallToolResult(&mcpm.CallToolResult{
+		Content: []mcpm.Content{
+			mcpm.NewTextContent("第一行"),
+			mcpm.NewImageContent(base64.StdEncoding.EncodeToString(png), "image/png"),
+			mcpm.NewEmbeddedResource(mcpm.TextResourceContents{URI: "file:///a.txt", Text: "资源内容"}),
+			mcpm.NewResourceLink("file:///b.log", "b.log", "日志", "text/plain"),
+		},
+		StructuredContent: map[string]any{"count": 2},
+	})
+	if err != nil {
+		t.Fatalf("ConvertCallToolResult error: %v", err)
+	}
+
+	for _, want := range []string{"第一行", "[图片 image/png 已保存到 ", "[资源 file:///a.txt]\n资源内容", "[资源链接 file:///b.log b.log: 日志]"} {
+		if !strings.Contains(res.Output, want) {
+			t.Fatalf("output missing %q: %s", want, res.Output)
+		}
+	}
+	if strings.Contains(res.Output, `"type"`) {
+		t.Fatalf("output should not contain raw content JSON: %s", res.Output)
+	}
+	if len(res.Images) != 1 || res.Images[0].MimeType != "image/png" || filepath.Ext(res.Images[0].Path) != ".png" {
+		t.Fatalf("unexpected images: %+v", res.Images)
+	}
+	data, err := os.ReadFile(res.Images[0].Path)
+	if err != nil || string(data) != string(png) {
+		t.Fatalf("saved image mismatch: %q err=%v", data, err)
+	}
+	if string(res.Structured) != `{"count":2}` {
+		t.Fatalf("unexpected structured: %s", res.Structured)
+	}
+
+	only, err := ConvertCallToolResult(&mcpm.CallToolResult{StructuredContent: map[string]any{"ok": true}})
+	if err != nil || only.Output != `{"ok":true}` {
+		t.Fatalf("structured-only output=%q err=%v", only.Output, err)
+	}
+}
+
+// TestConvertCallToolResult_IsError 验证 isError 结果被映射为工具错误。
+func TestConvertCallToolResult_IsError(t *testing.T) {
+	_, err := ConvertCallToolResult(mcpm.NewToolResultError("文件不存在"))
+	if err == nil || err.Error() != "文件不存在" {
+		t.Fatalf("expected tool error, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package mcp
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"chase-code/server/tools"
+)
+
+const (
+	// mcpToolPrefix 为对模型暴露的 MCP 工具名前缀，完整形式为 mcp__<server>__<tool>。
+	mcpToolPrefix = "mcp__"
+	// maxToolNameLen 为主流 provider 对工具名长度的限制（OpenAI/Anthropic 均为 64）。
+	maxToolNameLen = 64
+)
+
+// invalidToolNameChars 匹配 provider 工具名中不允许出现的字符（仅允许 [a-zA-Z0-9_-]）。
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// ServerClient 为带 server 名称的 MCPClient，名称来自 mcpServers 配置的键。
+type ServerClient struct {
+	Name   string
+	Client MCPClient
+	// Config 为该 server 的配置，Refresh 时按其中的 enabledTools/disabledTools 过滤工具。
+	Config MCPRemoteServerConfig
+}
+
+// Clients 返回不带名称的客户端列表。
+func Clients(servers []ServerClient) []MCPClient {
+	out := make([]MCPClient, 0, len(servers))
+	for _, s := range servers {
+		out = append(out, s.Client)
+	}
+	return out
+}
+
+// NamespacedToolName 返回对模型暴露的工具名 mcp__<server>__<tool>，非法字符替换为下划线；
+// 超出长度限制时截断并追加原始名称的短哈希，保证不同工具截断后仍可区分。
+func NamespacedToolName(server, tool string) string {
+	raw := mcpToolPrefix + server + "__" + tool
+	name := invalidToolNameChars.ReplaceAllString(raw, "_")
+	if len(name) <= maxToolNameLen {
+		return name
+	}
+	sum := sha1.Sum([]byte(raw))
+	suffix := "_" + hex.EncodeToString(sum[:])[:8]
+	return name[:maxToolNameLen-len(suffix)] + suffix
+}
+
+// mcpRoute 记录一个暴露名称对应的 server 与原始工具名。
+type mcpRoute struct {
+	server string
+	tool   string
+	client MCPClient
+}
+
+// MCPRouter 维护 “暴露名称 -> server/原始工具名” 的路由表，CallTool 直接调用工具所属的 server。
+// 它实现 tools.ToolCaller，可直接注入 ToolRouter。路由表可通过 Refresh 在会话中途重建。
+type MCPRouter struct {
+	mu     sync.RWMutex
+	routes map[string]mcpRoute
+	// servers 为全部 server，用于资源读取与 prompt 获取。
+	servers []ServerClient
+	// reserved 为已占用的工具名，Refresh 时沿用。
+	reserved []string
+	// usage 为最近一次 Refresh 时各 server 暴露的工具 schema 用量。
+	usage map[string]schemaUsage
+
+	// 变更触发的刷新由单个 worker 串行执行：运行期间的新通知只标记 refreshQueued，
+	// 当前刷新结束后再合并执行一次，保证回调收到的工具列表按时间顺序、不会被旧结果覆盖。
+	refreshMu      sync.Mutex
+	refreshRunning bool
+	refreshQueued  bool
+	// applyMu 保证 RefreshWith 的拉取与回调作为整体执行，手动刷新与变更刷新不会交错覆盖。
+	applyMu sync.Mutex
+}
+
+// schemaUsage 为单个 server 暴露给模型的工具 schema 用量。
+type schemaUsage struct {
+	tokens   int
+	filtered int
+}
+
+// NewMCPRouter 拉取各 server 的工具列表并构建路由表，返回可直接追加到工具集合的 ToolSpec。
+// reserved 为已占用的工具名（如内置的 shell_command / apply_patch）。与 reserved 或其它 MCP 工具
+// 重名（包括清洗后重名）的工具会被跳过，并在 warnings 中说明。
+// 单个 server 拉取失败只记录到 warnings，不影响其它 server；仅在 ctx 已取消时返回错误。
+func NewMCPRouter(ctx context.Context, servers []ServerClient, reserved []string) (*MCPRouter, []tools.ToolSpec, []string, error) {
+	r := &MCPRouter{routes: make(map[string]mcpRoute), servers: servers, reserved: reserved}
+	specs, warnings, err := r.Refresh(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return r, specs, warnings, nil
+}
+
+// Refresh 重新拉取所有 server 的工具列表并重建路由表，返回新的 MCP ToolSpec 列表。
+func (r *MCPRouter) Refresh(ctx context.Context) ([]tools.ToolSpec, []string, error) {
+	taken := make(map[string]string, len(r.reserved))
+	for _, name := range r.reserved {
+		taken[name] = "内置工具 " + name
+	}
+
+	var (
+		specs    []tools.ToolSpec
+		warnings []string
+	)
+	routes := make(map[string]mcpRoute)
+	usage := make(map[string]schemaUsage, len(r.servers))
+	for _, s := range r.servers {
+		list, err := s.Client.ListTools(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, fmt.Errorf("获取 MCP 工具列表被取消: %w", ctx.Err())
+			}
+			warnings = append(warnings, fmt.Sprintf("获取 MCP server %q 的工具列表失败: %v", s.Name, err))
+			continue
+		}
+		var u schemaUsage
+		for _, t := range list {
+			if !s.Config.ToolAllowed(t.Name) {
+				u.filtered++
+				continue
+			}
+			name := NamespacedToolName(s.Name, t.Name)
+			if owner, ok := taken[name]; ok {
+				warnings = append(warnings, fmt.Sprintf("MCP 工具 %s/%s 暴露为 %s 时与%s冲突，已跳过", s.Name, t.Name, name, owner))
+				continue
+			}
+			taken[name] = fmt.Sprintf(" MCP 工具 %s/%s", s.Name, t.Name)
+			routes[name] = mcpRoute{server: s.Name, tool: t.Name, client: s.Client}
+			spec := tools.ToolSpec{
+				Kind:        tools.ToolKindCustom,
+				Name:        name,
+				Description: t.Description,
+				Parameters:  t.Parameters,
+			}
+			u.tokens += EstimateSchemaTokens(spec)
+			specs = append(specs, spec)
+		}
+		usage[s.Name] = u
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+
+	r.mu.Lock()
+	r.routes = routes
+	r.usage = usage
+	r.mu.Unlock()
+	return specs, warnings, nil
+}
+
+// WatchToolChanges 在任一 server 重连成功或发出 tools/list_changed 通知时重建路由表，
+// 并把新的 MCP ToolSpec 交给 fn。回调在后台 goroutine 中串行执行，短时间内的多次通知合并为一次刷新。
+func (r *MCPRouter) WatchToolChanges(fn func(specs []tools.ToolSpec, warnings []string)) {
+	for _, s := range r.servers {
+		m, ok := s.Client.(*ManagedClient)
+		if !ok {
+			continue
+		}
+		name, timeout := s.Name, m.timeout
+		m.SetOnChange(func() {
+			r.scheduleRefresh(name, timeout, fn)
+		})
+	}
+}
+
+// scheduleRefresh 请求一次刷新；已有刷新在执行时只做标记，由执行中的 worker 在结束后再刷新一次。
+func (r *MCPRouter) scheduleRefresh(name string, timeout time.Duration, fn func(specs []tools.ToolSpec, warnings []string)) {
+	r.refreshMu.Lock()
+	if r.refreshRunning {
+		r.refreshQueued = true
+		r.refreshMu.Unlock()
+		return
+	}
+	r.refreshRunning = true
+	r.refreshMu.Unlock()
+
+	go func() {
+		for {
+			r.refreshAndNotify(name, timeout, fn)
+
+			r.refreshMu.Lock()
+			if !r.refreshQueued {
+				r.refreshRunning = false
+				r.refreshMu.Unlock()
+				return
+			}
+			r.refreshQueued = false
+			r.refreshMu.Unlock()
+		}
+	}()
+}
+
+// refreshAndNotify 重建路由表并把结果交给 fn。
+func (r *MCPRouter) refreshAndNotify(name string, timeout time.Duration, fn func(specs []tools.ToolSpec, warnings []string)) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	specs, _, err := r.RefreshWith(ctx, fn)
+	if err != nil {
+		log.Printf("[mcp] refresh tools after change on server=%s failed: %v", name, err)
+		return
+	}
+	log.Printf("[mcp] tools refreshed after change on server=%s total=%d", name, len(specs))
+}
+
+// RefreshWith 执行 Refresh 并在成功后把结果交给 fn；与其它 RefreshWith 调用互斥，
+// 保证路由表与 fn 应用的工具列表始终对应最近一次拉取的结果。
+func (r *MCPRouter) RefreshWith(ctx context.Context, fn func(specs []tools.ToolSpec, warnings []string)) ([]tools.ToolSpec, []string, error) {
+	r.applyMu.Lock()
+	defer r.applyMu.Unlock()
+	specs, warnings, err := r.Refresh(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fn != nil {
+		fn(specs, warnings)
+	}
+	return specs, warnings, nil
+}
+
+// Statuses 返回各 server 的运行状态，按配置顺序排列。
+func (r *MCPRouter) Statuses() []ServerStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]ServerStatus, 0, len(r.servers))
+	for _, s := range r.servers {
+		st := ServerStatus{Name: s.Name, State: ServerReady}
+		if m, ok := s.Client.(*ManagedClient); ok {
+			st = m.Status()
+		}
+		u := r.usage[s.Name]
+		st.SchemaTokens = u.tokens
+		st.FilteredTools = u.filtered
+		out = append(out, st)
+	}
+	return out
+}
+
+// SchemaTokens 返回最近一次 Refresh 时全部 MCP 工具 schema 的估算 token 数。
+func (r *MCPRouter) SchemaTokens() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	total := 0
+	for _, u := range r.usage {
+		total += u.tokens
+	}
+	return total
+}
+
+// route 返回暴露名称对应的路由。
+func (r *MCPRouter) route(name string) (mcpRoute, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	route, ok := r.routes[name]
+	return route, ok
+}
+
+// CallTool 按路由表把调用转发给工具所属的 server，并使用其原始工具名。
+func (r *MCPRouter) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+	route, ok := r.route(name)
+	if !ok {
+		if strings.HasPrefix(name, mcpToolPrefix) {
+			return "", fmt.Errorf("MCP 工具 %s 不存在或已被跳过", name)
+		}
+		return "", fmt.Errorf("未知工具: %s", name)
+	}
+	return route.client.CallTool(ctx, route.tool, arguments)
+}
+
+// AutoApprovedTools 返回配置中 autoApprove 列出的工具对应的暴露名称。
+// autoApprove 使用 server 自身的原始工具名，与 MCP 客户端的通用配置格式保持一致。
+// 来自项目配置（Untrusted）的 autoApprove 一律忽略，仓库不能替用户免除审批。
+func (r *MCPRouter) AutoApprovedTools(cfg *MCPConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []string
+	for name, route := range r.routes {
+		s := cfg.MCPServers[route.server]
+		if s.Untrusted {
+			continue
+		}
+		for _, allowed := range s.AutoApprove {
+			if allowed == route.tool {
+				out = append(out, name)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// CallToolResult 按路由表调用工具；底层客户端支持结构化结果时保留图片与 structuredContent。
+func (r *MCPRouter) CallToolResult(ctx context.Context, name string, arguments json.RawMessage) (tools.ToolResult, error) {
+	route, ok := r.route(name)
+	if !ok {
+		_, err := r.CallTool(ctx, name, arguments)
+		return tools.ToolResult{}, err
+	}
+	if rich, ok := route.client.(tools.ResultToolCaller); ok {
+		return rich.CallToolResult(ctx, route.tool, arguments)
+	}
+	out, err := route.client.CallTool(ctx, route.tool, arguments)
+	return tools.ToolResult{Output: out}, err
+}
+
+// Resolve 返回暴露名称对应的 server 与原始工具名。
+func (r *MCPRouter) Resolve(name string) (server, tool string, ok bool) {
+	route, ok := r.route(name)
+	return route.server, route.tool, ok
+}
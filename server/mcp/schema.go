@@ -0,0 +1,323 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+
+	"chase-code/server/tools"
+)
+
+const (
+	// maxSchemaRefDepth 为 $ref 内联的最大深度，超过后替换为不受限的 schema。
+	maxSchemaRefDepth = 8
+	// maxSchemaNodes 与 maxSchemaBytes 限制内联后的 schema 规模：即使没有递归，
+	// 多个属性共享同一 $defs 也会逐层放大，超出时放弃清洗并透传原始 schema。
+	maxSchemaNodes = 2000
+	maxSchemaBytes = 64 << 10
+)
+
+// schemaMetaKeywords 为模型服务商普遍不接受、且对参数语义没有影响的元关键字。
+var schemaMetaKeywords = []string{"$schema", "$id", "$comment", "$anchor", "$dynamicRef", "$dynamicAnchor", "$vocabulary", "$defs", "definitions"}
+
+// schemaCombinators 为顶层不被 OpenAI/Anthropic 接受的组合关键字。
+var schemaCombinators = []string{"anyOf", "oneOf", "allOf"}
+
+// normalizeMCPJSONSchema 尝试修正来自 MCP server 的 JSON Schema，
+// 使其满足各模型服务商对工具参数的通用要求（见 sanitizeSchema）。
+// 无法修正时直接透传，避免静默丢弃工具。
+func normalizeMCPJSONSchema(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return raw
+	}
+	fixed, err := sanitizeSchema(raw)
+	if err != nil {
+		log.Printf("[mcp] 无法修正工具参数 schema，直接透传原始数据: %v", err)
+		return raw
+	}
+	return fixed
+}
+
+// sanitizeSchema 对工具参数 schema 做通用清洗：
+//   - 内联 $ref（#/$defs、#/definitions 与 #），递归引用（正在展开的 $ref 再次出现）替换为空 schema；
+//   - 删除 $schema、$id、$defs 等元关键字；
+//   - 顶层 anyOf/oneOf/allOf 合并为单个 object，顶层 enum/not 删除；
+//   - object 补全缺失的 properties，删除 required 中不存在的字段。
+func sanitizeSchema(raw json.RawMessage) (json.RawMessage, error) {
+	var root map[string]any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("不是 JSON 对象: %w", err)
+	}
+	s := &schemaSanitizer{root: root}
+	out := s.node(root)
+	if s.nodes > maxSchemaNodes {
+		return nil, fmt.Errorf("内联 $ref 后 schema 超过 %d 个节点", maxSchemaNodes)
+	}
+	flattenTopLevelCombinators(out)
+
+	switch typ := out["type"].(type) {
+	case nil:
+		out["type"] = "object"
+	case string:
+		if typ != "object" {
+			return nil, fmt.Errorf("顶层 type 应为 object，实际为 %s", typ)
+		}
+	default:
+		return nil, fmt.Errorf("顶层 type 应为 object，实际为 %v", typ)
+	}
+	fixObject(out)
+	data, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSchemaBytes {
+		return nil, fmt.Errorf("内联 $ref 后 schema 为 %d 字节，超过上限 %d", len(data), maxSchemaBytes)
+	}
+	return data, nil
+}
+
+// schemaSanitizer 持有根 schema 用于解析 $ref，并记录展开过程中的状态。
+type schemaSanitizer struct {
+	root map[string]any
+	// expanding 为当前路径上正在展开的 $ref，再次遇到其中之一即为递归引用。
+	expanding []string
+	// nodes 为已生成的节点数，超过 maxSchemaNodes 后不再展开。
+	nodes int
+}
+
+// node 返回清洗后的 schema 副本。
+func (s *schemaSanitizer) node(m map[string]any) map[string]any {
+	s.nodes++
+	if s.nodes > maxSchemaNodes {
+		return map[string]any{}
+	}
+	if ref, ok := m["$ref"].(string); ok {
+		target, found := s.resolveRef(ref)
+		if !found || slices.Contains(s.expanding, ref) || len(s.expanding) >= maxSchemaRefDepth {
+			// 无法解析、递归引用或嵌套过深时退化为不受限 schema，保留描述。
+			out := map[string]any{}
+			if desc, ok := m["description"]; ok {
+				out["description"] = desc
+			}
+			return out
+		}
+		merged := make(map[string]any, len(target)+len(m))
+		for k, v := range target {
+			merged[k] = v
+		}
+		for k, v := range m {
+			if k != "$ref" {
+				merged[k] = v
+			}
+		}
+		s.expanding = append(s.expanding, ref)
+		defer func() { s.expanding = s.expanding[:len(s.expanding)-1] }()
+		return s.node(merged)
+	}
+
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	for _, k := range schemaMetaKeywords {
+		delete(out, k)
+	}
+
+	if props, ok := out["properties"].(map[string]any); ok {
+		fixed := make(map[string]any, len(props))
+		for name, p := range props {
+			fixed[name] = s.value(p)
+		}
+		out["properties"] = fixed
+	}
+	for _, k := range []string{"items", "additionalProperties", "not"} {
+		if v, ok := out[k]; ok {
+			out[k] = s.value(v)
+		}
+	}
+	for _, k := range append([]string{"prefixItems"}, schemaCombinators...) {
+		if list, ok := out[k].([]any); ok {
+			fixed := make([]any, len(list))
+			for i, v := range list {
+				fixed[i] = s.value(v)
+			}
+			out[k] = fixed
+		}
+	}
+	if isObjectSchema(out) {
+		fixObject(out)
+	}
+	return out
+}
+
+// value 清洗任意位置的子 schema；布尔 schema 与数组形式的 items 原样保留结构。
+func (s *schemaSanitizer) value(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		return s.node(t)
+	case []any:
+		fixed := make([]any, len(t))
+		for i, item := range t {
+			fixed[i] = s.value(item)
+		}
+		return fixed
+	}
+	return v
+}
+
+// resolveRef 解析文档内引用，只支持 # 开头的 JSON Pointer。
+func (s *schemaSanitizer) resolveRef(ref string) (map[string]any, bool) {
+	if ref == "#" {
+		return s.root, true
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	var cur any = s.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	target, ok := cur.(map[string]any)
+	return target, ok
+}
+
+// isObjectSchema 判断 schema 是否描述 object（显式 type 或带 properties）。
+func isObjectSchema(m map[string]any) bool {
+	if typ, ok := m["type"].(string); ok {
+		return typ == "object"
+	}
+	if types, ok := m["type"].([]any); ok {
+		for _, t := range types {
+			if t == "object" {
+				return true
+			}
+		}
+		return false
+	}
+	_, hasProps := m["properties"]
+	return hasProps
+}
+
+// fixObject 补全 properties 并删除 required 中不存在的字段。
+func fixObject(m map[string]any) {
+	props, ok := m["properties"].(map[string]any)
+	if !ok {
+		// 对于不带任何字段的工具（如 GetCurrentTime），补一个空 properties 以通过服务端校验。
+		props = map[string]any{}
+		m["properties"] = props
+	}
+	required, ok := m["required"].([]any)
+	if !ok {
+		delete(m, "required")
+		return
+	}
+	kept := make([]any, 0, len(required))
+	for _, r := range required {
+		if name, ok := r.(string); ok {
+			if _, exists := props[name]; exists {
+				kept = append(kept, name)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		delete(m, "required")
+		return
+	}
+	m["required"] = kept
+}
+
+// flattenTopLevelCombinators 将顶层的 anyOf/oneOf/allOf 合并为一个 object：
+// 各分支的 properties 取并集；allOf 的 required 取并集，anyOf/oneOf 只保留所有分支都要求的字段。
+func flattenTopLevelCombinators(root map[string]any) {
+	delete(root, "enum")
+	delete(root, "not")
+	for _, k := range schemaCombinators {
+		branches, ok := root[k].([]any)
+		delete(root, k)
+		if !ok {
+			continue
+		}
+		props, _ := root["properties"].(map[string]any)
+		if props == nil {
+			props = map[string]any{}
+		}
+		required := stringSet(root["required"])
+		var common map[string]bool
+		for _, b := range branches {
+			branch, ok := b.(map[string]any)
+			if !ok {
+				continue
+			}
+			if bp, ok := branch["properties"].(map[string]any); ok {
+				for name, p := range bp {
+					if _, exists := props[name]; !exists {
+						props[name] = p
+					}
+				}
+			}
+			br := stringSet(branch["required"])
+			if k == "allOf" {
+				for name := range br {
+					required[name] = true
+				}
+				continue
+			}
+			if common == nil {
+				common = br
+				continue
+			}
+			for name := range common {
+				if !br[name] {
+					delete(common, name)
+				}
+			}
+		}
+		for name := range common {
+			required[name] = true
+		}
+		root["properties"] = props
+		if len(required) > 0 {
+			names := make([]string, 0, len(required))
+			for name := range required {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			list := make([]any, len(names))
+			for i, name := range names {
+				list[i] = name
+			}
+			root["required"] = list
+		}
+		if _, ok := root["type"]; !ok {
+			root["type"] = "object"
+		}
+	}
+}
+
+// stringSet 将 JSON 字符串数组转换为集合。
+func stringSet(v any) map[string]bool {
+	out := make(map[string]bool)
+	list, _ := v.([]any)
+	for _, item := range list {
+		if s, ok := item.(string); ok {
+			out[s] = true
+		}
+	}
+	return out
+}
+
+// EstimateSchemaTokens 粗略估算一个工具定义（名称、描述与参数 schema）占用的 token 数，按约 4 字节 1 token 计算。
+func EstimateSchemaTokens(spec tools.ToolSpec) int {
+	n := len(spec.Name) + len(spec.Description) + len(spec.Parameters) + len(spec.Format)
+	return (n + 3) / 4
+}
@@ -0,0 +1,131 @@
+package mcp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSanitizeSchema(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "missing properties",
+			in:   `{"type":"object"}`,
+			want: `{"type":"object","properties":{}}`,
+		},
+		{
+			name: "meta keywords and dangling required",
+			in:   `{"$schema":"http://json-schema.org/draft-07/schema#","$id":"x","type":"object","properties":{"a":{"type":"string","$comment":"c"}},"required":["a","b"]}`,
+			want: `{"type":"object","properties":{"a":{"type":"string"}},"required":["a"]}`,
+		},
+		{
+			name: "ref inlining",
+			in:   `{"type":"object","properties":{"p":{"$ref":"#/$defs/point","description":"pos"}},"$defs":{"point":{"type":"object","properties":{"x":{"type":"number"}},"required":["x"]}}}`,
+			want: `{"type":"object","properties":{"p":{"type":"object","description":"pos","properties":{"x":{"type":"number"}},"required":["x"]}}}`,
+		},
+		{
+			name: "recursive ref",
+			in:   `{"type":"object","properties":{"node":{"$ref":"#/definitions/node"}},"definitions":{"node":{"type":"object","properties":{"next":{"$ref":"#/definitions/node"}}}}}`,
+		},
+		{
+			name: "top-level combinators",
+			in:   `{"anyOf":[{"properties":{"id":{"type":"string"},"q":{"type":"string"}},"required":["id","q"]},{"properties":{"id":{"type":"integer"}},"required":["id"]}]}`,
+			want: `{"type":"object","properties":{"id":{"type":"string"},"q":{"type":"string"}},"required":["id"]}`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, err := sanitizeSchema(json.RawMessage(tc.in))
+			if err != nil {
+				t.Fatalf("sanitizeSchema error: %v", err)
+			}
+			if tc.want == "" {
+				if !json.Valid(out) {
+					t.Fatalf("invalid output: %s", out)
+				}
+				return
+			}
+			var got, want any
+			_ = json.Unmarshal(out, &got)
+			_ = json.Unmarshal([]byte(tc.want), &want)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("got %s, want %s", out, tc.want)
+			}
+		})
+	}
+
+	if _, err := sanitizeSchema(json.RawMessage(`{"type":"string"}`)); err == nil {
+		t.Fatal("expect error for non-object top-level schema")
+	}
+	if got := normalizeMCPJSONSchema(json.RawMessage(`{"type":"string"}`)); string(got) != `{"type":"string"}` {
+		t.Fatalf("unfixable schema should pass through, got %s", got)
+	}
+}
+
+// TestSanitizeSchema_RecursiveRefs 验证递归 $ref 只展开一层，结果规模与引用数量成线性关系而非指数放大。
+func TestSanitizeSchema_RecursiveRefs(t *testing.T) {
+	t.Run("self reference to root", func(t *testing.T) {
+		in := `{"type":"object","properties":{"a":{"$ref":"#"},"b":{"$ref":"#"},"c":{"$ref":"#"},"d":{"$ref":"#"},"e":{"$ref":"#"},"f":{"$ref":"#","description":"self"}}}`
+		out, err := sanitizeSchema(json.RawMessage(in))
+		if err != nil {
+			t.Fatalf("sanitizeSchema error: %v", err)
+		}
+		if len(out) > 4096 {
+			t.Fatalf("output too large: %d bytes", len(out))
+		}
+		var got map[string]any
+		if err := json.Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		f := got["properties"].(map[string]any)["f"].(map[string]any)
+		if f["description"] != "self" {
+			t.Fatalf("expanded ref should keep description, got %v", f)
+		}
+		inner := f["properties"].(map[string]any)["a"]
+		if !reflect.DeepEqual(inner, map[string]any{}) {
+			t.Fatalf("cyclic ref should become {}, got %v", inner)
+		}
+	})
+
+	t.Run("mutual recursion", func(t *testing.T) {
+		in := `{"type":"object","properties":{"a":{"$ref":"#/$defs/a"}},"$defs":{"a":{"type":"object","properties":{"b":{"$ref":"#/$defs/b"}}},"b":{"type":"object","properties":{"a":{"$ref":"#/$defs/a"}}}}}`
+		want := `{"type":"object","properties":{"a":{"type":"object","properties":{"b":{"type":"object","properties":{"a":{}}}}}}}`
+		out, err := sanitizeSchema(json.RawMessage(in))
+		if err != nil {
+			t.Fatalf("sanitizeSchema error: %v", err)
+		}
+		var got, wantV any
+		_ = json.Unmarshal(out, &got)
+		_ = json.Unmarshal([]byte(want), &wantV)
+		if !reflect.DeepEqual(got, wantV) {
+			t.Fatalf("got %s, want %s", out, want)
+		}
+	})
+
+	t.Run("shared defs exceed budget", func(t *testing.T) {
+		// 无递归，但每层 6 个属性引用下一层，完全展开为 6^7 个节点。
+		defs := map[string]any{}
+		for i := 0; i < 7; i++ {
+			props := map[string]any{}
+			for _, p := range []string{"a", "b", "c", "d", "e", "f"} {
+				if i == 6 {
+					props[p] = map[string]any{"type": "string"}
+				} else {
+					props[p] = map[string]any{"$ref": "#/$defs/l" + string(rune('1'+i))}
+				}
+			}
+			defs["l"+string(rune('0'+i))] = map[string]any{"type": "object", "properties": props}
+		}
+		raw, _ := json.Marshal(map[string]any{"$ref": "#/$defs/l0", "$defs": defs})
+		if _, err := sanitizeSchema(raw); err == nil {
+			t.Fatal("expected budget error")
+		}
+		if got := normalizeMCPJSONSchema(raw); string(got) != string(raw) {
+			t.Fatal("over-budget schema should pass through unchanged")
+		}
+	})
+}
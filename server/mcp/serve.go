@@ -0,0 +1,238 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	pkgtools "chase-code/server/tools"
+)
+
+// TaskToolName 为 MCP server 模式下执行完整 agent turn 的高层工具名。
+const TaskToolName = "chase_code_task"
+
+// ApprovalPolicy 决定 MCP server 模式下需要人工确认的操作如何处理。
+type ApprovalPolicy string
+
+const (
+	// ApprovalElicit 通过 MCP elicitation 请客户端用户确认；客户端不支持时按拒绝处理。
+	ApprovalElicit ApprovalPolicy = "elicit"
+	// ApprovalReject 一律拒绝需要确认的操作。
+	ApprovalReject ApprovalPolicy = "reject"
+)
+
+// ParseApprovalPolicy 解析 --approval 参数，空字符串视为 elicit。
+func ParseApprovalPolicy(s string) (ApprovalPolicy, error) {
+	switch ApprovalPolicy(strings.ToLower(strings.TrimSpace(s))) {
+	case "", ApprovalElicit:
+		return ApprovalElicit, nil
+	case ApprovalReject:
+		return ApprovalReject, nil
+	}
+	return "", fmt.Errorf("未知的审批策略: %s（可选 elicit|reject）", s)
+}
+
+// ApprovalFunc 请求确认一次敏感操作，返回是否批准以及拒绝原因。
+type ApprovalFunc func(ctx context.Context, message string) (bool, string)
+
+// TaskRunner 执行一次完整的 agent turn 并返回最终回答，审批请求交给 approve 决定。
+// 由 CLI 注入，避免 mcp 包依赖 server 包的 Session。
+type TaskRunner func(ctx context.Context, prompt string, approve ApprovalFunc) (string, error)
+
+// ServeOptions 描述以 MCP server 身份对外暴露本地工具时的配置。
+type ServeOptions struct {
+	Name    string
+	Version string
+	// Router 执行 shell_command 与 apply_patch。
+	Router *pkgtools.ToolRouter
+	// Approval 为需要确认的操作（如删除文件的补丁）的处理策略。
+	Approval ApprovalPolicy
+	// RunTask 非空时额外暴露 chase_code_task 工具。
+	RunTask TaskRunner
+}
+
+// toolServer 持有 MCP server 及其工具处理所需的状态。
+type toolServer struct {
+	srv  *mcpserver.MCPServer
+	opts ServeOptions
+}
+
+// NewToolServer 构建暴露 chase-code 本地工具的 MCP server。
+func NewToolServer(opts ServeOptions) *mcpserver.MCPServer {
+	if opts.Name == "" {
+		opts.Name = "chase-code"
+	}
+	if opts.Version == "" {
+		opts.Version = "0.1.0"
+	}
+	if opts.Approval == "" {
+		opts.Approval = ApprovalElicit
+	}
+	ts := &toolServer{opts: opts}
+	ts.srv = mcpserver.NewMCPServer(opts.Name, opts.Version,
+		mcpserver.WithToolCapabilities(false),
+		mcpserver.WithElicitation(),
+	)
+
+	shell := pkgtools.ShellCommandToolSpec()
+	ts.srv.AddTool(mcp.NewToolWithRawSchema(shell.Name, shell.Description, shell.Parameters), ts.handleShell)
+
+	patch := pkgtools.ApplyPatchToolSpecFunction()
+	ts.srv.AddTool(mcp.NewToolWithRawSchema(patch.Name, patch.Description, patch.Parameters), ts.handleApplyPatch)
+
+	if opts.RunTask != nil {
+		ts.srv.AddTool(mcp.NewTool(TaskToolName,
+			mcp.WithDescription("Run a full chase-code agent turn for the given task and return the final answer."),
+			mcp.WithString("prompt", mcp.Required(), mcp.Description("The task for the agent to work on")),
+		), ts.handleTask)
+	}
+	return ts.srv
+}
+
+// ServeStdio 通过 stdin/stdout 提供 MCP 服务，直到输入流关闭。
+func ServeStdio(opts ServeOptions) error {
+	return mcpserver.ServeStdio(NewToolServer(opts))
+}
+
+// handleShell 在本地沙箱策略下执行 shell_command。
+func (ts *toolServer) handleShell(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	return ts.execute(ctx, pkgtools.ShellCommandToolSpec().Name, req)
+}
+
+// handleApplyPatch 先做补丁安全评估，需要确认时按审批策略处理，再应用补丁。
+func (ts *toolServer) handleApplyPatch(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args, err := rawArguments(req)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	parsed, err := pkgtools.ParseApplyPatchArguments(args)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("解析 apply_patch 参数失败: %v", err)), nil
+	}
+
+	decision := pkgtools.EvaluatePatchSafety(parsed.Summary)
+	switch decision.Level {
+	case pkgtools.PatchReject:
+		return mcp.NewToolResultError("补丁被安全策略拒绝: " + decision.Reason), nil
+	case pkgtools.PatchAskUser:
+		msg := fmt.Sprintf("%s\n涉及文件: %s", decision.Reason, strings.Join(decision.Paths, ", "))
+		if ok, reason := ts.approve(ctx, msg); !ok {
+			return mcp.NewToolResultError("补丁未获批准: " + reason), nil
+		}
+	}
+	return ts.execute(ctx, "apply_patch", req)
+}
+
+// handleTask 运行一次完整的 agent turn，审批请求同样按审批策略处理。
+func (ts *toolServer) handleTask(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	prompt := strings.TrimSpace(req.GetString("prompt", ""))
+	if prompt == "" {
+		return mcp.NewToolResultError(TaskToolName + " 需要非空 prompt 字段"), nil
+	}
+	// 审批请求需在调用方所在的 MCP session 上发起，因此固定使用工具调用的 ctx。
+	approve := func(_ context.Context, message string) (bool, string) {
+		return ts.approve(ctx, message)
+	}
+	answer, err := ts.opts.RunTask(ctx, prompt, approve)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("任务执行失败: %v", err)), nil
+	}
+	return mcp.NewToolResultText(answer), nil
+}
+
+// execute 通过 ToolRouter 执行本地工具，并把错误转换为 isError 结果。
+func (ts *toolServer) execute(ctx context.Context, name string, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args, err := rawArguments(req)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	res, err := ts.opts.Router.Execute(ctx, pkgtools.ToolCall{Kind: pkgtools.ToolKindFunction, ToolName: name, Arguments: args})
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	return mcp.NewToolResultText(res.Output), nil
+}
+
+// approve 按审批策略决定一次需要确认的操作：reject 直接拒绝，elicit 向客户端发起 elicitation。
+func (ts *toolServer) approve(ctx context.Context, message string) (bool, string) {
+	if ts.opts.Approval == ApprovalReject {
+		return false, "当前审批策略为 reject"
+	}
+	if !clientSupportsElicitation(ctx) {
+		return false, "客户端不支持 elicitation，按策略拒绝"
+	}
+
+	res, err := ts.srv.RequestElicitation(ctx, mcp.ElicitationRequest{
+		Params: mcp.ElicitationParams{
+			Message: "chase-code 请求确认:\n" + message,
+			RequestedSchema: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"approve": map[string]any{
+						"type":        "boolean",
+						"description": "是否批准该操作",
+					},
+				},
+				"required": []string{"approve"},
+			},
+		},
+	})
+	if err != nil {
+		if errors.Is(err, mcpserver.ErrElicitationNotSupported) {
+			return false, "客户端不支持 elicitation，按策略拒绝"
+		}
+		log.Printf("[mcp-server] elicitation failed: %v", err)
+		return false, fmt.Sprintf("请求确认失败: %v", err)
+	}
+	if res.Action != mcp.ElicitationResponseActionAccept {
+		return false, fmt.Sprintf("用户未批准（%s）", res.Action)
+	}
+	// 只有显式 approve=true 才算批准：缺少字段、类型不符或无法解析都按拒绝处理。
+	if !elicitationApproved(res.Content) {
+		return false, "用户拒绝"
+	}
+	return true, ""
+}
+
+// elicitationApproved 判断 elicitation 回复内容中的 approve 是否为 true。
+func elicitationApproved(content any) bool {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return false
+	}
+	var body struct {
+		Approve bool `json:"approve"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return false
+	}
+	return body.Approve
+}
+
+// clientSupportsElicitation 判断当前调用方在初始化时是否声明了 elicitation 能力。
+func clientSupportsElicitation(ctx context.Context) bool {
+	session, ok := mcpserver.ClientSessionFromContext(ctx).(mcpserver.SessionWithClientInfo)
+	if !ok {
+		return false
+	}
+	return session.GetClientCapabilities().Elicitation != nil
+}
+
+// rawArguments 将工具调用参数还原为 JSON，交给 ToolRouter 解析。
+func rawArguments(req mcp.CallToolRequest) (json.RawMessage, error) {
+	args := req.GetRawArguments()
+	if args == nil {
+		return json.RawMessage("{}"), nil
+	}
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("序列化工具参数失败: %w", err)
+	}
+	return data, nil
+}
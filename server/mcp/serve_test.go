@@ -0,0 +1,174 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	mcpclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+	mcpm "github.com/mark3labs/mcp-go/mcp"
+
+	pkgtools "chase-code/server/tools"
+)
+
+// stubElicitor 按预设动作回应 elicitation 请求，并记录收到的提示。
+type stubElicitor struct {
+	action   mcpm.ElicitationResponseAction
+	messages []string
+}
+
+func (e *stubElicitor) Elicit(ctx context.Context, req mcpm.ElicitationRequest) (*mcpm.ElicitationResult, error) {
+	e.messages = append(e.messages, req.Params.Message)
+	return &mcpm.ElicitationResult{ElicitationResponse: mcpm.ElicitationResponse{
+		Action:  e.action,
+		Content: map[string]any{"approve": e.action == mcpm.ElicitationResponseActionAccept},
+	}}, nil
+}
+
+// newToolServerClient 启动 chase-code MCP server 并以进程内客户端连接；elicitor 非空时声明 elicitation 能力。
+func newToolServerClient(t *testing.T, opts ServeOptions, elicitor *stubElicitor) *mcpclient.Client {
+	t.Helper()
+	srv := NewToolServer(opts)
+	var client *mcpclient.Client
+	if elicitor != nil {
+		tr := transport.NewInProcessTransportWithOptions(srv, transport.WithElicitationHandler(elicitor))
+		client = mcpclient.NewClient(tr, mcpclient.WithElicitationHandler(elicitor))
+	} else {
+		var err error
+		if client, err = mcpclient.NewInProcessClient(srv); err != nil {
+			t.Fatalf("NewInProcessClient error: %v", err)
+		}
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	if err := initMCPClient(context.Background(), "chase-code", client, 0); err != nil {
+		t.Fatalf("init client: %v", err)
+	}
+	return client
+}
+
+// callTool 调用工具并返回文本结果与 isError 标记。
+func callTool(t *testing.T, client *mcpclient.Client, name string, args map[string]any) (string, bool) {
+	t.Helper()
+	req := mcpm.CallToolRequest{}
+	req.Params.Name = name
+	req.Params.Arguments = args
+	res, err := client.CallTool(context.Background(), req)
+	if err != nil {
+		t.Fatalf("CallTool %s error: %v", name, err)
+	}
+	var parts []string
+	for _, c := range res.Content {
+		if text, ok := c.(mcpm.TextContent); ok {
+			parts = append(parts, text.Text)
+		}
+	}
+	return strings.Join(parts, "\n"), res.IsError
+}
+
+// TestToolServer_ShellAndPatchApproval 验证 shell_command 执行、删除文件的补丁按审批策略处理。
+func TestToolServer_ShellAndPatchApproval(t *testing.T) {
+	dir := t.TempDir()
+	t.Chdir(dir)
+	router := pkgtools.NewToolRouter(pkgtools.DefaultToolSpecs())
+
+	client := newToolServerClient(t, ServeOptions{Router: router}, nil)
+	tools, err := client.ListTools(context.Background(), mcpm.ListToolsRequest{})
+	if err != nil || len(tools.Tools) != 2 {
+		t.Fatalf("ListTools tools=%+v err=%v", tools, err)
+	}
+	out, isErr := callTool(t, client, "shell_command", map[string]any{"command": "echo hello-mcp"})
+	if isErr || !strings.Contains(out, "hello-mcp") {
+		t.Fatalf("shell_command out=%q isError=%v", out, isErr)
+	}
+
+	target := filepath.Join(dir, "victim.txt")
+	if err := os.WriteFile(target, []byte("x\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deletePatch := map[string]any{"input": "*** Begin Patch\n*** Delete File: victim.txt\n*** End Patch\n"}
+
+	// 客户端未声明 elicitation：按策略拒绝。
+	out, isErr = callTool(t, client, "apply_patch", deletePatch)
+	if !isErr || !strings.Contains(out, "elicitation") {
+		t.Fatalf("expected rejection without elicitation, out=%q isError=%v", out, isErr)
+	}
+
+	// reject 策略下即使客户端支持 elicitation 也不会询问。
+	elicitor := &stubElicitor{action: mcpm.ElicitationResponseActionAccept}
+	rejecting := newToolServerClient(t, ServeOptions{Router: router, Approval: ApprovalReject}, elicitor)
+	if _, isErr = callTool(t, rejecting, "apply_patch", deletePatch); !isErr || len(elicitor.messages) != 0 {
+		t.Fatalf("expected reject policy without elicitation, isError=%v messages=%v", isErr, elicitor.messages)
+	}
+
+	declining := newToolServerClient(t, ServeOptions{Router: router}, &stubElicitor{action: mcpm.ElicitationResponseActionDecline})
+	if _, isErr = callTool(t, declining, "apply_patch", deletePatch); !isErr {
+		t.Fatal("expected declined patch to fail")
+	}
+	if _, err := os.Stat(target); err != nil {
+		t.Fatalf("file should still exist: %v", err)
+	}
+
+	approving := newToolServerClient(t, ServeOptions{Router: router}, elicitor)
+	if out, isErr = callTool(t, approving, "apply_patch", deletePatch); isErr {
+		t.Fatalf("approved patch failed: %s", out)
+	}
+	if len(elicitor.messages) != 1 || !strings.Contains(elicitor.messages[0], "victim.txt") {
+		t.Fatalf("unexpected elicitation messages: %v", elicitor.messages)
+	}
+	if _, err := os.Stat(target); !os.IsNotExist(err) {
+		t.Fatalf("file should be deleted, stat err=%v", err)
+	}
+}
+
+// TestToolServer_Task 验证 chase_code_task 返回最终回答，且审批回调按客户端能力决定。
+func TestToolServer_Task(t *testing.T) {
+	var approvals []bool
+	runner := func(ctx context.Context, prompt string, approve ApprovalFunc) (string, error) {
+		ok, _ := approve(ctx, "删除 a.txt")
+		approvals = append(approvals, ok)
+		return "done: " + prompt, nil
+	}
+	opts := ServeOptions{Router: pkgtools.NewToolRouter(pkgtools.DefaultToolSpecs()), RunTask: runner}
+
+	plain := newToolServerClient(t, opts, nil)
+	out, isErr := callTool(t, plain, TaskToolName, map[string]any{"prompt": "清理"})
+	if isErr || out != "done: 清理" {
+		t.Fatalf("task out=%q isError=%v", out, isErr)
+	}
+	if _, isErr = callTool(t, plain, TaskToolName, map[string]any{}); !isErr {
+		t.Fatal("expected error for empty prompt")
+	}
+
+	elicitor := &stubElicitor{action: mcpm.ElicitationResponseActionAccept}
+	eliciting := newToolServerClient(t, opts, elicitor)
+	callTool(t, eliciting, TaskToolName, map[string]any{"prompt": "清理"})
+
+	if len(approvals) != 2 || approvals[0] || !approvals[1] {
+		t.Fatalf("unexpected approvals: %v", approvals)
+	}
+}
+
+// TestElicitationApproved 验证只有显式 approve=true 才视为批准。
+func TestElicitationApproved(t *testing.T) {
+	cases := []struct {
+		name    string
+		content any
+		want    bool
+	}{
+		{name: "approve true", content: map[string]any{"approve": true}, want: true},
+		{name: "approve false", content: map[string]any{"approve": false}},
+		{name: "missing field", content: map[string]any{}},
+		{name: "nil content", content: nil},
+		{name: "string instead of bool", content: map[string]any{"approve": "true"}},
+		{name: "raw json", content: json.RawMessage(`{"approve":true}`), want: true},
+	}
+	for _, tc := range cases {
+		if got := elicitationApproved(tc.content); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,385 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	gosdkclient "github.com/mark3labs/mcp-go/client"
+	"github.com/mark3labs/mcp-go/client/transport"
+
+	"chase-code/config"
+	"chase-code/server/tools"
+)
+
+const (
+	// stdioMaxRestarts 为 stdio server 连续崩溃后允许自动重启的次数，成功调用后清零。
+	stdioMaxRestarts = 3
+	// stdioKillTimeout 为关闭 stdin 后等待子进程自行退出的时间，超时后强制 kill。
+	stdioKillTimeout = 3 * time.Second
+)
+
+// errRestartLimit 表示 stdio server 连续崩溃次数已达上限，ManagedClient 据此标记为 ServerFailed 并停止重连。
+var errRestartLimit = errors.New("已停止自动重启")
+
+// stdioRestartBudget 记录同一个 stdio server 的连续重启次数。ManagedClient 重连时创建的新 StdioMCPClient
+// 共享同一份预算，保证无论是进程内重启还是重建客户端，连续崩溃都只能重启 stdioMaxRestarts 次。
+type stdioRestartBudget struct {
+	mu sync.Mutex
+	// started 表示子进程曾成功启动，之后的每次启动都计入 restarts。
+	started  bool
+	restarts int
+}
+
+// acquire 在启动子进程前调用，首次启动不计数；达到上限时返回 errRestartLimit。
+func (b *stdioRestartBudget) acquire(name string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.started {
+		return nil
+	}
+	// 达到上限后保持失败状态，直到有一次调用成功（restarts 清零）前不再拉起新进程。
+	if b.restarts >= stdioMaxRestarts {
+		return fmt.Errorf("MCP server %q 连续崩溃 %d 次，%w", name, b.restarts, errRestartLimit)
+	}
+	b.restarts++
+	log.Printf("[mcp] server=%s process exited, restarting (%d/%d)", name, b.restarts, stdioMaxRestarts)
+	return nil
+}
+
+// spawned 记录子进程已成功启动。
+func (b *stdioRestartBudget) spawned() {
+	b.mu.Lock()
+	b.started = true
+	b.mu.Unlock()
+}
+
+// reset 在一次调用成功后清零连续重启次数。
+func (b *stdioRestartBudget) reset() {
+	b.mu.Lock()
+	b.restarts = 0
+	b.mu.Unlock()
+}
+
+// StdioMCPClient 通过子进程 stdin/stdout 与 MCP server 通信，负责进程的完整生命周期：
+//   - 子进程 stderr 逐行写入日志；
+//   - 子进程意外退出时，进行中的调用立即失败，下一次调用自动重启；
+//   - Close 时先关闭 stdin，超时仍未退出则强制 kill。
+type StdioMCPClient struct {
+	name    string
+	command string
+	args    []string
+	env     []string
+	cwd     string
+	timeout time.Duration
+
+	mu     sync.Mutex
+	proc   *stdioProcess
+	budget *stdioRestartBudget
+	closed bool
+	// onToolsChanged 在每次（重新）启动子进程后注册到新的会话上。
+	onToolsChanged func()
+}
+
+// stdioProcess 为一次启动的子进程及其 MCP 会话。
+type stdioProcess struct {
+	client *gosdkclient.Client
+	cmd    *exec.Cmd
+	// exited 在子进程 stderr 关闭（即进程退出）时关闭。
+	exited chan struct{}
+}
+
+// NewStdioMCPClient 根据配置创建 stdio MCP 客户端并立即启动子进程完成初始化。
+func NewStdioMCPClient(name string, cfg MCPRemoteServerConfig) (*StdioMCPClient, error) {
+	return newStdioMCPClient(name, cfg, &stdioRestartBudget{})
+}
+
+// newStdioMCPClient 创建使用指定重启预算的 stdio 客户端。
+func newStdioMCPClient(name string, cfg MCPRemoteServerConfig, budget *stdioRestartBudget) (*StdioMCPClient, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("MCP server %q 缺少 command 字段", name)
+	}
+	c := &StdioMCPClient{
+		name:    name,
+		command: cfg.Command,
+		args:    cfg.Args,
+		env:     envList(cfg.Env, !cfg.Untrusted),
+		cwd:     cfg.Cwd,
+		timeout: resolveTimeout(cfg.Timeout),
+		budget:  budget,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	if _, err := c.process(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// envList 将 env 配置转换为 KEY=VALUE 列表，按键排序保证启动参数稳定。
+// expand 为 true 时展开值中的 ${VAR}（与配置文件相同，不处理 $VAR）。
+func envList(env map[string]string, expand bool) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := env[k]
+		if expand {
+			v = config.ExpandEnvRefs(v)
+		}
+		out = append(out, k+"="+v)
+	}
+	return out
+}
+
+// process 返回当前存活的子进程，必要时（首次调用或崩溃后）启动新进程。
+func (c *StdioMCPClient) process(ctx context.Context) (*stdioProcess, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, fmt.Errorf("MCP server %q 已关闭", c.name)
+	}
+	if c.proc != nil {
+		select {
+		case <-c.proc.exited:
+			c.proc.client.Close()
+			c.proc = nil
+		default:
+			return c.proc, nil
+		}
+	}
+	if err := c.budget.acquire(c.name); err != nil {
+		return nil, err
+	}
+
+	proc, err := c.spawn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.proc = proc
+	c.budget.spawned()
+	return proc, nil
+}
+
+// spawn 启动子进程并完成 MCP initialize 握手。
+func (c *StdioMCPClient) spawn(ctx context.Context) (*stdioProcess, error) {
+	proc := &stdioProcess{exited: make(chan struct{})}
+	cmdFunc := func(_ context.Context, command string, env []string, args []string) (*exec.Cmd, error) {
+		// 不使用 CommandContext：子进程生命周期由 Close 管理，而不是跟随某次调用的 ctx。
+		cmd := exec.Command(command, args...)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Dir = c.cwd
+		proc.cmd = cmd
+		return cmd, nil
+	}
+
+	t := transport.NewStdioWithOptions(c.command, c.env, c.args, transport.WithCommandFunc(cmdFunc))
+	if err := t.Start(context.Background()); err != nil {
+		return nil, fmt.Errorf("启动 MCP server %q 失败: %w", c.name, err)
+	}
+	go c.pipeStderr(t.Stderr(), proc.exited)
+
+	proc.client = gosdkclient.NewClient(t)
+	if c.onToolsChanged != nil {
+		(&GoSDKMCPClient{inner: proc.client}).OnToolsChanged(c.onToolsChanged)
+	}
+	if err := initMCPClient(ctx, c.name, proc.client, c.timeout); err != nil {
+		c.terminate(proc)
+		return nil, err
+	}
+	log.Printf("[mcp] server=%s started pid=%d command=%s", c.name, proc.cmd.Process.Pid, c.command)
+	return proc, nil
+}
+
+// pipeStderr 将子进程 stderr 逐行写入日志，读到 EOF 说明进程已退出。
+func (c *StdioMCPClient) pipeStderr(r io.Reader, exited chan struct{}) {
+	defer close(exited)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		log.Printf("[mcp] server=%s stderr: %s", c.name, scanner.Text())
+	}
+}
+
+// withProcess 在存活的子进程上执行 fn；子进程中途退出时立即取消调用而不是等到超时。
+// fn 成功时清零连续重启次数。
+func (c *StdioMCPClient) withProcess(ctx context.Context, fn func(ctx context.Context, inner *GoSDKMCPClient) error) error {
+	return c.runOnProcess(ctx, true, fn)
+}
+
+// runOnProcess 为 withProcess 的实现；resetBudget 为 false 时成功也不清零重启次数。
+func (c *StdioMCPClient) runOnProcess(ctx context.Context, resetBudget bool, fn func(ctx context.Context, inner *GoSDKMCPClient) error) error {
+	proc, err := c.process(ctx)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-proc.exited:
+			cancel()
+		case <-callCtx.Done():
+		}
+	}()
+
+	err = fn(callCtx, &GoSDKMCPClient{inner: proc.client})
+	select {
+	case <-proc.exited:
+		if ctx.Err() == nil {
+			return fmt.Errorf("MCP server %q 进程已退出: %w", c.name, err)
+		}
+	default:
+	}
+	if err == nil && resetBudget {
+		c.budget.reset()
+	}
+	return err
+}
+
+// ListTools 列出 stdio server 提供的工具。
+func (c *StdioMCPClient) ListTools(ctx context.Context) ([]MCPTool, error) {
+	var tools []MCPTool
+	err := c.withProcess(ctx, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		var err error
+		tools, err = inner.ListTools(ctx)
+		return err
+	})
+	return tools, err
+}
+
+// Ping 向 stdio server 发送 ping；子进程已崩溃时会先尝试重启。
+// 刚重启的进程能响应 ping 不代表已恢复正常，因此 ping 成功不清零重启次数，
+// 否则 ManagedClient 的健康检查会让每次调用即崩溃的 server 无限重启。
+func (c *StdioMCPClient) Ping(ctx context.Context) error {
+	return c.runOnProcess(ctx, false, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		return inner.Ping(ctx)
+	})
+}
+
+// OnToolsChanged 在 server 发出 notifications/tools/list_changed 时调用 fn，重启后的进程同样生效。
+func (c *StdioMCPClient) OnToolsChanged(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onToolsChanged = fn
+	if c.proc != nil {
+		(&GoSDKMCPClient{inner: c.proc.client}).OnToolsChanged(fn)
+	}
+}
+
+// ListResources 返回 stdio server 的资源列表。
+func (c *StdioMCPClient) ListResources(ctx context.Context) ([]MCPResource, error) {
+	var out []MCPResource
+	err := c.withProcess(ctx, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		var err error
+		out, err = inner.ListResources(ctx)
+		return err
+	})
+	return out, err
+}
+
+// ReadResource 读取 stdio server 上的资源。
+func (c *StdioMCPClient) ReadResource(ctx context.Context, uri string) (string, error) {
+	var out string
+	err := c.withProcess(ctx, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		var err error
+		out, err = inner.ReadResource(ctx, uri)
+		return err
+	})
+	return out, err
+}
+
+// ListPrompts 返回 stdio server 的 prompt 列表。
+func (c *StdioMCPClient) ListPrompts(ctx context.Context) ([]MCPPrompt, error) {
+	var out []MCPPrompt
+	err := c.withProcess(ctx, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		var err error
+		out, err = inner.ListPrompts(ctx)
+		return err
+	})
+	return out, err
+}
+
+// GetPrompt 获取 stdio server 上的 prompt。
+func (c *StdioMCPClient) GetPrompt(ctx context.Context, name string, arguments map[string]string) ([]MCPPromptMessage, error) {
+	var out []MCPPromptMessage
+	err := c.withProcess(ctx, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		var err error
+		out, err = inner.GetPrompt(ctx, name, arguments)
+		return err
+	})
+	return out, err
+}
+
+// CallTool 调用 stdio server 上的工具，返回纯文本输出。
+func (c *StdioMCPClient) CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error) {
+	res, err := c.CallToolResult(ctx, name, arguments)
+	return res.Output, err
+}
+
+// CallToolResult 调用 stdio server 上的工具，返回包含图片与 structuredContent 的结果。
+func (c *StdioMCPClient) CallToolResult(ctx context.Context, name string, arguments json.RawMessage) (tools.ToolResult, error) {
+	var out tools.ToolResult
+	err := c.withProcess(ctx, func(ctx context.Context, inner *GoSDKMCPClient) error {
+		var err error
+		out, err = inner.CallToolResult(ctx, name, arguments)
+		return err
+	})
+	return out, err
+}
+
+// Close 关闭子进程，之后的调用都会返回错误。
+func (c *StdioMCPClient) Close() error {
+	c.mu.Lock()
+	proc := c.proc
+	c.proc = nil
+	c.closed = true
+	c.mu.Unlock()
+	if proc != nil {
+		c.terminate(proc)
+	}
+	return nil
+}
+
+// terminate 先关闭 stdin 让子进程自行退出，超时后强制 kill。
+func (c *StdioMCPClient) terminate(proc *stdioProcess) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		proc.client.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(stdioKillTimeout):
+		if proc.cmd != nil && proc.cmd.Process != nil {
+			log.Printf("[mcp] server=%s did not exit in %s, killing pid=%d", c.name, stdioKillTimeout, proc.cmd.Process.Pid)
+			_ = proc.cmd.Process.Kill()
+		}
+		<-done
+	}
+}
+
+// CloseClients 关闭一组客户端中持有外部资源（子进程、连接）的客户端。
+func CloseClients(clients []MCPClient) {
+	for _, c := range clients {
+		if closer, ok := c.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Printf("[mcp] close client failed: %v", err)
+			}
+		}
+	}
+}
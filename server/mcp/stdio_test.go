@@ -0,0 +1,360 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mcpm "github.com/mark3labs/mcp-go/mcp"
+	mcpserver "github.com/mark3labs/mcp-go/server"
+
+	tools2 "chase-code/server/tools"
+)
+
+// stdioHelperEnv 为真时测试二进制作为 stdio MCP server 运行，供 stdio 客户端测试拉起。
+const stdioHelperEnv = "CHASE_CODE_MCP_STDIO_HELPER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(stdioHelperEnv) == "1" {
+		runStdioHelperServer()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runStdioHelperServer 提供 echo、crash 与 grow 三个工具：crash 会让进程直接退出，用于验证自动重启；
+// grow 会新增一个工具并发出 tools/list_changed 通知。
+func runStdioHelperServer() {
+	srv := mcpserver.NewMCPServer("stdio-helper", "0.0.1", mcpserver.WithToolCapabilities(true))
+	srv.AddTool(mcpm.NewTool("echo", mcpm.WithString("text")), func(ctx context.Context, req mcpm.CallToolRequest) (*mcpm.CallToolResult, error) {
+		cwd, _ := os.Getwd()
+		text := fmt.Sprintf("%v|%s|%s", req.GetArguments()["text"], os.Getenv("HELPER_GREETING"), cwd)
+		return mcpm.NewToolResultText(text), nil
+	})
+	srv.AddTool(mcpm.NewTool("crash"), func(ctx context.Context, req mcpm.CallToolRequest) (*mcpm.CallToolResult, error) {
+		fmt.Fprintln(os.Stderr, "helper crashing on purpose")
+		os.Exit(3)
+		return nil, nil
+	})
+	srv.AddTool(mcpm.NewTool("grow"), func(ctx context.Context, req mcpm.CallToolRequest) (*mcpm.CallToolResult, error) {
+		srv.AddTool(mcpm.NewTool("extra"), func(ctx context.Context, req mcpm.CallToolRequest) (*mcpm.CallToolResult, error) {
+			return mcpm.NewToolResultText("extra"), nil
+		})
+		return mcpm.NewToolResultText("grown"), nil
+	})
+	fmt.Fprintln(os.Stderr, "helper ready")
+	if err := mcpserver.ServeStdio(srv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// syncBuffer 为并发安全的日志缓冲区。
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestStdioMCPClient_Lifecycle 验证 stdio server 的启动、env/cwd 传递、stderr 日志、崩溃后重启与关闭。
+func TestStdioMCPClient_Lifecycle(t *testing.T) {
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	dir := t.TempDir()
+	cfg := &MCPConfig{MCPServers: map[string]MCPRemoteServerConfig{
+		"helper": {
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^$"},
+			Env:     map[string]string{stdioHelperEnv: "1", "HELPER_GREETING": "hi"},
+			Cwd:     dir,
+			Timeout: 10,
+		},
+	}}
+	clients := NewMCPClientsFromConfig(context.Background(), cfg)
+	if len(clients) != 1 {
+		t.Fatalf("expect 1 client, got %d", len(clients))
+	}
+	if clients[0].Name != "helper" {
+		t.Fatalf("unexpected server name: %s", clients[0].Name)
+	}
+	client := clients[0].Client
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	tools, err := client.ListTools(ctx)
+	if err != nil {
+		t.Fatalf("ListTools error: %v", err)
+	}
+	if len(tools) != 3 {
+		t.Fatalf("expect 3 tools, got %d", len(tools))
+	}
+
+	out, err := client.CallTool(ctx, "echo", json.RawMessage(`{"text":"ping"}`))
+	if err != nil {
+		t.Fatalf("CallTool(echo) error: %v", err)
+	}
+	if !strings.Contains(out, "ping|hi|") {
+		t.Fatalf("unexpected echo output: %s", out)
+	}
+	if realDir, _ := filepath.EvalSymlinks(dir); !strings.Contains(out, realDir) {
+		t.Fatalf("server should run in cwd %s, got %s", dir, out)
+	}
+
+	if _, err := client.CallTool(ctx, "crash", nil); err == nil {
+		t.Fatalf("crash tool should fail")
+	}
+	out, err = client.CallTool(ctx, "echo", json.RawMessage(`{"text":"again"}`))
+	if err != nil {
+		t.Fatalf("CallTool after crash should restart server, got error: %v", err)
+	}
+	if !strings.Contains(out, "again") {
+		t.Fatalf("unexpected echo output after restart: %s", out)
+	}
+
+	CloseClients(Clients(clients))
+	if _, err := client.CallTool(ctx, "echo", nil); err == nil {
+		t.Fatalf("CallTool after Close should fail")
+	}
+
+	for _, want := range []string{"stderr: helper ready", "stderr: helper crashing on purpose", "restarting (1/3)"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log should contain %q, got:\n%s", want, logs.String())
+		}
+	}
+}
+
+// TestStdioMCPClient_ToolsListChanged 验证 server 发出 tools/list_changed 后路由表被重建。
+func TestStdioMCPClient_ToolsListChanged(t *testing.T) {
+	cfg := &MCPConfig{MCPServers: map[string]MCPRemoteServerConfig{
+		"helper": {
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^$"},
+			Env:     map[string]string{stdioHelperEnv: "1"},
+			Timeout: 10,
+		},
+		"broken": {Type: "stdio"},
+	}}
+	clients := NewMCPClientsFromConfig(context.Background(), cfg)
+	defer CloseClients(Clients(clients))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	router, specs, _, err := NewMCPRouter(ctx, clients, nil)
+	if err != nil {
+		t.Fatalf("NewMCPRouter error: %v", err)
+	}
+	if len(specs) != 3 {
+		t.Fatalf("expect 3 tools before grow, got %d", len(specs))
+	}
+	statuses := router.Statuses()
+	if statuses[0].Name != "broken" || statuses[0].State != ServerFailed || statuses[1].State != ServerReady || statuses[1].Tools != 3 {
+		t.Fatalf("unexpected statuses: %+v", statuses)
+	}
+
+	changed := make(chan []tools2.ToolSpec, 1)
+	router.WatchToolChanges(func(specs []tools2.ToolSpec, warnings []string) {
+		changed <- specs
+	})
+	if _, err := router.CallTool(ctx, NamespacedToolName("helper", "grow"), nil); err != nil {
+		t.Fatalf("CallTool(grow) error: %v", err)
+	}
+	select {
+	case specs := <-changed:
+		if len(specs) != 4 {
+			t.Fatalf("expect 4 tools after grow, got %d", len(specs))
+		}
+	case <-ctx.Done():
+		t.Fatalf("tools list change was not observed")
+	}
+	if _, err := router.CallTool(ctx, NamespacedToolName("helper", "extra"), nil); err != nil {
+		t.Fatalf("new tool should be routable, got error: %v", err)
+	}
+}
+
+// TestRemoteMCPClient_ToolsListChanged 验证 streamable HTTP server 的 tools/list_changed 在连接超时之后仍能送达：
+// 通知流必须与 client 同生命周期，而不是随初始化的超时 ctx 一起结束。
+func TestRemoteMCPClient_ToolsListChanged(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	noop := func(ctx context.Context, req mcpm.CallToolRequest) (*mcpm.CallToolResult, error) {
+		return mcpm.NewToolResultText("ok"), nil
+	}
+	srv := mcpserver.NewMCPServer("live", "0.0.1", mcpserver.WithToolCapabilities(true))
+	srv.AddTool(mcpm.NewTool("first"), noop)
+	httpSrv := httptest.NewServer(mcpserver.NewStreamableHTTPServer(srv))
+	defer httpSrv.Close()
+
+	cfg := &MCPConfig{MCPServers: map[string]MCPRemoteServerConfig{
+		"live": {Type: "streamableHttp", URL: httpSrv.URL + "/mcp", Timeout: 1},
+	}}
+	clients := NewMCPClientsFromConfig(context.Background(), cfg)
+	defer CloseClients(Clients(clients))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	router, specs, _, err := NewMCPRouter(ctx, clients, nil)
+	if err != nil || len(specs) != 1 {
+		t.Fatalf("NewMCPRouter specs=%d err=%v", len(specs), err)
+	}
+	changed := make(chan []tools2.ToolSpec, 1)
+	router.WatchToolChanges(func(specs []tools2.ToolSpec, warnings []string) {
+		select {
+		case changed <- specs:
+		default:
+		}
+	})
+
+	// 超过连接超时（1 秒）后再变更工具列表。
+	time.Sleep(1500 * time.Millisecond)
+	srv.AddTool(mcpm.NewTool("second"), noop)
+	select {
+	case specs := <-changed:
+		if len(specs) != 2 {
+			t.Fatalf("expect 2 tools after change, got %d", len(specs))
+		}
+	case <-ctx.Done():
+		t.Fatal("tools/list_changed was not delivered")
+	}
+}
+
+// TestStdioMCPClient_RestartLimit 验证连续崩溃达到上限后保持失败状态，不再拉起新进程。
+func TestStdioMCPClient_RestartLimit(t *testing.T) {
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	client, err := NewStdioMCPClient("helper", MCPRemoteServerConfig{
+		Command: os.Args[0],
+		Args:    []string{"-test.run=^$"},
+		Env:     map[string]string{stdioHelperEnv: "1"},
+		Timeout: 10,
+	})
+	if err != nil {
+		t.Fatalf("NewStdioMCPClient error: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for i := 0; i <= stdioMaxRestarts; i++ {
+		if _, err := client.CallTool(ctx, "crash", nil); err == nil {
+			t.Fatalf("crash #%d should fail", i)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		_, err := client.CallTool(ctx, "echo", nil)
+		if err == nil || !strings.Contains(err.Error(), "已停止自动重启") {
+			t.Fatalf("call after restart limit should fail without respawn, got %v", err)
+		}
+	}
+	if got := strings.Count(logs.String(), " started pid="); got != stdioMaxRestarts+1 {
+		t.Fatalf("expect %d spawns, got %d:\n%s", stdioMaxRestarts+1, got, logs.String())
+	}
+}
+
+// TestManagedStdioClient_RestartLimit 验证经 ManagedClient 使用时重启上限同样生效：
+// 健康检查与重连不会绕过上限，达到上限后 server 标记为 failed，后续调用不再拉起新进程。
+func TestManagedStdioClient_RestartLimit(t *testing.T) {
+	logs := &syncBuffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := &MCPConfig{MCPServers: map[string]MCPRemoteServerConfig{
+		"helper": {
+			Command: os.Args[0],
+			Args:    []string{"-test.run=^$"},
+			Env:     map[string]string{stdioHelperEnv: "1"},
+			Timeout: 10,
+		},
+	}}
+	clients := NewMCPClientsFromConfig(context.Background(), cfg)
+	defer CloseClients(Clients(clients))
+	if len(clients) != 1 {
+		t.Fatalf("expect 1 client, got %d", len(clients))
+	}
+	client, ok := clients[0].Client.(*ManagedClient)
+	if !ok {
+		t.Fatalf("expect *ManagedClient, got %T", clients[0].Client)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for client.Status().State != ServerFailed {
+		if ctx.Err() != nil {
+			t.Fatalf("server should be marked failed, status=%+v\n%s", client.Status(), logs.String())
+		}
+		if _, err := client.CallTool(ctx, "crash", nil); err == nil {
+			t.Fatalf("crash tool should fail")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.CallTool(ctx, "echo", nil); err == nil {
+			t.Fatalf("call after restart limit should fail")
+		}
+		client.poke()
+		time.Sleep(50 * time.Millisecond)
+	}
+	if got := strings.Count(logs.String(), " started pid="); got != stdioMaxRestarts+1 {
+		t.Fatalf("expect %d spawns, got %d:\n%s", stdioMaxRestarts+1, got, logs.String())
+	}
+	if status := client.Status(); status.State != ServerFailed || !strings.Contains(status.LastError, "已停止自动重启") {
+		t.Fatalf("unexpected status after restart limit: %+v", status)
+	}
+}
+
+// TestStdioMCPClient_UntrustedEnv 验证不可信来源的 env 不展开 ${VAR}，可信来源正常展开。
+func TestStdioMCPClient_UntrustedEnv(t *testing.T) {
+	t.Setenv("CHASE_TEST_SECRET", "sk-secret")
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	for _, tc := range []struct {
+		untrusted bool
+		want      string
+	}{
+		{untrusted: false, want: "|sk-secret|"},
+		{untrusted: true, want: "|${CHASE_TEST_SECRET}|"},
+	} {
+		client, err := NewStdioMCPClient("helper", MCPRemoteServerConfig{
+			Command:   os.Args[0],
+			Args:      []string{"-test.run=^$"},
+			Env:       map[string]string{stdioHelperEnv: "1", "HELPER_GREETING": "${CHASE_TEST_SECRET}"},
+			Timeout:   10,
+			Untrusted: tc.untrusted,
+		})
+		if err != nil {
+			t.Fatalf("NewStdioMCPClient error: %v", err)
+		}
+		out, err := client.CallTool(ctx, "echo", json.RawMessage(`{"text":"x"}`))
+		client.Close()
+		if err != nil {
+			t.Fatalf("CallTool(echo) error: %v", err)
+		}
+		if !strings.Contains(out, tc.want) {
+			t.Fatalf("untrusted=%v: expect %q in output, got %s", tc.untrusted, tc.want, out)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"chase-code/server/llm"
+	servertools "chase-code/server/tools"
 )
 
 const sessionDirName = "sessions"
@@ -24,24 +25,24 @@ func getSessionDir() (string, error) {
 }
 
 type StoredSession struct {
-	ID        string             `json:"id"`
-	UpdatedAt time.Time          `json:"updated_at"`
-	History   []llm.ResponseItem `json:"history"`
+	ID        string    `json:"id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Title 为模型生成的会话标题，用于 /resume 列表展示。
+	Title   string             `json:"title,omitempty"`
+	History []llm.ResponseItem `json:"history"`
+	// Plan 为模型通过 update_plan 维护的任务清单，恢复会话时一并还原。
+	Plan []servertools.PlanItem `json:"plan,omitempty"`
 }
 
-// Save 保存会话历史。
-func Save(id string, history []llm.ResponseItem) error {
+// Save 保存会话记录（历史、计划与标题），UpdatedAt 由本函数填写。
+func Save(data StoredSession) error {
 	dir, err := getSessionDir()
 	if err != nil {
 		return err
 	}
 
-	path := filepath.Join(dir, id+".json")
-	data := StoredSession{
-		ID:        id,
-		UpdatedAt: time.Now(),
-		History:   history,
-	}
+	path := filepath.Join(dir, data.ID+".json")
+	data.UpdatedAt = time.Now()
 
 	bytes, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
@@ -53,6 +54,15 @@ func Save(id string, history []llm.ResponseItem) error {
 
 // Load 加载会话历史。
 func Load(id string) ([]llm.ResponseItem, error) {
+	sess, err := LoadSession(id)
+	if err != nil {
+		return nil, err
+	}
+	return sess.History, nil
+}
+
+// LoadSession 加载完整的会话记录（历史与计划）。
+func LoadSession(id string) (*StoredSession, error) {
 	dir, err := getSessionDir()
 	if err != nil {
 		return nil, err
@@ -68,7 +78,7 @@ func Load(id string) ([]llm.ResponseItem, error) {
 	if err := json.Unmarshal(data, &sess); err != nil {
 		return nil, err
 	}
-	return sess.History, nil
+	return &sess, nil
 }
 
 // List 列出所有会话 ID。
@@ -91,3 +101,33 @@ func List() ([]string, error) {
 	}
 	return ids, nil
 }
+
+// SessionInfo 为会话列表展示所需的摘要信息。
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListInfo 列出所有会话的摘要信息，顺序与 List 一致；无法解析的文件只返回 ID。
+func ListInfo() ([]SessionInfo, error) {
+	ids, err := List()
+	if err != nil {
+		return nil, err
+	}
+	dir, err := getSessionDir()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]SessionInfo, 0, len(ids))
+	for _, id := range ids {
+		info := SessionInfo{ID: id}
+		if data, err := os.ReadFile(filepath.Join(dir, id+".json")); err == nil {
+			_ = json.Unmarshal(data, &info)
+			info.ID = id
+		}
+		out = append(out, info)
+	}
+	return out, nil
+}
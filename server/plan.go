@@ -0,0 +1,60 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	servertools "chase-code/server/tools"
+)
+
+// Plan 返回当前计划的副本。
+func (s *Session) Plan() []servertools.PlanItem {
+	if s == nil {
+		return nil
+	}
+	s.planMu.Lock()
+	defer s.planMu.Unlock()
+	if len(s.plan) == 0 {
+		return nil
+	}
+	out := make([]servertools.PlanItem, len(s.plan))
+	copy(out, s.plan)
+	return out
+}
+
+// setPlan 替换当前计划并通知上层刷新清单。
+func (s *Session) setPlan(plan []servertools.PlanItem, step int, explanation string) {
+	s.planMu.Lock()
+	s.plan = plan
+	s.planMu.Unlock()
+
+	s.Sink.SendEvent(Event{
+		Kind:    EventPlanUpdated,
+		Time:    time.Now(),
+		Step:    step,
+		Message: explanation,
+		Plan:    s.Plan(),
+	})
+}
+
+// restorePlan 在恢复会话时还原计划，空计划同样会通知上层清空清单。
+func (s *Session) restorePlan(plan []servertools.PlanItem) {
+	s.setPlan(plan, 0, "")
+}
+
+// executeUpdatePlan 处理 update_plan 工具：校验参数、保存计划并返回简短确认。
+func (s *Session) executeUpdatePlan(call servertools.ToolCall, step int) (ResponseItem, error) {
+	args, err := servertools.ParseUpdatePlanArguments(call.Arguments)
+	if err != nil {
+		return ResponseItem{}, err
+	}
+	s.setPlan(args.Plan, step, args.Explanation)
+	log.Printf("[agent] step=%d plan updated items=%d", step, len(args.Plan))
+
+	return ResponseItem{
+		Type:       ResponseItemToolResult,
+		ToolName:   call.ToolName,
+		ToolOutput: fmt.Sprintf("Plan updated (%d steps)", len(args.Plan)),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log"
+
+	"chase-code/server/llm"
+)
+
+// responseChain 记录上一次可链式续接的服务端响应（previous_response_id）。
+// items 为该响应对应的历史条目数：下次调用只需发送 items 之后新增的条目。
+type responseChain struct {
+	client llm.LLMClient
+	id     string
+	items  int
+}
+
+// resetResponseChain 使服务端会话链失效，下一次调用回退到完整回放。
+// 历史被替换（加载会话、重置、压缩）时必须调用。
+func (s *Session) resetResponseChain() {
+	s.chain = responseChain{}
+}
+
+// chainedPrompt 尝试基于上一次响应构造增量 Prompt。
+// 仅当客户端支持链式调用、与上一次是同一个客户端且历史只在末尾追加时返回 true。
+func (s *Session) chainedPrompt(client llm.LLMClient, p Prompt) (Prompt, bool) {
+	chainer, ok := client.(llm.ResponseChainer)
+	if !ok || !chainer.ChainsResponses() {
+		return p, false
+	}
+	chain := s.chain
+	if chain.id == "" || chain.client != client || chain.items >= len(p.Items) {
+		return p, false
+	}
+
+	delta := make([]ResponseItem, len(p.Items)-chain.items)
+	copy(delta, p.Items[chain.items:])
+	p.Items = delta
+	// provider 优先使用 Items，只在 Items 为空时回退到 Messages；这里一并清空 Messages，
+	// 保证增量请求在任何情况下都不会退回完整历史。
+	p.Messages = nil
+	p.PreviousResponseID = chain.id
+	return p, true
+}
+
+// updateResponseChain 在回复写入历史后记录新的链起点；客户端不支持链式调用时清空链。
+func (s *Session) updateResponseChain(client llm.LLMClient, res *llm.LLMResult, historyLen int) {
+	chainer, ok := client.(llm.ResponseChainer)
+	if !ok || !chainer.ChainsResponses() || res == nil || res.ResponseID == "" {
+		s.resetResponseChain()
+		return
+	}
+	s.chain = responseChain{client: client, id: res.ResponseID, items: historyLen}
+}
+
+// recordReasoningItems 将 provider 返回的原始 reasoning 条目（加密思考、thinking 块）写入历史，
+// 保证它们在对应的助手回复之前回放。
+func (s *Session) recordReasoningItems(cm *ContextManager, res *llm.LLMResult) {
+	if res == nil || len(res.ReasoningItems) == 0 {
+		return
+	}
+	for _, raw := range res.ReasoningItems {
+		cm.Record(ResponseItem{
+			Type: llm.ResponseItemReasoning,
+			Raw:  append([]byte(nil), raw...),
+		})
+	}
+	log.Printf("[session] recorded reasoning items=%d", len(res.ReasoningItems))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"chase-code/server/llm"
+)
+
+// SetRoleClient 为指定角色绑定客户端；client 为 nil 时解除绑定，该角色回退到主模型。
+// agent 角色即主模型，直接设置 Session.Client。
+func (s *Session) SetRoleClient(role llm.ModelRole, client llm.LLMClient) {
+	if s == nil {
+		return
+	}
+	if role == llm.ModelRoleAgent {
+		if client != nil {
+			s.Client = client
+		}
+		return
+	}
+
+	s.rolesMu.Lock()
+	defer s.rolesMu.Unlock()
+	if client == nil {
+		delete(s.roleClients, role)
+		return
+	}
+	if s.roleClients == nil {
+		s.roleClients = make(map[llm.ModelRole]llm.LLMClient)
+	}
+	s.roleClients[role] = client
+}
+
+// roleClient 返回角色显式绑定的客户端，未绑定时返回 nil。
+func (s *Session) roleClient(role llm.ModelRole) llm.LLMClient {
+	if role == llm.ModelRoleAgent {
+		return s.Client
+	}
+	s.rolesMu.Lock()
+	defer s.rolesMu.Unlock()
+	return s.roleClients[role]
+}
+
+// completeWithRole 使用角色模型执行一次非流式调用；角色模型未绑定或调用失败时回退到主模型。
+func (s *Session) completeWithRole(ctx context.Context, role llm.ModelRole, p Prompt) (*llm.LLMResult, error) {
+	client := s.roleClient(role)
+	if client == nil || client == s.Client {
+		return s.Client.Complete(ctx, p)
+	}
+
+	res, err := client.Complete(ctx, p)
+	if err == nil || ctx.Err() != nil {
+		return res, err
+	}
+	log.Printf("[session] role=%s model failed, falling back to main model: %v", role, err)
+	return s.Client.Complete(ctx, p)
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	"chase-code/server/config"
@@ -25,6 +26,9 @@ type Session struct {
 	Sink     EventSink
 	MaxSteps int // 单次 turn 允许的最多 LLM+工具循环步数，<=0 时使用默认 10
 
+	// Fallbacks 为 Client 因网络错误或 5xx 不可用时依次尝试的备用模型，仅作用于当前 step。
+	Fallbacks []*llm.LLMModel
+
 	Config config.SessionConfig
 
 	// approvals 用于接收来自 CLI 的补丁审批结果。
@@ -32,12 +36,44 @@ type Session struct {
 
 	// history 记录会话内所有对话与工具轨迹，生命周期跟随 Session。
 	history []ResponseItem
+
+	// plan 为模型通过 update_plan 工具维护的任务清单。
+	planMu sync.Mutex
+	plan   []servertools.PlanItem
+
+	// roleClients 为 compact/title 等角色绑定的模型，未绑定的角色使用 Client。
+	rolesMu     sync.Mutex
+	roleClients map[llm.ModelRole]llm.LLMClient
+
+	// title 为会话标题，由 title 角色模型在首个 turn 时后台生成。
+	titleMu      sync.Mutex
+	title        string
+	titlePending bool
+
+	// alwaysAllowedTools 为用户选择“本会话始终允许”的远程工具。
+	alwaysAllowedTools map[string]bool
+
+	// chain 为 Responses API 的服务端会话链，历史被替换时失效。
+	chain responseChain
+
+	// exhaustedAtStep 记录上一次 turn 因步数耗尽中止时已执行的步数（>0 时可通过 ContinueTurn 续跑）。
+	exhaustedAtStep int
+
+	// pendingInputs 为 turn 运行期间用户追加的输入，由 runTurnStep 在调用 LLM 前注入。
+	// turnActive 标记 turn 是否仍会消费队列，与队列共用 pendingMu。
+	pendingMu     sync.Mutex
+	pendingInputs []string
+	turnActive    bool
+	// pendingSystemPrompt 为工具列表变化后待替换的系统提示词，同样在下一次 LLM 调用前生效。
+	pendingSystemPrompt *string
 }
 
-// ApprovalDecision 表示一次补丁审批请求的结果。
+// ApprovalDecision 表示一次审批请求（补丁、重复调用、远程工具）的结果。
 type ApprovalDecision struct {
 	RequestID string
 	Approved  bool
+	// Always 仅用于远程工具审批：批准后本会话内不再询问该工具。
+	Always bool
 }
 
 // NewSession 创建一个带事件和审批通道的 Session。
@@ -59,13 +95,17 @@ func NewSession(client llm.LLMClient, router *servertools.ToolRouter, sink Event
 
 // LoadHistory 从持久化存储加载历史记录。
 func (s *Session) LoadHistory(id string) error {
-	history, err := persistence.Load(id)
+	stored, err := persistence.LoadSession(id)
 	if err != nil {
 		return err
 	}
-	s.history = history
+	s.history = stored.History
 	s.ID = id // 切换到该会话 ID
-	log.Printf("[session] loaded history for session %s (items=%d)", id, len(history))
+	s.exhaustedAtStep = 0
+	s.resetResponseChain()
+	s.setTitle(stored.Title)
+	s.restorePlan(stored.Plan)
+	log.Printf("[session] loaded history for session %s (items=%d plan=%d)", id, len(stored.History), len(stored.Plan))
 	return nil
 }
 
@@ -89,6 +129,15 @@ func (s *Session) ResetHistoryWithSystemPrompt(systemPrompt string) {
 	}
 
 	s.history = nil
+	s.clearPendingInputs()
+	s.pendingMu.Lock()
+	s.pendingSystemPrompt = nil
+	s.pendingMu.Unlock()
+	s.resetResponseChain()
+	s.setTitle("")
+	s.planMu.Lock()
+	s.plan = nil
+	s.planMu.Unlock()
 	if strings.TrimSpace(systemPrompt) == "" {
 		return
 	}
@@ -99,6 +148,36 @@ func (s *Session) ResetHistoryWithSystemPrompt(systemPrompt string) {
 	})
 }
 
+// UpdateSystemPrompt 在不清空历史的前提下替换系统提示词（如 MCP 工具列表变化后），可在 turn 运行期间调用：
+// 新提示词在下一次 LLM 调用前写入历史，并放弃服务端会话链，避免继续沿用旧的系统提示词。
+func (s *Session) UpdateSystemPrompt(systemPrompt string) {
+	if s == nil || strings.TrimSpace(systemPrompt) == "" {
+		return
+	}
+	s.pendingMu.Lock()
+	s.pendingSystemPrompt = &systemPrompt
+	s.pendingMu.Unlock()
+}
+
+// applyPendingSystemPrompt 将待替换的系统提示词写入 cm 中的第一条 system 消息。
+func (s *Session) applyPendingSystemPrompt(cm *ContextManager) {
+	s.pendingMu.Lock()
+	pending := s.pendingSystemPrompt
+	s.pendingSystemPrompt = nil
+	s.pendingMu.Unlock()
+	if pending == nil {
+		return
+	}
+	for i, it := range cm.items {
+		if it.Type == ResponseItemMessage && it.Role == RoleSystem {
+			cm.items[i].Text = *pending
+			s.resetResponseChain()
+			log.Printf("[session] system prompt updated")
+			return
+		}
+	}
+}
+
 // AppendEnvironmentContext appends a codex-style environment context message.
 func (s *Session) AppendEnvironmentContext(contextText string) {
 	if s == nil {
@@ -115,9 +194,11 @@ func (s *Session) AppendEnvironmentContext(contextText string) {
 }
 
 type turnContext struct {
-	baseCtx  context.Context
-	cm       *ContextManager
-	maxSteps int
+	baseCtx   context.Context
+	cm        *ContextManager
+	firstStep int
+	maxSteps  int
+	loops     *toolLoopDetector
 }
 
 // RunTurn 执行一轮用户指令：
@@ -130,12 +211,54 @@ func (s *Session) RunTurn(ctx context.Context, userInput string) error {
 	}
 
 	turn := s.newTurnContext(ctx, userInput)
+	log.Printf("[agent] new turn input=%q history_len=%d", userInput, len(s.history))
+	s.maybeGenerateTitle(turn.baseCtx, userInput)
+	return s.runTurnLoop(turn)
+}
+
+// CanContinueTurn 判断上一次 turn 是否因步数耗尽而中止，可以续跑。
+func (s *Session) CanContinueTurn() bool {
+	return s != nil && s.exhaustedAtStep > 0
+}
+
+// ContinueTurn 在上一次 turn 步数耗尽后继续执行最多 steps 步，不追加新的用户消息。
+// steps<=0 时使用 MaxSteps。
+func (s *Session) ContinueTurn(ctx context.Context, steps int) error {
+	if s == nil || s.Client == nil || s.Router == nil {
+		return nil
+	}
+	if !s.CanContinueTurn() {
+		return fmt.Errorf("上一次任务并未因步数耗尽而中止，无需继续")
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if steps <= 0 {
+		steps = s.MaxSteps
+	}
+
+	turn := &turnContext{
+		baseCtx:   ctx,
+		cm:        NewContextManager(s.history),
+		firstStep: s.exhaustedAtStep,
+		maxSteps:  resolveMaxSteps(steps),
+		loops:     newToolLoopDetector(s.Config.LoopDetection.Threshold),
+	}
+	log.Printf("[agent] continue turn from step=%d steps=%d history_len=%d", turn.firstStep, turn.maxSteps, len(s.history))
+	return s.runTurnLoop(turn)
+}
+
+// runTurnLoop 驱动 turn 的 LLM+工具循环，直到得到最终回答或步数耗尽。
+func (s *Session) runTurnLoop(turn *turnContext) error {
 	defer s.commitHistory(turn.cm)
+	s.beginTurn()
+	defer s.endTurn()
 
-	log.Printf("[agent] new turn input=%q history_len=%d", userInput, len(s.history))
-	s.Sink.SendEvent(Event{Kind: EventTurnStarted, Time: time.Now()})
+	s.exhaustedAtStep = 0
+	s.Sink.SendEvent(Event{Kind: EventTurnStarted, Time: time.Now(), Step: turn.firstStep})
 
-	for step := 0; step < turn.maxSteps; step++ {
+	lastStep := turn.firstStep + turn.maxSteps
+	for step := turn.firstStep; step < lastStep; step++ {
 		done, err := s.runTurnStep(turn, step)
 		if err != nil {
 			return err
@@ -145,7 +268,8 @@ func (s *Session) RunTurn(ctx context.Context, userInput string) error {
 		}
 	}
 
-	s.finishTurnDueToMaxSteps(turn.maxSteps)
+	s.exhaustedAtStep = lastStep
+	s.finishTurnDueToMaxSteps(lastStep)
 	return nil
 }
 
@@ -167,6 +291,7 @@ func (s *Session) newTurnContext(ctx context.Context, userInput string) *turnCon
 		baseCtx:  baseCtx,
 		cm:       cm,
 		maxSteps: resolveMaxSteps(s.MaxSteps),
+		loops:    newToolLoopDetector(s.Config.LoopDetection.Threshold),
 	}
 }
 
@@ -176,11 +301,21 @@ func (s *Session) commitHistory(cm *ContextManager) {
 		return
 	}
 	s.history = cm.History()
-	if err := persistence.Save(s.ID, s.history); err != nil {
+	if err := s.persist(); err != nil {
 		log.Printf("[session] failed to save session %s: %v", s.ID, err)
 	}
 }
 
+// persist 将当前历史、计划与标题写入持久化存储。
+func (s *Session) persist() error {
+	return persistence.Save(persistence.StoredSession{
+		ID:      s.ID,
+		Title:   s.Title(),
+		History: s.history,
+		Plan:    s.Plan(),
+	})
+}
+
 // resolveMaxSteps 确保最大步数始终是一个可用的正数。
 func resolveMaxSteps(maxSteps int) int {
 	if maxSteps <= 0 {
@@ -192,9 +327,11 @@ func resolveMaxSteps(maxSteps int) int {
 // runTurnStep 执行单步 LLM + 工具调用，返回是否已经结束本次 turn。
 func (s *Session) runTurnStep(turn *turnContext, step int) (bool, error) {
 	s.emitAgentThinking(step)
+	s.applyPendingSystemPrompt(turn.cm)
+	s.drainPendingInputs(turn.cm, step)
 
 	prompt := s.buildPrompt(turn.cm)
-	res, err := s.callLLM(turn.baseCtx, prompt, step)
+	res, client, err := s.callLLM(turn.baseCtx, prompt, step)
 	if err != nil {
 		return true, err
 	}
@@ -204,13 +341,23 @@ func (s *Session) runTurnStep(turn *turnContext, step int) (bool, error) {
 
 	calls := s.resolveToolCalls(res, reply, step)
 	s.ensureCallIDs(calls, step)
+	s.recordReasoningItems(turn.cm, res)
 	s.recordAssistantReply(turn.cm, reply, calls)
+	s.updateResponseChain(client, res, len(turn.cm.items))
 	if len(calls) == 0 {
+		if !s.finishTurnIfIdle() {
+			// 回答期间用户又追加了输入：先输出本次回复，再继续同一个 turn 处理新输入。
+			s.emitAgentText(step, reply)
+			return false, nil
+		}
 		s.emitFinalReply(step, reply)
 		return true, nil
 	}
 
-	s.executeToolCalls(turn.baseCtx, turn.cm, calls, step)
+	if !s.executeToolCalls(turn, calls, step) {
+		s.finishTurnDueToLoop(step)
+		return true, nil
+	}
 
 	return false, nil
 }
@@ -224,24 +371,86 @@ func (s *Session) buildPrompt(cm *ContextManager) Prompt {
 	}
 }
 
-// callLLM 执行一次 LLM 调用，并在调用失败时记录日志。
-func (s *Session) callLLM(baseCtx context.Context, prompt Prompt, step int) (*llm.LLMResult, error) {
+// callLLM 执行一次 LLM 调用，并返回实际产生回复的客户端。
+// 支持链式调用时先只发送增量条目，失败则回退完整回放；当前模型不可用时按 Fallbacks 顺序切换备用模型。
+// 失败的调用若已输出部分增量文本，重试前先发送 EventAgentTextDiscarded，避免同一段文本被重复展示。
+func (s *Session) callLLM(baseCtx context.Context, prompt Prompt, step int) (*llm.LLMResult, llm.LLMClient, error) {
+	if chained, ok := s.chainedPrompt(s.Client, prompt); ok {
+		res, partial, err := s.streamLLM(baseCtx, s.Client, chained, step)
+		if err == nil {
+			return res, s.Client, nil
+		}
+		if baseCtx.Err() != nil {
+			return nil, nil, err
+		}
+		// 服务端响应可能已过期或被删除，放弃链式调用，改为完整回放重试。
+		log.Printf("[agent] step=%d chained call failed, falling back to full replay: %v", step, err)
+		s.resetResponseChain()
+		s.discardPartialOutput(step, partial)
+	}
+
+	res, partial, err := s.streamLLM(baseCtx, s.Client, prompt, step)
+	if err == nil || !llm.IsRetryableError(err) {
+		return res, s.Client, err
+	}
+
+	for _, fb := range s.Fallbacks {
+		if fb == nil || fb.Client == nil || fb.Client == s.Client {
+			continue
+		}
+		if baseCtx.Err() != nil {
+			break
+		}
+		s.discardPartialOutput(step, partial)
+		s.emitModelFallback(step, fb, err)
+		res, partial, err = s.streamLLM(baseCtx, fb.Client, prompt, step)
+		if err == nil || !llm.IsRetryableError(err) {
+			return res, fb.Client, err
+		}
+	}
+	return nil, nil, err
+}
+
+// discardPartialOutput 在失败的调用已输出过增量文本时通知上层丢弃这部分文本。
+func (s *Session) discardPartialOutput(step int, partial bool) {
+	if !partial {
+		return
+	}
+	s.Sink.SendEvent(Event{Kind: EventAgentTextDiscarded, Time: time.Now(), Step: step})
+}
+
+// emitModelFallback 发送切换备用模型事件。
+func (s *Session) emitModelFallback(step int, model *llm.LLMModel, cause error) {
+	log.Printf("[agent] step=%d switching to fallback model alias=%s model=%s cause=%v", step, model.Alias, model.Model, cause)
+	s.Sink.SendEvent(Event{
+		Kind:    EventModelFallback,
+		Time:    time.Now(),
+		Step:    step,
+		Message: fmt.Sprintf("当前模型不可用（%v），本步切换到备用模型 %s (%s)", cause, model.Alias, model.Model),
+	})
+}
+
+// streamLLM 使用指定客户端执行一次流式调用，并在调用失败时记录日志。
+// 第二个返回值表示是否已向上层发送过正文增量。
+func (s *Session) streamLLM(baseCtx context.Context, client llm.LLMClient, prompt Prompt, step int) (*llm.LLMResult, bool, error) {
 	log.Printf("[agent] step=%d calling LLM (history_items=%d, prompt_msgs=%d)", step, len(prompt.Items), len(prompt.Messages))
 
 	// 为本次 LLM 调用单独设置超时，避免影响后续工具执行/审批流程。
 	llmCtx, cancelLLM := context.WithTimeout(baseCtx, 600*time.Second) // 增加超时以适应流式传输
 	defer cancelLLM()
 
-	stream := s.Client.Stream(llmCtx, prompt)
+	stream := client.Stream(llmCtx, prompt)
 
 	var lastError error
 	var finalResult *llm.LLMResult
+	partial := false
 
 	for ev := range stream.C {
 		switch ev.Kind {
 		case llm.LLMEventTextDelta:
 			// 仅当 delta 不为空时才发送，避免不必要的 UI 刷新
 			if ev.TextDelta != "" {
+				partial = true
 				s.Sink.SendEvent(Event{
 					Kind:    EventAgentTextDelta,
 					Time:    time.Now(),
@@ -249,6 +458,15 @@ func (s *Session) callLLM(baseCtx context.Context, prompt Prompt, step int) (*ll
 					Message: ev.TextDelta,
 				})
 			}
+		case llm.LLMEventReasoningDelta:
+			if ev.TextDelta != "" {
+				s.Sink.SendEvent(Event{
+					Kind:    EventAgentReasoningDelta,
+					Time:    time.Now(),
+					Step:    step,
+					Message: ev.TextDelta,
+				})
+			}
 		case llm.LLMEventError:
 			lastError = ev.Error
 		case llm.LLMEventCompleted:
@@ -258,14 +476,14 @@ func (s *Session) callLLM(baseCtx context.Context, prompt Prompt, step int) (*ll
 
 	if lastError != nil {
 		log.Printf("[agent] step=%d LLM error: %v", step, lastError)
-		return nil, lastError
+		return nil, partial, lastError
 	}
 
 	if finalResult == nil {
-		return nil, fmt.Errorf("LLM stream completed without result")
+		return nil, partial, fmt.Errorf("LLM stream completed without result")
 	}
 
-	return finalResult, nil
+	return finalResult, partial, nil
 }
 
 // logLLMReply 输出 LLM 回复摘要日志。
@@ -338,74 +556,125 @@ func (s *Session) emitAgentThinking(step int) {
 
 // emitFinalReply 发送最终回答事件并结束当前 turn。
 func (s *Session) emitFinalReply(step int, reply string) {
+	s.emitAgentText(step, reply)
+	s.Sink.SendEvent(Event{Kind: EventTurnFinished, Time: time.Now(), Step: step})
+}
+
+// emitAgentText 发送一轮回答完成事件。
+func (s *Session) emitAgentText(step int, reply string) {
 	s.Sink.SendEvent(Event{
 		Kind:    EventAgentTextDone,
 		Time:    time.Now(),
 		Step:    step,
 		Message: reply,
 	})
-	s.Sink.SendEvent(Event{Kind: EventTurnFinished, Time: time.Now(), Step: step})
 }
 
-// finishTurnDueToMaxSteps 在达到最大步数时输出终止事件。
-func (s *Session) finishTurnDueToMaxSteps(maxSteps int) {
+// finishTurnDueToMaxSteps 在达到最大步数时输出可续跑提示与终止事件。
+func (s *Session) finishTurnDueToMaxSteps(step int) {
+	s.Sink.SendEvent(Event{
+		Kind:    EventMaxStepsReached,
+		Time:    time.Now(),
+		Step:    step,
+		Message: fmt.Sprintf("已用完步数预算，任务可能尚未完成（可继续 %d 步）", resolveMaxSteps(s.MaxSteps)),
+	})
 	s.Sink.SendEvent(Event{
 		Kind:    EventTurnFinished,
 		Time:    time.Now(),
-		Step:    maxSteps,
+		Step:    step,
 		Message: "达到最大步数，终止",
 	})
 }
 
+// finishTurnDueToLoop 在用户因重复调用选择终止时输出结束事件。
+func (s *Session) finishTurnDueToLoop(step int) {
+	s.Sink.SendEvent(Event{
+		Kind:    EventTurnFinished,
+		Time:    time.Now(),
+		Step:    step,
+		Message: "检测到重复工具调用，已按用户选择终止",
+	})
+}
+
 // executeToolCalls 执行所有工具调用并将结果写回历史。
-func (s *Session) executeToolCalls(ctx context.Context, cm *ContextManager, calls []servertools.ToolCall, step int) {
+// 返回 false 表示因重复调用被用户终止，剩余调用会记录为已跳过，保持调用与结果成对。
+func (s *Session) executeToolCalls(turn *turnContext, calls []servertools.ToolCall, step int) bool {
 	log.Printf("[agent] step=%d resolved %d tool_calls", step, len(calls))
+	var notes []string
+	for i, call := range calls {
+		output := s.executeSingleToolCall(turn.baseCtx, turn.cm, call, step)
+		note, ok := s.checkToolLoop(turn, call, output, step)
+		if !ok {
+			s.recordSkippedToolCalls(turn.cm, calls[i+1:])
+			return false
+		}
+		if note != "" {
+			notes = append(notes, note)
+		}
+	}
+	recordLoopNotes(turn.cm, notes)
+	return true
+}
+
+// recordSkippedToolCalls 为未执行的工具调用写入占位结果。
+func (s *Session) recordSkippedToolCalls(cm *ContextManager, calls []servertools.ToolCall) {
 	for _, call := range calls {
-		s.executeSingleToolCall(ctx, cm, call, step)
+		cm.Record(ResponseItem{
+			Type:       ResponseItemToolResult,
+			ToolName:   call.ToolName,
+			ToolOutput: "工具未执行：用户终止了本次任务",
+			CallID:     call.CallID,
+		})
 	}
 }
 
-// executeSingleToolCall 执行单个工具调用，并将结果写回 ContextManager。
-func (s *Session) executeSingleToolCall(ctx context.Context, cm *ContextManager, call servertools.ToolCall, step int) {
+// executeSingleToolCall 执行单个工具调用，并将结果写回 ContextManager，返回写入的工具输出。
+func (s *Session) executeSingleToolCall(ctx context.Context, cm *ContextManager, call servertools.ToolCall, step int) string {
 	log.Printf("[agent] step=%d executing tool=%s", step, call.ToolName)
 
 	item, execErr := s.executeToolCall(ctx, call, step)
 	if execErr != nil {
 		s.emitToolError(step, call.ToolName, execErr)
 		log.Printf("[agent] step=%d tool=%s error=%v", step, call.ToolName, execErr)
+		output := fmt.Sprintf("工具执行失败: %v", execErr)
 		cm.Record(ResponseItem{
 			Type:       ResponseItemToolResult,
 			ToolName:   call.ToolName,
-			ToolOutput: fmt.Sprintf("工具执行失败: %v", execErr),
+			ToolOutput: output,
 			CallID:     call.CallID,
 		})
-		return
+		return output
 	}
 
 	s.emitToolOutput(step, call.ToolName, item.ToolOutput)
 	log.Printf("[agent] step=%d tool=%s done output_len=%d", step, call.ToolName, len(item.ToolOutput))
 
-	cm.Record(ResponseItem{
-		Type:       ResponseItemToolResult,
-		ToolName:   item.ToolName,
-		ToolOutput: item.ToolOutput,
-		CallID:     call.CallID,
-	})
+	item.CallID = call.CallID
+	cm.Record(item)
+	return item.ToolOutput
 }
 
 // executeToolCall 处理工具调用分发和安全审批。
 func (s *Session) executeToolCall(ctx context.Context, call servertools.ToolCall, step int) (ResponseItem, error) {
-	if call.ToolName == "apply_patch" {
+	switch call.ToolName {
+	case "apply_patch":
 		return s.executeApplyPatchWithSafety(ctx, call, step)
+	case "update_plan":
+		return s.executeUpdatePlan(call, step)
+	}
+	if err := s.approveRemoteTool(ctx, call, step); err != nil {
+		return ResponseItem{}, err
 	}
 	res, err := s.Router.Execute(ctx, call)
 	if err != nil {
 		return ResponseItem{}, err
 	}
 	return ResponseItem{
-		Type:       ResponseItemToolResult,
-		ToolName:   res.ToolName,
-		ToolOutput: res.Output,
+		Type:           ResponseItemToolResult,
+		ToolName:       res.ToolName,
+		ToolOutput:     res.Output,
+		ToolImages:     res.Images,
+		ToolStructured: res.Structured,
 	}, nil
 }
 
@@ -586,13 +855,19 @@ func (s *Session) emitPatchApprovalResult(call servertools.ToolCall, step int, r
 }
 
 func (s *Session) waitForApproval(ctx context.Context, requestID string) (bool, error) {
+	d, err := s.waitForDecision(ctx, requestID)
+	return d.Approved, err
+}
+
+// waitForDecision 等待指定请求的审批结果。
+func (s *Session) waitForDecision(ctx context.Context, requestID string) (ApprovalDecision, error) {
 	for {
 		select {
 		case <-ctx.Done():
-			return false, ctx.Err()
+			return ApprovalDecision{}, ctx.Err()
 		case d := <-s.approvals:
 			if d.RequestID == requestID {
-				return d.Approved, nil
+				return d, nil
 			}
 			// 非本请求的审批结果直接丢弃（当前实现只考虑串行审批）。
 		}
@@ -624,8 +899,8 @@ func (s *Session) ManualCompactHistory(ctx context.Context) (string, error) {
 		Items: cm.History(),
 	}
 
-	// 2. 调用 LLM 生成摘要 (非流式)
-	res, err := s.Client.Complete(ctx, compactPrompt)
+	// 2. 调用 LLM 生成摘要 (非流式)，优先使用 compact 角色模型
+	res, err := s.completeWithRole(ctx, llm.ModelRoleCompact, compactPrompt)
 	if err != nil {
 		return "", fmt.Errorf("生成摘要失败: %w", err)
 	}
@@ -651,11 +926,12 @@ func (s *Session) ManualCompactHistory(ctx context.Context) (string, error) {
 		Text: summaryMsg,
 	})
 
-	// 3.3 替换当前历史
+	// 3.3 替换当前历史（服务端会话链随之失效）
 	s.history = newHistory
+	s.resetResponseChain()
 
 	// 3.4 立即持久化
-	if err := persistence.Save(s.ID, s.history); err != nil {
+	if err := s.persist(); err != nil {
 		log.Printf("[session] failed to save compacted session: %v", err)
 	}
 
@@ -0,0 +1,467 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"chase-code/server/config"
+	"chase-code/server/llm"
+	servertools "chase-code/server/tools"
+)
+
+// TestMain 将 HOME 指向临时目录，避免测试读取用户配置或写入真实的会话存储。
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "chase-code-server-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// recordingSink 记录全部事件，并可在收到事件时回调（例如自动审批）。
+type recordingSink struct {
+	mu      sync.Mutex
+	events  []Event
+	onEvent func(Event)
+}
+
+func (r *recordingSink) SendEvent(ev Event) {
+	r.mu.Lock()
+	r.events = append(r.events, ev)
+	r.mu.Unlock()
+	if r.onEvent != nil {
+		r.onEvent(ev)
+	}
+}
+
+// kinds 返回指定类型的事件。
+func (r *recordingSink) kinds(kind EventKind) []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []Event
+	for _, ev := range r.events {
+		if ev.Kind == kind {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// fakeRemote 模拟 MCP server，记录被代理的工具调用。
+type fakeRemote struct {
+	calls []string
+}
+
+func (f *fakeRemote) CallTool(_ context.Context, name string, arguments json.RawMessage) (string, error) {
+	f.calls = append(f.calls, name+" "+string(arguments))
+	return "remote:" + name, nil
+}
+
+// lastToolResult 返回 Prompt 中最后一条工具结果。
+func lastToolResult(p llm.Prompt) (ResponseItem, bool) {
+	for i := len(p.Items) - 1; i >= 0; i-- {
+		if p.Items[i].Type == ResponseItemToolResult {
+			return p.Items[i], true
+		}
+	}
+	return ResponseItem{}, false
+}
+
+// expectToolResult 生成断言最后一条工具结果包含 want 的 Check。
+func expectToolResult(want string) func(llm.Prompt) error {
+	return func(p llm.Prompt) error {
+		item, ok := lastToolResult(p)
+		if !ok {
+			return fmt.Errorf("prompt 中没有工具结果")
+		}
+		if !strings.Contains(item.ToolOutput, want) {
+			return fmt.Errorf("工具结果 %q 不包含 %q", item.ToolOutput, want)
+		}
+		return nil
+	}
+}
+
+func newTestSession(client llm.LLMClient, router *servertools.ToolRouter, sink EventSink) *Session {
+	s := NewSession(client, router, sink, 5)
+	s.ResetHistoryWithSystemPrompt("test system prompt")
+	return s
+}
+
+// TestRunTurn_RoutesUnknownToolsToRemote 验证工具循环：未内置工具代理到远程（MCP）并把结果回传给模型。
+func TestRunTurn_RoutesUnknownToolsToRemote(t *testing.T) {
+	remote := &fakeRemote{}
+	router := servertools.NewToolRouterWithMCP(nil, remote)
+	router.SetAutoApprovedTools([]string{"search_docs"})
+	client := llm.NewScriptedClient(
+		llm.ScriptedStep{ToolCalls: []llm.ToolCall{{ToolName: "search_docs", Arguments: json.RawMessage(`{"q":"go"}`)}}},
+		llm.ScriptedStep{Text: "找到了", Check: expectToolResult("remote:search_docs")},
+	)
+	sink := &recordingSink{}
+	s := newTestSession(client, router, sink)
+
+	require.NoError(t, s.RunTurn(context.Background(), "查文档"))
+
+	assert.Equal(t, []string{`search_docs {"q":"go"}`}, remote.calls)
+	assert.Equal(t, 0, client.Remaining())
+	done := sink.kinds(EventAgentTextDone)
+	require.NotEmpty(t, done)
+	assert.Equal(t, "找到了", done[len(done)-1].Message)
+	assert.Len(t, sink.kinds(EventTurnFinished), 1)
+
+	// 工具调用与结果应按 CallID 成对写入历史。
+	var callIDs, resultIDs []string
+	for _, item := range s.history {
+		for _, c := range item.ToolCalls {
+			callIDs = append(callIDs, c.CallID)
+		}
+		if item.Type == ResponseItemToolResult {
+			resultIDs = append(resultIDs, item.CallID)
+		}
+	}
+	assert.Equal(t, []string{"local-0-0"}, callIDs)
+	assert.Equal(t, callIDs, resultIDs)
+}
+
+// TestRunTurn_PatchApproval 验证 always_ask 模式下补丁需要审批，批准后写入文件，拒绝后把原因返回模型。
+func TestRunTurn_PatchApproval(t *testing.T) {
+	patch := func(name string) json.RawMessage {
+		args, _ := json.Marshal(map[string]string{"input": "*** Begin Patch\n*** Add File: " + name + "\n+hello\n*** End Patch"})
+		return args
+	}
+
+	cases := []struct {
+		name     string
+		approve  bool
+		file     string
+		expected string
+	}{
+		{name: "approved", approve: true, file: "approved.txt", expected: "approved.txt"},
+		{name: "rejected", approve: false, file: "rejected.txt", expected: "补丁被用户拒绝"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			t.Chdir(dir)
+
+			router := servertools.NewToolRouter(servertools.DefaultToolSpecs())
+			client := llm.NewScriptedClient(
+				llm.ScriptedStep{ToolCalls: []llm.ToolCall{{ToolName: "apply_patch", Arguments: patch(tc.file)}}},
+				llm.ScriptedStep{Text: "done", Check: expectToolResult(tc.expected)},
+			)
+			sink := &recordingSink{}
+			s := newTestSession(client, router, sink)
+			s.Config.ToolApproval.ApplyPatch = config.ApprovalModeAlwaysAsk
+			sink.onEvent = func(ev Event) {
+				if ev.Kind == EventPatchApprovalRequest {
+					s.ApprovalsChan() <- ApprovalDecision{RequestID: ev.RequestID, Approved: tc.approve}
+				}
+			}
+
+			require.NoError(t, s.RunTurn(context.Background(), "写文件"))
+
+			assert.Len(t, sink.kinds(EventPatchApprovalRequest), 1)
+			assert.Equal(t, 0, client.Remaining())
+			_, err := os.Stat(filepath.Join(dir, tc.file))
+			if tc.approve {
+				assert.NoError(t, err)
+			} else {
+				assert.True(t, os.IsNotExist(err))
+			}
+		})
+	}
+}
+
+// TestManualCompactHistory_UsesCompactRole 验证压缩使用 compact 角色模型，且失败时回退主模型。
+func TestManualCompactHistory_UsesCompactRole(t *testing.T) {
+	router := servertools.NewToolRouter(nil)
+	main := llm.NewScriptedClient(
+		llm.ScriptedStep{Text: "first answer"},
+		llm.ScriptedStep{Text: "main summary"},
+	)
+	s := newTestSession(main, router, &recordingSink{})
+	require.NoError(t, s.RunTurn(context.Background(), "hello"))
+
+	compact := llm.NewScriptedClient(llm.ScriptedStep{Text: "cheap summary"})
+	s.SetRoleClient(llm.ModelRoleCompact, compact)
+
+	summary, err := s.ManualCompactHistory(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, summary, "cheap summary")
+	assert.Equal(t, 0, compact.Remaining())
+	assert.Equal(t, 1, main.Remaining(), "主模型不应被用于压缩")
+
+	require.NoError(t, s.RunTurn(context.Background(), "more"))
+	s.SetRoleClient(llm.ModelRoleCompact, llm.NewScriptedClient(llm.ScriptedStep{Err: fmt.Errorf("rate limited")}))
+	main = llm.NewScriptedClient(llm.ScriptedStep{Text: "fallback summary"})
+	s.Client = main
+	summary, err = s.ManualCompactHistory(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, summary, "fallback summary")
+}
+
+// TestRunTurn_CassetteReplay 验证录制的 turn 可以在不访问模型的情况下完整回放。
+func TestRunTurn_CassetteReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "turn.json")
+	newRouter := func() *servertools.ToolRouter {
+		router := servertools.NewToolRouterWithMCP(nil, &fakeRemote{})
+		router.SetAutoApprovedTools([]string{"lookup"})
+		return router
+	}
+	script := llm.NewScriptedClient(
+		llm.ScriptedStep{ToolCalls: []llm.ToolCall{{ToolName: "lookup", Arguments: json.RawMessage(`{}`)}}},
+		llm.ScriptedStep{Text: "recorded answer"},
+	)
+	recorder, err := llm.NewReplayClient(llm.CassetteRecord, path, script)
+	require.NoError(t, err)
+	require.NoError(t, newTestSession(recorder, newRouter(), &recordingSink{}).RunTurn(context.Background(), "go"))
+
+	player, err := llm.NewReplayClient(llm.CassetteReplay, path, nil)
+	require.NoError(t, err)
+	sink := &recordingSink{}
+	require.NoError(t, newTestSession(player, newRouter(), sink).RunTurn(context.Background(), "go"))
+
+	done := sink.kinds(EventAgentTextDone)
+	require.NotEmpty(t, done)
+	assert.Equal(t, "recorded answer", done[len(done)-1].Message)
+	assert.Empty(t, sink.kinds(EventTurnError))
+}
+
+// TestRunTurn_RemoteToolApproval 验证远程工具审批：autoApprove 直接执行，拒绝时不调用远程，“始终允许”后本会话不再询问。
+func TestRunTurn_RemoteToolApproval(t *testing.T) {
+	call := func(name string) llm.ScriptedStep {
+		return llm.ScriptedStep{ToolCalls: []llm.ToolCall{{ToolName: name, Arguments: json.RawMessage(`{"path":"a.txt"}`)}}}
+	}
+	remote := &fakeRemote{}
+	router := servertools.NewToolRouterWithMCP(nil, remote)
+	router.SetAutoApprovedTools([]string{"read"})
+
+	client := llm.NewScriptedClient(
+		call("read"),
+		call("write"),
+		llm.ScriptedStep{Text: "rejected", Check: expectToolResult("工具调用被用户拒绝")},
+		call("write"),
+		call("write"),
+		llm.ScriptedStep{Text: "done", Check: expectToolResult("remote:write")},
+	)
+	sink := &recordingSink{}
+	s := newTestSession(client, router, sink)
+
+	decisions := []ApprovalDecision{{Approved: false}, {Approved: true, Always: true}}
+	sink.onEvent = func(ev Event) {
+		if ev.Kind != EventToolApprovalRequest {
+			return
+		}
+		assert.Equal(t, "write", ev.ToolName)
+		assert.Equal(t, "{\n  \"path\": \"a.txt\"\n}", ev.Message)
+		d := decisions[0]
+		decisions = decisions[1:]
+		d.RequestID = ev.RequestID
+		s.ApprovalsChan() <- d
+	}
+
+	require.NoError(t, s.RunTurn(context.Background(), "first"))
+	require.NoError(t, s.RunTurn(context.Background(), "second"))
+
+	assert.Len(t, sink.kinds(EventToolApprovalRequest), 2)
+	assert.Len(t, sink.kinds(EventToolApprovalResult), 2)
+	assert.Equal(t, []string{
+		`read {"path":"a.txt"}`,
+		`write {"path":"a.txt"}`,
+		`write {"path":"a.txt"}`,
+	}, remote.calls)
+	assert.Equal(t, 0, client.Remaining())
+}
+
+// TestRunTurn_FallbackAfterPartialStream 验证流式输出中途断开时切换备用模型，并先通知上层丢弃已输出的部分文本。
+func TestRunTurn_FallbackAfterPartialStream(t *testing.T) {
+	netErr := llm.NetworkError{Err: fmt.Errorf("connection reset by peer")}
+	main := llm.NewScriptedClient(llm.ScriptedStep{Deltas: []string{"写到一半"}, StreamErr: netErr})
+	backup := llm.NewScriptedClient(llm.ScriptedStep{Text: "完整回答"})
+	sink := &recordingSink{}
+	s := newTestSession(main, servertools.NewToolRouter(nil), sink)
+	s.Fallbacks = []*llm.LLMModel{{Client: main, Alias: "main"}, {Client: backup, Alias: "backup", Model: "backup-model"}}
+
+	require.NoError(t, s.RunTurn(context.Background(), "hi"))
+
+	assert.Len(t, sink.kinds(EventAgentTextDiscarded), 1)
+	require.Len(t, sink.kinds(EventModelFallback), 1)
+	assert.Contains(t, sink.kinds(EventModelFallback)[0].Message, "backup")
+	done := sink.kinds(EventAgentTextDone)
+	require.Len(t, done, 1)
+	assert.Equal(t, "完整回答", done[0].Message)
+	assert.Equal(t, 0, backup.Remaining())
+
+	// 切换前的事件顺序：半截增量 -> 丢弃 -> 切换 -> 新的增量。
+	var order []EventKind
+	for _, ev := range sink.events {
+		switch ev.Kind {
+		case EventAgentTextDelta, EventAgentTextDiscarded, EventModelFallback:
+			order = append(order, ev.Kind)
+		}
+	}
+	assert.Equal(t, []EventKind{EventAgentTextDelta, EventAgentTextDiscarded, EventModelFallback, EventAgentTextDelta}, order)
+}
+
+// TestRunTurn_NonRetryableErrorSkipsFallback 验证非网络/5xx 错误直接返回，不切换备用模型。
+func TestRunTurn_NonRetryableErrorSkipsFallback(t *testing.T) {
+	main := llm.NewScriptedClient(llm.ScriptedStep{Err: fmt.Errorf("invalid request")})
+	backup := llm.NewScriptedClient(llm.ScriptedStep{Text: "不应使用"})
+	sink := &recordingSink{}
+	s := newTestSession(main, servertools.NewToolRouter(nil), sink)
+	s.Fallbacks = []*llm.LLMModel{{Client: backup, Alias: "backup"}}
+
+	require.Error(t, s.RunTurn(context.Background(), "hi"))
+	assert.Equal(t, 1, backup.Remaining())
+	assert.Empty(t, sink.kinds(EventModelFallback))
+	assert.Empty(t, sink.kinds(EventAgentTextDiscarded))
+}
+
+// TestRunTurn_LoopNoteAfterParallelResults 验证并行工具调用中检测到重复时，纠正提示在整批工具结果之后写入，
+// 不会插在 tool 消息之间破坏 tool_calls/tool 的相邻关系。
+func TestRunTurn_LoopNoteAfterParallelResults(t *testing.T) {
+	router := servertools.NewToolRouterWithMCP(nil, &fakeRemote{})
+	router.SetAutoApprovedTools([]string{"lookup", "other"})
+	args := json.RawMessage(`{"q":"x"}`)
+	client := llm.NewScriptedClient(
+		llm.ScriptedStep{ToolCalls: []llm.ToolCall{
+			{ToolName: "lookup", Arguments: args, CallID: "c1"},
+			{ToolName: "lookup", Arguments: args, CallID: "c2"},
+			{ToolName: "other", Arguments: args, CallID: "c3"},
+		}},
+		llm.ScriptedStep{Text: "done"},
+	)
+	sink := &recordingSink{}
+	s := newTestSession(client, router, sink)
+	s.Config.LoopDetection = config.LoopDetectionConfig{Threshold: 2, Action: config.LoopActionInject}
+
+	require.NoError(t, s.RunTurn(context.Background(), "go"))
+	require.Len(t, sink.kinds(EventLoopDetected), 1)
+
+	items := client.Prompts()[1].Items
+	start := -1
+	for i, it := range items {
+		if it.Role == RoleAssistant && len(it.ToolCalls) == 3 {
+			start = i
+		}
+	}
+	require.GreaterOrEqual(t, start, 0)
+	require.Len(t, items, start+5)
+	for i, id := range []string{"c1", "c2", "c3"} {
+		assert.Equal(t, ResponseItemToolResult, items[start+1+i].Type)
+		assert.Equal(t, id, items[start+1+i].CallID)
+	}
+	note := items[start+4]
+	assert.Equal(t, RoleUser, note.Role, "system 消息在 Anthropic 中会被移到顶层，提示须以 user 角色紧跟工具结果")
+	assert.Contains(t, note.Text, "lookup")
+}
+
+// TestRunTurn_LoopAskNoteUsesCount 验证 ask 模式下用户选择继续后，纠正提示给出的是实际重复次数而非阈值。
+func TestRunTurn_LoopAskNoteUsesCount(t *testing.T) {
+	router := servertools.NewToolRouterWithMCP(nil, &fakeRemote{})
+	router.SetAutoApprovedTools([]string{"lookup"})
+	lookup := llm.ScriptedStep{ToolCalls: []llm.ToolCall{{ToolName: "lookup", Arguments: json.RawMessage(`{"q":"x"}`)}}}
+	client := llm.NewScriptedClient(lookup, lookup, lookup, lookup, llm.ScriptedStep{Text: "done"})
+	sink := &recordingSink{}
+	s := newTestSession(client, router, sink)
+	s.Config.LoopDetection = config.LoopDetectionConfig{Threshold: 2, Action: config.LoopActionAsk}
+	sink.onEvent = func(ev Event) {
+		if ev.Kind == EventLoopDetected && ev.RequestID != "" {
+			s.ApprovalsChan() <- ApprovalDecision{RequestID: ev.RequestID, Approved: true}
+		}
+	}
+
+	require.NoError(t, s.RunTurn(context.Background(), "go"))
+	require.Len(t, sink.kinds(EventLoopDecision), 2)
+
+	items := client.Prompts()[4].Items
+	last := items[len(items)-1]
+	assert.Equal(t, RoleUser, last.Role)
+	assert.Contains(t, last.Text, "lookup 4 次")
+}
+
+// TestQueueUserInput_TurnLifecycle 验证排队输入只在 turn 运行期间被接受：运行中的输入注入当前 turn，
+// 中止后剩余的输入可由 TakePendingInputs 交给新 turn，会话重置时被丢弃。
+func TestQueueUserInput_TurnLifecycle(t *testing.T) {
+	router := servertools.NewToolRouterWithMCP(nil, &fakeRemote{})
+	router.SetAutoApprovedTools([]string{"lookup"})
+	sink := &recordingSink{}
+	var s *Session
+	client := llm.NewScriptedClient(
+		llm.ScriptedStep{Text: "first", Check: func(llm.Prompt) error {
+			assert.True(t, s.QueueUserInput("顺便看看 README"))
+			return nil
+		}},
+		llm.ScriptedStep{Text: "second", Check: func(p llm.Prompt) error {
+			last := p.Items[len(p.Items)-1]
+			assert.Equal(t, "顺便看看 README", last.Text)
+			return nil
+		}},
+	)
+	s = newTestSession(client, router, sink)
+	require.NoError(t, s.RunTurn(context.Background(), "hi"))
+	assert.Len(t, sink.kinds(EventUserInputInjected), 1)
+	assert.Len(t, sink.kinds(EventTurnFinished), 1)
+
+	// turn 结束后不再入队，由调用方另起新 turn。
+	assert.False(t, s.QueueUserInput("too late"))
+	assert.Empty(t, s.PendingInputs())
+
+	// 步数耗尽时仍在队列中的输入留给下一个 turn。
+	client = llm.NewScriptedClient(llm.ScriptedStep{
+		ToolCalls: []llm.ToolCall{{ToolName: "lookup", Arguments: json.RawMessage(`{}`)}},
+		Check: func(llm.Prompt) error {
+			assert.True(t, s.QueueUserInput("leftover"))
+			return nil
+		},
+	})
+	s.Client = client
+	s.MaxSteps = 1
+	require.NoError(t, s.RunTurn(context.Background(), "again"))
+	assert.Equal(t, []string{"leftover"}, s.TakePendingInputs())
+	assert.Empty(t, s.PendingInputs())
+
+	s.pendingMu.Lock()
+	s.pendingInputs = []string{"stale"}
+	s.pendingMu.Unlock()
+	s.ResetHistoryWithSystemPrompt("sys")
+	assert.Empty(t, s.PendingInputs())
+	require.Len(t, sink.kinds(EventUserInputDropped), 1)
+}
+
+// TestUpdateSystemPrompt_AppliedBeforeNextCall 验证 turn 运行期间更新的系统提示词在下一次 LLM 调用前替换旧提示词，且不清空历史。
+func TestUpdateSystemPrompt_AppliedBeforeNextCall(t *testing.T) {
+	router := servertools.NewToolRouterWithMCP(nil, &fakeRemote{})
+	router.SetAutoApprovedTools([]string{"lookup"})
+	var s *Session
+	client := llm.NewScriptedClient(
+		llm.ScriptedStep{
+			ToolCalls: []llm.ToolCall{{ToolName: "lookup", Arguments: json.RawMessage(`{}`)}},
+			Check: func(p llm.Prompt) error {
+				assert.Equal(t, "old tools", p.Items[0].Text)
+				s.UpdateSystemPrompt("new tools")
+				return nil
+			},
+		},
+		llm.ScriptedStep{Text: "done", Check: func(p llm.Prompt) error {
+			assert.Equal(t, "new tools", p.Items[0].Text)
+			assert.Equal(t, "hi", p.Items[1].Text)
+			return nil
+		}},
+	)
+	s = newTestSession(client, router, &recordingSink{})
+	s.ResetHistoryWithSystemPrompt("old tools")
+	require.NoError(t, s.RunTurn(context.Background(), "hi"))
+	assert.Equal(t, "new tools", s.history[0].Text)
+}
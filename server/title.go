@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"chase-code/server/llm"
+)
+
+const (
+	// titleTimeout 为生成会话标题的最长等待时间。
+	titleTimeout = 30 * time.Second
+	// titleMaxRunes 为会话标题的最大长度。
+	titleMaxRunes = 40
+)
+
+// Title 返回当前会话标题，尚未生成时为空。
+func (s *Session) Title() string {
+	if s == nil {
+		return ""
+	}
+	s.titleMu.Lock()
+	defer s.titleMu.Unlock()
+	return s.title
+}
+
+// setTitle 更新会话标题。
+func (s *Session) setTitle(title string) {
+	s.titleMu.Lock()
+	s.title = title
+	s.titleMu.Unlock()
+}
+
+// maybeGenerateTitle 在会话还没有标题且配置了 title 角色模型时，后台根据首条输入生成标题。
+// 标题随下一次历史提交一起持久化，不阻塞当前 turn。
+func (s *Session) maybeGenerateTitle(ctx context.Context, userInput string) {
+	client := s.roleClient(llm.ModelRoleTitle)
+	if client == nil || strings.TrimSpace(userInput) == "" {
+		return
+	}
+
+	s.titleMu.Lock()
+	if s.title != "" || s.titlePending {
+		s.titleMu.Unlock()
+		return
+	}
+	s.titlePending = true
+	s.titleMu.Unlock()
+
+	go func() {
+		defer func() {
+			s.titleMu.Lock()
+			s.titlePending = false
+			s.titleMu.Unlock()
+		}()
+
+		titleCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), titleTimeout)
+		defer cancel()
+		res, err := client.Complete(titleCtx, buildTitlePrompt(userInput))
+		if err != nil {
+			log.Printf("[session] generate title failed: %v", err)
+			return
+		}
+		title := normalizeTitle(res.Message.Content)
+		if title == "" {
+			return
+		}
+		s.setTitle(title)
+		log.Printf("[session] title generated session=%s title=%q", s.ID, title)
+	}()
+}
+
+// buildTitlePrompt 构造生成会话标题的 Prompt。
+func buildTitlePrompt(userInput string) Prompt {
+	return Prompt{Items: []ResponseItem{
+		{
+			Type: ResponseItemMessage,
+			Role: RoleSystem,
+			Text: "根据用户的第一条请求，为这次编码会话起一个简短标题（不超过 20 个字），只输出标题本身，不要引号和标点结尾。",
+		},
+		{Type: ResponseItemMessage, Role: RoleUser, Text: userInput},
+	}}
+}
+
+// normalizeTitle 取模型输出的第一行并去掉引号，超长时截断。
+func normalizeTitle(raw string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(raw), "\n")
+	line = strings.Trim(strings.TrimSpace(line), "\"'“”《》#* ")
+	runes := []rune(line)
+	if len(runes) > titleMaxRunes {
+		line = string(runes[:titleMaxRunes])
+	}
+	return line
+}
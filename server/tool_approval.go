@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	servertools "chase-code/server/tools"
+)
+
+// approveRemoteTool 对需要审批的远程（MCP）工具发起审批，未获批准时返回错误。
+// autoApprove 中的工具与本会话已选择“始终允许”的工具直接放行。
+func (s *Session) approveRemoteTool(ctx context.Context, call servertools.ToolCall, step int) error {
+	if !s.Router.RequiresApproval(call.ToolName) || s.alwaysAllowedTools[call.ToolName] {
+		return nil
+	}
+
+	reqID := fmt.Sprintf("tool-%d-%d", time.Now().UnixNano(), step)
+	server, tool := s.Router.RemoteToolOwner(call.ToolName)
+	s.Sink.SendEvent(Event{
+		Kind:      EventToolApprovalRequest,
+		Time:      time.Now(),
+		Step:      step,
+		ToolName:  tool,
+		Server:    server,
+		RequestID: reqID,
+		Message:   prettyToolArguments(call.Arguments),
+	})
+
+	d, err := s.waitForDecision(ctx, reqID)
+	if err != nil {
+		return err
+	}
+
+	result := "rejected by user"
+	switch {
+	case d.Approved && d.Always:
+		if s.alwaysAllowedTools == nil {
+			s.alwaysAllowedTools = make(map[string]bool)
+		}
+		s.alwaysAllowedTools[call.ToolName] = true
+		result = "approved (always for this session)"
+	case d.Approved:
+		result = "approved"
+	}
+	log.Printf("[agent] step=%d tool approval tool=%s result=%s", step, call.ToolName, result)
+	s.Sink.SendEvent(Event{
+		Kind:      EventToolApprovalResult,
+		Time:      time.Now(),
+		Step:      step,
+		ToolName:  tool,
+		Server:    server,
+		RequestID: reqID,
+		Message:   result,
+	})
+
+	if !d.Approved {
+		return fmt.Errorf("工具调用被用户拒绝")
+	}
+	return nil
+}
+
+// prettyToolArguments 将工具参数格式化为缩进 JSON，无法解析时原样返回。
+func prettyToolArguments(args json.RawMessage) string {
+	if len(bytes.TrimSpace(args)) == 0 {
+		return "{}"
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, args, "", "  "); err != nil {
+		return string(args)
+	}
+	return buf.String()
+}
@@ -0,0 +1,105 @@
+package tools
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// commandSeparator 匹配 shell 中串联多条命令的操作符，用于逐段检查。
+var commandSeparator = regexp.MustCompile(`&&|\|\||[;|\n]`)
+
+// shellMetaChars 为拆分后仍残留在命令段中的 shell 元字符：后台执行、重定向、命令替换与进程替换。
+// 这些写法可以绕过逐段检查（如 git status & rm -rf ~、git log > ~/.bashrc），允许列表模式下一律拒绝。
+var shellMetaChars = []string{"&", ">", "<", "`", "$("}
+
+// safeRedirections 为允许保留的无副作用重定向，检查元字符前先从命令段中去掉。
+var safeRedirections = map[string]bool{"2>&1": true, "1>&2": true, ">&2": true}
+
+// devNullRedirections 为指向 /dev/null 的重定向操作符，可与目标写在一起或以空格分隔。
+var devNullRedirections = map[string]bool{">": true, "1>": true, "2>": true, "&>": true}
+
+// envAssignment 匹配命令前缀中的环境变量赋值（如 FOO=bar cmd）。
+var envAssignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+
+// SetAllowedCommands 设置 shell 工具允许执行的命令前缀，为空表示不限制。
+// 条目可以是程序名（如 git）或带子命令的前缀（如 "go test"）。
+func (r *ToolRouter) SetAllowedCommands(allowed []string) {
+	r.allowedCommands = nil
+	for _, a := range allowed {
+		if a = strings.Join(strings.Fields(a), " "); a != "" {
+			r.allowedCommands = append(r.allowedCommands, a)
+		}
+	}
+}
+
+// checkCommandAllowed 检查命令中的每一段是否都命中允许列表。
+func (r *ToolRouter) checkCommandAllowed(command string) error {
+	if len(r.allowedCommands) == 0 {
+		return nil
+	}
+	for _, segment := range commandSeparator.Split(command, -1) {
+		if meta := findShellMetaChar(segment); meta != "" {
+			return fmt.Errorf("命令 %q 包含 allowed_commands 模式下不允许的 shell 元字符 %q", strings.TrimSpace(segment), meta)
+		}
+		normalized := normalizeCommandSegment(segment)
+		if normalized == "" {
+			continue
+		}
+		if !matchesAllowedCommand(normalized, r.allowedCommands) {
+			return fmt.Errorf("命令 %q 不在 allowed_commands 允许列表中（允许: %s）", strings.TrimSpace(segment), strings.Join(r.allowedCommands, ", "))
+		}
+	}
+	return nil
+}
+
+// findShellMetaChar 返回命令段中第一个不允许的 shell 元字符，去掉 2>&1、>/dev/null 等无害重定向后再检查。
+func findShellMetaChar(segment string) string {
+	fields := strings.Fields(segment)
+	kept := make([]string, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if safeRedirections[f] {
+			continue
+		}
+		if op, ok := strings.CutSuffix(f, "/dev/null"); ok && devNullRedirections[op] {
+			continue
+		}
+		if devNullRedirections[f] && i+1 < len(fields) && fields[i+1] == "/dev/null" {
+			i++
+			continue
+		}
+		kept = append(kept, f)
+	}
+	rest := strings.Join(kept, " ")
+	for _, meta := range shellMetaChars {
+		if strings.Contains(rest, meta) {
+			return meta
+		}
+	}
+	return ""
+}
+
+// normalizeCommandSegment 去掉前导环境变量赋值与括号，并将程序路径规范为文件名。
+func normalizeCommandSegment(segment string) string {
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(segment), "(){} "))
+	for len(fields) > 0 && envAssignment.MatchString(fields[0]) {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+	fields[0] = filepath.Base(fields[0])
+	return strings.Join(fields, " ")
+}
+
+// matchesAllowedCommand 判断命令是否等于某个允许前缀或以“前缀+空格”开头。
+func matchesAllowedCommand(command string, allowed []string) bool {
+	for _, a := range allowed {
+		if command == a || strings.HasPrefix(command, a+" ") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCommandAllowed(t *testing.T) {
+	r := NewToolRouter(nil)
+	r.SetAllowedCommands([]string{"git", "go test", "ls", "tail"})
+
+	cases := []struct {
+		name    string
+		command string
+		allowed bool
+	}{
+		{name: "plain", command: "git status", allowed: true},
+		{name: "subcommand prefix", command: "go test ./...", allowed: true},
+		{name: "env assignment and path", command: "GOFLAGS= /usr/bin/git log", allowed: true},
+		{name: "chained allowed", command: "git status && ls -la; git diff | tail -n 5", allowed: true},
+		{name: "subshell", command: "(git status)", allowed: true},
+		{name: "stderr to stdout", command: "go test ./... 2>&1 | tail", allowed: true},
+		{name: "dev null", command: "git fetch 2>/dev/null && ls > /dev/null", allowed: true},
+		{name: "not in list", command: "rm -rf /", allowed: false},
+		{name: "prefix is not a word boundary", command: "gitx status", allowed: false},
+		{name: "chained disallowed", command: "git status && rm -rf ~", allowed: false},
+		{name: "background operator", command: "git status & rm -rf ~", allowed: false},
+		{name: "background without spaces", command: "git status&rm -rf ~", allowed: false},
+		{name: "output redirection", command: "git log > ~/.bashrc", allowed: false},
+		{name: "append redirection", command: "git log >> ~/.bashrc", allowed: false},
+		{name: "input redirection", command: "git apply < /tmp/evil.patch", allowed: false},
+		{name: "process substitution", command: "ls <(rm -rf ~)", allowed: false},
+		{name: "command substitution", command: "git log $(rm -rf ~)", allowed: false},
+		{name: "backticks", command: "git log `rm -rf ~`", allowed: false},
+		{name: "pipe stderr", command: "git status |& rm -rf ~", allowed: false},
+		{name: "newline", command: "git status\nrm -rf ~", allowed: false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := r.checkCommandAllowed(tc.command)
+			if tc.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckCommandAllowed_NoList(t *testing.T) {
+	r := NewToolRouter(nil)
+	assert.NoError(t, r.checkCommandAllowed("git status & rm -rf ~"), "未配置允许列表时不做限制")
+}
@@ -0,0 +1,103 @@
+package tools
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PlanStatus 表示计划中单个步骤的状态。
+type PlanStatus string
+
+const (
+	PlanStatusPending    PlanStatus = "pending"
+	PlanStatusInProgress PlanStatus = "in_progress"
+	PlanStatusCompleted  PlanStatus = "completed"
+)
+
+// PlanItem 是 update_plan 工具中的一个步骤。
+type PlanItem struct {
+	Step   string     `json:"step"`
+	Status PlanStatus `json:"status"`
+}
+
+// UpdatePlanArgs 是 update_plan 工具的参数。
+type UpdatePlanArgs struct {
+	Explanation string     `json:"explanation,omitempty"`
+	Plan        []PlanItem `json:"plan"`
+}
+
+var toolParamsUpdatePlan = json.RawMessage(`{
+  "type": "object",
+  "properties": {
+    "explanation": {
+      "type": "string",
+      "description": "Optional short note about why the plan changed"
+    },
+    "plan": {
+      "type": "array",
+      "description": "The full list of steps, replacing any previous plan",
+      "items": {
+        "type": "object",
+        "properties": {
+          "step": {
+            "type": "string",
+            "description": "A short, concrete description of the step"
+          },
+          "status": {
+            "type": "string",
+            "enum": ["pending", "in_progress", "completed"],
+            "description": "Current status of the step"
+          }
+        },
+        "required": ["step", "status"],
+        "additionalProperties": false
+      }
+    }
+  },
+  "required": ["plan"],
+  "additionalProperties": false
+}`)
+
+// UpdatePlanToolSpec returns the update_plan tool definition.
+func UpdatePlanToolSpec() ToolSpec {
+	strict := false
+	return ToolSpec{
+		Kind: ToolKindFunction,
+		Name: "update_plan",
+		Description: "Updates the task plan shown to the user.\n" +
+			"- Use it for non-trivial, multi-step tasks; skip it for simple one-step requests.\n" +
+			"- Always send the full plan. At most one step can be in_progress at a time.\n" +
+			"- Mark a step completed as soon as it is done, before moving to the next one.",
+		Parameters: toolParamsUpdatePlan,
+		Strict:     &strict,
+	}
+}
+
+// ParseUpdatePlanArguments 解析并校验 update_plan 的参数。
+func ParseUpdatePlanArguments(raw json.RawMessage) (UpdatePlanArgs, error) {
+	var args UpdatePlanArgs
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return UpdatePlanArgs{}, fmt.Errorf("解析 update_plan 参数失败: %w", err)
+	}
+
+	inProgress := 0
+	for i := range args.Plan {
+		item := &args.Plan[i]
+		item.Step = strings.TrimSpace(item.Step)
+		if item.Step == "" {
+			return UpdatePlanArgs{}, fmt.Errorf("update_plan 第 %d 个步骤内容为空", i+1)
+		}
+		switch item.Status {
+		case PlanStatusPending, PlanStatusCompleted:
+		case PlanStatusInProgress:
+			inProgress++
+		default:
+			return UpdatePlanArgs{}, fmt.Errorf("update_plan 第 %d 个步骤状态非法: %q", i+1, item.Status)
+		}
+	}
+	if inProgress > 1 {
+		return UpdatePlanArgs{}, fmt.Errorf("update_plan 同一时间最多只能有一个 in_progress 步骤")
+	}
+	return args, nil
+}
@@ -0,0 +1,55 @@
+package tools
+
+// ToolResolver 由可以反查工具归属的 ToolCaller 实现（如 MCPRouter），用于在审批时展示来源。
+type ToolResolver interface {
+	Resolve(name string) (server, tool string, ok bool)
+}
+
+// SetAutoApprovedTools 设置无需用户审批即可执行的远程工具（对应 MCP 配置中的 autoApprove）。
+func (r *ToolRouter) SetAutoApprovedTools(names []string) {
+	m := make(map[string]bool, len(names))
+	for _, name := range names {
+		m[name] = true
+	}
+	r.mu.Lock()
+	r.autoApproved = m
+	r.mu.Unlock()
+}
+
+// IsRemoteTool 判断工具是否会被代理到远程服务执行。
+// remote 能反查工具归属时，只有能查到的工具才算远程工具：模型编造的未知工具名不会触发审批，
+// 执行时直接返回未知工具错误。无法反查的 remote 保守地把所有非内置工具都视为远程工具。
+func (r *ToolRouter) IsRemoteTool(name string) bool {
+	if r.remote == nil {
+		return false
+	}
+	switch name {
+	case "shell", "shell_command", "apply_patch":
+		return false
+	}
+	if resolver, ok := r.remote.(ToolResolver); ok {
+		_, _, found := resolver.Resolve(name)
+		return found
+	}
+	return true
+}
+
+// RequiresApproval 判断工具调用是否需要用户审批：远程工具默认需要，autoApprove 中的工具除外。
+func (r *ToolRouter) RequiresApproval(name string) bool {
+	if !r.IsRemoteTool(name) {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !r.autoApproved[name]
+}
+
+// RemoteToolOwner 返回远程工具所属的 server 与原始工具名，无法反查时返回暴露名称本身。
+func (r *ToolRouter) RemoteToolOwner(name string) (server, tool string) {
+	if resolver, ok := r.remote.(ToolResolver); ok {
+		if server, tool, ok := resolver.Resolve(name); ok {
+			return server, tool
+		}
+	}
+	return "", name
+}
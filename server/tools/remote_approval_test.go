@@ -0,0 +1,54 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeResolvingCaller 为能反查工具归属的远程 ToolCaller。
+type fakeResolvingCaller struct {
+	tools map[string]string
+}
+
+func (f fakeResolvingCaller) CallTool(_ context.Context, name string, _ json.RawMessage) (string, error) {
+	if _, ok := f.tools[name]; !ok {
+		return "", fmt.Errorf("未知工具: %s", name)
+	}
+	return "ok", nil
+}
+
+func (f fakeResolvingCaller) Resolve(name string) (string, string, bool) {
+	tool, ok := f.tools[name]
+	return "docs", tool, ok
+}
+
+// fakeCaller 为无法反查工具归属的远程 ToolCaller。
+type fakeCaller struct{}
+
+func (fakeCaller) CallTool(context.Context, string, json.RawMessage) (string, error) {
+	return "ok", nil
+}
+
+func TestRequiresApproval_RemoteTools(t *testing.T) {
+	r := NewToolRouterWithMCP(nil, fakeResolvingCaller{tools: map[string]string{"mcp__docs__search": "search"}})
+
+	assert.True(t, r.RequiresApproval("mcp__docs__search"))
+	assert.False(t, r.RequiresApproval("shell"), "内置工具不走远程审批")
+	assert.False(t, r.IsRemoteTool("mcp__docs__missing"), "查不到归属的工具不是远程工具")
+	assert.False(t, r.RequiresApproval("made_up_tool"), "未知工具不应弹出审批")
+	_, err := r.Execute(context.Background(), ToolCall{ToolName: "made_up_tool"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "未知工具")
+
+	r.SetAutoApprovedTools([]string{"mcp__docs__search"})
+	assert.False(t, r.RequiresApproval("mcp__docs__search"))
+
+	// 无法反查时保守处理，仍然要求审批。
+	assert.True(t, NewToolRouterWithMCP(nil, fakeCaller{}).RequiresApproval("anything"))
+	assert.False(t, NewToolRouter(nil).IsRemoteTool("anything"))
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -20,16 +21,38 @@ type ToolCaller interface {
 	CallTool(ctx context.Context, name string, arguments json.RawMessage) (string, error)
 }
 
+// ResultToolCaller 由能返回结构化结果（图片、structuredContent）的 ToolCaller 实现，
+// ToolRouter 优先使用它代理远程工具。
+type ResultToolCaller interface {
+	CallToolResult(ctx context.Context, name string, arguments json.RawMessage) (ToolResult, error)
+}
+
 type ToolRouter struct {
+	// mu 保护 specs 与 autoApproved：MCP 工具列表变化时会在后台 goroutine 中更新。
+	mu    sync.RWMutex
 	specs map[string]ToolSpec
 	// remote 用于代理执行本地未内置的工具（如 MCP server 提供的工具）。
 	remote ToolCaller
+	// allowedCommands 非空时限制 shell 工具可执行的命令，见 SetAllowedCommands。
+	allowedCommands []string
+	// autoApproved 为无需审批的远程工具，见 SetAutoApprovedTools。
+	autoApproved map[string]bool
 }
 
 // ToolResult 表示单次工具调用的原始结果，由上层自行封装为 ResponseItem。
 type ToolResult struct {
 	ToolName string
 	Output   string
+	// Images 为工具返回并已落盘的图片，可作为图片输入提供给支持视觉的模型。
+	Images []ToolImage
+	// Structured 为远程工具返回的 structuredContent 原文。
+	Structured json.RawMessage
+}
+
+// ToolImage 描述一张已保存到本地的工具输出图片。
+type ToolImage struct {
+	Path     string `json:"path"`
+	MimeType string `json:"mime_type"`
 }
 
 func NewToolRouter(tools []ToolSpec) *ToolRouter {
@@ -52,6 +75,8 @@ func NewToolRouterWithMCP(tools []ToolSpec, remote ToolCaller) *ToolRouter {
 }
 
 func (r *ToolRouter) Specs() []ToolSpec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	out := make([]ToolSpec, 0, len(r.specs))
 	for _, s := range r.specs {
 		out = append(out, s)
@@ -59,6 +84,17 @@ func (r *ToolRouter) Specs() []ToolSpec {
 	return out
 }
 
+// SetSpecs 替换全部工具定义，用于会话中途 MCP 工具列表变化后更新下一次请求携带的工具。
+func (r *ToolRouter) SetSpecs(tools []ToolSpec) {
+	m := make(map[string]ToolSpec, len(tools))
+	for _, t := range tools {
+		m[t.Name] = t
+	}
+	r.mu.Lock()
+	r.specs = m
+	r.mu.Unlock()
+}
+
 func (r *ToolRouter) Execute(ctx context.Context, call ToolCall) (ToolResult, error) {
 	switch call.ToolName {
 	case "shell", "shell_command":
@@ -67,6 +103,14 @@ func (r *ToolRouter) Execute(ctx context.Context, call ToolCall) (ToolResult, er
 		return r.execApplyPatch(call)
 	default:
 		// 若注入了 remote client，则尝试将未知工具代理到远程服务（如 MCP server）。
+		if rich, ok := r.remote.(ResultToolCaller); ok {
+			res, err := rich.CallToolResult(ctx, call.ToolName, call.Arguments)
+			if err != nil {
+				return ToolResult{}, fmt.Errorf("远程工具 %s 执行失败: %w", call.ToolName, err)
+			}
+			res.ToolName = call.ToolName
+			return res, nil
+		}
 		if r.remote != nil {
 			out, err := r.remote.CallTool(ctx, call.ToolName, call.Arguments)
 			if err != nil {
@@ -98,6 +142,9 @@ func (r *ToolRouter) execShell(_ context.Context, call ToolCall) (ToolResult, er
 	if strings.TrimSpace(args.Command) == "" {
 		return ToolResult{}, fmt.Errorf("%s 工具需要非空 command 字段", call.ToolName)
 	}
+	if err := r.checkCommandAllowed(args.Command); err != nil {
+		return ToolResult{}, err
+	}
 
 	policy := SandboxWorkspaceWrite
 	if args.Policy != "" {
@@ -10,7 +10,8 @@ import (
 // BuildToolSpecsForModel 根据模型能力选择合适的工具集合。
 func BuildToolSpecsForModel(model *llm.LLMModel) []servertools.ToolSpec {
 	mode := resolveApplyPatchToolMode(model)
-	return servertools.ToolSpecsWithApplyPatchMode(mode)
+	tools := servertools.ToolSpecsWithApplyPatchMode(mode)
+	return append(tools, servertools.UpdatePlanToolSpec())
 }
 
 // resolveApplyPatchToolMode 为 apply_patch 选择工具形态。